@@ -1,6 +1,7 @@
 package bench
 
 import (
+	"context"
 	"crypto/rand"
 	"fmt"
 	"runtime"
@@ -34,7 +35,14 @@ type SizeResult struct {
 	Put           OperationResult
 	Get           OperationResult
 	Exists        OperationResult
+	Stat          OperationResult
 	ConcurrentPut OperationResult
+	// PutMany, GetMany and ExistsMany time batches of batchSize objects,
+	// with OpsPerSec counting objects rather than batches. They are left
+	// zero for stores that do not implement store.BatchStore.
+	PutMany    OperationResult
+	GetMany    OperationResult
+	ExistsMany OperationResult
 }
 
 type BackendResult struct {
@@ -46,9 +54,16 @@ type BackendResult struct {
 type RunResult struct {
 	Timestamp time.Time
 	Backends  []BackendResult
+	Codecs    []CodecResult
+	Deltas    []DeltaResult
 }
 
-const iterations = 100
+const (
+	iterations = 100
+	// batchSize objects go into each batch call, so the batch benchmarks
+	// touch as many objects as the single-object ones.
+	batchSize = 10
+)
 
 func randomData(size int) []byte {
 	data := make([]byte, size)
@@ -82,6 +97,13 @@ func measure(n int, fn func() error) (OperationResult, error) {
 	return percentileStats(n, latencies), nil
 }
 
+// measureBatch times n calls of fn that each handle batchSize objects.
+func measureBatch(n int, fn func() error) (OperationResult, error) {
+	result, err := measure(n, fn)
+	result.OpsPerSec *= batchSize
+	return result, err
+}
+
 func measureConcurrent(n int, fn func() error) (OperationResult, error) {
 	workers := runtime.NumCPU()
 	latencies := make([]time.Duration, n)
@@ -116,7 +138,9 @@ func measureConcurrent(n int, fn func() error) (OperationResult, error) {
 	return percentileStats(n, latencies), nil
 }
 
-func RunBackend(name string, s store.ObjectStore) BackendResult {
+// RunBackend measures s at every size in Sizes. Cancelling ctx, or letting
+// its deadline pass, aborts the run with the error recorded in the result.
+func RunBackend(ctx context.Context, name string, s store.ObjectStore) BackendResult {
 	result := BackendResult{Backend: name}
 
 	for _, size := range Sizes {
@@ -127,7 +151,7 @@ func RunBackend(name string, s store.ObjectStore) BackendResult {
 		shas := make([]string, iterations)
 		for i := range iterations {
 			obj := &object.Object{Type: object.TypeBlob, Data: randomData(size.Bytes)}
-			sha, err := s.Put(obj)
+			sha, err := s.Put(ctx, obj)
 			if err != nil {
 				result.Error = fmt.Sprintf("setup Put failed: %v", err)
 				return result
@@ -138,7 +162,7 @@ func RunBackend(name string, s store.ObjectStore) BackendResult {
 		// Put
 		putResult, err := measure(iterations, func() error {
 			obj := &object.Object{Type: object.TypeBlob, Data: data}
-			_, err := s.Put(obj)
+			_, err := s.Put(ctx, obj)
 			return err
 		})
 		if err != nil {
@@ -150,7 +174,7 @@ func RunBackend(name string, s store.ObjectStore) BackendResult {
 		// Get — cycle through pre-populated SHAs
 		i := 0
 		getResult, err := measure(iterations, func() error {
-			_, err := s.Get(shas[i%len(shas)])
+			_, err := s.Get(ctx, shas[i%len(shas)])
 			i++
 			return err
 		})
@@ -163,7 +187,7 @@ func RunBackend(name string, s store.ObjectStore) BackendResult {
 		// Exists — cycle through pre-populated SHAs
 		j := 0
 		existsResult, err := measure(iterations, func() error {
-			_, err := s.Exists(shas[j%len(shas)])
+			_, err := s.Exists(ctx, shas[j%len(shas)])
 			j++
 			return err
 		})
@@ -173,10 +197,23 @@ func RunBackend(name string, s store.ObjectStore) BackendResult {
 		}
 		sr.Exists = existsResult
 
+		// Stat — type and size without the body
+		j = 0
+		statResult, err := measure(iterations, func() error {
+			_, _, err := store.Stat(ctx, s, shas[j%len(shas)])
+			j++
+			return err
+		})
+		if err != nil {
+			result.Error = fmt.Sprintf("Stat benchmark failed: %v", err)
+			return result
+		}
+		sr.Stat = statResult
+
 		// Concurrent Put
 		concResult, err := measureConcurrent(iterations, func() error {
 			obj := &object.Object{Type: object.TypeBlob, Data: randomData(size.Bytes)}
-			_, err := s.Put(obj)
+			_, err := s.Put(ctx, obj)
 			return err
 		})
 		if err != nil {
@@ -185,8 +222,66 @@ func RunBackend(name string, s store.ObjectStore) BackendResult {
 		}
 		sr.ConcurrentPut = concResult
 
+		if bs, ok := s.(store.BatchStore); ok {
+			if err := runBatch(ctx, bs, &sr, shas); err != nil {
+				result.Error = err.Error()
+				return result
+			}
+		}
+
 		result.Results = append(result.Results, sr)
 	}
 
 	return result
 }
+
+// runBatch measures the BatchStore methods, reusing shas from the
+// single-object benchmarks for GetMany and ExistsMany.
+func runBatch(ctx context.Context, s store.BatchStore, sr *SizeResult, shas []string) error {
+	rounds := iterations / batchSize
+
+	// PutMany — fresh objects each round, generated before timing starts
+	batches := make([][]*object.Object, rounds)
+	for i := range batches {
+		batches[i] = make([]*object.Object, batchSize)
+		for j := range batches[i] {
+			batches[i][j] = &object.Object{Type: object.TypeBlob, Data: randomData(sr.Size.Bytes)}
+		}
+	}
+	i := 0
+	putResult, err := measureBatch(rounds, func() error {
+		_, err := s.PutMany(ctx, batches[i])
+		i++
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("PutMany benchmark failed: %v", err)
+	}
+	sr.PutMany = putResult
+
+	// GetMany — consecutive slices of the pre-populated SHAs
+	j := 0
+	getResult, err := measureBatch(rounds, func() error {
+		_, err := s.GetMany(ctx, shas[j*batchSize:(j+1)*batchSize])
+		j++
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("GetMany benchmark failed: %v", err)
+	}
+	sr.GetMany = getResult
+
+	// ExistsMany — consecutive slices of the pre-populated SHAs
+	k := 0
+	existsResult, err := measureBatch(rounds, func() error {
+		_, err := s.ExistsMany(ctx, shas[k*batchSize:(k+1)*batchSize])
+		k++
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("ExistsMany benchmark failed: %v", err)
+	}
+	sr.ExistsMany = existsResult
+
+	return nil
+}
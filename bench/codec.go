@@ -0,0 +1,134 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+
+	"git.wyat.me/git-storage/object"
+)
+
+// CodecResult holds one codec's results at every size in Sizes.
+type CodecResult struct {
+	Codec   string
+	Results []CodecSizeResult
+	Error   string
+}
+
+// CodecSizeResult times compressing and decompressing one object with a
+// codec, independent of any backend.
+type CodecSizeResult struct {
+	Size       Size
+	Compress   OperationResult
+	Decompress OperationResult
+	// Ratio is the stored size as a fraction of the object's own size,
+	// header included.
+	Ratio float64
+}
+
+// words are drawn from to build text that compresses like source code.
+var words = []string{
+	"func", "return", "err", "nil", "if", "for", "range", "ctx", "context.Context",
+	"string", "[]byte", "int64", "fmt.Errorf", "object", "store", "sha", "data",
+	"{", "}", "(", ")", ":=", "=", "!=", "//", "the", "of", "a", "to", "is",
+}
+
+// textData returns size bytes of lines of code-like text. Unlike
+// randomData it compresses, as most of what git stores does.
+func textData(size int) []byte {
+	data := make([]byte, 0, size+80)
+	for len(data) < size {
+		data = append(data, "\t"...)
+		for range 4 + rand.IntN(8) {
+			data = append(data, words[rand.IntN(len(words))]...)
+			data = append(data, ' ')
+		}
+		data = fmt.Appendf(data, "x%d\n", rand.IntN(1000))
+	}
+	return data[:size]
+}
+
+// RunCodecs measures every built-in codec, and zstd with a dictionary
+// trained on small text objects, at every size in Sizes. Objects are
+// code-like text, since random data is incompressible.
+func RunCodecs(ctx context.Context) []CodecResult {
+	var results []CodecResult
+	for _, c := range []object.Codec{object.Zlib, object.Zstd, object.None} {
+		results = append(results, runCodec(ctx, c))
+	}
+	c, err := trainedCodec()
+	if err != nil {
+		return append(results, CodecResult{Codec: "zstd-dict", Error: err.Error()})
+	}
+	return append(results, runCodec(ctx, c))
+}
+
+// trainedCodec returns a zstd codec with a dictionary trained on small
+// objects like the ones it is measured on.
+func trainedCodec() (object.Codec, error) {
+	samples := make([]*object.Object, 50)
+	for i := range samples {
+		samples[i] = &object.Object{Type: object.TypeBlob, Data: textData(Sizes[0].Bytes)}
+	}
+	dict, err := object.TrainZstdDict(samples, 16<<10)
+	if err != nil {
+		return nil, err
+	}
+	return object.NewZstdDict(dict)
+}
+
+func runCodec(ctx context.Context, c object.Codec) CodecResult {
+	result := CodecResult{Codec: c.Name()}
+
+	for _, size := range Sizes {
+		if err := ctx.Err(); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		sr := CodecSizeResult{Size: size}
+
+		objs := make([]*object.Object, iterations)
+		for i := range objs {
+			objs[i] = &object.Object{Type: object.TypeBlob, Data: textData(size.Bytes)}
+		}
+		compressed := make([][]byte, iterations)
+		var raw, stored int
+
+		// Compress
+		i := 0
+		compressResult, err := measure(iterations, func() error {
+			b, _, err := object.SHA1.SerializeCodec(objs[i], c)
+			compressed[i] = b
+			i++
+			return err
+		})
+		if err != nil {
+			result.Error = fmt.Sprintf("Compress benchmark failed: %v", err)
+			return result
+		}
+		sr.Compress = compressResult
+
+		// Decompress
+		j := 0
+		decompressResult, err := measure(iterations, func() error {
+			_, err := object.Deserialize(compressed[j], c)
+			j++
+			return err
+		})
+		if err != nil {
+			result.Error = fmt.Sprintf("Decompress benchmark failed: %v", err)
+			return result
+		}
+		sr.Decompress = decompressResult
+
+		for k, obj := range objs {
+			raw += len(fmt.Sprintf("%s %d\x00", obj.Type, len(obj.Data))) + len(obj.Data)
+			stored += len(compressed[k])
+		}
+		sr.Ratio = float64(stored) / float64(raw)
+
+		result.Results = append(result.Results, sr)
+	}
+
+	return result
+}
@@ -0,0 +1,122 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand/v2"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+// DeltaDepths are the chain depths RunDeltas stores versions with. Zero
+// stores every version whole.
+var DeltaDepths = []int{0, 10, 50}
+
+// deltaFileSize is the size of the file whose versions RunDeltas stores.
+const deltaFileSize = 100 * 1024
+
+// DeltaResult holds one backend's results at every depth in DeltaDepths.
+type DeltaResult struct {
+	Backend string
+	Results []DeltaDepthResult
+	Error   string
+}
+
+// DeltaDepthResult measures storing successive versions of one file with
+// deltas in chains of at most MaxDepth.
+type DeltaDepthResult struct {
+	MaxDepth int
+	// PutDeltas times batches of batchSize versions, each hinted against
+	// the version before it, with OpsPerSec counting versions.
+	PutDeltas OperationResult
+	// Get reads every version, rebuilding it from its delta chain.
+	Get OperationResult
+	// Ratio is the bytes the store holds as a fraction of the versions'
+	// total size.
+	Ratio float64
+}
+
+// fileVersions returns n versions of a size-byte text file, each with a
+// few lines changed from the version before it, as a file's history in a
+// repository would have.
+func fileVersions(n, size int) []*object.Object {
+	lines := bytes.SplitAfter(textData(size), []byte("\n"))
+	versions := make([]*object.Object, n)
+	for i := range versions {
+		for range 3 {
+			lines[rand.IntN(len(lines))] = textData(40)
+		}
+		versions[i] = &object.Object{Type: object.TypeBlob, Data: bytes.Join(lines, nil)}
+	}
+	return versions
+}
+
+// RunDeltas stores iterations versions of a file in a fresh store for
+// each depth in DeltaDepths, opened by open with store.WithDeltas(depth),
+// and reports how much space the deltas save against what they cost to
+// write and read.
+func RunDeltas(ctx context.Context, name string, open func(opts ...store.Option) (store.SizeStore, error)) DeltaResult {
+	result := DeltaResult{Backend: name}
+	versions := fileVersions(iterations, deltaFileSize)
+	shas := make([]string, len(versions))
+	bases := make([]string, len(versions))
+	var raw int
+	for i, obj := range versions {
+		shas[i] = object.Hash(obj)
+		if i > 0 {
+			bases[i] = shas[i-1]
+		}
+		raw += len(obj.Data)
+	}
+
+	for _, depth := range DeltaDepths {
+		if err := ctx.Err(); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		s, err := open(store.WithDeltas(depth))
+		if err != nil {
+			result.Error = fmt.Sprintf("open store: %v", err)
+			return result
+		}
+		dr := DeltaDepthResult{MaxDepth: depth}
+
+		// PutDeltas — in order, so each version's base is already stored
+		i := 0
+		putResult, err := measureBatch(len(versions)/batchSize, func() error {
+			_, err := store.PutDeltas(ctx, s, versions[i:i+batchSize], bases[i:i+batchSize])
+			i += batchSize
+			return err
+		})
+		if err != nil {
+			result.Error = fmt.Sprintf("PutDeltas benchmark failed: %v", err)
+			return result
+		}
+		dr.PutDeltas = putResult
+
+		// Get — every version, so every position in the chains
+		j := 0
+		getResult, err := measure(len(versions), func() error {
+			_, err := s.Get(ctx, shas[j])
+			j++
+			return err
+		})
+		if err != nil {
+			result.Error = fmt.Sprintf("Get benchmark failed: %v", err)
+			return result
+		}
+		dr.Get = getResult
+
+		stored, err := s.StoredSize(ctx)
+		if err != nil {
+			result.Error = fmt.Sprintf("StoredSize failed: %v", err)
+			return result
+		}
+		dr.Ratio = float64(stored) / float64(raw)
+
+		result.Results = append(result.Results, dr)
+	}
+	return result
+}
@@ -1,13 +1,22 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"git.wyat.me/git-storage/server"
 )
 
+// shutdownTimeout is how long in-flight requests get to finish once the
+// server is told to stop.
+const shutdownTimeout = 30 * time.Second
+
 func main() {
 	repoRoot := os.Getenv("REPO_ROOT")
 	if repoRoot == "" {
@@ -24,8 +33,30 @@ func main() {
 		log.Fatalf("failed to create server: %v", err)
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	httpSrv := &http.Server{Addr: ":" + port, Handler: srv.Handler()}
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-ctx.Done()
+		log.Printf("shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := httpSrv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+	}()
+
 	log.Printf("listening on :%s, repos at %s", port, repoRoot)
-	if err := http.ListenAndServe(":"+port, srv.Handler()); err != nil {
+	if err := httpSrv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("failed to start server: %v", err)
 	}
+	// ListenAndServe returns as soon as Shutdown starts; the stores are
+	// closed only once in-flight requests have finished with them.
+	<-shutdown
+	if err := srv.Close(); err != nil {
+		log.Fatalf("failed to close stores: %v", err)
+	}
 }
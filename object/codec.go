@@ -0,0 +1,295 @@
+package object
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec compresses the stored form of an object, "<type> <size>\0<data>".
+// Every value a codec writes starts with its tag, so Deserialize and
+// NewReader can tell which codec wrote a value and a store can change
+// codecs without rewriting what it already holds.
+type Codec interface {
+	// Name identifies the codec in errors and benchmark results.
+	Name() string
+	// Tag is the first byte of every value the codec writes.
+	Tag() byte
+	// NewWriter returns a writer that compresses to w, starting with the
+	// tag. Closing it flushes the value but does not close w.
+	NewWriter(w io.Writer) io.WriteCloser
+	// NewReader decompresses a value read from r, tag included.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// Tags of the built-in codecs. A zlib stream's first byte says it is
+// deflate with a 32KB window, so zlib values need no tag of their own and
+// objects stored before codecs existed read as zlib. The other tags are
+// not valid zlib headers.
+const (
+	tagNone     byte = 0x00
+	tagZstd     byte = 0x01
+	tagZstdDict byte = 0x02
+	tagZlib     byte = 0x78
+)
+
+var (
+	// Zlib is git's own object compression, and the default.
+	Zlib Codec = zlibCodec{}
+	// Zstd compresses faster than zlib and usually smaller.
+	Zstd Codec = newZstdCodec("zstd", tagZstd, nil)
+	// None stores objects uncompressed.
+	None Codec = noneCodec{}
+)
+
+// codecFor returns the codec that wrote values starting with tag, looking
+// in codecs before the built-in ones.
+func codecFor(tag byte, codecs []Codec) (Codec, error) {
+	for _, c := range codecs {
+		if c.Tag() == tag {
+			return c, nil
+		}
+	}
+	for _, c := range []Codec{Zlib, Zstd, None} {
+		if c.Tag() == tag {
+			return c, nil
+		}
+	}
+	if tag == tagZstdDict {
+		return nil, fmt.Errorf("object compressed with a zstd dictionary, but no dictionary was given")
+	}
+	return nil, fmt.Errorf("unknown codec tag %#02x", tag)
+}
+
+// Compress compresses data that is not an object, such as a stored delta,
+// with codec c.
+func Compress(data []byte, c Codec) ([]byte, error) {
+	var buf bytes.Buffer
+	w := c.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("compress: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("compress: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress reverses Compress for a value written by any built-in codec
+// or by one of codecs.
+func Decompress(compressed []byte, codecs ...Codec) ([]byte, error) {
+	if len(compressed) == 0 {
+		return nil, fmt.Errorf("read codec tag: %w", io.ErrUnexpectedEOF)
+	}
+	c, err := codecFor(compressed[0], codecs)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("%s new reader: %w", c.Name(), err)
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("decompress: %w", err)
+	}
+	return data, nil
+}
+
+type zlibCodec struct{}
+
+func (zlibCodec) Name() string { return "zlib" }
+func (zlibCodec) Tag() byte    { return tagZlib }
+
+func (zlibCodec) NewWriter(w io.Writer) io.WriteCloser {
+	return zlib.NewWriter(w)
+}
+
+func (zlibCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
+type noneCodec struct{}
+
+func (noneCodec) Name() string { return "none" }
+func (noneCodec) Tag() byte    { return tagNone }
+
+func (noneCodec) NewWriter(w io.Writer) io.WriteCloser {
+	return &taggedWriter{w: w, tag: tagNone, wc: nopCloser{w}}
+}
+
+func (noneCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	if err := readTag(r, tagNone); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(r), nil
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// taggedWriter writes tag ahead of the first bytes written to wc, so that
+// a value is tagged even if nothing else is written to it.
+type taggedWriter struct {
+	w      io.Writer
+	tag    byte
+	tagged bool
+	wc     io.WriteCloser
+}
+
+func (t *taggedWriter) writeTag() error {
+	if t.tagged {
+		return nil
+	}
+	if _, err := t.w.Write([]byte{t.tag}); err != nil {
+		return err
+	}
+	t.tagged = true
+	return nil
+}
+
+func (t *taggedWriter) Write(p []byte) (int, error) {
+	if err := t.writeTag(); err != nil {
+		return 0, err
+	}
+	return t.wc.Write(p)
+}
+
+func (t *taggedWriter) Close() error {
+	if err := t.writeTag(); err != nil {
+		return err
+	}
+	return t.wc.Close()
+}
+
+func readTag(r io.Reader, want byte) error {
+	var tag [1]byte
+	if _, err := io.ReadFull(r, tag[:]); err != nil {
+		return fmt.Errorf("read codec tag: %w", noEOF(err))
+	}
+	if tag[0] != want {
+		return fmt.Errorf("codec tag %#02x, want %#02x", tag[0], want)
+	}
+	return nil
+}
+
+// zstdCodec pools its encoders and decoders, which are expensive to set
+// up relative to compressing one small object. Each runs with a
+// concurrency of one, so an abandoned one holds no goroutines.
+type zstdCodec struct {
+	name     string
+	tag      byte
+	encoders sync.Pool
+	decoders sync.Pool
+}
+
+func newZstdCodec(name string, tag byte, d *zstdDicts) *zstdCodec {
+	c := &zstdCodec{name: name, tag: tag}
+	c.encoders.New = func() any {
+		opts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+		if d != nil {
+			opts = append(opts, zstd.WithEncoderDict(d.current))
+		}
+		// the options are fixed and known to be valid
+		e, _ := zstd.NewWriter(nil, opts...)
+		return e
+	}
+	c.decoders.New = func() any {
+		opts := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+		if d != nil {
+			opts = append(opts, zstd.WithDecoderDicts(d.all...))
+		}
+		dec, _ := zstd.NewReader(nil, opts...)
+		return dec
+	}
+	return c
+}
+
+func (c *zstdCodec) Name() string { return c.name }
+func (c *zstdCodec) Tag() byte    { return c.tag }
+
+func (c *zstdCodec) NewWriter(w io.Writer) io.WriteCloser {
+	e := c.encoders.Get().(*zstd.Encoder)
+	e.Reset(w)
+	return &taggedWriter{w: w, tag: c.tag, wc: &zstdWriter{Encoder: e, pool: &c.encoders}}
+}
+
+func (c *zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	if err := readTag(r, c.tag); err != nil {
+		return nil, err
+	}
+	d := c.decoders.Get().(*zstd.Decoder)
+	if err := d.Reset(r); err != nil {
+		c.decoders.Put(d)
+		return nil, err
+	}
+	return &zstdReader{Decoder: d, pool: &c.decoders}, nil
+}
+
+// zstdWriter returns its encoder to the pool once the value is complete.
+type zstdWriter struct {
+	*zstd.Encoder
+	pool *sync.Pool
+}
+
+func (w *zstdWriter) Close() error {
+	err := w.Encoder.Close()
+	w.Encoder.Reset(nil)
+	w.pool.Put(w.Encoder)
+	return err
+}
+
+// zstdReader returns its decoder to the pool when closed.
+type zstdReader struct {
+	*zstd.Decoder
+	pool *sync.Pool
+}
+
+func (r *zstdReader) Close() error {
+	r.Decoder.Reset(nil)
+	r.pool.Put(r.Decoder)
+	return nil
+}
+
+type zstdDicts struct {
+	current []byte
+	all     [][]byte
+}
+
+// NewZstdDict returns a zstd codec that compresses with the trained
+// dictionary dict, which shrinks small objects such as trees and commits
+// far more than zstd alone can. It decompresses values written with dict
+// or with any of the older dictionaries, so a store can move to a newly
+// trained dictionary and still read what the old one wrote.
+func NewZstdDict(dict []byte, older ...[]byte) (Codec, error) {
+	d := &zstdDicts{current: dict, all: append([][]byte{dict}, older...)}
+	for _, b := range d.all {
+		if _, err := zstd.InspectDictionary(b); err != nil {
+			return nil, fmt.Errorf("zstd dictionary: %w", err)
+		}
+	}
+	return newZstdCodec("zstd-dict", tagZstdDict, d), nil
+}
+
+// TrainZstdDict builds a zstd dictionary of at most size bytes from
+// sample objects, for NewZstdDict. Samples are best drawn from the
+// repository's own small trees and commits.
+func TrainZstdDict(samples []*Object, size int) ([]byte, error) {
+	input := make([][]byte, len(samples))
+	for i, obj := range samples {
+		input[i] = append(fmt.Appendf(nil, "%s %d\x00", obj.Type, len(obj.Data)), obj.Data...)
+	}
+	b, err := dict.BuildZstdDict(input, dict.Options{MaxDictSize: size, HashBytes: 6})
+	if err != nil {
+		return nil, fmt.Errorf("train zstd dictionary: %w", err)
+	}
+	return b, nil
+}
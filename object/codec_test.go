@@ -0,0 +1,160 @@
+package object
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// sampleCommits returns n commits that differ the way a repository's
+// commits do, for training and testing dictionaries.
+func sampleCommits(n int) []*Object {
+	objs := make([]*Object, n)
+	for i := range objs {
+		data := fmt.Sprintf("tree %040x\nparent %040x\nauthor Ada Lovelace <ada@example.com> %d +0100\ncommitter Ada Lovelace <ada@example.com> %d +0100\n\nFix off-by-one in chunk %d of the object store\n",
+			i*7919, i*104729, 1700000000+i*60, 1700000000+i*60, i)
+		objs[i] = &Object{Type: TypeCommit, Data: []byte(data)}
+	}
+	return objs
+}
+
+func trainDict(t *testing.T, samples int) []byte {
+	t.Helper()
+	d, err := TrainZstdDict(sampleCommits(samples), 1024)
+	if err != nil {
+		t.Fatalf("TrainZstdDict failed: %v", err)
+	}
+	return d
+}
+
+func trainedCodec(t *testing.T) Codec {
+	t.Helper()
+	c, err := NewZstdDict(trainDict(t, 50))
+	if err != nil {
+		t.Fatalf("NewZstdDict failed: %v", err)
+	}
+	return c
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, c := range []Codec{Zlib, Zstd, None, trainedCodec(t)} {
+		t.Run(c.Name(), func(t *testing.T) {
+			for _, obj := range []*Object{
+				{Type: TypeBlob, Data: nil},
+				{Type: TypeBlob, Data: []byte("hello\n")},
+				{Type: TypeCommit, Data: sampleCommits(1)[0].Data},
+				{Type: TypeBlob, Data: bytes.Repeat([]byte("0123456789abcdef"), 64*1024)},
+			} {
+				compressed, sha, err := serializeCodec(obj, c)
+				if err != nil {
+					t.Fatalf("serializeCodec failed: %v", err)
+				}
+				if compressed[0] != c.Tag() {
+					t.Errorf("value starts with %#02x, want tag %#02x", compressed[0], c.Tag())
+				}
+				if want := Hash(obj); sha != want {
+					t.Errorf("got SHA %s, want %s", sha, want)
+				}
+
+				got, err := Deserialize(compressed, c)
+				if err != nil {
+					t.Fatalf("Deserialize failed: %v", err)
+				}
+				if got.Type != obj.Type || !bytes.Equal(got.Data, obj.Data) {
+					t.Errorf("%d byte %s did not round-trip", len(obj.Data), obj.Type)
+				}
+
+				r, err := NewReader(bytes.NewReader(compressed), c)
+				if err != nil {
+					t.Fatalf("NewReader failed: %v", err)
+				}
+				body, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatalf("Read failed: %v", err)
+				}
+				r.Close()
+				if r.Type != obj.Type || !bytes.Equal(body, obj.Data) {
+					t.Errorf("%d byte %s did not stream back", len(obj.Data), obj.Type)
+				}
+			}
+		})
+	}
+}
+
+// serializeCodec is SHA1.SerializeCodec.
+func serializeCodec(obj *Object, c Codec) ([]byte, string, error) {
+	return SHA1.SerializeCodec(obj, c)
+}
+
+func TestCodecZlibMatchesSerialize(t *testing.T) {
+	// values stored before codecs existed are zlib with no separate tag
+	obj := &Object{Type: TypeBlob, Data: []byte("hello\n")}
+	want, _, _ := Serialize(obj)
+	got, _, _ := serializeCodec(obj, Zlib)
+	if !bytes.Equal(got, want) {
+		t.Error("zlib codec output differs from Serialize")
+	}
+}
+
+func TestCodecBuiltinsNeedNoArguments(t *testing.T) {
+	obj := &Object{Type: TypeBlob, Data: []byte("mixed\n")}
+	for _, c := range []Codec{Zlib, Zstd, None} {
+		compressed, _, _ := serializeCodec(obj, c)
+		got, err := Deserialize(compressed)
+		if err != nil {
+			t.Fatalf("%s: Deserialize failed: %v", c.Name(), err)
+		}
+		if !bytes.Equal(got.Data, obj.Data) {
+			t.Errorf("%s: got %q", c.Name(), got.Data)
+		}
+	}
+}
+
+func TestCodecDictionary(t *testing.T) {
+	c := trainedCodec(t)
+	commit := sampleCommits(1000)[999]
+
+	dictSized, _, _ := serializeCodec(commit, c)
+	zstdSized, _, _ := serializeCodec(commit, Zstd)
+	if len(dictSized) >= len(zstdSized) {
+		t.Errorf("dictionary did not help: %d bytes, %d without", len(dictSized), len(zstdSized))
+	}
+
+	if _, err := Deserialize(dictSized); err == nil || !strings.Contains(err.Error(), "no dictionary") {
+		t.Errorf("expected a missing dictionary error, got %v", err)
+	}
+
+	// a codec with a new dictionary still reads the old one's values
+	old, d := trainDict(t, 40), trainDict(t, 60)
+	oldCodec, _ := NewZstdDict(old)
+	newCodec, err := NewZstdDict(d, old)
+	if err != nil {
+		t.Fatalf("NewZstdDict failed: %v", err)
+	}
+	compressed, _, _ := serializeCodec(commit, oldCodec)
+	got, err := Deserialize(compressed, newCodec)
+	if err != nil {
+		t.Fatalf("Deserialize with an older dictionary failed: %v", err)
+	}
+	if !bytes.Equal(got.Data, commit.Data) {
+		t.Error("commit did not round-trip through the older dictionary")
+	}
+
+	if _, err := NewZstdDict([]byte("not a dictionary")); err == nil {
+		t.Error("expected NewZstdDict to reject a malformed dictionary")
+	}
+}
+
+func TestCodecUnknownTag(t *testing.T) {
+	if _, err := Deserialize([]byte("not zlib")); err == nil || !strings.Contains(err.Error(), "unknown codec") {
+		t.Errorf("Deserialize: expected an unknown codec error, got %v", err)
+	}
+	if _, err := NewReader(bytes.NewReader([]byte("not zlib"))); err == nil || !strings.Contains(err.Error(), "unknown codec") {
+		t.Errorf("NewReader: expected an unknown codec error, got %v", err)
+	}
+	if _, err := Deserialize(nil); err == nil {
+		t.Error("Deserialize: expected an error for an empty value")
+	}
+}
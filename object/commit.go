@@ -0,0 +1,244 @@
+package object
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Commit is a parsed commit object. Encode reproduces the bytes git wrote
+// for it exactly, so a parsed commit keeps its SHA.
+type Commit struct {
+	Tree      string
+	Parents   []string
+	Author    Signature
+	Committer Signature
+	// Extra holds the headers after committer, such as encoding, mergetag
+	// and gpgsig, in the order they appeared. Git writes gpgsig last, but
+	// other tools do not always, and moving it would change the SHA.
+	Extra   []Header
+	Message string
+}
+
+// Header is a commit or tag header line. Multi-line values are stored
+// joined with "\n", without the space that starts each continuation line.
+type Header struct {
+	Key   string
+	Value string
+}
+
+// Signature is an author, committer or tagger line:
+// "Name <email> 1700000000 +0100". When is in the signer's own time zone,
+// whose name is the offset as it was written.
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+// ParseCommit parses the body of a commit object.
+func ParseCommit(data []byte) (*Commit, error) {
+	headers, message, err := parseHeaders(data)
+	if err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+
+	c := &Commit{Message: message}
+	next := func(key string) (string, bool) {
+		if len(headers) == 0 || headers[0].Key != key {
+			return "", false
+		}
+		value := headers[0].Value
+		headers = headers[1:]
+		return value, true
+	}
+
+	var ok bool
+	if c.Tree, ok = next("tree"); !ok {
+		return nil, fmt.Errorf("commit: missing tree")
+	}
+	for {
+		parent, ok := next("parent")
+		if !ok {
+			break
+		}
+		c.Parents = append(c.Parents, parent)
+	}
+	author, ok := next("author")
+	if !ok {
+		return nil, fmt.Errorf("commit: missing author")
+	}
+	if c.Author, err = parseSignature(author); err != nil {
+		return nil, fmt.Errorf("commit author: %w", err)
+	}
+	committer, ok := next("committer")
+	if !ok {
+		return nil, fmt.Errorf("commit: missing committer")
+	}
+	if c.Committer, err = parseSignature(committer); err != nil {
+		return nil, fmt.Errorf("commit committer: %w", err)
+	}
+	c.Extra = headers
+	return c, nil
+}
+
+// GPGSig returns the commit's signature, without the leading space git
+// puts on each continuation line, or "" if it is not signed.
+func (c *Commit) GPGSig() string {
+	for _, h := range c.Extra {
+		if h.Key == "gpgsig" {
+			return h.Value
+		}
+	}
+	return ""
+}
+
+// CommitLinks is the part of a commit that a history walk needs.
+type CommitLinks struct {
+	Tree    string
+	Parents []string
+	// Time is the committer's timestamp, or 0 if it cannot be read.
+	Time int64
+}
+
+// ScanCommit reads only the tree and parent headers of a commit, and the
+// committer's timestamp where it can. Like git's own history walk it
+// passes over malformed identities and every other header, so a commit
+// git can traverse can be traversed here too; ParseCommit and Validate
+// are what check the rest.
+func ScanCommit(data []byte) (*CommitLinks, error) {
+	c := &CommitLinks{}
+	for len(data) > 0 {
+		var line []byte
+		line, data, _ = bytes.Cut(data, []byte("\n"))
+		if len(line) == 0 {
+			break
+		}
+		key, value, _ := bytes.Cut(line, []byte(" "))
+		switch string(key) {
+		case "tree":
+			if c.Tree == "" {
+				c.Tree = string(value)
+			}
+		case "parent":
+			c.Parents = append(c.Parents, string(value))
+		case "committer":
+			// "Name <email> 1700000000 +0000", or whatever is left of it
+			if gt := bytes.LastIndexByte(value, '>'); gt >= 0 {
+				if fields := bytes.Fields(value[gt+1:]); len(fields) > 0 {
+					c.Time, _ = strconv.ParseInt(string(fields[0]), 10, 64)
+				}
+			}
+		}
+	}
+	if c.Tree == "" {
+		return nil, fmt.Errorf("commit: missing tree")
+	}
+	return c, nil
+}
+
+// Encode returns the commit's object body.
+func (c *Commit) Encode() []byte {
+	var buf bytes.Buffer
+	writeHeader(&buf, "tree", c.Tree)
+	for _, p := range c.Parents {
+		writeHeader(&buf, "parent", p)
+	}
+	writeHeader(&buf, "author", c.Author.String())
+	writeHeader(&buf, "committer", c.Committer.String())
+	for _, h := range c.Extra {
+		writeHeader(&buf, h.Key, h.Value)
+	}
+	buf.WriteByte('\n')
+	buf.WriteString(c.Message)
+	return buf.Bytes()
+}
+
+// parseHeaders splits a commit or tag into its headers and the message
+// after the first blank line.
+func parseHeaders(data []byte) ([]Header, string, error) {
+	var headers []Header
+	for {
+		if len(data) == 0 {
+			return nil, "", fmt.Errorf("missing blank line before message")
+		}
+		line, rest, ok := bytes.Cut(data, []byte("\n"))
+		if !ok {
+			return nil, "", fmt.Errorf("unterminated header %q", line)
+		}
+		data = rest
+		if len(line) == 0 {
+			return headers, string(data), nil
+		}
+		if cont, ok := bytes.CutPrefix(line, []byte(" ")); ok {
+			if len(headers) == 0 {
+				return nil, "", fmt.Errorf("continuation line before any header")
+			}
+			headers[len(headers)-1].Value += "\n" + string(cont)
+			continue
+		}
+		key, value, ok := bytes.Cut(line, []byte(" "))
+		if !ok {
+			return nil, "", fmt.Errorf("malformed header %q", line)
+		}
+		headers = append(headers, Header{Key: string(key), Value: string(value)})
+	}
+}
+
+func writeHeader(buf *bytes.Buffer, key, value string) {
+	buf.WriteString(key)
+	buf.WriteByte(' ')
+	buf.WriteString(strings.ReplaceAll(value, "\n", "\n "))
+	buf.WriteByte('\n')
+}
+
+func parseSignature(s string) (Signature, error) {
+	lt := strings.IndexByte(s, '<')
+	gt := strings.IndexByte(s, '>')
+	if lt < 1 || s[lt-1] != ' ' || gt < lt {
+		return Signature{}, fmt.Errorf("malformed identity %q", s)
+	}
+	fields := strings.Split(s[gt+1:], " ")
+	if len(fields) != 3 || fields[0] != "" {
+		return Signature{}, fmt.Errorf("malformed date in %q", s)
+	}
+	secs, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return Signature{}, fmt.Errorf("malformed date in %q", s)
+	}
+	offset, err := parseZone(fields[2])
+	if err != nil {
+		return Signature{}, fmt.Errorf("%w in %q", err, s)
+	}
+	return Signature{
+		Name:  s[:lt-1],
+		Email: s[lt+1 : gt],
+		When:  time.Unix(secs, 0).In(time.FixedZone(fields[2], offset)),
+	}, nil
+}
+
+// parseZone returns the offset in seconds of a zone written as "+hhmm".
+func parseZone(zone string) (int, error) {
+	if len(zone) != 5 || (zone[0] != '+' && zone[0] != '-') || strings.Trim(zone[1:], "0123456789") != "" {
+		return 0, fmt.Errorf("malformed time zone %q", zone)
+	}
+	hours, _ := strconv.Atoi(zone[1:3])
+	minutes, _ := strconv.Atoi(zone[3:])
+	offset := hours*3600 + minutes*60
+	if zone[0] == '-' {
+		offset = -offset
+	}
+	return offset, nil
+}
+
+// String formats s as git writes it in a commit or tag.
+func (s Signature) String() string {
+	zone, offset := s.When.Zone()
+	if o, err := parseZone(zone); err != nil || o != offset {
+		// not a zone parsed from a signature, so format the offset
+		zone = s.When.Format("-0700")
+	}
+	return fmt.Sprintf("%s <%s> %d %s", s.Name, s.Email, s.When.Unix(), zone)
+}
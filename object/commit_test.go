@@ -0,0 +1,192 @@
+package object
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The fixtures in testdata are the output of git cat-file for objects made
+// by git itself, including gpg-signed commits and tags and a merge of a
+// signed tag.
+
+// testRoundTrip checks that every fixture matching pattern encodes back
+// to exactly the bytes it was parsed from.
+func testRoundTrip(t *testing.T, pattern string, roundTrip func(data []byte) ([]byte, error)) {
+	paths, err := filepath.Glob(filepath.Join("testdata", pattern))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no fixtures match %s", pattern)
+	}
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := roundTrip(data)
+			if err != nil {
+				t.Fatalf("parse failed: %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("round trip changed the object:\ngot:\n%s\nwant:\n%s", got, data)
+			}
+		})
+	}
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestCommitRoundTrip(t *testing.T) {
+	testRoundTrip(t, "commit-*", func(data []byte) ([]byte, error) {
+		c, err := ParseCommit(data)
+		if err != nil {
+			return nil, err
+		}
+		return c.Encode(), nil
+	})
+}
+
+func TestCommitRoundTripHeaderOrder(t *testing.T) {
+	// a signature before other headers, as some tools write it
+	const ident = "A <a@example.com> 1700000000 +0000"
+	data := []byte("tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+		"author " + ident + "\n" +
+		"committer " + ident + "\n" +
+		"gpgsig -----BEGIN PGP SIGNATURE-----\n \n c2lnbmF0dXJl\n -----END PGP SIGNATURE-----\n" +
+		"encoding ISO-8859-1\n" +
+		"\nmsg\n")
+	c, err := ParseCommit(data)
+	if err != nil {
+		t.Fatalf("ParseCommit failed: %v", err)
+	}
+	if got := c.Encode(); !bytes.Equal(got, data) {
+		t.Errorf("round trip changed the object:\ngot:\n%s\nwant:\n%s", got, data)
+	}
+	if want := "-----BEGIN PGP SIGNATURE-----\n\nc2lnbmF0dXJl\n-----END PGP SIGNATURE-----"; c.GPGSig() != want {
+		t.Errorf("gpgsig: got %q, want %q", c.GPGSig(), want)
+	}
+}
+
+func TestParseCommit(t *testing.T) {
+	c, err := ParseCommit(readFixture(t, "commit-mergetag"))
+	if err != nil {
+		t.Fatalf("ParseCommit failed: %v", err)
+	}
+	if c.Tree != "3a268f459c50eadb5fcb6658fe3e536b5ed187c2" {
+		t.Errorf("tree: got %s", c.Tree)
+	}
+	if len(c.Parents) != 2 || c.Parents[1] != "b35a4d4ac234c20a7e5a1926396dfc4aa1fd275d" {
+		t.Errorf("parents: got %v", c.Parents)
+	}
+	if c.Author.Name != "Ada Lovelace" || c.Author.Email != "ada@example.com" {
+		t.Errorf("author: got %q <%q>", c.Author.Name, c.Author.Email)
+	}
+	if want := time.Date(2023, 11, 14, 22, 15, 0, 0, time.UTC); !c.Committer.When.Equal(want) {
+		t.Errorf("committer time: got %v, want %v", c.Committer.When, want)
+	}
+	if _, offset := c.Committer.When.Zone(); offset != 3600 {
+		t.Errorf("committer offset: got %d, want 3600", offset)
+	}
+	if len(c.Extra) != 1 || c.Extra[0].Key != "mergetag" || !strings.HasPrefix(c.Extra[0].Value, "object b35a4d4") {
+		t.Errorf("extra headers: got %q", c.Extra)
+	}
+	if c.Message != "Merge signed tag\n" {
+		t.Errorf("message: got %q", c.Message)
+	}
+
+	signed, err := ParseCommit(readFixture(t, "commit-gpgsig"))
+	if err != nil {
+		t.Fatalf("ParseCommit failed: %v", err)
+	}
+	if sig := signed.GPGSig(); !strings.HasPrefix(sig, "-----BEGIN PGP SIGNATURE-----\n\n") || !strings.HasSuffix(sig, "-----END PGP SIGNATURE-----") {
+		t.Errorf("gpgsig: got %q", sig)
+	}
+
+	encoded, err := ParseCommit(readFixture(t, "commit-encoding"))
+	if err != nil {
+		t.Fatalf("ParseCommit failed: %v", err)
+	}
+	if _, offset := encoded.Author.When.Zone(); offset != -(8*3600 + 30*60) {
+		t.Errorf("author offset: got %d, want -30600", offset)
+	}
+	if len(encoded.Extra) != 1 || encoded.Extra[0] != (Header{"encoding", "ISO-8859-1"}) {
+		t.Errorf("extra headers: got %q", encoded.Extra)
+	}
+}
+
+func TestSignatureString(t *testing.T) {
+	for _, line := range []string{
+		"A U Thor <author@example.com> 1700000000 +0000",
+		"A U Thor <author@example.com> 1700000000 -0000",
+		" <> 0 +1400",
+	} {
+		sig, err := parseSignature(line)
+		if err != nil {
+			t.Fatalf("parseSignature %q failed: %v", line, err)
+		}
+		if got := sig.String(); got != line {
+			t.Errorf("got %q, want %q", got, line)
+		}
+	}
+
+	// a time from elsewhere is written with its offset
+	sig := Signature{Name: "A", Email: "a@example.com", When: time.Unix(1700000000, 0).In(time.FixedZone("IST", 5*3600+1800))}
+	if got, want := sig.String(), "A <a@example.com> 1700000000 +0530"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseCommitErrors(t *testing.T) {
+	const ident = "A <a@example.com> 1700000000 +0000"
+	for name, data := range map[string]string{
+		"no tree":      "author " + ident + "\ncommitter " + ident + "\n\nmsg\n",
+		"no author":    "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\ncommitter " + ident + "\n\nmsg\n",
+		"no committer": "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\nauthor " + ident + "\n\nmsg\n",
+		"bad ident":    "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\nauthor A a@example.com 1 +0000\ncommitter " + ident + "\n\nmsg\n",
+		"bad zone":     "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\nauthor A <a@example.com> 1 +00\ncommitter " + ident + "\n\nmsg\n",
+		"no message":   "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\nauthor " + ident + "\ncommitter " + ident + "\n",
+	} {
+		if _, err := ParseCommit([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestScanCommitToleratesMalformedHeaders(t *testing.T) {
+	data := "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+		"parent 3a268f459c50eadb5fcb6658fe3e536b5ed187c2\n" +
+		"author A a@example.com 1 +00\n" +
+		"committer Broken <b@example.com> 1700000000 +0000 trailing\n" +
+		"mergetag object b35a4d4ac234c20a7e5a1926396dfc4aa1fd275d\n" +
+		" type commit\n" +
+		"\nmsg\n"
+	if _, err := ParseCommit([]byte(data)); err == nil {
+		t.Fatal("expected ParseCommit to reject the malformed author")
+	}
+	c, err := ScanCommit([]byte(data))
+	if err != nil {
+		t.Fatalf("ScanCommit failed: %v", err)
+	}
+	if c.Tree != "4b825dc642cb6eb9a060e54bf8d69288fbee4904" {
+		t.Errorf("tree: got %s", c.Tree)
+	}
+	if len(c.Parents) != 1 || c.Parents[0] != "3a268f459c50eadb5fcb6658fe3e536b5ed187c2" {
+		t.Errorf("parents: got %v", c.Parents)
+	}
+	if c.Time != 1700000000 {
+		t.Errorf("time: got %d, want 1700000000", c.Time)
+	}
+
+	if _, err := ScanCommit([]byte("parent 3a268f459c50eadb5fcb6658fe3e536b5ed187c2\n\nmsg\n")); err == nil {
+		t.Error("expected an error for a commit without a tree")
+	}
+}
@@ -0,0 +1,141 @@
+package object
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormatHash(t *testing.T) {
+	// from git hash-object --stdin in a repository of each format
+	for _, tc := range []struct {
+		format Format
+		data   string
+		want   string
+	}{
+		{SHA1, "hello\n", "ce013625030ba8dba906f756967f9e9ca394464a"},
+		{SHA1, "", "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"},
+		{SHA256, "hello\n", "2cf8d83d9ee29543b34a87727421fdecb7e3f3a183d337639025de576db9ebb4"},
+		{SHA256, "", "473a0f4c3be8a93681a267e3b1e9a7dcda1185436fe141f7749120a303721813"},
+	} {
+		obj := &Object{Type: TypeBlob, Data: []byte(tc.data)}
+		if got := tc.format.Hash(obj); got != tc.want {
+			t.Errorf("%s Hash %q: got %s, want %s", tc.format, tc.data, got, tc.want)
+		}
+
+		compressed, sha, err := tc.format.Serialize(obj)
+		if err != nil {
+			t.Fatalf("Serialize failed: %v", err)
+		}
+		if sha != tc.want {
+			t.Errorf("%s Serialize %q: got %s, want %s", tc.format, tc.data, sha, tc.want)
+		}
+		got, err := Deserialize(compressed)
+		if err != nil {
+			t.Fatalf("Deserialize failed: %v", err)
+		}
+		if got.Type != obj.Type || !bytes.Equal(got.Data, obj.Data) {
+			t.Errorf("%s: %q did not round-trip", tc.format, tc.data)
+		}
+	}
+}
+
+// The fixtures in testdata/sha256 come from a repository created with
+// git init --object-format=sha256.
+func TestSHA256Fixtures(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		typ  ObjectType
+		sha  string
+	}{
+		{"commit", TypeCommit, "6317f53191fdc62b56d7e14bc58d28e677783a848d9b421d7c353ad359d72f82"},
+		{"tree", TypeTree, "3c4fc99108f8555717727245382cf20786e8fd322d87b10101e198a4529c5182"},
+		{"tag", TypeTag, "19a084ae7933c9bfe8fae27fff25e356edf2b0e126fdc244614b9f8941424db6"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := readFixture(t, filepath.Join("sha256", tc.name))
+			obj := &Object{Type: tc.typ, Data: data}
+			if got := SHA256.Hash(obj); got != tc.sha {
+				t.Errorf("Hash: got %s, want %s", got, tc.sha)
+			}
+			if err := SHA256.Validate(obj); err != nil {
+				t.Errorf("Validate failed: %v", err)
+			}
+			if err := Validate(obj); !errors.Is(err, ErrInvalid) {
+				t.Errorf("expected a SHA-256 %s to be invalid as SHA-1, got %v", tc.name, err)
+			}
+		})
+	}
+
+	data := readFixture(t, filepath.Join("sha256", "tree"))
+	tree, err := SHA256.ParseTree(data)
+	if err != nil {
+		t.Fatalf("ParseTree failed: %v", err)
+	}
+	if len(tree.Entries) != 2 || tree.Entries[0].SHA != "2cf8d83d9ee29543b34a87727421fdecb7e3f3a183d337639025de576db9ebb4" {
+		t.Errorf("got entries %v", tree.Entries)
+	}
+	encoded, err := tree.Encode()
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if !bytes.Equal(encoded, data) {
+		t.Error("SHA-256 tree did not round-trip")
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, f := range []Format{SHA1, SHA256} {
+		if got, err := ParseFormat(f.String()); err != nil || got != f {
+			t.Errorf("ParseFormat(%q): got (%v, %v)", f, got, err)
+		}
+	}
+	if _, err := ParseFormat("md5"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestCollisionDetected(t *testing.T) {
+	// testdata/shattered holds the first 320 bytes of the two SHAttered
+	// PDFs, which is where they collide. No published collision is a valid
+	// git object, so the detector is exercised on the bytes without an
+	// object header, as a Writer would hash an attacker's crafted object.
+	for _, name := range []string{"1.bin", "2.bin"} {
+		data, err := os.ReadFile(filepath.Join("testdata/shattered", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := &Writer{zw: zlib.NewWriter(io.Discard), h: SHA1.newHash(), size: int64(len(data))}
+		if _, err := w.Write(data); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if err := w.Close(); !errors.Is(err, ErrCollision) {
+			t.Errorf("%s: expected ErrCollision, got %v", name, err)
+		}
+	}
+}
+
+func TestCollisionNotDetected(t *testing.T) {
+	// behind a blob header the same bytes are no longer a collision, and
+	// must hash as git hash-object does
+	for name, want := range map[string]string{
+		"1.bin": "ef380704685cc8e54de9bc13556d1ff7026ec0cc",
+		"2.bin": "6e98aef8bba6ff517f5b164d7418c5e2a6cf90c9",
+	} {
+		data, err := os.ReadFile(filepath.Join("testdata/shattered", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, sha, err := Serialize(&Object{Type: TypeBlob, Data: data})
+		if err != nil {
+			t.Fatalf("%s: Serialize failed: %v", name, err)
+		}
+		if sha != want {
+			t.Errorf("%s: got %s, want %s", name, sha, want)
+		}
+	}
+}
@@ -2,11 +2,7 @@ package object
 
 import (
 	"bytes"
-	"compress/zlib"
-	"crypto/sha1"
-	"encoding/hex"
 	"fmt"
-	"io"
 	"strconv"
 	"strings"
 )
@@ -25,35 +21,23 @@ type Object struct {
 	Data []byte
 }
 
-func Serialize(obj *Object) (compressed []byte, sha string, err error) {
-	header := fmt.Sprintf("%s %d\x00", obj.Type, len(obj.Data))
-	content := append([]byte(header), obj.Data...)
-
-	sum := sha1.Sum(content)
-	sha = hex.EncodeToString(sum[:])
-
-	var buf bytes.Buffer
-	w := zlib.NewWriter(&buf)
-	if _, err = w.Write(content); err != nil {
-		return nil, "", fmt.Errorf("zlib write: %w", err)
-	}
-	if err = w.Close(); err != nil {
-		return nil, "", fmt.Errorf("zlib close: %w", err)
-	}
-
-	return buf.Bytes(), sha, nil
+// Hash returns the SHA-1 of obj without compressing it, as Serialize
+// would compute it.
+func Hash(obj *Object) string {
+	return SHA1.Hash(obj)
 }
 
-func Deserialize(compressed []byte) (*Object, error) {
-	r, err := zlib.NewReader(bytes.NewReader(compressed))
-	if err != nil {
-		return nil, fmt.Errorf("zlib new reader: %w", err)
-	}
-	defer r.Close()
+// Serialize compresses obj and names it by its SHA-1.
+func Serialize(obj *Object) (compressed []byte, sha string, err error) {
+	return SHA1.Serialize(obj)
+}
 
-	content, err := io.ReadAll(r)
+// Deserialize decompresses an object written by any built-in codec or by
+// one of codecs.
+func Deserialize(compressed []byte, codecs ...Codec) (*Object, error) {
+	content, err := Decompress(compressed, codecs...)
 	if err != nil {
-		return nil, fmt.Errorf("zlib read: %w", err)
+		return nil, err
 	}
 	return parse(content)
 }
@@ -64,25 +48,35 @@ func parse(content []byte) (*Object, error) {
 		return nil, fmt.Errorf("invalid object: no null byte")
 	}
 
-	header := string(content[:nullIdx])
+	typ, size, err := parseHeader(string(content[:nullIdx]))
+	if err != nil {
+		return nil, err
+	}
 	data := content[nullIdx+1:]
+	if size != int64(len(data)) {
+		return nil, fmt.Errorf("invalid data size: expected %d, got %d", size, len(data))
+	}
+
+	return &Object{
+		Type: typ,
+		Data: data,
+	}, nil
+}
 
+// parseHeader parses "<type> <size>", the object header without its
+// trailing null byte.
+func parseHeader(header string) (ObjectType, int64, error) {
 	parts := strings.SplitN(header, " ", 2)
 	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid object header: %q", header)
-
+		return "", 0, fmt.Errorf("invalid object header: %q", header)
 	}
 
-	size, err := strconv.Atoi(parts[1])
+	size, err := strconv.ParseInt(parts[1], 10, 64)
 	if err != nil {
-		return nil, fmt.Errorf("invalid size in header: %w", err)
+		return "", 0, fmt.Errorf("invalid size in header: %w", err)
 	}
-	if size != len(data) {
-		return nil, fmt.Errorf("invalid data size: expected %d, got %d", size, len(data))
+	if size < 0 {
+		return "", 0, fmt.Errorf("invalid size in header: %d", size)
 	}
-
-	return &Object{
-		Type: ObjectType(parts[0]),
-		Data: data,
-	}, nil
+	return ObjectType(parts[0]), size, nil
 }
@@ -0,0 +1,222 @@
+package object
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/pjbgf/sha1cd"
+)
+
+// maxHeaderLen bounds how far NewReader looks for the null byte ending an
+// object header. "commit 18446744073709551615" is 27 bytes.
+const maxHeaderLen = 64
+
+// Writer hashes and compresses an object as its body is written, so that
+// large blobs never have to be held in memory. The body's size must be
+// known up front because the header that the SHA covers includes it.
+type Writer struct {
+	zw     io.WriteCloser
+	h      hash.Hash
+	header []byte
+	size   int64
+	n      int64
+	err    error
+}
+
+// NewWriter returns a Writer that writes the zlib-compressed object to w.
+// The caller must write exactly size bytes of body and then call Close.
+// Like zlib.NewWriter, it writes nothing to w until the first Write or
+// Close.
+func NewWriter(w io.Writer, typ ObjectType, size int64) *Writer {
+	return SHA1.NewWriter(w, typ, size)
+}
+
+func newWriter(zw io.WriteCloser, h hash.Hash, typ ObjectType, size int64) *Writer {
+	ow := &Writer{zw: zw, h: h, size: size}
+	ow.header = fmt.Appendf(nil, "%s %d\x00", typ, size)
+	ow.h.Write(ow.header)
+	return ow
+}
+
+// writeHeader writes the object header ahead of the first body bytes.
+func (w *Writer) writeHeader() error {
+	if w.header == nil {
+		return nil
+	}
+	if _, err := w.zw.Write(w.header); err != nil {
+		w.err = fmt.Errorf("compress: %w", err)
+		return w.err
+	}
+	w.header = nil
+	return nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if err := w.writeHeader(); err != nil {
+		return 0, err
+	}
+	if int64(len(p)) > w.size-w.n {
+		w.err = fmt.Errorf("object body longer than its declared %d bytes", w.size)
+		return 0, w.err
+	}
+	n, err := w.zw.Write(p)
+	w.h.Write(p[:n])
+	w.n += int64(n)
+	if err != nil {
+		w.err = fmt.Errorf("compress: %w", err)
+		return n, w.err
+	}
+	return n, nil
+}
+
+// Close flushes the compressed stream. It fails if fewer bytes were
+// written than the size given to NewWriter, and with ErrCollision if the
+// object is half of a SHA-1 collision. It does not close the underlying
+// writer.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.n != w.size {
+		w.err = fmt.Errorf("object body is %d bytes, declared %d", w.n, w.size)
+		return w.err
+	}
+	if h, ok := w.h.(sha1cd.CollisionResistantHash); ok {
+		if _, collided := h.CollisionResistantSum(nil); collided {
+			w.err = fmt.Errorf("object %s: %w", w.SHA(), ErrCollision)
+			return w.err
+		}
+	}
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	if err := w.zw.Close(); err != nil {
+		w.err = fmt.Errorf("compress: %w", err)
+		return w.err
+	}
+	return nil
+}
+
+// SHA returns the object's SHA. It is only meaningful after Close has
+// succeeded.
+func (w *Writer) SHA() string {
+	return hex.EncodeToString(w.h.Sum(nil))
+}
+
+// Reader decompresses a stored object, parsing its header up front and
+// streaming the body.
+type Reader struct {
+	Type ObjectType
+	Size int64
+
+	src io.Reader
+	zr  io.ReadCloser
+	br  *bufio.Reader
+	n   int64
+}
+
+// NewReader reads the header of the compressed object in r. Reads from the
+// returned Reader yield the object body. Closing it also closes r if r is
+// an io.Closer. The object may have been written by any built-in codec or
+// by one of codecs.
+func NewReader(r io.Reader, codecs ...Codec) (*Reader, error) {
+	var tag [1]byte
+	if _, err := io.ReadFull(r, tag[:]); err != nil {
+		return nil, fmt.Errorf("read codec tag: %w", noEOF(err))
+	}
+	c, err := codecFor(tag[0], codecs)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := c.NewReader(io.MultiReader(bytes.NewReader(tag[:]), r))
+	if err != nil {
+		return nil, fmt.Errorf("%s new reader: %w", c.Name(), err)
+	}
+	br := bufio.NewReader(zr)
+
+	var header []byte
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			zr.Close()
+			return nil, fmt.Errorf("read object header: %w", noEOF(err))
+		}
+		if b == 0 {
+			break
+		}
+		if len(header) == maxHeaderLen {
+			zr.Close()
+			return nil, fmt.Errorf("invalid object: no null byte")
+		}
+		header = append(header, b)
+	}
+
+	typ, size, err := parseHeader(string(header))
+	if err != nil {
+		zr.Close()
+		return nil, err
+	}
+	return &Reader{Type: typ, Size: size, src: r, zr: zr, br: br}, nil
+}
+
+// NewBodyReader returns a Reader over an object body stored apart from its
+// header, as packfiles store them. Closing it closes body.
+func NewBodyReader(typ ObjectType, size int64, body io.ReadCloser) *Reader {
+	return &Reader{Type: typ, Size: size, zr: body, br: bufio.NewReader(body)}
+}
+
+// Read reads the object body. It reports an error rather than io.EOF if
+// the body does not match the size in the header.
+func (r *Reader) Read(p []byte) (int, error) {
+	remaining := r.Size - r.n
+	if remaining == 0 {
+		// reading past the body makes the codec verify its checksum, and
+		// catches bodies longer than their header says.
+		if n, err := r.br.Read(make([]byte, 1)); n > 0 {
+			return 0, fmt.Errorf("invalid data size: longer than %d", r.Size)
+		} else if err != io.EOF {
+			return 0, fmt.Errorf("decompress: %w", noEOF(err))
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := r.br.Read(p)
+	r.n += int64(n)
+	if err == io.EOF && r.n == r.Size {
+		// an uncompressed source can end along with the body's last bytes
+		return n, nil
+	}
+	if err == io.EOF {
+		return n, fmt.Errorf("invalid data size: expected %d, got %d", r.Size, r.n)
+	}
+	if err != nil {
+		return n, fmt.Errorf("decompress: %w", err)
+	}
+	return n, nil
+}
+
+func (r *Reader) Close() error {
+	err := r.zr.Close()
+	if c, ok := r.src.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
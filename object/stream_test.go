@@ -0,0 +1,142 @@
+package object
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestStreamMatchesSerialize(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 256*1024) // 4MB
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, TypeBlob, int64(len(data)))
+	// write in uneven pieces, as io.Copy from a network reader would
+	for chunk := range slices.Chunk(data, 100_000) {
+		if _, err := w.Write(chunk); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	_, wantSHA, err := Serialize(&Object{Type: TypeBlob, Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.SHA() != wantSHA {
+		t.Errorf("SHA mismatch: got %s, want %s", w.SHA(), wantSHA)
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	defer r.Close()
+	if r.Type != TypeBlob || r.Size != int64(len(data)) {
+		t.Errorf("header: got %s %d, want blob %d", r.Type, r.Size, len(data))
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("body mismatch")
+	}
+}
+
+func TestWriterRejectsWrongSize(t *testing.T) {
+	w := NewWriter(io.Discard, TypeBlob, 5)
+	if _, err := w.Write([]byte("hello\n")); err == nil {
+		t.Error("expected an error writing past the declared size")
+	}
+
+	w = NewWriter(io.Discard, TypeBlob, 6)
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err == nil {
+		t.Error("expected an error closing a short body")
+	}
+}
+
+func TestReaderRejectsWrongSize(t *testing.T) {
+	for name, content := range map[string]string{
+		"short": "blob 10\x00hello\n",
+		"long":  "blob 3\x00hello\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			r, err := NewReader(bytes.NewReader(compress(t, content)))
+			if err != nil {
+				t.Fatalf("NewReader failed: %v", err)
+			}
+			if _, err := io.ReadAll(r); err == nil {
+				t.Error("expected a size error")
+			}
+		})
+	}
+
+	if _, err := NewReader(bytes.NewReader(compress(t, strings.Repeat("x", 100)))); err == nil {
+		t.Error("expected an error for a header without a null byte")
+	}
+}
+
+func TestReaderEOFWithLastBytes(t *testing.T) {
+	// an uncompressed value whose source returns its last bytes with io.EOF
+	body := bytes.Repeat([]byte("x"), 64*1024)
+	compressed, _, err := SHA1.SerializeCodec(&Object{Type: TypeBlob, Data: body}, None)
+	if err != nil {
+		t.Fatalf("SerializeCodec failed: %v", err)
+	}
+	r, err := NewReader(&eofReader{compressed})
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	// reads larger than bufio's buffer go straight to the source
+	buf := make([]byte, 2*len(body))
+	var got []byte
+	for {
+		n, err := r.Read(buf)
+		got = append(got, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+	}
+	if !bytes.Equal(got, body) {
+		t.Error("body did not round-trip")
+	}
+}
+
+// eofReader returns io.EOF along with the last bytes of data.
+type eofReader struct {
+	data []byte
+}
+
+func (r *eofReader) Read(p []byte) (int, error) {
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	if len(r.data) == 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func compress(t *testing.T, content string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,74 @@
+package object
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Tag is a parsed annotated tag. A signed tag's signature is part of its
+// Message, as git stores it.
+type Tag struct {
+	Object string
+	Type   ObjectType
+	Name   string
+	// Tagger is nil for tags made before git recorded one.
+	Tagger *Signature
+	// Extra holds any headers after tagger, in the order they appeared.
+	Extra   []Header
+	Message string
+}
+
+// ParseTag parses the body of a tag object.
+func ParseTag(data []byte) (*Tag, error) {
+	headers, message, err := parseHeaders(data)
+	if err != nil {
+		return nil, fmt.Errorf("tag: %w", err)
+	}
+
+	t := &Tag{Message: message}
+	for i, key := range []string{"object", "type", "tag"} {
+		if len(headers) <= i || headers[i].Key != key {
+			return nil, fmt.Errorf("tag: missing %s", key)
+		}
+	}
+	t.Object, t.Type, t.Name = headers[0].Value, ObjectType(headers[1].Value), headers[2].Value
+	headers = headers[3:]
+	if len(headers) > 0 && headers[0].Key == "tagger" {
+		tagger, err := parseSignature(headers[0].Value)
+		if err != nil {
+			return nil, fmt.Errorf("tag tagger: %w", err)
+		}
+		t.Tagger = &tagger
+		headers = headers[1:]
+	}
+	t.Extra = headers
+	return t, nil
+}
+
+// TagObject reads only the object header of a tag, which is all that
+// peeling it needs; ParseTag and Validate check the rest.
+func TagObject(data []byte) (string, error) {
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+	target, ok := bytes.CutPrefix(line, []byte("object "))
+	if !ok {
+		return "", fmt.Errorf("tag: missing object")
+	}
+	return string(target), nil
+}
+
+// Encode returns the tag's object body.
+func (t *Tag) Encode() []byte {
+	var buf bytes.Buffer
+	writeHeader(&buf, "object", t.Object)
+	writeHeader(&buf, "type", string(t.Type))
+	writeHeader(&buf, "tag", t.Name)
+	if t.Tagger != nil {
+		writeHeader(&buf, "tagger", t.Tagger.String())
+	}
+	for _, h := range t.Extra {
+		writeHeader(&buf, h.Key, h.Value)
+	}
+	buf.WriteByte('\n')
+	buf.WriteString(t.Message)
+	return buf.Bytes()
+}
@@ -0,0 +1,51 @@
+package object
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTagRoundTrip(t *testing.T) {
+	testRoundTrip(t, "tag-*", func(data []byte) ([]byte, error) {
+		tag, err := ParseTag(data)
+		if err != nil {
+			return nil, err
+		}
+		return tag.Encode(), nil
+	})
+}
+
+func TestParseTag(t *testing.T) {
+	tag, err := ParseTag(readFixture(t, "tag-signed"))
+	if err != nil {
+		t.Fatalf("ParseTag failed: %v", err)
+	}
+	if tag.Object != "a7ef6b5c29b5a2ab2b9fe74126a4ff36506b434a" || tag.Type != TypeCommit || tag.Name != "v1-signed" {
+		t.Errorf("got object %s type %s name %s", tag.Object, tag.Type, tag.Name)
+	}
+	if tag.Tagger == nil || tag.Tagger.Email != "ada@example.com" {
+		t.Errorf("tagger: got %v", tag.Tagger)
+	}
+	if !strings.HasPrefix(tag.Message, "Signed release\n-----BEGIN PGP SIGNATURE-----\n") {
+		t.Errorf("message: got %q", tag.Message)
+	}
+}
+
+func TestParseTagWithoutTagger(t *testing.T) {
+	// tags from before git 0.99.1 have no tagger line
+	data := "object 4b825dc642cb6eb9a060e54bf8d69288fbee4904\ntype tree\ntag old\n\nold tag\n"
+	tag, err := ParseTag([]byte(data))
+	if err != nil {
+		t.Fatalf("ParseTag failed: %v", err)
+	}
+	if tag.Tagger != nil {
+		t.Errorf("expected no tagger, got %v", tag.Tagger)
+	}
+	if got := string(tag.Encode()); got != data {
+		t.Errorf("round trip: got %q, want %q", got, data)
+	}
+
+	if _, err := ParseTag([]byte("type tree\ntag old\n\nold tag\n")); err == nil {
+		t.Error("expected an error for a tag with no object")
+	}
+}
@@ -0,0 +1,103 @@
+package object
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Tree entry modes as git writes them. Trees are "40000", without the
+// leading zero ls-tree shows.
+const (
+	ModeBlob       = "100644"
+	ModeExecutable = "100755"
+	ModeSymlink    = "120000"
+	ModeTree       = "40000"
+	ModeGitlink    = "160000"
+)
+
+// Tree is a parsed tree object. Entries keep the order they were stored
+// in; Encode writes them in that order.
+type Tree struct {
+	Entries []TreeEntry
+}
+
+type TreeEntry struct {
+	Mode string
+	Name string
+	SHA  string
+}
+
+// ParseTree parses the body of a tree object, a sequence of
+// "<mode> <name>\0" followed by the entry's binary SHA-1.
+func ParseTree(data []byte) (*Tree, error) {
+	return SHA1.ParseTree(data)
+}
+
+func parseTree(data []byte, hashLen int) (*Tree, error) {
+	t := &Tree{}
+	for len(data) > 0 {
+		sp := bytes.IndexByte(data, ' ')
+		if sp < 1 {
+			return nil, fmt.Errorf("tree: malformed entry mode")
+		}
+		nul := bytes.IndexByte(data[sp+1:], 0)
+		if nul < 0 {
+			return nil, fmt.Errorf("tree: unterminated entry name")
+		}
+		end := sp + 1 + nul + 1 + hashLen
+		if end > len(data) {
+			return nil, fmt.Errorf("tree: truncated entry")
+		}
+		t.Entries = append(t.Entries, TreeEntry{
+			Mode: string(data[:sp]),
+			Name: string(data[sp+1 : sp+1+nul]),
+			SHA:  hex.EncodeToString(data[sp+1+nul+1 : end]),
+		})
+		data = data[end:]
+	}
+	return t, nil
+}
+
+// Encode returns the tree's object body. The entries' SHAs may be of
+// either format, but should all be of the repository's.
+func (t *Tree) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	for _, e := range t.Entries {
+		sha, err := hex.DecodeString(e.SHA)
+		if err != nil || (len(sha) != SHA1.Size() && len(sha) != SHA256.Size()) {
+			return nil, fmt.Errorf("tree entry %s: invalid SHA %q", e.Name, e.SHA)
+		}
+		buf.WriteString(e.Mode)
+		buf.WriteByte(' ')
+		buf.WriteString(e.Name)
+		buf.WriteByte(0)
+		buf.Write(sha)
+	}
+	return buf.Bytes(), nil
+}
+
+// Sort puts the entries in the order git requires.
+func (t *Tree) Sort() {
+	slices.SortFunc(t.Entries, CompareEntries)
+}
+
+// CompareEntries orders tree entries by name, comparing a subtree's name
+// as if it ended in "/", so "foo.txt" sorts before a directory "foo" but
+// after a file "foo".
+func CompareEntries(a, b TreeEntry) int {
+	return strings.Compare(a.sortName(), b.sortName())
+}
+
+func (e TreeEntry) sortName() string {
+	if e.IsTree() {
+		return e.Name + "/"
+	}
+	return e.Name
+}
+
+func (e TreeEntry) IsTree() bool {
+	return e.Mode == ModeTree
+}
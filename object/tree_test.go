@@ -0,0 +1,74 @@
+package object
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestTreeRoundTrip(t *testing.T) {
+	testRoundTrip(t, "tree-*", func(data []byte) ([]byte, error) {
+		tree, err := ParseTree(data)
+		if err != nil {
+			return nil, err
+		}
+		return tree.Encode()
+	})
+}
+
+func TestParseTree(t *testing.T) {
+	tree, err := ParseTree(readFixture(t, "tree-gitlink"))
+	if err != nil {
+		t.Fatalf("ParseTree failed: %v", err)
+	}
+	want := []TreeEntry{
+		{ModeBlob, "a.txt", "78981922613b2afb6025042ff6bd878ac1994e85"},
+		{ModeTree, "dir", "799450a0f8f3a9dcf7ffa00d7b73fdf822939cf9"},
+		{ModeBlob, "foo-bar", "6a69f92020f5df77af6e8813ff1232493383b708"},
+		{ModeBlob, "foo.txt", "587be6b4c3f93f93c489c0111bba5596147a26cb"},
+		{ModeTree, "foo", "9c02fee14ddb5a7726d26bb6eefa3ae0ba7e1154"},
+		{ModeSymlink, "link", "8d14cbf983b3fad683171c9418998d9f68340823"},
+		{ModeExecutable, "run.sh", "1a2485251c33a70432394c93fb89330ef214bfc9"},
+		{ModeGitlink, "sub", "1234567890123456789012345678901234567890"},
+	}
+	if len(tree.Entries) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(tree.Entries), len(want))
+	}
+	for i, e := range tree.Entries {
+		if e != want[i] {
+			t.Errorf("entry %d: got %v, want %v", i, e, want[i])
+		}
+	}
+}
+
+func TestTreeSort(t *testing.T) {
+	data := readFixture(t, "tree-gitlink")
+	tree, err := ParseTree(data)
+	if err != nil {
+		t.Fatalf("ParseTree failed: %v", err)
+	}
+	// the fixture has the directory "foo" after "foo.txt", as git sorts it
+	rand.Shuffle(len(tree.Entries), func(i, j int) {
+		tree.Entries[i], tree.Entries[j] = tree.Entries[j], tree.Entries[i]
+	})
+	tree.Sort()
+	got, err := tree.Encode()
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("sorted tree does not match git's order")
+	}
+}
+
+func TestParseTreeErrors(t *testing.T) {
+	for name, data := range map[string]string{
+		"no mode":     " a.txt\x00" + string(make([]byte, 20)),
+		"no name end": "100644 a.txt",
+		"short sha":   "100644 a.txt\x00" + string(make([]byte, 19)),
+	} {
+		if _, err := ParseTree([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
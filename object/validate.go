@@ -0,0 +1,167 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalid is wrapped by every error Validate returns.
+var ErrInvalid = errors.New("invalid object")
+
+// Validate checks obj against the rules git fsck applies to objects it
+// receives. It rejects unknown types, malformed or unsorted trees, trees
+// with entries that could overwrite a checkout's .git directory, and
+// commits and tags with malformed headers or identities. Blobs are always
+// valid. SHAs are checked as SHA-1s.
+func Validate(obj *Object) error {
+	return SHA1.Validate(obj)
+}
+
+func validate(f Format, obj *Object) error {
+	var err error
+	switch obj.Type {
+	case TypeBlob:
+	case TypeTree:
+		err = validateTree(f, obj.Data)
+	case TypeCommit:
+		err = validateCommit(f, obj.Data)
+	case TypeTag:
+		err = validateTag(f, obj.Data)
+	default:
+		err = fmt.Errorf("unknown type %q", obj.Type)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	return nil
+}
+
+func validateTree(f Format, data []byte) error {
+	tree, err := f.ParseTree(data)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool, len(tree.Entries))
+	for i, e := range tree.Entries {
+		switch e.Mode {
+		case ModeBlob, ModeExecutable, ModeSymlink, ModeTree, ModeGitlink:
+		default:
+			return fmt.Errorf("tree entry %q: bad mode %s", e.Name, e.Mode)
+		}
+		switch {
+		case e.Name == "":
+			return fmt.Errorf("tree entry with empty name")
+		case strings.Contains(e.Name, "/"):
+			return fmt.Errorf("tree entry %q: name contains a slash", e.Name)
+		case e.Name == "." || e.Name == "..":
+			return fmt.Errorf("tree entry %q: name is a relative path", e.Name)
+		case isDotGit(e.Name, ".git", "git~1"):
+			return fmt.Errorf("tree entry %q: name would be a .git directory", e.Name)
+		case e.Mode == ModeSymlink && isDotGit(e.Name, ".gitmodules", "gitmod~1"):
+			return fmt.Errorf("tree entry %q: .gitmodules is a symlink", e.Name)
+		}
+		if names[e.Name] {
+			return fmt.Errorf("tree entry %q: duplicate name", e.Name)
+		}
+		names[e.Name] = true
+		if i > 0 && CompareEntries(tree.Entries[i-1], e) >= 0 {
+			return fmt.Errorf("tree entry %q: not sorted", e.Name)
+		}
+	}
+	return nil
+}
+
+// isDotGit reports whether a checkout on a case-insensitive filesystem
+// could write name as the file dotName. NTFS drops trailing dots and
+// spaces and also answers to the 8.3 short name, and HFS+ ignores some
+// zero-width code points.
+func isDotGit(name, dotName, shortName string) bool {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 0x200c && r <= 0x200f, r >= 0x202a && r <= 0x202e, r >= 0x206a && r <= 0x206f, r == 0xfeff:
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.ToLower(strings.TrimRight(name, ". "))
+	return name == dotName || name == shortName
+}
+
+func validateCommit(f Format, data []byte) error {
+	c, err := ParseCommit(data)
+	if err != nil {
+		return err
+	}
+	if !f.IsSHA(c.Tree) {
+		return fmt.Errorf("commit: bad tree %q", c.Tree)
+	}
+	for _, p := range c.Parents {
+		if !f.IsSHA(p) {
+			return fmt.Errorf("commit: bad parent %q", p)
+		}
+	}
+	// ParseCommit has checked the identities' shape, and these checks
+	// need them as written.
+	headers, _, _ := parseHeaders(data)
+	seen := map[string]bool{}
+	for _, h := range headers {
+		if h.Key != "author" && h.Key != "committer" {
+			continue
+		}
+		if seen[h.Key] {
+			return fmt.Errorf("commit: more than one %s", h.Key)
+		}
+		seen[h.Key] = true
+		if err := validateIdent(h.Value); err != nil {
+			return fmt.Errorf("commit %s: %w", h.Key, err)
+		}
+	}
+	return nil
+}
+
+func validateTag(f Format, data []byte) error {
+	t, err := ParseTag(data)
+	if err != nil {
+		return err
+	}
+	if !f.IsSHA(t.Object) {
+		return fmt.Errorf("tag: bad object %q", t.Object)
+	}
+	switch t.Type {
+	case TypeBlob, TypeTree, TypeCommit, TypeTag:
+	default:
+		return fmt.Errorf("tag: unknown type %q", t.Type)
+	}
+	if t.Name == "" {
+		return fmt.Errorf("tag: empty name")
+	}
+	if t.Tagger != nil {
+		headers, _, _ := parseHeaders(data)
+		if err := validateIdent(headers[3].Value); err != nil {
+			return fmt.Errorf("tag tagger: %w", err)
+		}
+	}
+	return nil
+}
+
+// validateIdent applies the checks parseSignature leaves out: the email
+// must not contain another "<", and the date must be plain digits without
+// leading zeros. parseSignature has already rejected dates that overflow.
+func validateIdent(s string) error {
+	lt := strings.IndexByte(s, '<')
+	gt := strings.IndexByte(s, '>')
+	if strings.ContainsRune(s[lt+1:gt], '<') {
+		return fmt.Errorf("bad email in %q", s)
+	}
+	date := strings.Fields(s[gt+1:])[0]
+	if strings.Trim(date, "0123456789") != "" || (len(date) > 1 && date[0] == '0') {
+		return fmt.Errorf("bad date in %q", s)
+	}
+	return nil
+}
+
+// IsSHA reports whether s is a lowercase hex SHA in format f.
+func (f Format) IsSHA(s string) bool {
+	return len(s) == 2*f.Size() && strings.Trim(s, "0123456789abcdef") == ""
+}
@@ -0,0 +1,86 @@
+package object
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateFixtures(t *testing.T) {
+	paths, _ := filepath.Glob(filepath.Join("testdata", "*-*"))
+	for _, path := range paths {
+		name := filepath.Base(path)
+		typ, _, _ := strings.Cut(name, "-")
+		if err := Validate(&Object{Type: ObjectType(typ), Data: readFixture(t, name)}); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	const (
+		sha   = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+		ident = "A <a@example.com> 1700000000 +0000"
+	)
+	entry := func(mode, name string) string {
+		return mode + " " + name + "\x00" + strings.Repeat("\x01", 20)
+	}
+	commit := func(headers string) *Object {
+		return &Object{Type: TypeCommit, Data: []byte(headers + "\nmessage\n")}
+	}
+
+	for name, tc := range map[string]struct {
+		obj  *Object
+		want string // empty if the object is valid
+	}{
+		"blob":             {&Object{Type: TypeBlob, Data: []byte("anything\x00")}, ""},
+		"unknown type":     {&Object{Type: "bolb"}, "unknown type"},
+		"empty tree":       {&Object{Type: TypeTree}, ""},
+		"sorted tree":      {&Object{Type: TypeTree, Data: []byte(entry(ModeBlob, "foo.txt") + entry(ModeTree, "foo") + entry(ModeGitlink, "sub"))}, ""},
+		"unsorted tree":    {&Object{Type: TypeTree, Data: []byte(entry(ModeTree, "foo") + entry(ModeBlob, "foo.txt"))}, "not sorted"},
+		"duplicate entry":  {&Object{Type: TypeTree, Data: []byte(entry(ModeBlob, "foo") + entry(ModeBlob, "foo.txt") + entry(ModeTree, "foo"))}, "duplicate"},
+		"bad mode":         {&Object{Type: TypeTree, Data: []byte(entry("100664", "a"))}, "bad mode"},
+		"zero-padded mode": {&Object{Type: TypeTree, Data: []byte(entry("040000", "a"))}, "bad mode"},
+		"slash":            {&Object{Type: TypeTree, Data: []byte(entry(ModeBlob, "a/b"))}, "slash"},
+		"dotdot":           {&Object{Type: TypeTree, Data: []byte(entry(ModeTree, ".."))}, "relative"},
+		".git":             {&Object{Type: TypeTree, Data: []byte(entry(ModeTree, ".git"))}, ".git directory"},
+		".GIT":             {&Object{Type: TypeTree, Data: []byte(entry(ModeTree, ".GIT"))}, ".git directory"},
+		".git. (NTFS)":     {&Object{Type: TypeTree, Data: []byte(entry(ModeTree, ".git. "))}, ".git directory"},
+		"git~1 (NTFS)":     {&Object{Type: TypeTree, Data: []byte(entry(ModeTree, "GIT~1"))}, ".git directory"},
+		".git (HFS+)":      {&Object{Type: TypeTree, Data: []byte(entry(ModeTree, ".g\u200cit"))}, ".git directory"},
+		".gitignore":       {&Object{Type: TypeTree, Data: []byte(entry(ModeBlob, ".gitignore"))}, ""},
+		".gitmodules link": {&Object{Type: TypeTree, Data: []byte(entry(ModeSymlink, ".gitmodules"))}, "symlink"},
+		".gitmodules file": {&Object{Type: TypeTree, Data: []byte(entry(ModeBlob, ".gitmodules"))}, ""},
+		"truncated tree":   {&Object{Type: TypeTree, Data: []byte("100644 a\x00\x01")}, "truncated"},
+
+		"commit":              {commit("tree " + sha + "\nparent " + sha + "\nauthor " + ident + "\ncommitter " + ident + "\n"), ""},
+		"no tree":             {commit("author " + ident + "\ncommitter " + ident + "\n"), "missing tree"},
+		"bad tree":            {commit("tree 4B825DC642CB6EB9A060E54BF8D69288FBEE4904\nauthor " + ident + "\ncommitter " + ident + "\n"), "bad tree"},
+		"bad parent":          {commit("tree " + sha + "\nparent abc\nauthor " + ident + "\ncommitter " + ident + "\n"), "bad parent"},
+		"no committer":        {commit("tree " + sha + "\nauthor " + ident + "\n"), "missing committer"},
+		"two authors":         {commit("tree " + sha + "\nauthor " + ident + "\ncommitter " + ident + "\nauthor " + ident + "\n"), "more than one author"},
+		"bracket in email":    {commit("tree " + sha + "\nauthor A <a<b@example.com> 1 +0000\ncommitter " + ident + "\n"), "bad email"},
+		"zero-padded date":    {commit("tree " + sha + "\nauthor A <a@example.com> 01 +0000\ncommitter " + ident + "\n"), "bad date"},
+		"signed date":         {commit("tree " + sha + "\nauthor A <a@example.com> +1 +0000\ncommitter " + ident + "\n"), "bad date"},
+		"overflowing date":    {commit("tree " + sha + "\nauthor A <a@example.com> 99999999999999999999 +0000\ncommitter " + ident + "\n"), "malformed date"},
+		"bad time zone":       {commit("tree " + sha + "\nauthor A <a@example.com> 1 +000\ncommitter " + ident + "\n"), "time zone"},
+		"no space before <":   {commit("tree " + sha + "\nauthor A<a@example.com> 1 +0000\ncommitter " + ident + "\n"), "malformed identity"},
+		"tag":                 {&Object{Type: TypeTag, Data: []byte("object " + sha + "\ntype tree\ntag v1\ntagger " + ident + "\n\nmsg\n")}, ""},
+		"tag without tagger":  {&Object{Type: TypeTag, Data: []byte("object " + sha + "\ntype tree\ntag v1\n\nmsg\n")}, ""},
+		"tag of unknown type": {&Object{Type: TypeTag, Data: []byte("object " + sha + "\ntype bolb\ntag v1\n\nmsg\n")}, "unknown type"},
+		"tag bad object":      {&Object{Type: TypeTag, Data: []byte("object 1234\ntype tree\ntag v1\n\nmsg\n")}, "bad object"},
+		"tag empty name":      {&Object{Type: TypeTag, Data: []byte("object " + sha + "\ntype tree\ntag \n\nmsg\n")}, "empty name"},
+		"tag bad tagger":      {&Object{Type: TypeTag, Data: []byte("object " + sha + "\ntype tree\ntag v1\ntagger A <a@example.com> 007 +0000\n\nmsg\n")}, "bad date"},
+	} {
+		err := Validate(tc.obj)
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", name, err)
+		case tc.want != "" && !errors.Is(err, ErrInvalid):
+			t.Errorf("%s: expected ErrInvalid, got %v", name, err)
+		case tc.want != "" && !strings.Contains(err.Error(), tc.want):
+			t.Errorf("%s: error %q does not mention %q", name, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package pack
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+// headerSize is the size of a pack's header, where its first entry starts.
+const headerSize = 12
+
+// Appender builds a pack in a file an entry at a time, for stores that
+// cannot know how many objects a pack will hold until they finish it. The
+// header counts no objects until Finish, and the pack has no index until
+// its owner writes one, so the objects appended so far are looked up in
+// memory. Every entry is written whole. An Appender is not safe for
+// concurrent use.
+type Appender struct {
+	f       *os.File
+	size    int64
+	entries []IndexEntry
+	objects map[string]appended
+}
+
+type appended struct {
+	typ    object.ObjectType
+	size   int64
+	offset int64
+	end    int64
+}
+
+// NewAppender creates an empty pack in dir, named as os.CreateTemp names
+// files after pattern.
+func NewAppender(dir, pattern string) (*Appender, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, store.Unavailable(err)
+	}
+	if _, err := f.Write(appendHeader(nil, 0)); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, store.Unavailable(err)
+	}
+	return newAppender(f), nil
+}
+
+func newAppender(f *os.File) *Appender {
+	return &Appender{f: f, size: headerSize, objects: make(map[string]appended)}
+}
+
+// RecoverAppender reopens the pack at path, which an Appender was writing
+// when its store stopped, keeping every entry up to the first one that was
+// not completely written and cutting off the rest. The header's count is
+// ignored, so a pack that had been sealed but not moved into place is
+// recovered too. A pack that ends before its first entry is recovered
+// empty.
+func RecoverAppender(path string) (*Appender, error) {
+	// a pack that was sealed is read-only
+	if err := os.Chmod(path, 0o644); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	a := newAppender(f)
+	if err := a.scan(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Truncate(a.size); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// scan reads back the entries of a recovered pack.
+func (a *Appender) scan() error {
+	cr := &crcReader{r: bufio.NewReaderSize(a.f, 64*1024), crc: crc32.NewIEEE()}
+	var hdr [headerSize]byte
+	if _, err := io.ReadFull(cr, hdr[:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			// nothing but, at most, part of the header was written
+			_, err = a.f.WriteAt(appendHeader(nil, 0), 0)
+		}
+		return err
+	}
+	if string(hdr[:4]) != signature {
+		return fmt.Errorf("invalid pack signature %q", hdr[:4])
+	}
+	if v := binary.BigEndian.Uint32(hdr[4:8]); v != version {
+		return fmt.Errorf("unsupported pack version %d", v)
+	}
+	for {
+		cr.crc.Reset()
+		sha, typ, size, ok := readEntry(cr)
+		if cr.err != nil {
+			return fmt.Errorf("read entry at %d: %w", a.size, cr.err)
+		}
+		if !ok {
+			return nil
+		}
+		a.record(sha, typ, size, cr.crc.Sum32(), cr.n)
+	}
+}
+
+// record notes that the entry for sha runs from the end of the pack to
+// end.
+func (a *Appender) record(sha string, typ object.ObjectType, size int64, crc uint32, end int64) {
+	a.entries = append(a.entries, IndexEntry{SHA: sha, Offset: a.size, CRC: crc})
+	a.objects[sha] = appended{typ: typ, size: size, offset: a.size, end: end}
+	a.size = end
+}
+
+// Name returns the name of the pack's file.
+func (a *Appender) Name() string {
+	return a.f.Name()
+}
+
+// Count returns how many objects have been appended.
+func (a *Appender) Count() int {
+	return len(a.entries)
+}
+
+// Size returns how many bytes the pack holds, not counting the checksum
+// Finish appends.
+func (a *Appender) Size() int64 {
+	return a.size
+}
+
+// Has reports whether sha has been appended.
+func (a *Appender) Has(sha string) bool {
+	_, ok := a.objects[sha]
+	return ok
+}
+
+// Find returns the offset of sha's entry, as Index.Find does.
+func (a *Appender) Find(sha string) (int64, bool) {
+	o, ok := a.objects[sha]
+	return o.offset, ok
+}
+
+// Add appends an entry for the size-byte object sha, read from body. A
+// failed write is cut off again, so the pack always ends with a complete
+// entry. Add gives up once ctx is done.
+func (a *Appender) Add(ctx context.Context, sha string, typ object.ObjectType, size int64, body io.Reader) error {
+	w := &fileWriter{ctx: ctx, w: io.NewOffsetWriter(a.f, a.size)}
+	n, crc, err := writeEntry(w, typ, size, body)
+	if err != nil {
+		a.f.Truncate(a.size)
+		return err
+	}
+	a.record(sha, typ, size, crc, a.size+n)
+	return nil
+}
+
+// AddStream appends the size-byte object read from r, hashing it as it is
+// written, and returns its SHA. It does not check whether the object has
+// been appended before.
+func (a *Appender) AddStream(ctx context.Context, typ object.ObjectType, size int64, r io.Reader) (string, error) {
+	h := object.SHA1.NewCodecWriter(io.Discard, object.None, typ, size)
+	w := &fileWriter{ctx: ctx, w: io.NewOffsetWriter(a.f, a.size)}
+	n, crc, err := writeEntry(w, typ, size, io.TeeReader(r, h))
+	if err == nil {
+		err = h.Close()
+	}
+	if err != nil {
+		a.f.Truncate(a.size)
+		return "", err
+	}
+	a.record(h.SHA(), typ, size, crc, a.size+n)
+	return h.SHA(), nil
+}
+
+// Get reads sha back from the pack. A failed read is ErrUnavailable, and
+// an entry that cannot be decoded ErrCorrupt.
+func (a *Appender) Get(sha string) (*object.Object, error) {
+	o, ok := a.objects[sha]
+	if !ok {
+		return nil, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
+	}
+	raw := make([]byte, o.end-o.offset)
+	if _, err := a.f.ReadAt(raw, o.offset); err != nil {
+		return nil, fmt.Errorf("get %s: %w", sha, store.Unavailable(err))
+	}
+	obj, err := decodeEntry(raw)
+	if err != nil {
+		return nil, fmt.Errorf("object %s: %w: %w", sha, store.ErrCorrupt, err)
+	}
+	return obj, nil
+}
+
+// Stat returns sha's type and size from memory.
+func (a *Appender) Stat(sha string) (object.ObjectType, int64, bool) {
+	o, ok := a.objects[sha]
+	return o.typ, o.size, ok
+}
+
+// Finish seals the pack, syncs it and closes its file, and returns its
+// checksum and the entries its index must list. If Finish fails, the file
+// is left for RecoverAppender.
+func (a *Appender) Finish() (string, []IndexEntry, error) {
+	sum, err := seal(a.f, a.size, len(a.entries))
+	if err == nil {
+		err = a.f.Sync()
+	}
+	if err == nil {
+		err = a.f.Close()
+	}
+	if err != nil {
+		return "", nil, store.Unavailable(err)
+	}
+	return sum, a.entries, nil
+}
+
+// Abort closes and removes the pack.
+func (a *Appender) Abort() {
+	a.f.Close()
+	os.Remove(a.f.Name())
+}
+
+// fileWriter writes to a pack being appended to, giving up once ctx is
+// done.
+type fileWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := w.w.Write(p)
+	if err != nil {
+		return n, store.Unavailable(err)
+	}
+	return n, nil
+}
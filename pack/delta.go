@@ -0,0 +1,93 @@
+package pack
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidDelta = errors.New("invalid delta")
+
+// ApplyDelta reconstructs a target object from base and a git delta
+// (the copy/insert instruction stream used by OFS_DELTA and REF_DELTA).
+func ApplyDelta(base, delta []byte) ([]byte, error) {
+	srcSize, n := deltaVarint(delta)
+	if n == 0 {
+		return nil, fmt.Errorf("%w: truncated source size", ErrInvalidDelta)
+	}
+	delta = delta[n:]
+	if srcSize != uint64(len(base)) {
+		return nil, fmt.Errorf("%w: base size %d, delta expects %d", ErrInvalidDelta, len(base), srcSize)
+	}
+
+	dstSize, n := deltaVarint(delta)
+	if n == 0 {
+		return nil, fmt.Errorf("%w: truncated target size", ErrInvalidDelta)
+	}
+	delta = delta[n:]
+	if dstSize > uint64(MaxObjectSize) {
+		return nil, fmt.Errorf("%w: target of %d bytes, limit is %d", ErrTooLarge, dstSize, MaxObjectSize)
+	}
+
+	out := make([]byte, 0, min(dstSize, maxPrealloc))
+	for len(delta) > 0 {
+		cmd := delta[0]
+		delta = delta[1:]
+
+		switch {
+		case cmd&0x80 != 0:
+			var off, size uint64
+			for i := range 4 {
+				if cmd&(1<<i) != 0 {
+					if len(delta) == 0 {
+						return nil, fmt.Errorf("%w: truncated copy offset", ErrInvalidDelta)
+					}
+					off |= uint64(delta[0]) << (8 * i)
+					delta = delta[1:]
+				}
+			}
+			for i := range 3 {
+				if cmd&(0x10<<i) != 0 {
+					if len(delta) == 0 {
+						return nil, fmt.Errorf("%w: truncated copy size", ErrInvalidDelta)
+					}
+					size |= uint64(delta[0]) << (8 * i)
+					delta = delta[1:]
+				}
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if off+size > uint64(len(base)) || uint64(len(out))+size > dstSize {
+				return nil, fmt.Errorf("%w: copy out of bounds", ErrInvalidDelta)
+			}
+			out = append(out, base[off:off+size]...)
+		case cmd != 0:
+			size := int(cmd)
+			if len(delta) < size || uint64(len(out)+size) > dstSize {
+				return nil, fmt.Errorf("%w: insert out of bounds", ErrInvalidDelta)
+			}
+			out = append(out, delta[:size]...)
+			delta = delta[size:]
+		default:
+			return nil, fmt.Errorf("%w: reserved opcode 0", ErrInvalidDelta)
+		}
+	}
+
+	if uint64(len(out)) != dstSize {
+		return nil, fmt.Errorf("%w: produced %d bytes, expected %d", ErrInvalidDelta, len(out), dstSize)
+	}
+	return out, nil
+}
+
+// deltaVarint reads the little-endian base-128 sizes at the start of a
+// delta. It returns the number of bytes consumed, or 0 if truncated.
+func deltaVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		v |= uint64(b[i]&0x7f) << (7 * i)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
@@ -0,0 +1,107 @@
+package pack
+
+const (
+	deltaBlockSize = 16
+	maxCopySize    = 0xffffff
+	maxInsertSize  = 0x7f
+)
+
+// CreateDelta returns a git delta that rebuilds target from base. Matches
+// are found by indexing base in fixed-size blocks, which is far simpler
+// than git's rolling hash but still finds the long shared runs that make
+// up most of the savings between versions of a file.
+func CreateDelta(base, target []byte) []byte {
+	out := appendDeltaVarint(nil, uint64(len(base)))
+	out = appendDeltaVarint(out, uint64(len(target)))
+
+	index := make(map[string]int, len(base)/deltaBlockSize)
+	for i := 0; i+deltaBlockSize <= len(base); i += deltaBlockSize {
+		key := string(base[i : i+deltaBlockSize])
+		if _, ok := index[key]; !ok {
+			index[key] = i
+		}
+	}
+
+	var insert []byte
+	flushInsert := func() {
+		for len(insert) > 0 {
+			n := min(len(insert), maxInsertSize)
+			out = append(out, byte(n))
+			out = append(out, insert[:n]...)
+			insert = insert[n:]
+		}
+	}
+
+	for i := 0; i < len(target); {
+		off, ok := -1, false
+		if i+deltaBlockSize <= len(target) {
+			off, ok = index[string(target[i:i+deltaBlockSize])]
+		}
+		if !ok {
+			insert = append(insert, target[i])
+			i++
+			continue
+		}
+
+		// extend the match backwards into pending inserts, then forwards
+		for off > 0 && len(insert) > 0 && base[off-1] == insert[len(insert)-1] {
+			off--
+			i--
+			insert = insert[:len(insert)-1]
+		}
+		n := deltaBlockSize
+		for off+n < len(base) && i+n < len(target) && base[off+n] == target[i+n] {
+			n++
+		}
+
+		flushInsert()
+		for n > 0 {
+			size := min(n, maxCopySize)
+			out = appendCopyOp(out, off, size)
+			off += size
+			i += size
+			n -= size
+		}
+	}
+	flushInsert()
+
+	return out
+}
+
+// Delta returns a delta that rebuilds target from base if it is worth
+// storing in place of target, which by git's rule means it is under half
+// target's size.
+func Delta(base, target []byte) ([]byte, bool) {
+	delta := CreateDelta(base, target)
+	if len(delta) >= len(target)/2-20 {
+		return nil, false
+	}
+	return delta, true
+}
+
+func appendCopyOp(out []byte, off, size int) []byte {
+	cmd := byte(0x80)
+	var args []byte
+	for i := range 4 {
+		if b := byte(off >> (8 * i)); b != 0 {
+			cmd |= 1 << i
+			args = append(args, b)
+		}
+	}
+	for i := range 3 {
+		if b := byte(size >> (8 * i)); b != 0 {
+			cmd |= 0x10 << i
+			args = append(args, b)
+		}
+	}
+	out = append(out, cmd)
+	return append(out, args...)
+}
+
+func appendDeltaVarint(out []byte, v uint64) []byte {
+	for v >= 0x80 {
+		out = append(out, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(out, byte(v))
+}
@@ -0,0 +1,171 @@
+package pack
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sync"
+
+	"git.wyat.me/git-storage/object"
+)
+
+var zlibWriters = sync.Pool{
+	New: func() any { return zlib.NewWriter(nil) },
+}
+
+// appendHeader appends the 12-byte header of a pack of count objects.
+func appendHeader(buf []byte, count int) []byte {
+	buf = append(buf, signature...)
+	buf = binary.BigEndian.AppendUint32(buf, version)
+	return binary.BigEndian.AppendUint32(buf, uint32(count))
+}
+
+// writeEntry writes the size-byte body read from body to w as a whole
+// entry, and returns how many bytes the entry took and their CRC-32, which
+// the pack's index records.
+func writeEntry(w io.Writer, typ object.ObjectType, size int64, body io.Reader) (int64, uint32, error) {
+	et, err := entryTypeOf(typ)
+	if err != nil {
+		return 0, 0, err
+	}
+	cw := &crcWriter{w: w, crc: crc32.NewIEEE()}
+	if _, err := cw.Write(appendEntryHeader(nil, et, size)); err != nil {
+		return 0, 0, fmt.Errorf("write entry: %w", err)
+	}
+	zw := zlibWriters.Get().(*zlib.Writer)
+	defer zlibWriters.Put(zw)
+	zw.Reset(cw)
+	n, err := io.Copy(zw, io.LimitReader(body, size))
+	if err != nil {
+		return 0, 0, fmt.Errorf("write entry: %w", err)
+	}
+	if n != size {
+		return 0, 0, fmt.Errorf("object body is %d bytes, declared %d", n, size)
+	}
+	if err := zw.Close(); err != nil {
+		return 0, 0, fmt.Errorf("write entry: %w", err)
+	}
+	return cw.n, cw.crc.Sum32(), nil
+}
+
+// decodeEntry decodes raw, one whole entry as writeEntry wrote it.
+func decodeEntry(raw []byte) (*object.Object, error) {
+	br := bytes.NewReader(raw)
+	et, size, err := readEntryHeader(br)
+	if err != nil {
+		return nil, fmt.Errorf("read entry header: %w", noEOF(err))
+	}
+	typ, err := et.objectType()
+	if err != nil {
+		return nil, err
+	}
+	data, err := inflate(br, size)
+	if err != nil {
+		return nil, fmt.Errorf("inflate entry: %w", err)
+	}
+	return &object.Object{Type: typ, Data: data}, nil
+}
+
+// readEntry hashes the whole object in the entry at cr's position,
+// reporting false if there is no complete entry there.
+func readEntry(cr *crcReader) (string, object.ObjectType, int64, bool) {
+	et, size, err := readEntryHeader(cr)
+	if err != nil {
+		return "", "", 0, false
+	}
+	typ, err := et.objectType()
+	if err != nil {
+		return "", "", 0, false
+	}
+	zr, err := zlib.NewReader(cr)
+	if err != nil {
+		return "", "", 0, false
+	}
+	defer zr.Close()
+	w := object.SHA1.NewCodecWriter(io.Discard, object.None, typ, size)
+	if _, err := io.Copy(w, zr); err != nil {
+		return "", "", 0, false
+	}
+	if err := w.Close(); err != nil {
+		return "", "", 0, false
+	}
+	return w.SHA(), typ, size, true
+}
+
+// seal finishes a pack that was written with a placeholder count: it
+// writes count into the header of the size-byte pack in f, then appends
+// the pack's checksum, which it returns.
+func seal(f interface {
+	io.ReaderAt
+	io.WriterAt
+}, size int64, count int) (string, error) {
+	if _, err := f.WriteAt(appendHeader(nil, count), 0); err != nil {
+		return "", fmt.Errorf("write pack header: %w", err)
+	}
+	h := sha1.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, size)); err != nil {
+		return "", fmt.Errorf("checksum pack: %w", err)
+	}
+	sum := h.Sum(nil)
+	if _, err := f.WriteAt(sum, size); err != nil {
+		return "", fmt.Errorf("write pack trailer: %w", err)
+	}
+	return hex.EncodeToString(sum), nil
+}
+
+// crcWriter counts, and computes the CRC-32 of, what is written to w.
+type crcWriter struct {
+	w   io.Writer
+	crc hash.Hash32
+	n   int64
+}
+
+func (c *crcWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.crc.Write(p[:n])
+	c.n += int64(n)
+	return n, err
+}
+
+// crcReader is scanner with a CRC-32 in place of the pack's checksum. It
+// keeps any error other than io.EOF from r, so that a pack that ends part
+// way through an entry can be told from one that failed to be read.
+type crcReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+	n   int64
+	err error
+}
+
+func (c *crcReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.crc.Write(p[:n])
+	c.n += int64(n)
+	c.keep(err)
+	return n, err
+}
+
+func (c *crcReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err != nil {
+		c.keep(err)
+		return 0, err
+	}
+	c.crc.Write([]byte{b})
+	c.n++
+	return b, nil
+}
+
+func (c *crcReader) keep(err error) {
+	if err != nil && !errors.Is(err, io.EOF) && c.err == nil {
+		c.err = err
+	}
+}
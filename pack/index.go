@@ -0,0 +1,228 @@
+package pack
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A v2 pack index (.idx) lists every object in a pack sorted by SHA:
+// magic and version, a fan-out table counting the objects whose SHA's
+// first byte is at most each value, the SHAs, the CRC-32 of each packed
+// entry, 31-bit offsets whose top bit instead points into a table of
+// 64-bit ones, and finally the pack's checksum and its own.
+const (
+	indexMagic   = "\377tOc"
+	indexVersion = 2
+	fanoutSize   = 256 * 4
+	// largeOffset flags a 31-bit offset as an index into the 64-bit table.
+	largeOffset = 1 << 31
+)
+
+var ErrInvalidIndex = errors.New("invalid pack index")
+
+// IndexEntry is what an index records about one object in a pack.
+type IndexEntry struct {
+	SHA    string
+	Offset int64
+	// CRC is the CRC-32 of the object's entry as it is stored in the pack.
+	CRC uint32
+}
+
+// Index looks objects up in a parsed v2 pack index. Its tables are slices
+// of the data it was parsed from, which may be memory-mapped and must not
+// change while the Index is in use.
+type Index struct {
+	fanout  []byte
+	shas    []byte
+	crcs    []byte
+	offsets []byte
+	large   []byte
+	pack    []byte
+
+	// ends is every entry's offset in pack order, for finding where an
+	// entry ends, sorted the first time it is needed.
+	once sync.Once
+	ends []int64
+}
+
+// ParseIndex parses a v2 pack index, checking its structure and checksum.
+func ParseIndex(data []byte) (*Index, error) {
+	if len(data) < 8+fanoutSize+2*sha1.Size {
+		return nil, fmt.Errorf("%w: %d bytes", ErrInvalidIndex, len(data))
+	}
+	if string(data[:4]) != indexMagic {
+		return nil, fmt.Errorf("%w: no v2 signature", ErrInvalidIndex)
+	}
+	if v := binary.BigEndian.Uint32(data[4:8]); v != indexVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidIndex, v)
+	}
+	trailer := len(data) - sha1.Size
+	if sum := sha1.Sum(data[:trailer]); !bytes.Equal(sum[:], data[trailer:]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidIndex)
+	}
+
+	x := &Index{fanout: data[8 : 8+fanoutSize]}
+	var prev uint32
+	for i := range 256 {
+		n := binary.BigEndian.Uint32(x.fanout[i*4:])
+		if n < prev {
+			return nil, fmt.Errorf("%w: fan-out table decreases at %#02x", ErrInvalidIndex, i)
+		}
+		prev = n
+	}
+	n := int(prev)
+	tables := 8 + fanoutSize + n*(sha1.Size+4+4)
+	if tables > trailer-sha1.Size || (trailer-sha1.Size-tables)%8 != 0 {
+		return nil, fmt.Errorf("%w: %d bytes for %d objects", ErrInvalidIndex, len(data), n)
+	}
+	rest := data[8+fanoutSize:]
+	x.shas, rest = rest[:n*sha1.Size], rest[n*sha1.Size:]
+	x.crcs, rest = rest[:n*4], rest[n*4:]
+	x.offsets, rest = rest[:n*4], rest[n*4:]
+	x.large = rest[:trailer-sha1.Size-tables]
+	x.pack = data[trailer-sha1.Size : trailer]
+
+	for i := range n {
+		if i > 0 && bytes.Compare(x.sha(i-1), x.sha(i)) >= 0 {
+			return nil, fmt.Errorf("%w: objects out of order at %d", ErrInvalidIndex, i)
+		}
+		if v := binary.BigEndian.Uint32(x.offsets[i*4:]); v&largeOffset != 0 && int(v&^largeOffset) >= len(x.large)/8 {
+			return nil, fmt.Errorf("%w: 64-bit offset %d out of range", ErrInvalidIndex, v&^largeOffset)
+		}
+	}
+	return x, nil
+}
+
+// Count is the number of objects in the pack.
+func (x *Index) Count() int {
+	return len(x.shas) / sha1.Size
+}
+
+// PackChecksum is the checksum of the pack the index belongs to, which
+// names both files.
+func (x *Index) PackChecksum() string {
+	return hex.EncodeToString(x.pack)
+}
+
+func (x *Index) sha(i int) []byte {
+	return x.shas[i*sha1.Size : (i+1)*sha1.Size]
+}
+
+// SHA returns the i'th SHA in the index, which lists them in order.
+func (x *Index) SHA(i int) string {
+	return hex.EncodeToString(x.sha(i))
+}
+
+// Offset returns where the i'th object's entry starts in the pack.
+func (x *Index) Offset(i int) int64 {
+	v := binary.BigEndian.Uint32(x.offsets[i*4:])
+	if v&largeOffset == 0 {
+		return int64(v)
+	}
+	return int64(binary.BigEndian.Uint64(x.large[(v&^largeOffset)*8:]))
+}
+
+// Find returns the offset of sha's entry in the pack, reporting false if
+// the pack does not hold it. The fan-out table narrows the search to the
+// SHAs sharing its first byte, which are then binary searched.
+func (x *Index) Find(sha string) (int64, bool) {
+	var want [sha1.Size]byte
+	if len(sha) != 2*sha1.Size {
+		return 0, false
+	}
+	if _, err := hex.Decode(want[:], []byte(sha)); err != nil {
+		return 0, false
+	}
+	lo := 0
+	if want[0] > 0 {
+		lo = int(binary.BigEndian.Uint32(x.fanout[(int(want[0])-1)*4:]))
+	}
+	hi := int(binary.BigEndian.Uint32(x.fanout[int(want[0])*4:]))
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(x.sha(lo+i), want[:]) >= 0
+	})
+	if i == hi || !bytes.Equal(x.sha(i), want[:]) {
+		return 0, false
+	}
+	return x.Offset(i), true
+}
+
+// entryEnd returns where the entry starting at offset ends: at the next
+// entry, or at end for the pack's last entry. It reports false if no entry
+// starts at offset.
+func (x *Index) entryEnd(offset, end int64) (int64, bool) {
+	x.once.Do(func() {
+		x.ends = make([]int64, x.Count())
+		for i := range x.ends {
+			x.ends[i] = x.Offset(i)
+		}
+		slices.Sort(x.ends)
+	})
+	i, ok := slices.BinarySearch(x.ends, offset)
+	if !ok {
+		return 0, false
+	}
+	if i+1 < len(x.ends) {
+		return x.ends[i+1], true
+	}
+	return end, true
+}
+
+// WriteIndex writes a v2 index of entries, which need not be sorted, for
+// the pack with checksum packChecksum.
+func WriteIndex(w io.Writer, entries []IndexEntry, packChecksum string) error {
+	pack, err := hex.DecodeString(packChecksum)
+	if err != nil || len(pack) != sha1.Size {
+		return fmt.Errorf("invalid pack checksum %q", packChecksum)
+	}
+	// lowercase hex sorts as the bytes it encodes do
+	sorted := slices.SortedFunc(slices.Values(entries), func(a, b IndexEntry) int {
+		return strings.Compare(a.SHA, b.SHA)
+	})
+
+	h := sha1.New()
+	buf := append([]byte(indexMagic), 0, 0, 0, indexVersion)
+	var fanout [256]uint32
+	shas := make([]byte, 0, len(sorted)*sha1.Size)
+	for _, e := range sorted {
+		sha, err := hex.DecodeString(e.SHA)
+		if err != nil || len(sha) != sha1.Size {
+			return fmt.Errorf("invalid SHA %q", e.SHA)
+		}
+		shas = append(shas, sha...)
+		fanout[sha[0]]++
+	}
+	var total uint32
+	for _, n := range fanout {
+		total += n
+		buf = binary.BigEndian.AppendUint32(buf, total)
+	}
+	buf = append(buf, shas...)
+	for _, e := range sorted {
+		buf = binary.BigEndian.AppendUint32(buf, e.CRC)
+	}
+	var large []byte
+	for _, e := range sorted {
+		if e.Offset < largeOffset {
+			buf = binary.BigEndian.AppendUint32(buf, uint32(e.Offset))
+			continue
+		}
+		buf = binary.BigEndian.AppendUint32(buf, largeOffset|uint32(len(large)/8))
+		large = binary.BigEndian.AppendUint64(large, uint64(e.Offset))
+	}
+	buf = append(append(buf, large...), pack...)
+	h.Write(buf)
+	if _, err := w.Write(h.Sum(buf)); err != nil {
+		return fmt.Errorf("write index: %w", err)
+	}
+	return nil
+}
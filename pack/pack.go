@@ -0,0 +1,145 @@
+package pack
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"git.wyat.me/git-storage/object"
+)
+
+// EntryType is the 3-bit object type stored in each packfile entry header.
+type EntryType byte
+
+const (
+	TypeCommit   EntryType = 1
+	TypeTree     EntryType = 2
+	TypeBlob     EntryType = 3
+	TypeTag      EntryType = 4
+	TypeOfsDelta EntryType = 6
+	TypeRefDelta EntryType = 7
+)
+
+const (
+	signature = "PACK"
+	version   = 2
+)
+
+var (
+	ErrChecksum = errors.New("pack checksum mismatch")
+	ErrTooLarge = errors.New("object too large")
+)
+
+// MaxObjectSize is the largest object, or delta, a pack entry may declare.
+// Sizes in entry and delta headers come from whoever sent the pack, so
+// anything over it is refused before any memory is set aside for it.
+var MaxObjectSize int64 = 4 << 30
+
+// maxPrealloc caps how much is allocated up front from a declared size;
+// buffers grow past it only as data actually arrives.
+const maxPrealloc = 1 << 20
+
+// checkSize refuses a size read from an entry or delta header that is
+// over MaxObjectSize.
+func checkSize(size int64) error {
+	if size < 0 || size > MaxObjectSize {
+		return fmt.Errorf("%w: %d bytes declared, limit is %d", ErrTooLarge, size, MaxObjectSize)
+	}
+	return nil
+}
+
+func entryTypeOf(t object.ObjectType) (EntryType, error) {
+	switch t {
+	case object.TypeCommit:
+		return TypeCommit, nil
+	case object.TypeTree:
+		return TypeTree, nil
+	case object.TypeBlob:
+		return TypeBlob, nil
+	case object.TypeTag:
+		return TypeTag, nil
+	}
+	return 0, fmt.Errorf("unknown object type %q", t)
+}
+
+func (t EntryType) objectType() (object.ObjectType, error) {
+	switch t {
+	case TypeCommit:
+		return object.TypeCommit, nil
+	case TypeTree:
+		return object.TypeTree, nil
+	case TypeBlob:
+		return object.TypeBlob, nil
+	case TypeTag:
+		return object.TypeTag, nil
+	}
+	return "", fmt.Errorf("invalid object type %d", t)
+}
+
+// readEntryHeader decodes the variable-length type and size that starts
+// every packfile entry.
+func readEntryHeader(r io.ByteReader) (EntryType, int64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	typ := EntryType((b >> 4) & 0x7)
+	size := int64(b & 0x0f)
+	shift := 4
+	for b&0x80 != 0 {
+		if shift > 60 {
+			return 0, 0, errors.New("entry size overflow")
+		}
+		b, err = r.ReadByte()
+		if err != nil {
+			return 0, 0, err
+		}
+		size |= int64(b&0x7f) << shift
+		shift += 7
+	}
+	return typ, size, nil
+}
+
+// readOfsDeltaOffset decodes the base offset of an OFS_DELTA entry, which
+// git stores as a big-endian varint with an implicit +1 per continuation.
+func readOfsDeltaOffset(r io.ByteReader) (int64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	off := int64(b & 0x7f)
+	for b&0x80 != 0 {
+		if off > 1<<55 {
+			return 0, errors.New("delta offset overflow")
+		}
+		b, err = r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		off = ((off + 1) << 7) | int64(b&0x7f)
+	}
+	return off, nil
+}
+
+func appendEntryHeader(buf []byte, typ EntryType, size int64) []byte {
+	b := byte(typ)<<4 | byte(size&0x0f)
+	size >>= 4
+	for size != 0 {
+		buf = append(buf, b|0x80)
+		b = byte(size & 0x7f)
+		size >>= 7
+	}
+	return append(buf, b)
+}
+
+func appendOfsDeltaOffset(buf []byte, off int64) []byte {
+	var tmp [10]byte
+	i := len(tmp) - 1
+	tmp[i] = byte(off & 0x7f)
+	for off >>= 7; off != 0; off >>= 7 {
+		off--
+		i--
+		tmp[i] = 0x80 | byte(off&0x7f)
+	}
+	return append(buf, tmp[i:]...)
+}
@@ -0,0 +1,330 @@
+package pack
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+const (
+	// maxChain bounds how many deltas are followed to reach a whole
+	// object, so that deltas forming a cycle in a corrupt pack cannot
+	// loop forever. git's --depth tops out at 4095.
+	maxChain = 4095
+	// headerPrefix is enough of an entry to hold any header: up to 10
+	// bytes of type and size, then a 20-byte REF_DELTA base or a shorter
+	// OFS_DELTA offset.
+	headerPrefix = 32
+	// openBuffer is how much of a streamed entry is read from the pack at
+	// a time.
+	openBuffer = 256 << 10
+)
+
+// Packfile reads objects out of a pack on demand, finding them with the
+// pack's index, rather than unpacking it. Each object is read with as few
+// ReadAt calls as its delta chain allows, so r may be a memory-mapped file
+// or ranged reads of a remote one. Errors are classified as the store
+// package's: damage to the pack is ErrCorrupt and a failing ReadAt is
+// ErrUnavailable.
+type Packfile struct {
+	r    io.ReaderAt
+	idx  *Index
+	size int64
+}
+
+// NewPackfile returns a Packfile reading the size-byte pack in r, which
+// idx indexes.
+func NewPackfile(r io.ReaderAt, idx *Index, size int64) *Packfile {
+	return &Packfile{r: r, idx: idx, size: size}
+}
+
+func (p *Packfile) Index() *Index {
+	return p.idx
+}
+
+// entry is a parsed entry header. For a delta, size is the size of the
+// delta itself and base the offset of the entry it applies to. data holds
+// the compressed data if it was read along with the header.
+type entry struct {
+	typ    EntryType
+	size   int64
+	offset int64
+	body   int64
+	end    int64
+	base   int64
+	data   []byte
+}
+
+func (e entry) isDelta() bool {
+	return e.typ == TypeOfsDelta || e.typ == TypeRefDelta
+}
+
+// Get reads sha and applies any deltas it is stored as.
+func (p *Packfile) Get(sha string) (*object.Object, error) {
+	offset, ok := p.idx.Find(sha)
+	if !ok {
+		return nil, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
+	}
+	obj, err := p.objectAt(offset)
+	if err != nil {
+		return nil, fmt.Errorf("object %s: %w", sha, err)
+	}
+	return obj, nil
+}
+
+// Stat returns sha's type and size. It reads only the headers along its
+// delta chain, and the start of the delta sha is stored as, if any.
+func (p *Packfile) Stat(sha string) (object.ObjectType, int64, error) {
+	offset, ok := p.idx.Find(sha)
+	if !ok {
+		return "", 0, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
+	}
+	typ, size, err := p.stat(offset)
+	if err != nil {
+		return "", 0, fmt.Errorf("object %s: %w", sha, err)
+	}
+	return typ, size, nil
+}
+
+func (p *Packfile) stat(offset int64) (object.ObjectType, int64, error) {
+	e, err := p.entry(offset, false)
+	if err != nil {
+		return "", 0, err
+	}
+	size := e.size
+	if e.isDelta() {
+		if size, err = p.deltaSize(e); err != nil {
+			return "", 0, err
+		}
+	}
+	for depth := 0; e.isDelta(); depth++ {
+		if depth == maxChain {
+			return "", 0, fmt.Errorf("%w: entry at %d: delta chain longer than %d", store.ErrCorrupt, offset, maxChain)
+		}
+		if e, err = p.entry(e.base, false); err != nil {
+			return "", 0, err
+		}
+	}
+	typ, _ := e.typ.objectType()
+	return typ, size, nil
+}
+
+// Open returns a reader over sha's body. Whole entries are inflated as
+// they are read; deltas have to be applied in memory first.
+func (p *Packfile) Open(sha string) (*object.Reader, error) {
+	offset, ok := p.idx.Find(sha)
+	if !ok {
+		return nil, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
+	}
+	e, err := p.entry(offset, false)
+	if err != nil {
+		return nil, fmt.Errorf("object %s: %w", sha, err)
+	}
+	if e.isDelta() {
+		obj, err := p.objectAt(offset)
+		if err != nil {
+			return nil, fmt.Errorf("object %s: %w", sha, err)
+		}
+		body := io.NopCloser(bytes.NewReader(obj.Data))
+		return object.NewBodyReader(obj.Type, int64(len(obj.Data)), body), nil
+	}
+	zr, err := zlib.NewReader(bufio.NewReaderSize(p.section(e), openBuffer))
+	if err != nil {
+		return nil, fmt.Errorf("object %s: %w", sha, p.corrupt(offset, err))
+	}
+	typ, _ := e.typ.objectType()
+	return object.NewBodyReader(typ, e.size, zr), nil
+}
+
+// objectAt reads the object whose entry starts at offset, collecting its
+// delta chain down to a whole object and applying it back up. Each entry
+// along the chain is read in one ReadAt.
+func (p *Packfile) objectAt(offset int64) (*object.Object, error) {
+	e, err := p.entry(offset, true)
+	if err != nil {
+		return nil, err
+	}
+	var chain []entry
+	for e.isDelta() {
+		if len(chain) == maxChain {
+			return nil, fmt.Errorf("%w: entry at %d: delta chain longer than %d", store.ErrCorrupt, offset, maxChain)
+		}
+		chain = append(chain, e)
+		if e, err = p.entry(e.base, true); err != nil {
+			return nil, err
+		}
+	}
+	typ, _ := e.typ.objectType()
+	data, err := p.inflate(e)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		delta, err := p.inflate(chain[i])
+		if err != nil {
+			return nil, err
+		}
+		if data, err = ApplyDelta(data, delta); err != nil {
+			return nil, p.corrupt(chain[i].offset, err)
+		}
+	}
+	return &object.Object{Type: typ, Data: data}, nil
+}
+
+// entry reads the header of the entry at offset, which the index must
+// list, and works out where the entry ends from the next one. If whole is
+// set, the compressed data is read in the same ReadAt as the header.
+func (p *Packfile) entry(offset int64, whole bool) (entry, error) {
+	end, ok := p.idx.entryEnd(offset, p.size-sha1.Size)
+	if !ok {
+		return entry{}, fmt.Errorf("%w: no entry at offset %d", store.ErrCorrupt, offset)
+	}
+	if offset < 12 || end <= offset || end > p.size-sha1.Size {
+		return entry{}, fmt.Errorf("%w: entry at %d outside the %d-byte pack", store.ErrCorrupt, offset, p.size)
+	}
+	n := end - offset
+	if !whole {
+		n = min(headerPrefix, n)
+	}
+	buf := make([]byte, n)
+	if err := p.readAt(buf, offset); err != nil {
+		return entry{}, err
+	}
+	br := bytes.NewReader(buf)
+	typ, size, err := readEntryHeader(br)
+	if err != nil {
+		return entry{}, p.corrupt(offset, noEOF(err))
+	}
+	if err := checkSize(size); err != nil {
+		return entry{}, p.corrupt(offset, err)
+	}
+	e := entry{typ: typ, size: size, offset: offset, end: end}
+	switch typ {
+	case TypeOfsDelta:
+		rel, err := readOfsDeltaOffset(br)
+		if err != nil {
+			return entry{}, p.corrupt(offset, noEOF(err))
+		}
+		if rel <= 0 || rel > offset {
+			return entry{}, p.corrupt(offset, errors.New("invalid delta base offset"))
+		}
+		e.base = offset - rel
+	case TypeRefDelta:
+		var base [sha1.Size]byte
+		if _, err := io.ReadFull(br, base[:]); err != nil {
+			return entry{}, p.corrupt(offset, err)
+		}
+		// packs kept on disk are never thin, so the base must be here too
+		if e.base, ok = p.idx.Find(hex.EncodeToString(base[:])); !ok {
+			return entry{}, p.corrupt(offset, fmt.Errorf("delta base %x not in pack", base))
+		}
+	default:
+		if _, err := typ.objectType(); err != nil {
+			return entry{}, p.corrupt(offset, err)
+		}
+	}
+	e.body = offset + int64(len(buf)-br.Len())
+	if whole {
+		e.data = buf[e.body-offset:]
+	}
+	return e, nil
+}
+
+// inflate inflates e's compressed data, reading it in one ReadAt unless
+// it was read with the header.
+func (p *Packfile) inflate(e entry) ([]byte, error) {
+	raw := e.data
+	if raw == nil {
+		raw = make([]byte, e.end-e.body)
+		if err := p.readAt(raw, e.body); err != nil {
+			return nil, err
+		}
+	}
+	data, err := inflate(bytes.NewReader(raw), e.size)
+	if err != nil {
+		return nil, p.corrupt(e.offset, err)
+	}
+	return data, nil
+}
+
+// deltaSize inflates just enough of delta entry e to read the size of the
+// object it produces.
+func (p *Packfile) deltaSize(e entry) (int64, error) {
+	zr, err := zlib.NewReader(p.section(e))
+	if err != nil {
+		return 0, p.corrupt(e.offset, err)
+	}
+	defer zr.Close()
+	// two varints of at most 10 bytes each
+	buf := make([]byte, min(20, e.size))
+	if _, err := io.ReadFull(zr, buf); err != nil {
+		return 0, p.corrupt(e.offset, err)
+	}
+	_, n := deltaVarint(buf)
+	if n == 0 {
+		return 0, p.corrupt(e.offset, ErrInvalidDelta)
+	}
+	size, m := deltaVarint(buf[n:])
+	if m == 0 || size > 1<<62 {
+		return 0, p.corrupt(e.offset, ErrInvalidDelta)
+	}
+	return int64(size), nil
+}
+
+// section returns a reader over e's compressed data.
+func (p *Packfile) section(e entry) io.Reader {
+	return &sectionReader{p: p, off: e.body, end: e.end}
+}
+
+// readAt fills b from the pack at off. Reading past the end of the pack
+// means the index does not match it.
+func (p *Packfile) readAt(b []byte, off int64) error {
+	n, err := p.r.ReadAt(b, off)
+	switch {
+	case n == len(b):
+		return nil
+	case err == nil || err == io.EOF:
+		return fmt.Errorf("%w: pack truncated at %d", store.ErrCorrupt, off+int64(n))
+	case errors.Is(err, store.ErrUnavailable):
+		return err
+	}
+	return fmt.Errorf("read pack: %w", store.Unavailable(err))
+}
+
+// corrupt marks err, from decoding the entry at offset, as ErrCorrupt,
+// unless it came from reading the pack and is already classified.
+func (p *Packfile) corrupt(offset int64, err error) error {
+	if errors.Is(err, store.ErrCorrupt) || errors.Is(err, store.ErrUnavailable) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return fmt.Errorf("%w: entry at %d: %w", store.ErrCorrupt, offset, err)
+}
+
+// sectionReader is io.SectionReader with readAt's error handling.
+type sectionReader struct {
+	p   *Packfile
+	off int64
+	end int64
+}
+
+func (s *sectionReader) Read(b []byte) (int, error) {
+	if s.off == s.end {
+		return 0, io.EOF
+	}
+	b = b[:min(int64(len(b)), s.end-s.off)]
+	if err := s.p.readAt(b, s.off); err != nil {
+		return 0, err
+	}
+	s.off += int64(len(b))
+	return len(b), nil
+}
@@ -0,0 +1,278 @@
+package pack
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+// indexed returns b's pack and an index of it, naming the objects shas in
+// the order they were added.
+func indexed(t *testing.T, b *packBuilder, shas ...string) ([]byte, *Index) {
+	t.Helper()
+	data := b.bytes()
+	entries := make([]IndexEntry, len(shas))
+	for i, sha := range shas {
+		end := int64(len(data) - 20)
+		if i+1 < len(b.offsets) {
+			end = b.offsets[i+1]
+		}
+		raw := data[b.offsets[i]:end]
+		entries[i] = IndexEntry{SHA: sha, Offset: b.offsets[i], CRC: crc32.ChecksumIEEE(raw)}
+	}
+	var buf bytes.Buffer
+	if err := WriteIndex(&buf, entries, hex.EncodeToString(data[len(data)-20:])); err != nil {
+		t.Fatalf("WriteIndex failed: %v", err)
+	}
+	idx, err := ParseIndex(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ParseIndex failed: %v", err)
+	}
+	return data, idx
+}
+
+func TestIndexRoundtrip(t *testing.T) {
+	entries := []IndexEntry{
+		{SHA: helloWorldSHA, Offset: 100, CRC: 2},
+		{SHA: helloSHA, Offset: 12, CRC: 1},
+		{SHA: helloThereSHA, Offset: 5 << 30, CRC: 3},
+	}
+	var buf bytes.Buffer
+	if err := WriteIndex(&buf, entries, helloSHA); err != nil {
+		t.Fatalf("WriteIndex failed: %v", err)
+	}
+	idx, err := ParseIndex(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ParseIndex failed: %v", err)
+	}
+
+	if idx.Count() != 3 {
+		t.Errorf("Count = %d, want 3", idx.Count())
+	}
+	if idx.PackChecksum() != helloSHA {
+		t.Errorf("PackChecksum = %s, want %s", idx.PackChecksum(), helloSHA)
+	}
+	for i, want := range []string{helloWorldSHA, helloThereSHA, helloSHA} {
+		if got := idx.SHA(i); got != want {
+			t.Errorf("SHA(%d) = %s, want %s", i, got, want)
+		}
+	}
+	for _, e := range entries {
+		offset, ok := idx.Find(e.SHA)
+		if !ok || offset != e.Offset {
+			t.Errorf("Find(%s) = %d, %v, want %d", e.SHA, offset, ok, e.Offset)
+		}
+	}
+	for _, sha := range []string{
+		"0000000000000000000000000000000000000000",
+		"94954abda49de8615a048f8d2e64b5de848e27a0",
+		"ffffffffffffffffffffffffffffffffffffffff",
+		"not a sha",
+	} {
+		if _, ok := idx.Find(sha); ok {
+			t.Errorf("Find(%s) found an object", sha)
+		}
+	}
+
+	data := buf.Bytes()
+	data[len(data)-1] ^= 0xff
+	if _, err := ParseIndex(data); !errors.Is(err, ErrInvalidIndex) {
+		t.Errorf("ParseIndex of a damaged index: got %v, want ErrInvalidIndex", err)
+	}
+}
+
+func TestPackfileGet(t *testing.T) {
+	base, _ := hex.DecodeString(helloSHA)
+
+	var b packBuilder
+	b.entry(TypeBlob, nil, []byte("hello\n"))
+	b.entry(TypeOfsDelta, []byte{byte(b.next() - b.offsets[0])}, copyInsertDelta(6, "world\n"))
+	b.entry(TypeRefDelta, base, copyInsertDelta(6, "there\n"))
+	data, idx := indexed(t, &b, helloSHA, helloWorldSHA, helloThereSHA)
+	p := NewPackfile(bytes.NewReader(data), idx, int64(len(data)))
+
+	want := map[string]string{
+		helloSHA:      "hello\n",
+		helloWorldSHA: "hello\nworld\n",
+		helloThereSHA: "hello\nthere\n",
+	}
+	for sha, body := range want {
+		obj, err := p.Get(sha)
+		if err != nil {
+			t.Fatalf("Get %s failed: %v", sha, err)
+		}
+		if obj.Type != object.TypeBlob || string(obj.Data) != body {
+			t.Errorf("Get %s: got %s %q, want blob %q", sha, obj.Type, obj.Data, body)
+		}
+
+		typ, size, err := p.Stat(sha)
+		if err != nil {
+			t.Fatalf("Stat %s failed: %v", sha, err)
+		}
+		if typ != object.TypeBlob || size != int64(len(body)) {
+			t.Errorf("Stat %s: got %s %d, want blob %d", sha, typ, size, len(body))
+		}
+
+		r, err := p.Open(sha)
+		if err != nil {
+			t.Fatalf("Open %s failed: %v", sha, err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("read %s failed: %v", sha, err)
+		}
+		if string(got) != body {
+			t.Errorf("Open %s: got %q, want %q", sha, got, body)
+		}
+	}
+
+	missing := "0000000000000000000000000000000000000000"
+	if _, err := p.Get(missing); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Get of a missing object: got %v, want ErrNotFound", err)
+	}
+	if _, _, err := p.Stat(missing); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Stat of a missing object: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestPackfileErrors(t *testing.T) {
+	var b packBuilder
+	b.entry(TypeBlob, nil, []byte("hello\n"))
+	b.entry(TypeOfsDelta, []byte{byte(b.next() - b.offsets[0])}, copyInsertDelta(6, "world\n"))
+	data, idx := indexed(t, &b, helloSHA, helloWorldSHA)
+
+	t.Run("damaged", func(t *testing.T) {
+		damaged := bytes.Clone(data)
+		damaged[b.offsets[1]-3] ^= 0xff
+		p := NewPackfile(bytes.NewReader(damaged), idx, int64(len(damaged)))
+		for _, sha := range []string{helloSHA, helloWorldSHA} {
+			if _, err := p.Get(sha); !errors.Is(err, store.ErrCorrupt) {
+				t.Errorf("Get %s: got %v, want ErrCorrupt", sha, err)
+			}
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		p := NewPackfile(bytes.NewReader(data[:b.offsets[1]]), idx, int64(len(data)))
+		if _, err := p.Get(helloWorldSHA); !errors.Is(err, store.ErrCorrupt) {
+			t.Errorf("got %v, want ErrCorrupt", err)
+		}
+	})
+
+	t.Run("unreadable", func(t *testing.T) {
+		p := NewPackfile(failingReaderAt{}, idx, int64(len(data)))
+		if _, err := p.Get(helloSHA); !errors.Is(err, store.ErrUnavailable) {
+			t.Errorf("got %v, want ErrUnavailable", err)
+		}
+	})
+}
+
+type failingReaderAt struct{}
+
+func (failingReaderAt) ReadAt([]byte, int64) (int, error) {
+	return 0, errors.New("disk on fire")
+}
+
+func TestAppender(t *testing.T) {
+	dir := t.TempDir()
+	a, err := NewAppender(dir, "tmp_pack_*")
+	if err != nil {
+		t.Fatalf("NewAppender failed: %v", err)
+	}
+	for _, body := range []string{"hello\n", "hello\nworld\n"} {
+		obj := &object.Object{Type: object.TypeBlob, Data: []byte(body)}
+		if err := a.Add(t.Context(), object.Hash(obj), obj.Type, int64(len(body)), bytes.NewReader(obj.Data)); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	sha, err := a.AddStream(t.Context(), object.TypeBlob, 12, strings.NewReader("hello\nthere\n"))
+	if err != nil {
+		t.Fatalf("AddStream failed: %v", err)
+	}
+	if sha != helloThereSHA {
+		t.Errorf("AddStream = %s, want %s", sha, helloThereSHA)
+	}
+	if _, err := a.AddStream(t.Context(), object.TypeBlob, 10, strings.NewReader("short")); err == nil {
+		t.Error("AddStream accepted a body shorter than its size")
+	}
+
+	want := map[string]string{
+		helloSHA:      "hello\n",
+		helloWorldSHA: "hello\nworld\n",
+		helloThereSHA: "hello\nthere\n",
+	}
+	check := func(a *Appender) {
+		t.Helper()
+		if a.Count() != len(want) {
+			t.Fatalf("Count = %d, want %d", a.Count(), len(want))
+		}
+		for sha, body := range want {
+			obj, err := a.Get(sha)
+			if err != nil {
+				t.Fatalf("Get %s failed: %v", sha, err)
+			}
+			if string(obj.Data) != body {
+				t.Errorf("Get %s: got %q, want %q", sha, obj.Data, body)
+			}
+			if typ, size, ok := a.Stat(sha); !ok || typ != object.TypeBlob || size != int64(len(body)) {
+				t.Errorf("Stat %s = %s, %d, %v", sha, typ, size, ok)
+			}
+		}
+	}
+	check(a)
+	complete := a.Size()
+
+	// the start of an entry that was never finished, as a crash would
+	// leave it
+	f, err := os.OpenFile(a.Name(), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("open pack: %v", err)
+	}
+	f.Write([]byte{0xb5, 0x01, 0x78})
+	f.Close()
+
+	a, err = RecoverAppender(a.Name())
+	if err != nil {
+		t.Fatalf("RecoverAppender failed: %v", err)
+	}
+	if a.Size() != complete {
+		t.Errorf("recovered %d bytes, want %d", a.Size(), complete)
+	}
+	check(a)
+
+	sum, entries, err := a.Finish()
+	if err != nil {
+		t.Fatalf("Finish failed: %v", err)
+	}
+	var idx bytes.Buffer
+	if err := WriteIndex(&idx, entries, sum); err != nil {
+		t.Fatalf("WriteIndex failed: %v", err)
+	}
+	index, err := ParseIndex(idx.Bytes())
+	if err != nil {
+		t.Fatalf("ParseIndex failed: %v", err)
+	}
+	data, err := os.ReadFile(a.Name())
+	if err != nil {
+		t.Fatalf("read pack: %v", err)
+	}
+	if hex.EncodeToString(data[len(data)-20:]) != sum {
+		t.Errorf("pack trailer does not match Finish's checksum %s", sum)
+	}
+	p := NewPackfile(bytes.NewReader(data), index, int64(len(data)))
+	for sha, body := range want {
+		obj, err := p.Get(sha)
+		if err != nil || string(obj.Data) != body {
+			t.Errorf("Get %s from the finished pack = %v, %v, want %q", sha, obj, err, body)
+		}
+	}
+}
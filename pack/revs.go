@@ -0,0 +1,307 @@
+package pack
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+// ListObjects returns every object reachable from wants that is not
+// reachable from haves, in the order git itself would pack them: commits
+// newest first, then tags, then the trees and blobs those commits introduce.
+// Haves that the store does not contain are ignored. A missing object
+// anywhere under wants is an error, which makes this double as a
+// connectivity check.
+func ListObjects(ctx context.Context, s store.ObjectStore, wants, haves []string) ([]string, error) {
+	w := &revWalk{ctx: ctx, store: s, commits: make(map[string]*commitNode), seen: make(map[string]bool)}
+
+	for _, sha := range haves {
+		exists, err := s.Exists(ctx, sha)
+		if err != nil {
+			return nil, fmt.Errorf("exists %s: %w", sha, err)
+		}
+		if !exists {
+			continue
+		}
+		if err := w.addTip(sha, true); err != nil {
+			return nil, err
+		}
+	}
+	for _, sha := range wants {
+		if err := w.addTip(sha, false); err != nil {
+			return nil, err
+		}
+	}
+
+	commits, err := w.walkCommits()
+	if err != nil {
+		return nil, err
+	}
+
+	// trees of uninteresting commits bordering the ones we send are already
+	// on the other side, as is everything under them.
+	for _, c := range commits {
+		for _, p := range c.parents {
+			if parent := w.commits[p]; parent.uninteresting {
+				if err := w.markTree(parent.tree); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	for _, sha := range w.uninterestingObjects {
+		if err := w.markTree(sha); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make([]string, 0, len(commits)+len(w.tags))
+	for _, c := range commits {
+		out = append(out, c.sha)
+	}
+	out = append(out, w.tags...)
+	for _, c := range commits {
+		if out, err = w.appendTree(out, c.tree); err != nil {
+			return nil, err
+		}
+	}
+	for _, sha := range w.wantObjects {
+		if out, err = w.appendObject(out, sha); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+type commitNode struct {
+	sha           string
+	tree          string
+	parents       []string
+	time          int64
+	uninteresting bool
+	queued        bool
+}
+
+type revWalk struct {
+	ctx     context.Context
+	store   store.ObjectStore
+	commits map[string]*commitNode
+	queue   commitQueue
+	// seen holds trees and blobs that are either already emitted or known
+	// to be on the other side.
+	seen map[string]bool
+
+	tags                 []string
+	wantObjects          []string
+	uninterestingObjects []string
+}
+
+// addTip peels tags down to their target and queues commits for the walk.
+// Trees and blobs named directly are remembered for the object pass.
+func (w *revWalk) addTip(sha string, uninteresting bool) error {
+	for {
+		obj, err := w.get(sha)
+		if err != nil {
+			return err
+		}
+		switch obj.Type {
+		case object.TypeTag:
+			if !uninteresting && !w.seen[sha] {
+				w.seen[sha] = true
+				w.tags = append(w.tags, sha)
+			}
+			target, err := object.TagObject(obj.Data)
+			if err != nil {
+				return fmt.Errorf("object %s: %w", sha, err)
+			}
+			sha = target
+			continue
+		case object.TypeCommit:
+			c, err := w.commit(sha, obj)
+			if err != nil {
+				return err
+			}
+			if uninteresting {
+				c.uninteresting = true
+			}
+			w.push(c)
+		default:
+			if uninteresting {
+				w.uninterestingObjects = append(w.uninterestingObjects, sha)
+			} else {
+				w.wantObjects = append(w.wantObjects, sha)
+			}
+		}
+		return nil
+	}
+}
+
+// walkCommits pops commits newest first, propagating uninteresting marks
+// to parents, and stops once only uninteresting commits remain queued.
+func (w *revWalk) walkCommits() ([]*commitNode, error) {
+	var out []*commitNode
+	for w.queue.interesting() {
+		c := heap.Pop(&w.queue).(*commitNode)
+		for _, p := range c.parents {
+			parent, err := w.commit(p, nil)
+			if err != nil {
+				return nil, err
+			}
+			if c.uninteresting {
+				parent.uninteresting = true
+			}
+			w.push(parent)
+		}
+		if !c.uninteresting {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+func (w *revWalk) push(c *commitNode) {
+	if c.queued {
+		return
+	}
+	c.queued = true
+	heap.Push(&w.queue, c)
+}
+
+func (w *revWalk) commit(sha string, obj *object.Object) (*commitNode, error) {
+	if c, ok := w.commits[sha]; ok {
+		return c, nil
+	}
+	if obj == nil {
+		var err error
+		if obj, err = w.get(sha); err != nil {
+			return nil, err
+		}
+	}
+	if obj.Type != object.TypeCommit {
+		return nil, fmt.Errorf("object %s is a %s, expected commit", sha, obj.Type)
+	}
+	links, err := object.ScanCommit(obj.Data)
+	if err != nil {
+		return nil, fmt.Errorf("object %s: %w", sha, err)
+	}
+	c := &commitNode{sha: sha, tree: links.Tree, parents: links.Parents, time: links.Time}
+	w.commits[sha] = c
+	return c, nil
+}
+
+// markTree marks sha and everything below it as already present.
+func (w *revWalk) markTree(sha string) error {
+	if w.seen[sha] {
+		return nil
+	}
+	w.seen[sha] = true
+	obj, err := w.get(sha)
+	if err != nil {
+		return err
+	}
+	if obj.Type != object.TypeTree {
+		return nil
+	}
+	tree, err := object.ParseTree(obj.Data)
+	if err != nil {
+		return fmt.Errorf("object %s: %w", sha, err)
+	}
+	for _, e := range tree.Entries {
+		switch e.Mode {
+		case object.ModeGitlink:
+			// submodule commits live in another repository
+		case object.ModeTree:
+			if err := w.markTree(e.SHA); err != nil {
+				return err
+			}
+		default:
+			w.seen[e.SHA] = true
+		}
+	}
+	return nil
+}
+
+func (w *revWalk) appendTree(out []string, sha string) ([]string, error) {
+	if w.seen[sha] {
+		return out, nil
+	}
+	w.seen[sha] = true
+	obj, err := w.get(sha)
+	if err != nil {
+		return nil, err
+	}
+	out = append(out, sha)
+	tree, err := object.ParseTree(obj.Data)
+	if err != nil {
+		return nil, fmt.Errorf("object %s: %w", sha, err)
+	}
+	for _, e := range tree.Entries {
+		switch {
+		case e.Mode == object.ModeGitlink:
+			// submodule commits live in another repository
+		case e.Mode == object.ModeTree:
+			if out, err = w.appendTree(out, e.SHA); err != nil {
+				return nil, err
+			}
+		case !w.seen[e.SHA]:
+			exists, err := w.store.Exists(w.ctx, e.SHA)
+			if err != nil {
+				return nil, fmt.Errorf("tree %s: exists %s: %w", sha, e.SHA, err)
+			}
+			if !exists {
+				return nil, fmt.Errorf("tree %s: object %s: %w", sha, e.SHA, store.ErrNotFound)
+			}
+			w.seen[e.SHA] = true
+			out = append(out, e.SHA)
+		}
+	}
+	return out, nil
+}
+
+func (w *revWalk) appendObject(out []string, sha string) ([]string, error) {
+	obj, err := w.get(sha)
+	if err != nil {
+		return nil, err
+	}
+	if obj.Type == object.TypeTree {
+		return w.appendTree(out, sha)
+	}
+	if w.seen[sha] {
+		return out, nil
+	}
+	w.seen[sha] = true
+	return append(out, sha), nil
+}
+
+func (w *revWalk) get(sha string) (*object.Object, error) {
+	obj, err := w.store.Get(w.ctx, sha)
+	if err != nil {
+		return nil, fmt.Errorf("walk: %w", err)
+	}
+	return obj, nil
+}
+
+type commitQueue []*commitNode
+
+func (q commitQueue) Len() int           { return len(q) }
+func (q commitQueue) Less(i, j int) bool { return q[i].time > q[j].time }
+func (q commitQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x any)        { *q = append(*q, x.(*commitNode)) }
+func (q *commitQueue) Pop() any {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
+
+func (q commitQueue) interesting() bool {
+	for _, c := range q {
+		if !c.uninteresting {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package pack
+
+import (
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"testing"
+
+	"git.wyat.me/git-storage/object"
+)
+
+func TestListObjectsExcludesHaves(t *testing.T) {
+	s := newMemStore()
+
+	blobA := mustPut(t, s, object.TypeBlob, "a\n")
+	blobB := mustPut(t, s, object.TypeBlob, "b\n")
+	tree1 := mustPut(t, s, object.TypeTree, treeEntry("100644", "a.txt", blobA))
+	tree2 := mustPut(t, s, object.TypeTree, treeEntry("100644", "a.txt", blobA)+treeEntry("100644", "b.txt", blobB))
+	commit1 := mustPut(t, s, object.TypeCommit, commitData(tree1, "", 1))
+	commit2 := mustPut(t, s, object.TypeCommit, commitData(tree2, commit1, 2))
+
+	all, err := ListObjects(t.Context(), s, []string{commit2}, nil)
+	if err != nil {
+		t.Fatalf("ListObjects failed: %v", err)
+	}
+	want := []string{commit2, commit1, tree2, blobA, blobB, tree1}
+	if !slices.Equal(all, want) {
+		t.Errorf("full list mismatch:\n got %v\nwant %v", all, want)
+	}
+
+	incremental, err := ListObjects(t.Context(), s, []string{commit2}, []string{commit1})
+	if err != nil {
+		t.Fatalf("ListObjects failed: %v", err)
+	}
+	want = []string{commit2, tree2, blobB}
+	if !slices.Equal(incremental, want) {
+		t.Errorf("incremental list mismatch:\n got %v\nwant %v", incremental, want)
+	}
+}
+
+func TestListObjectsMissingObject(t *testing.T) {
+	s := newMemStore()
+
+	missing := "0123456789012345678901234567890123456789"
+	tree := mustPut(t, s, object.TypeTree, treeEntry("100644", "gone.txt", missing))
+	commit := mustPut(t, s, object.TypeCommit, commitData(tree, "", 1))
+
+	if _, err := ListObjects(t.Context(), s, []string{commit}, nil); err == nil {
+		t.Error("expected error for commit referencing a missing blob")
+	}
+}
+
+func TestListObjectsMalformedIdentity(t *testing.T) {
+	s := newMemStore()
+
+	blob := mustPut(t, s, object.TypeBlob, "a\n")
+	tree := mustPut(t, s, object.TypeTree, treeEntry("100644", "a.txt", blob))
+	// real histories contain commits like this, which git still walks
+	root := mustPut(t, s, object.TypeCommit, "tree "+tree+"\nauthor Nobody <nobody> \ncommitter Nobody\n\nold\n")
+	tip := mustPut(t, s, object.TypeCommit, commitData(tree, root, 2))
+
+	got, err := ListObjects(t.Context(), s, []string{tip}, nil)
+	if err != nil {
+		t.Fatalf("ListObjects failed: %v", err)
+	}
+	if want := []string{tip, root, tree, blob}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func mustPut(t *testing.T, s *memStore, typ object.ObjectType, data string) string {
+	t.Helper()
+	sha, err := s.Put(t.Context(), &object.Object{Type: typ, Data: []byte(data)})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	return sha
+}
+
+func treeEntry(mode, name, sha string) string {
+	raw, _ := hex.DecodeString(sha)
+	return mode + " " + name + "\x00" + string(raw)
+}
+
+func commitData(tree, parent string, time int) string {
+	data := "tree " + tree + "\n"
+	if parent != "" {
+		data += "parent " + parent + "\n"
+	}
+	ident := fmt.Sprintf("Test <test@example.com> %d +0000", time)
+	return data + "author " + ident + "\ncommitter " + ident + "\n\ncommit\n"
+}
@@ -0,0 +1,374 @@
+package pack
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+// ProgressFunc is called after each object is unpacked. Objects reach the
+// store in batches, so the last batch is written after the final call.
+type ProgressFunc func(done, total int)
+
+type Result struct {
+	Objects  int
+	Deltas   int
+	Checksum string
+}
+
+type resolved struct {
+	sha string
+	typ object.ObjectType
+}
+
+type pendingDelta struct {
+	offset     int64
+	baseOffset int64
+	baseSHA    string
+	delta      []byte
+}
+
+// Unpack reads a v2 packfile from r and writes every object it contains to
+// s, resolving OFS_DELTA and REF_DELTA entries. REF_DELTA bases may come
+// from later in the pack or already be present in s, which allows thin
+// packs. Objects are written in batches as they are parsed, so a pack that
+// fails its trailing checksum can still leave valid objects behind.
+func Unpack(ctx context.Context, r io.Reader, s store.ObjectStore, progress ProgressFunc) (*Result, error) {
+	sr := &scanner{r: bufio.NewReaderSize(r, 64*1024), h: sha1.New()}
+
+	var hdr [12]byte
+	if _, err := io.ReadFull(sr, hdr[:]); err != nil {
+		return nil, fmt.Errorf("read pack header: %w", err)
+	}
+	if string(hdr[:4]) != signature {
+		return nil, fmt.Errorf("invalid pack signature %q", hdr[:4])
+	}
+	if v := binary.BigEndian.Uint32(hdr[4:8]); v != version {
+		return nil, fmt.Errorf("unsupported pack version %d", v)
+	}
+	total := int(binary.BigEndian.Uint32(hdr[8:12]))
+
+	u := &unpacker{
+		ctx:      ctx,
+		store:    s,
+		progress: progress,
+		total:    total,
+		byOffset: make(map[int64]resolved, total),
+		buffered: make(map[string]*object.Object),
+		result:   &Result{},
+	}
+
+	var pending []pendingDelta
+	for range total {
+		offset := sr.n
+		typ, size, err := readEntryHeader(sr)
+		if err != nil {
+			return nil, fmt.Errorf("read entry header at %d: %w", offset, noEOF(err))
+		}
+		if err := checkSize(size); err != nil {
+			return nil, fmt.Errorf("entry at %d: %w", offset, err)
+		}
+
+		p := pendingDelta{offset: offset}
+		switch typ {
+		case TypeOfsDelta:
+			rel, err := readOfsDeltaOffset(sr)
+			if err != nil {
+				return nil, fmt.Errorf("read delta offset at %d: %w", offset, noEOF(err))
+			}
+			if rel <= 0 || rel > offset {
+				return nil, fmt.Errorf("invalid delta base offset at %d", offset)
+			}
+			p.baseOffset = offset - rel
+		case TypeRefDelta:
+			var base [20]byte
+			if _, err := io.ReadFull(sr, base[:]); err != nil {
+				return nil, fmt.Errorf("read delta base at %d: %w", offset, err)
+			}
+			p.baseSHA = hex.EncodeToString(base[:])
+		}
+
+		if typ != TypeOfsDelta && typ != TypeRefDelta && size > streamThreshold {
+			objType, err := typ.objectType()
+			if err != nil {
+				return nil, fmt.Errorf("entry at %d: %w", offset, err)
+			}
+			if err := u.putStream(offset, objType, size, sr); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		data, err := inflate(sr, size)
+		if err != nil {
+			return nil, fmt.Errorf("inflate entry at %d: %w", offset, err)
+		}
+
+		if typ != TypeOfsDelta && typ != TypeRefDelta {
+			objType, err := typ.objectType()
+			if err != nil {
+				return nil, fmt.Errorf("entry at %d: %w", offset, err)
+			}
+			if err := u.put(offset, &object.Object{Type: objType, Data: data}, ""); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		p.delta = data
+		ok, err := u.resolve(p)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			pending = append(pending, p)
+		}
+	}
+
+	sum := hex.EncodeToString(sr.h.Sum(nil))
+	var trailer [20]byte
+	if _, err := io.ReadFull(sr.r, trailer[:]); err != nil {
+		return nil, fmt.Errorf("read pack trailer: %w", noEOF(err))
+	}
+	if hex.EncodeToString(trailer[:]) != sum {
+		return nil, ErrChecksum
+	}
+	u.result.Checksum = sum
+
+	// deltas whose base appeared later in the pack, or whose base is itself
+	// a pending delta, are retried until no more can be resolved.
+	for len(pending) > 0 {
+		remaining := pending[:0]
+		for _, p := range pending {
+			ok, err := u.resolve(p)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				remaining = append(remaining, p)
+			}
+		}
+		if len(remaining) == len(pending) {
+			p := remaining[0]
+			if p.baseSHA != "" {
+				return nil, fmt.Errorf("delta at %d: base object %s not found", p.offset, p.baseSHA)
+			}
+			return nil, fmt.Errorf("delta at %d: base at offset %d never resolved", p.offset, p.baseOffset)
+		}
+		pending = remaining
+	}
+
+	if err := u.flush(); err != nil {
+		return nil, err
+	}
+	return u.result, nil
+}
+
+// Objects are written to the store in batches of up to batchObjects
+// objects or batchBytes of object data, whichever fills first. Whole
+// objects larger than streamThreshold skip the batch and are streamed
+// into the store as they are inflated.
+const (
+	batchObjects    = 1000
+	batchBytes      = 16 << 20
+	streamThreshold = 32 << 20
+)
+
+type unpacker struct {
+	ctx      context.Context
+	store    store.ObjectStore
+	progress ProgressFunc
+	total    int
+	byOffset map[int64]resolved
+	result   *Result
+
+	// batch holds objects not yet written to the store, and buffered
+	// indexes them by SHA so deltas can use them as bases meanwhile.
+	// bases holds, for each object in batch that came from a delta, the
+	// SHA of its base, which the store may use to keep it as a delta.
+	batch    []*object.Object
+	bases    []string
+	buffered map[string]*object.Object
+	size     int
+}
+
+// put adds obj to the batch. base is the SHA of the object it was
+// resolved against, or empty if it was stored whole in the pack.
+func (u *unpacker) put(offset int64, obj *object.Object, base string) error {
+	sha := object.Hash(obj)
+	u.byOffset[offset] = resolved{sha: sha, typ: obj.Type}
+	u.result.Objects++
+	if u.progress != nil {
+		u.progress(u.result.Objects, u.total)
+	}
+	if _, ok := u.buffered[sha]; ok {
+		return nil
+	}
+	u.batch = append(u.batch, obj)
+	u.bases = append(u.bases, base)
+	u.buffered[sha] = obj
+	u.size += len(obj.Data)
+	if len(u.batch) >= batchObjects || u.size >= batchBytes {
+		return u.flush()
+	}
+	return nil
+}
+
+// putStream inflates a large whole object from r straight into the store.
+func (u *unpacker) putStream(offset int64, typ object.ObjectType, size int64, r io.Reader) error {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("inflate entry at %d: %w", offset, err)
+	}
+	defer zr.Close()
+
+	sha, err := store.PutStream(u.ctx, u.store, typ, size, zr)
+	if err != nil {
+		return fmt.Errorf("put entry at %d: %w", offset, err)
+	}
+	// the store stops reading once it has size bytes, which may leave the
+	// end of the zlib stream, and its checksum, unread.
+	if n, err := io.Copy(io.Discard, zr); err != nil {
+		return fmt.Errorf("inflate entry at %d: %w", offset, err)
+	} else if n != 0 {
+		return fmt.Errorf("inflate entry at %d: longer than header says", offset)
+	}
+
+	u.byOffset[offset] = resolved{sha: sha, typ: typ}
+	u.result.Objects++
+	if u.progress != nil {
+		u.progress(u.result.Objects, u.total)
+	}
+	return nil
+}
+
+// flush writes the batched objects to the store.
+func (u *unpacker) flush() error {
+	if len(u.batch) == 0 {
+		return nil
+	}
+	if _, err := store.PutDeltas(u.ctx, u.store, u.batch, u.bases); err != nil {
+		return fmt.Errorf("write %d objects: %w", len(u.batch), err)
+	}
+	u.batch = u.batch[:0]
+	u.bases = u.bases[:0]
+	clear(u.buffered)
+	u.size = 0
+	return nil
+}
+
+// base returns the object sha from the current batch or from the store,
+// reporting false if neither has it.
+func (u *unpacker) base(sha string) (*object.Object, bool, error) {
+	if obj, ok := u.buffered[sha]; ok {
+		return obj, true, nil
+	}
+	obj, err := u.store.Get(u.ctx, sha)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return obj, true, nil
+}
+
+// resolve applies p against its base if the base is available, reporting
+// false when it has to wait for a base that has not been written yet.
+func (u *unpacker) resolve(p pendingDelta) (bool, error) {
+	baseSHA := p.baseSHA
+	if baseSHA == "" {
+		base, ok := u.byOffset[p.baseOffset]
+		if !ok {
+			return false, nil
+		}
+		baseSHA = base.sha
+	}
+
+	base, ok, err := u.base(baseSHA)
+	if err != nil {
+		return false, fmt.Errorf("delta at %d: get base %s: %w", p.offset, baseSHA, err)
+	}
+	if !ok {
+		if p.baseSHA == "" {
+			return false, fmt.Errorf("delta at %d: get base %s: %w", p.offset, baseSHA, store.ErrNotFound)
+		}
+		return false, nil
+	}
+	data, err := ApplyDelta(base.Data, p.delta)
+	if err != nil {
+		return false, fmt.Errorf("delta at %d: %w", p.offset, err)
+	}
+	if err := u.put(p.offset, &object.Object{Type: base.Type, Data: data}, baseSHA); err != nil {
+		return false, err
+	}
+	u.result.Deltas++
+	return true, nil
+}
+
+// inflate reads the zlib stream in r, which must hold exactly size bytes.
+// size comes from the entry header, so it only bounds the read: the
+// buffer grows as data arrives rather than being allocated up front.
+func inflate(r io.Reader, size int64) ([]byte, error) {
+	if err := checkSize(size); err != nil {
+		return nil, err
+	}
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	buf := bytes.NewBuffer(make([]byte, 0, min(size, maxPrealloc)))
+	if _, err := io.Copy(buf, io.LimitReader(zr, size+1)); err != nil {
+		return nil, err
+	}
+	if int64(buf.Len()) != size {
+		return nil, fmt.Errorf("inflated %d bytes, header says %d", buf.Len(), size)
+	}
+	return buf.Bytes(), nil
+}
+
+// scanner tracks the offset of, and hashes, every byte consumed from the
+// pack. It implements io.ByteReader so that zlib reads exactly up to the
+// end of each compressed entry instead of buffering past it.
+type scanner struct {
+	r *bufio.Reader
+	h hash.Hash
+	n int64
+}
+
+func (s *scanner) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.h.Write(p[:n])
+	s.n += int64(n)
+	return n, err
+}
+
+func (s *scanner) ReadByte() (byte, error) {
+	b, err := s.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	s.h.Write([]byte{b})
+	s.n++
+	return b, nil
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,248 @@
+package pack
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+const (
+	helloSHA      = "ce013625030ba8dba906f756967f9e9ca394464a"
+	helloWorldSHA = "94954abda49de8615a048f8d2e64b5de848e27a1"
+	helloThereSHA = "c4c04173748f9107f27a8a2e4007ec50c88da299"
+)
+
+func TestUnpackResolvesDeltas(t *testing.T) {
+	base, _ := hex.DecodeString(helloSHA)
+
+	var b packBuilder
+	b.entry(TypeBlob, nil, []byte("hello\n"))
+	b.entry(TypeOfsDelta, []byte{byte(b.next() - b.offsets[0])}, copyInsertDelta(6, "world\n"))
+	b.entry(TypeRefDelta, base, copyInsertDelta(6, "there\n"))
+
+	s := newMemStore()
+	var calls int
+	result, err := Unpack(t.Context(), bytes.NewReader(b.bytes()), s, func(done, total int) {
+		calls++
+		if total != 3 {
+			t.Errorf("progress total %d, want 3", total)
+		}
+	})
+	if err != nil {
+		t.Fatalf("Unpack failed: %v", err)
+	}
+
+	if result.Objects != 3 || result.Deltas != 2 {
+		t.Errorf("got %d objects, %d deltas, want 3 and 2", result.Objects, result.Deltas)
+	}
+	if calls != 3 {
+		t.Errorf("progress called %d times, want 3", calls)
+	}
+
+	want := map[string]string{
+		helloSHA:      "hello\n",
+		helloWorldSHA: "hello\nworld\n",
+		helloThereSHA: "hello\nthere\n",
+	}
+	for sha, data := range want {
+		obj, err := s.Get(t.Context(), sha)
+		if err != nil {
+			t.Fatalf("Get %s failed: %v", sha, err)
+		}
+		if obj.Type != object.TypeBlob || string(obj.Data) != data {
+			t.Errorf("object %s: got %s %q, want blob %q", sha, obj.Type, obj.Data, data)
+		}
+	}
+}
+
+func TestUnpackRefDeltaBeforeBase(t *testing.T) {
+	base, _ := hex.DecodeString(helloSHA)
+
+	var b packBuilder
+	b.entry(TypeRefDelta, base, copyInsertDelta(6, "world\n"))
+	b.entry(TypeBlob, nil, []byte("hello\n"))
+
+	s := newMemStore()
+	if _, err := Unpack(t.Context(), bytes.NewReader(b.bytes()), s, nil); err != nil {
+		t.Fatalf("Unpack failed: %v", err)
+	}
+	if ok, _ := s.Exists(t.Context(), helloWorldSHA); !ok {
+		t.Error("expected delta to be resolved after its base")
+	}
+}
+
+func TestUnpackStreamsLargeObjects(t *testing.T) {
+	large := bytes.Repeat([]byte("large\n"), streamThreshold/6+1)
+	largeSHA := object.Hash(&object.Object{Type: object.TypeBlob, Data: large})
+
+	// a small object after the large one checks that streaming consumed
+	// exactly the large entry.
+	var b packBuilder
+	b.entry(TypeBlob, nil, large)
+	b.entry(TypeBlob, nil, []byte("hello\n"))
+
+	s := newMemStore()
+	result, err := Unpack(t.Context(), bytes.NewReader(b.bytes()), s, nil)
+	if err != nil {
+		t.Fatalf("Unpack failed: %v", err)
+	}
+	if result.Objects != 2 {
+		t.Errorf("got %d objects, want 2", result.Objects)
+	}
+	obj, err := s.Get(t.Context(), largeSHA)
+	if err != nil {
+		t.Fatalf("Get large object failed: %v", err)
+	}
+	if !bytes.Equal(obj.Data, large) {
+		t.Error("large object mismatch")
+	}
+	if ok, _ := s.Exists(t.Context(), helloSHA); !ok {
+		t.Error("expected the object after the large one to be stored")
+	}
+}
+
+func TestUnpackChecksumMismatch(t *testing.T) {
+	var b packBuilder
+	b.entry(TypeBlob, nil, []byte("hello\n"))
+	raw := b.bytes()
+	raw[len(raw)-1] ^= 0xff
+
+	_, err := Unpack(t.Context(), bytes.NewReader(raw), newMemStore(), nil)
+	if !errors.Is(err, ErrChecksum) {
+		t.Errorf("expected ErrChecksum, got %v", err)
+	}
+}
+
+func TestUnpackMissingBase(t *testing.T) {
+	var b packBuilder
+	b.entry(TypeRefDelta, make([]byte, 20), copyInsertDelta(6, "world\n"))
+
+	if _, err := Unpack(t.Context(), bytes.NewReader(b.bytes()), newMemStore(), nil); err == nil {
+		t.Error("expected error for delta with missing base")
+	}
+}
+
+func TestUnpackRefusesOversizedDelta(t *testing.T) {
+	// a delta declaring a 1TB target must be refused, not allocated
+	delta := []byte{6, 0x80, 0x80, 0x80, 0x80, 0x80, 0x20, 0x90, 6}
+
+	var b packBuilder
+	b.entry(TypeBlob, nil, []byte("hello\n"))
+	b.entry(TypeOfsDelta, []byte{byte(b.next() - b.offsets[0])}, delta)
+
+	_, err := Unpack(t.Context(), bytes.NewReader(b.bytes()), newMemStore(), nil)
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestUnpackRefusesOversizedEntry(t *testing.T) {
+	var b packBuilder
+	b.count++
+	// a blob header declaring 1TB, followed by a tiny zlib stream
+	b.buf.Write([]byte{0xb0, 0x80, 0x80, 0x80, 0x80, 0x80, 0x02})
+	zw := zlib.NewWriter(&b.buf)
+	zw.Write([]byte("hello\n"))
+	zw.Close()
+
+	_, err := Unpack(t.Context(), bytes.NewReader(b.bytes()), newMemStore(), nil)
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+}
+
+type packBuilder struct {
+	buf     bytes.Buffer
+	offsets []int64
+	count   int
+}
+
+func (b *packBuilder) next() int64 {
+	return int64(12 + b.buf.Len())
+}
+
+// entry appends a packfile entry. For deltas, prefix is the encoded base
+// offset or the raw 20-byte base SHA.
+func (b *packBuilder) entry(typ EntryType, prefix, data []byte) {
+	b.offsets = append(b.offsets, b.next())
+	b.count++
+
+	size := len(data)
+	c := byte(typ)<<4 | byte(size&0x0f)
+	size >>= 4
+	for size != 0 {
+		b.buf.WriteByte(c | 0x80)
+		c = byte(size & 0x7f)
+		size >>= 7
+	}
+	b.buf.WriteByte(c)
+	b.buf.Write(prefix)
+
+	zw := zlib.NewWriter(&b.buf)
+	zw.Write(data)
+	zw.Close()
+}
+
+func (b *packBuilder) bytes() []byte {
+	var out bytes.Buffer
+	out.WriteString("PACK")
+	binary.Write(&out, binary.BigEndian, uint32(2))
+	binary.Write(&out, binary.BigEndian, uint32(b.count))
+	out.Write(b.buf.Bytes())
+	sum := sha1.Sum(out.Bytes())
+	out.Write(sum[:])
+	return out.Bytes()
+}
+
+// copyInsertDelta builds a delta that copies the first n bytes of the base
+// and then inserts s.
+func copyInsertDelta(n int, s string) []byte {
+	return append([]byte{byte(n), byte(n + len(s)), 0x90, byte(n), byte(len(s))}, s...)
+}
+
+type memStore struct {
+	mu      sync.Mutex
+	objects map[string]*object.Object
+}
+
+func newMemStore() *memStore {
+	return &memStore{objects: make(map[string]*object.Object)}
+}
+
+func (s *memStore) Put(ctx context.Context, obj *object.Object) (string, error) {
+	_, sha, err := object.Serialize(obj)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[sha] = obj
+	return sha, nil
+}
+
+func (s *memStore) Get(ctx context.Context, sha string) (*object.Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[sha]
+	if !ok {
+		return nil, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
+	}
+	return obj, nil
+}
+
+func (s *memStore) Exists(ctx context.Context, sha string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.objects[sha]
+	return ok, nil
+}
@@ -0,0 +1,233 @@
+package pack
+
+import (
+	"bufio"
+	"compress/zlib"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+const (
+	DefaultDeltaWindow = 10
+	// objects larger than this are never kept in the delta window or
+	// searched for a delta, which bounds the memory held by the writer to
+	// roughly window * maxDeltaSize.
+	maxDeltaSize = 8 << 20
+	// maxDeltaDepth matches git's default pack.depth.
+	maxDeltaDepth = 50
+	// Write reads objects from the store in batches of up to readBatch
+	// objects or readBatchBytes of object data, whichever fills first.
+	// Objects over streamThreshold are not read whole at all.
+	readBatch      = 256
+	readBatchBytes = 16 << 20
+)
+
+type WriteOptions struct {
+	// DeltaWindow is how many recent objects of the same type are tried as
+	// OFS_DELTA bases. Zero writes every object whole.
+	DeltaWindow int
+	Progress    ProgressFunc
+}
+
+type windowEntry struct {
+	offset int64
+	data   []byte
+	depth  int
+}
+
+// Write streams a v2 packfile containing shas, read from s, to w. Objects
+// are fetched in batches bounded by count and size and written one by
+// one, and objects over streamThreshold are copied from the store through
+// the compressor without being read whole, so only the delta window and
+// one bounded batch are held in memory rather than the whole pack.
+func Write(ctx context.Context, w io.Writer, s store.ObjectStore, shas []string, opts WriteOptions) (*Result, error) {
+	bw := bufio.NewWriterSize(w, 64*1024)
+	pw := &packWriter{w: bw, h: sha1.New()}
+
+	if _, err := pw.Write(appendHeader(nil, len(shas))); err != nil {
+		return nil, fmt.Errorf("write pack header: %w", err)
+	}
+
+	pk := &packer{
+		pw:      pw,
+		zw:      zlib.NewWriter(pw),
+		opts:    opts,
+		total:   len(shas),
+		windows: make(map[object.ObjectType][]windowEntry),
+		result:  &Result{},
+	}
+	for i := 0; i < len(shas); {
+		n, stream, err := nextBatch(ctx, s, shas[i:])
+		if err != nil {
+			return nil, err
+		}
+		if stream {
+			if err := pk.writeStream(ctx, s, shas[i]); err != nil {
+				return nil, err
+			}
+			i++
+			continue
+		}
+		batch, err := store.GetMany(ctx, s, shas[i:i+n])
+		if err != nil {
+			return nil, fmt.Errorf("get objects: %w", err)
+		}
+		for j, obj := range batch {
+			if err := pk.write(shas[i+j], obj); err != nil {
+				return nil, err
+			}
+		}
+		i += n
+	}
+
+	sum := pw.h.Sum(nil)
+	if _, err := bw.Write(sum); err != nil {
+		return nil, fmt.Errorf("write pack trailer: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, fmt.Errorf("flush pack: %w", err)
+	}
+	pk.result.Checksum = hex.EncodeToString(sum)
+	return pk.result, nil
+}
+
+// nextBatch works out how many of shas to read from s at once, from their
+// sizes, stopping before any object over streamThreshold. If the first
+// object is over it, it reports that it is to be streamed on its own
+// instead.
+func nextBatch(ctx context.Context, s store.ObjectStore, shas []string) (int, bool, error) {
+	var total int64
+	for i, sha := range shas[:min(len(shas), readBatch)] {
+		_, size, err := store.Stat(ctx, s, sha)
+		if err != nil {
+			return 0, false, fmt.Errorf("stat %s: %w", sha, err)
+		}
+		if size > streamThreshold {
+			return max(i, 1), i == 0, nil
+		}
+		if i > 0 && total+size > readBatchBytes {
+			return i, false, nil
+		}
+		total += size
+	}
+	return min(len(shas), readBatch), false, nil
+}
+
+// packer writes the entries of one pack.
+type packer struct {
+	pw      *packWriter
+	zw      *zlib.Writer
+	opts    WriteOptions
+	total   int
+	windows map[object.ObjectType][]windowEntry
+	result  *Result
+}
+
+// write writes obj, as a delta against an object in its window if one is
+// worthwhile.
+func (pk *packer) write(sha string, obj *object.Object) error {
+	typ, err := entryTypeOf(obj.Type)
+	if err != nil {
+		return fmt.Errorf("object %s: %w", sha, err)
+	}
+
+	offset := pk.pw.n
+	var hdrBuf []byte
+	payload := obj.Data
+	depth := 0
+
+	window := pk.windows[obj.Type]
+	if base, delta, ok := bestDelta(window, obj.Data); ok {
+		hdrBuf = appendEntryHeader(hdrBuf, TypeOfsDelta, int64(len(delta)))
+		hdrBuf = appendOfsDeltaOffset(hdrBuf, offset-base.offset)
+		payload = delta
+		depth = base.depth + 1
+		pk.result.Deltas++
+	} else {
+		hdrBuf = appendEntryHeader(hdrBuf, typ, int64(len(obj.Data)))
+	}
+
+	if _, err := pk.pw.Write(hdrBuf); err != nil {
+		return fmt.Errorf("write entry %s: %w", sha, err)
+	}
+	pk.zw.Reset(pk.pw)
+	if _, err := pk.zw.Write(payload); err != nil {
+		return fmt.Errorf("compress %s: %w", sha, err)
+	}
+	if err := pk.zw.Close(); err != nil {
+		return fmt.Errorf("compress %s: %w", sha, err)
+	}
+
+	if pk.opts.DeltaWindow > 0 && len(obj.Data) <= maxDeltaSize {
+		window = append(window, windowEntry{offset: offset, data: obj.Data, depth: depth})
+		if len(window) > pk.opts.DeltaWindow {
+			window = window[1:]
+		}
+		pk.windows[obj.Type] = window
+	}
+	pk.done()
+	return nil
+}
+
+// writeStream copies sha from s into the pack as a whole entry, reading
+// it through the compressor rather than into memory.
+func (pk *packer) writeStream(ctx context.Context, s store.ObjectStore, sha string) error {
+	r, err := store.GetStream(ctx, s, sha)
+	if err != nil {
+		return fmt.Errorf("get object %s: %w", sha, err)
+	}
+	defer r.Close()
+	if _, _, err := writeEntry(pk.pw, r.Type, r.Size, r); err != nil {
+		return fmt.Errorf("object %s: %w", sha, err)
+	}
+	pk.done()
+	return nil
+}
+
+func (pk *packer) done() {
+	pk.result.Objects++
+	if pk.opts.Progress != nil {
+		pk.opts.Progress(pk.result.Objects, pk.total)
+	}
+}
+
+// bestDelta picks the window entry giving the smallest worthwhile delta.
+// Targets over maxDeltaSize are not searched.
+func bestDelta(window []windowEntry, target []byte) (windowEntry, []byte, bool) {
+	if len(target) > maxDeltaSize {
+		return windowEntry{}, nil, false
+	}
+	var best windowEntry
+	var bestDelta []byte
+	for i := len(window) - 1; i >= 0; i-- {
+		if window[i].depth >= maxDeltaDepth {
+			continue
+		}
+		delta, ok := Delta(window[i].data, target)
+		if ok && (bestDelta == nil || len(delta) < len(bestDelta)) {
+			best, bestDelta = window[i], delta
+		}
+	}
+	return best, bestDelta, bestDelta != nil
+}
+
+// packWriter hashes and counts everything written before the trailer.
+type packWriter struct {
+	w io.Writer
+	h hash.Hash
+	n int64
+}
+
+func (p *packWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.h.Write(b[:n])
+	p.n += int64(n)
+	return n, err
+}
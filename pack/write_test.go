@@ -0,0 +1,209 @@
+package pack
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+func TestCreateDeltaRoundtrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	base := make([]byte, 64*1024)
+	rng.Read(base)
+
+	target := append([]byte("prefix"), base[:30000]...)
+	target = append(target, []byte("middle")...)
+	target = append(target, base[40000:]...)
+
+	delta := CreateDelta(base, target)
+	if len(delta) > 100 {
+		t.Errorf("delta is %d bytes, expected a handful of copy ops", len(delta))
+	}
+
+	got, err := ApplyDelta(base, delta)
+	if err != nil {
+		t.Fatalf("ApplyDelta failed: %v", err)
+	}
+	if !bytes.Equal(got, target) {
+		t.Error("ApplyDelta(CreateDelta) did not reproduce target")
+	}
+}
+
+func TestWriteUnpackRoundtrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	src := newMemStore()
+
+	content := make([]byte, 8*1024)
+	rng.Read(content)
+
+	var shas []string
+	for i := range 5 {
+		data := append([]byte{}, content...)
+		data[i*100] ^= 0xff
+		sha, err := src.Put(t.Context(), &object.Object{Type: object.TypeBlob, Data: data})
+		if err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		shas = append(shas, sha)
+	}
+	sha, err := src.Put(t.Context(), &object.Object{Type: object.TypeCommit, Data: []byte("tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n\nempty\n")})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	shas = append(shas, sha)
+
+	var buf bytes.Buffer
+	written, err := Write(t.Context(), &buf, src, shas, WriteOptions{DeltaWindow: DefaultDeltaWindow})
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if written.Objects != len(shas) {
+		t.Errorf("wrote %d objects, want %d", written.Objects, len(shas))
+	}
+	if written.Deltas != 4 {
+		t.Errorf("wrote %d deltas, want 4", written.Deltas)
+	}
+	if buf.Len() > 2*len(content) {
+		t.Errorf("pack is %d bytes, deltas should keep it near %d", buf.Len(), len(content))
+	}
+
+	dst := newMemStore()
+	read, err := Unpack(t.Context(), &buf, dst, nil)
+	if err != nil {
+		t.Fatalf("Unpack failed: %v", err)
+	}
+	if read.Checksum != written.Checksum {
+		t.Errorf("checksum mismatch: wrote %s, read %s", written.Checksum, read.Checksum)
+	}
+	for _, sha := range shas {
+		want, _ := src.Get(t.Context(), sha)
+		got, err := dst.Get(t.Context(), sha)
+		if err != nil {
+			t.Fatalf("Get %s failed: %v", sha, err)
+		}
+		if got.Type != want.Type || !bytes.Equal(got.Data, want.Data) {
+			t.Errorf("object %s did not round-trip", sha)
+		}
+	}
+}
+
+func TestWriteStreamsLargeObjects(t *testing.T) {
+	s := &streamOnlyStore{memStore: newMemStore()}
+	large := bytes.Repeat([]byte("large object\n"), streamThreshold/13+1)
+	largeSHA, err := s.Put(t.Context(), &object.Object{Type: object.TypeBlob, Data: large})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	smallSHA, err := s.Put(t.Context(), &object.Object{Type: object.TypeBlob, Data: []byte("hello\n")})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	shas := []string{smallSHA, largeSHA, smallSHA}
+	if _, err := Write(t.Context(), &buf, s, shas, WriteOptions{DeltaWindow: DefaultDeltaWindow}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	dst := newMemStore()
+	if _, err := Unpack(t.Context(), &buf, dst, nil); err != nil {
+		t.Fatalf("Unpack failed: %v", err)
+	}
+	got, err := dst.Get(t.Context(), largeSHA)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if !bytes.Equal(got.Data, large) {
+		t.Error("large object did not round-trip")
+	}
+}
+
+// streamOnlyStore refuses to Get objects over streamThreshold, so a
+// writer that reads them whole fails.
+type streamOnlyStore struct {
+	*memStore
+}
+
+func (s *streamOnlyStore) Get(ctx context.Context, sha string) (*object.Object, error) {
+	obj, err := s.memStore.Get(ctx, sha)
+	if err == nil && len(obj.Data) > streamThreshold {
+		return nil, fmt.Errorf("object %s read whole", sha)
+	}
+	return obj, err
+}
+
+func (s *streamOnlyStore) Stat(ctx context.Context, sha string) (object.ObjectType, int64, error) {
+	obj, err := s.memStore.Get(ctx, sha)
+	if err != nil {
+		return "", 0, err
+	}
+	return obj.Type, int64(len(obj.Data)), nil
+}
+
+func (s *streamOnlyStore) PutStream(ctx context.Context, typ object.ObjectType, size int64, r io.Reader) (string, error) {
+	return store.PutWhole(ctx, s, typ, size, r)
+}
+
+func (s *streamOnlyStore) GetStream(ctx context.Context, sha string) (*object.Reader, error) {
+	obj, err := s.memStore.Get(ctx, sha)
+	if err != nil {
+		return nil, err
+	}
+	body := io.NopCloser(bytes.NewReader(obj.Data))
+	return object.NewBodyReader(obj.Type, int64(len(obj.Data)), body), nil
+}
+
+func TestWriteBoundsBatchBytes(t *testing.T) {
+	s := &batchCountingStore{memStore: newMemStore()}
+	var shas []string
+	for i := range 5 {
+		data := bytes.Repeat([]byte{byte(i)}, 6<<20)
+		sha, err := s.Put(t.Context(), &object.Object{Type: object.TypeBlob, Data: data})
+		if err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		shas = append(shas, sha)
+	}
+
+	if _, err := Write(t.Context(), io.Discard, s, shas, WriteOptions{}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	for _, n := range s.batches {
+		if n > readBatchBytes {
+			t.Errorf("read a batch of %d bytes, limit is %d", n, readBatchBytes)
+		}
+	}
+	if len(s.batches) != 3 {
+		t.Errorf("read %d batches, want 3", len(s.batches))
+	}
+}
+
+// batchCountingStore records how many bytes each GetMany returns.
+type batchCountingStore struct {
+	*memStore
+	batches []int
+}
+
+func (s *batchCountingStore) PutMany(ctx context.Context, objs []*object.Object) ([]string, error) {
+	return store.PutMany(ctx, s.memStore, objs)
+}
+
+func (s *batchCountingStore) GetMany(ctx context.Context, shas []string) ([]*object.Object, error) {
+	objs, err := store.GetMany(ctx, s.memStore, shas)
+	n := 0
+	for _, obj := range objs {
+		n += len(obj.Data)
+	}
+	s.batches = append(s.batches, n)
+	return objs, err
+}
+
+func (s *batchCountingStore) ExistsMany(ctx context.Context, shas []string) ([]bool, error) {
+	return store.ExistsMany(ctx, s.memStore, shas)
+}
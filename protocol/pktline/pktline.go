@@ -0,0 +1,156 @@
+package pktline
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	// MaxPacketLen is the largest pkt-line git will send or accept, including
+	// the 4-byte length prefix.
+	MaxPacketLen = 65520
+	// MaxPayloadLen is the largest payload that fits in a single pkt-line.
+	MaxPayloadLen = MaxPacketLen - 4
+)
+
+type PacketType int
+
+const (
+	Data PacketType = iota
+	Flush
+	Delim
+	ResponseEnd
+)
+
+var (
+	flushPkt       = []byte("0000")
+	delimPkt       = []byte("0001")
+	responseEndPkt = []byte("0002")
+)
+
+var ErrPacketTooLong = errors.New("pkt-line too long")
+
+type Reader struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReaderSize(r, MaxPacketLen), buf: make([]byte, MaxPayloadLen)}
+}
+
+// ReadPacket reads the next pkt-line. For data packets the returned payload
+// is only valid until the next call to ReadPacket.
+func (r *Reader) ReadPacket() (PacketType, []byte, error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r.r, lenBuf[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, nil, fmt.Errorf("read pkt-line length: %w", err)
+		}
+		return 0, nil, err
+	}
+
+	n, err := strconv.ParseUint(string(lenBuf[:]), 16, 16)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid pkt-line length %q", lenBuf[:])
+	}
+
+	switch n {
+	case 0:
+		return Flush, nil, nil
+	case 1:
+		return Delim, nil, nil
+	case 2:
+		return ResponseEnd, nil, nil
+	case 3:
+		return 0, nil, fmt.Errorf("invalid pkt-line length %q", lenBuf[:])
+	}
+	if n > MaxPacketLen {
+		return 0, nil, ErrPacketTooLong
+	}
+
+	payload := r.buf[:n-4]
+	if _, err := io.ReadFull(r.r, payload); err != nil {
+		return 0, nil, fmt.Errorf("read pkt-line payload: %w", noEOF(err))
+	}
+	return Data, payload, nil
+}
+
+// ReadLine reads a data packet and strips a single trailing LF. Any
+// non-data packet is reported through the returned PacketType with an empty
+// line.
+func (r *Reader) ReadLine() (PacketType, string, error) {
+	typ, payload, err := r.ReadPacket()
+	if err != nil || typ != Data {
+		return typ, "", err
+	}
+	if len(payload) > 0 && payload[len(payload)-1] == '\n' {
+		payload = payload[:len(payload)-1]
+	}
+	return Data, string(payload), nil
+}
+
+type Writer struct {
+	w io.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (w *Writer) WritePacket(payload []byte) error {
+	if len(payload) > MaxPayloadLen {
+		return ErrPacketTooLong
+	}
+	buf := make([]byte, 4+len(payload))
+	hexLen(buf, len(buf))
+	copy(buf[4:], payload)
+	_, err := w.w.Write(buf)
+	return err
+}
+
+func (w *Writer) WriteString(s string) error {
+	return w.WritePacket([]byte(s))
+}
+
+// WriteLine writes s terminated by LF, the form git uses for text packets.
+func (w *Writer) WriteLine(s string) error {
+	return w.WritePacket([]byte(s + "\n"))
+}
+
+func (w *Writer) Linef(format string, args ...any) error {
+	return w.WriteLine(fmt.Sprintf(format, args...))
+}
+
+func (w *Writer) Flush() error {
+	_, err := w.w.Write(flushPkt)
+	return err
+}
+
+func (w *Writer) Delim() error {
+	_, err := w.w.Write(delimPkt)
+	return err
+}
+
+func (w *Writer) ResponseEnd() error {
+	_, err := w.w.Write(responseEndPkt)
+	return err
+}
+
+func hexLen(buf []byte, n int) {
+	const digits = "0123456789abcdef"
+	buf[0] = digits[(n>>12)&0xf]
+	buf[1] = digits[(n>>8)&0xf]
+	buf[2] = digits[(n>>4)&0xf]
+	buf[3] = digits[n&0xf]
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
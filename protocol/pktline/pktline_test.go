@@ -0,0 +1,125 @@
+package pktline
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestWriteAndReadPackets(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+
+	if err := w.WriteLine("# service=git-upload-pack"); err != nil {
+		t.Fatalf("WriteLine failed: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if err := w.Delim(); err != nil {
+		t.Fatalf("Delim failed: %v", err)
+	}
+	if err := w.ResponseEnd(); err != nil {
+		t.Fatalf("ResponseEnd failed: %v", err)
+	}
+
+	const expected = "001e# service=git-upload-pack\n000000010002"
+	if buf.String() != expected {
+		t.Fatalf("encoded %q, expected %q", buf.String(), expected)
+	}
+
+	r := NewReader(&buf)
+	typ, line, err := r.ReadLine()
+	if err != nil {
+		t.Fatalf("ReadLine failed: %v", err)
+	}
+	if typ != Data || line != "# service=git-upload-pack" {
+		t.Errorf("got %v %q, want data line", typ, line)
+	}
+
+	for _, want := range []PacketType{Flush, Delim, ResponseEnd} {
+		typ, _, err := r.ReadPacket()
+		if err != nil {
+			t.Fatalf("ReadPacket failed: %v", err)
+		}
+		if typ != want {
+			t.Errorf("got packet type %v, want %v", typ, want)
+		}
+	}
+
+	if _, _, err := r.ReadPacket(); err != io.EOF {
+		t.Errorf("expected io.EOF at end of stream, got %v", err)
+	}
+}
+
+func TestPacketLimits(t *testing.T) {
+	w := NewWriter(io.Discard)
+	if err := w.WritePacket(make([]byte, MaxPayloadLen)); err != nil {
+		t.Errorf("max payload rejected: %v", err)
+	}
+	if err := w.WritePacket(make([]byte, MaxPayloadLen+1)); !errors.Is(err, ErrPacketTooLong) {
+		t.Errorf("expected ErrPacketTooLong, got %v", err)
+	}
+
+	r := NewReader(strings.NewReader("fff1"))
+	if _, _, err := r.ReadPacket(); !errors.Is(err, ErrPacketTooLong) {
+		t.Errorf("expected ErrPacketTooLong on read, got %v", err)
+	}
+
+	r = NewReader(strings.NewReader("zzzz"))
+	if _, _, err := r.ReadPacket(); err == nil {
+		t.Error("expected error for invalid length prefix")
+	}
+
+	r = NewReader(strings.NewReader("000ahel"))
+	if _, _, err := r.ReadPacket(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF for truncated packet, got %v", err)
+	}
+}
+
+func TestSidebandRoundtrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+
+	data := bytes.Repeat([]byte("x"), MaxSidebandLen*2+10)
+	progress := NewSidebandWriter(w, BandProgress, MaxSidebandLen)
+	if _, err := progress.Write([]byte("Counting objects: 1\n")); err != nil {
+		t.Fatalf("progress Write failed: %v", err)
+	}
+	pack := NewSidebandWriter(w, BandData, MaxSidebandLen)
+	if _, err := pack.Write(data); err != nil {
+		t.Fatalf("data Write failed: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	var gotProgress bytes.Buffer
+	got, err := io.ReadAll(NewSidebandReader(NewReader(&buf), &gotProgress))
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("data mismatch: got %d bytes, want %d", len(got), len(data))
+	}
+	if gotProgress.String() != "Counting objects: 1\n" {
+		t.Errorf("progress mismatch: got %q", gotProgress.String())
+	}
+}
+
+func TestSidebandError(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	NewSidebandWriter(w, BandError, MaxSidebandLen).Write([]byte("repository corrupt\n"))
+
+	_, err := io.ReadAll(NewSidebandReader(NewReader(&buf), nil))
+	var remoteErr *RemoteError
+	if !errors.As(err, &remoteErr) {
+		t.Fatalf("expected RemoteError, got %v", err)
+	}
+	if remoteErr.Message != "repository corrupt" {
+		t.Errorf("message mismatch: got %q", remoteErr.Message)
+	}
+}
@@ -0,0 +1,113 @@
+package pktline
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+type Band byte
+
+const (
+	BandData     Band = 1
+	BandProgress Band = 2
+	BandError    Band = 3
+)
+
+const (
+	// MaxSidebandLen is the largest payload per packet with side-band-64k,
+	// one byte less than a full pkt-line to make room for the band.
+	MaxSidebandLen = MaxPayloadLen - 1
+	// MaxSmallSidebandLen is the limit for the original side-band capability.
+	MaxSmallSidebandLen = 1000 - 4 - 1
+)
+
+// SidebandWriter multiplexes writes onto a single band, splitting them into
+// packets no larger than the negotiated limit.
+type SidebandWriter struct {
+	w    *Writer
+	band Band
+	max  int
+	buf  []byte
+}
+
+func NewSidebandWriter(w *Writer, band Band, max int) *SidebandWriter {
+	return &SidebandWriter{w: w, band: band, max: max, buf: make([]byte, max+1)}
+}
+
+func (s *SidebandWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), s.max)
+		s.buf[0] = byte(s.band)
+		copy(s.buf[1:], p[:n])
+		if err := s.w.WritePacket(s.buf[:n+1]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// SidebandReader demultiplexes a side-band stream. Band 1 is returned from
+// Read, band 2 is copied to Progress if set, and band 3 ends the stream with
+// a RemoteError. A flush packet is treated as end of stream.
+type SidebandReader struct {
+	r        *Reader
+	Progress io.Writer
+	pending  []byte
+	err      error
+}
+
+func NewSidebandReader(r *Reader, progress io.Writer) *SidebandReader {
+	return &SidebandReader{r: r, Progress: progress}
+}
+
+type RemoteError struct {
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return "remote error: " + e.Message
+}
+
+func (s *SidebandReader) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+
+		typ, payload, err := s.r.ReadPacket()
+		if err != nil {
+			s.err = noEOF(err)
+			continue
+		}
+		if typ != Data {
+			s.err = io.EOF
+			continue
+		}
+		if len(payload) == 0 {
+			continue
+		}
+
+		switch Band(payload[0]) {
+		case BandData:
+			s.pending = payload[1:]
+		case BandProgress:
+			if s.Progress != nil {
+				if _, err := s.Progress.Write(payload[1:]); err != nil {
+					return 0, err
+				}
+			}
+		case BandError:
+			s.err = &RemoteError{Message: strings.TrimRight(string(payload[1:]), "\n")}
+		default:
+			s.err = fmt.Errorf("invalid side-band %d", payload[0])
+		}
+	}
+
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
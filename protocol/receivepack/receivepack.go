@@ -0,0 +1,365 @@
+package receivepack
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/pack"
+	"git.wyat.me/git-storage/protocol/pktline"
+	"git.wyat.me/git-storage/store"
+)
+
+const (
+	zeroSHA = "0000000000000000000000000000000000000000"
+	agent   = "agent=git-storage"
+)
+
+var capabilities = []string{
+	"report-status",
+	"report-status-v2",
+	"delete-refs",
+	"atomic",
+	"side-band-64k",
+	"quiet",
+	"ofs-delta",
+	agent,
+}
+
+// Command is one "<old> <new> <ref>" line of a push.
+type Command struct {
+	Old  string
+	New  string
+	Name string
+}
+
+func (c Command) isDelete() bool { return c.New == zeroSHA }
+
+// AdvertiseRefs writes the receive-pack ref advertisement. Symbolic refs
+// are not advertised to pushing clients.
+func AdvertiseRefs(w io.Writer, refs []store.Ref) error {
+	pw := pktline.NewWriter(w)
+	capLine := strings.Join(capabilities, " ")
+
+	first := true
+	for _, ref := range refs {
+		if ref.SHA == "" || ref.Target != "" {
+			continue
+		}
+		var err error
+		if first {
+			err = pw.Linef("%s %s\x00%s", ref.SHA, ref.Name, capLine)
+		} else {
+			err = pw.Linef("%s %s", ref.SHA, ref.Name)
+		}
+		if err != nil {
+			return err
+		}
+		first = false
+	}
+	if first {
+		if err := pw.Linef("%s capabilities^{}\x00%s", zeroSHA, capLine); err != nil {
+			return err
+		}
+	}
+	return pw.Flush()
+}
+
+// Serve handles a receive-pack request: it reads the ref update commands,
+// ingests the pack that follows into s, checks that every new tip is fully
+// connected, applies the updates to refs and reports the result of each
+// one.
+func Serve(ctx context.Context, w io.Writer, r io.Reader, s store.ObjectStore, refs store.RefStore) error {
+	// pktline.NewReader reuses br since it is already large enough, so the
+	// pack that follows the commands can be read from br directly.
+	br := bufio.NewReaderSize(r, pktline.MaxPacketLen)
+	pr := pktline.NewReader(br)
+
+	cmds, caps, err := readCommands(pr)
+	if err != nil {
+		return err
+	}
+	if len(cmds) == 0 {
+		return nil
+	}
+
+	pw := pktline.NewWriter(w)
+	var progress io.Writer
+	if caps["side-band-64k"] && !caps["quiet"] {
+		progress = pktline.NewSidebandWriter(pw, pktline.BandProgress, pktline.MaxSidebandLen)
+	}
+
+	unpackErr := unpack(ctx, br, s, cmds, progress)
+
+	results := make([]string, len(cmds))
+	if unpackErr != nil {
+		for i := range cmds {
+			results[i] = "unpacker error"
+		}
+	} else {
+		current, err := refs.ListRefs(ctx, "refs/")
+		if err != nil {
+			return fmt.Errorf("list refs: %w", err)
+		}
+		tips := make([]string, 0, len(current))
+		for _, ref := range current {
+			if ref.SHA != "" {
+				tips = append(tips, ref.SHA)
+			}
+		}
+		if caps["atomic"] {
+			results = applyAtomic(ctx, s, refs, tips, cmds)
+		} else {
+			for i, cmd := range cmds {
+				results[i] = apply(ctx, s, refs, tips, cmd)
+			}
+		}
+	}
+
+	if !caps["report-status"] && !caps["report-status-v2"] {
+		return unpackErr
+	}
+
+	var report bytes.Buffer
+	rw := pktline.NewWriter(&report)
+	if unpackErr != nil {
+		rw.WriteLine("unpack " + unpackErr.Error())
+	} else {
+		rw.WriteLine("unpack ok")
+	}
+	for i, cmd := range cmds {
+		if results[i] == "" {
+			rw.WriteLine("ok " + cmd.Name)
+		} else {
+			rw.WriteLine("ng " + cmd.Name + " " + results[i])
+		}
+	}
+	rw.Flush()
+
+	if caps["side-band-64k"] {
+		data := pktline.NewSidebandWriter(pw, pktline.BandData, pktline.MaxSidebandLen)
+		if _, err := data.Write(report.Bytes()); err != nil {
+			return err
+		}
+		if err := pw.Flush(); err != nil {
+			return err
+		}
+	} else if _, err := w.Write(report.Bytes()); err != nil {
+		return err
+	}
+	return unpackErr
+}
+
+func readCommands(pr *pktline.Reader) ([]Command, map[string]bool, error) {
+	var cmds []Command
+	caps := make(map[string]bool)
+	for {
+		typ, line, err := pr.ReadLine()
+		if err == io.EOF && len(cmds) == 0 {
+			return nil, caps, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("read command: %w", err)
+		}
+		if typ == pktline.Flush {
+			return cmds, caps, nil
+		}
+
+		line, capList, hasCaps := strings.Cut(line, "\x00")
+		if hasCaps && len(cmds) == 0 {
+			for _, c := range strings.Fields(capList) {
+				caps[c] = true
+			}
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 || len(fields[0]) != 40 || len(fields[1]) != 40 {
+			return nil, nil, fmt.Errorf("malformed command %q", line)
+		}
+		cmds = append(cmds, Command{Old: fields[0], New: fields[1], Name: fields[2]})
+	}
+}
+
+func unpack(ctx context.Context, br *bufio.Reader, s store.ObjectStore, cmds []Command, progress io.Writer) error {
+	needPack := false
+	for _, cmd := range cmds {
+		if !cmd.isDelete() {
+			needPack = true
+		}
+	}
+	if !needPack {
+		return nil
+	}
+	// a push that only moves refs to objects we already have may still
+	// omit the pack entirely.
+	if _, err := br.Peek(1); err == io.EOF {
+		return nil
+	}
+
+	var fn pack.ProgressFunc
+	if progress != nil {
+		last := -1
+		fn = func(done, total int) {
+			pct := done * 100 / max(total, 1)
+			if pct == last && done != total {
+				return
+			}
+			last = pct
+			if done == total {
+				fmt.Fprintf(progress, "Unpacking objects: 100%% (%d/%d), done.\n", done, total)
+				return
+			}
+			fmt.Fprintf(progress, "Unpacking objects: %3d%% (%d/%d)\r", pct, done, total)
+		}
+	}
+
+	if _, err := pack.Unpack(ctx, br, s, fn); err != nil {
+		return err
+	}
+	return nil
+}
+
+// apply validates and performs a single command, returning the reason for
+// an "ng" status or an empty string on success.
+func apply(ctx context.Context, s store.ObjectStore, refs store.RefStore, tips []string, cmd Command) string {
+	if reason := check(ctx, s, tips, cmd); reason != "" {
+		return reason
+	}
+	if err := updateRef(ctx, refs, cmd); err != nil {
+		return errorReason(err)
+	}
+	return ""
+}
+
+// applyAtomic performs every command in one ref transaction. If any of
+// them is refused, none are applied and the others report the failure of
+// the push as a whole, as git does.
+func applyAtomic(ctx context.Context, s store.ObjectStore, refs store.RefStore, tips []string, cmds []Command) []string {
+	results := make([]string, len(cmds))
+	failed := false
+	updates := make([]store.RefUpdate, len(cmds))
+	for i, cmd := range cmds {
+		if results[i] = check(ctx, s, tips, cmd); results[i] != "" {
+			failed = true
+		}
+		updates[i] = refUpdate(cmd)
+	}
+
+	if !failed {
+		err := refs.UpdateRefs(ctx, updates)
+		if err == nil {
+			return results
+		}
+		var txErr *store.TransactionError
+		if !errors.As(err, &txErr) {
+			txErr = &store.TransactionError{}
+			for _, cmd := range cmds {
+				txErr.Rejected = append(txErr.Rejected, store.RefRejection{Name: cmd.Name, Err: err})
+			}
+		}
+		for _, r := range txErr.Rejected {
+			for i, cmd := range cmds {
+				if cmd.Name == r.Name {
+					results[i] = errorReason(r.Err)
+				}
+			}
+		}
+	}
+
+	for i := range results {
+		if results[i] == "" {
+			results[i] = "atomic push failure"
+		}
+	}
+	return results
+}
+
+// check validates a command against the store before any ref is moved,
+// returning the reason for an "ng" status or an empty string.
+func check(ctx context.Context, s store.ObjectStore, tips []string, cmd Command) string {
+	if !validRefName(cmd.Name) {
+		return "funny refname"
+	}
+
+	if !cmd.isDelete() {
+		obj, err := s.Get(ctx, cmd.New)
+		if err != nil {
+			return objectErrorReason(err)
+		}
+		if strings.HasPrefix(cmd.Name, "refs/heads/") && obj.Type != object.TypeCommit {
+			return "trying to write non-commit object to branch"
+		}
+		if _, err := pack.ListObjects(ctx, s, []string{cmd.New}, tips); err != nil {
+			return objectErrorReason(err)
+		}
+	}
+	return ""
+}
+
+// refUpdate translates the zero SHAs of the wire protocol into the
+// RefStore's empty values for "absent" and "delete". Like git, a delete
+// with a zero old value removes the ref whatever it holds.
+func refUpdate(cmd Command) store.RefUpdate {
+	u := store.RefUpdate{Name: cmd.Name, OldSHA: cmd.Old, NewSHA: cmd.New}
+	if u.OldSHA == zeroSHA {
+		u.OldSHA = ""
+	}
+	if u.NewSHA == zeroSHA {
+		u.NewSHA = ""
+	}
+	return u
+}
+
+func updateRef(ctx context.Context, refs store.RefStore, cmd Command) error {
+	u := refUpdate(cmd)
+	if u.IsDelete() {
+		return refs.DeleteRef(ctx, u.Name, u.OldSHA)
+	}
+	return refs.UpdateRef(ctx, u.Name, u.OldSHA, u.NewSHA)
+}
+
+// objectErrorReason tells a push that really lacks objects apart from a
+// store that could not be read.
+func objectErrorReason(err error) string {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return "missing necessary objects"
+	case errors.Is(err, store.ErrCorrupt):
+		return "corrupt object"
+	default:
+		return "failed to read objects"
+	}
+}
+
+func errorReason(err error) string {
+	if errors.Is(err, store.ErrRefConflict) {
+		return "stale info"
+	}
+	return "failed to update ref"
+}
+
+// validRefName applies the subset of git check-ref-format rules that keep
+// a name safe to store and unambiguous to clients.
+func validRefName(name string) bool {
+	if !strings.HasPrefix(name, "refs/") || strings.HasSuffix(name, "/") || strings.HasSuffix(name, ".") {
+		return false
+	}
+	if strings.Contains(name, "..") || strings.Contains(name, "//") || strings.Contains(name, "@{") {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || strings.HasSuffix(part, ".lock") {
+			return false
+		}
+	}
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f || strings.ContainsRune(" ~^:?*[\\", c) {
+			return false
+		}
+	}
+	return true
+}
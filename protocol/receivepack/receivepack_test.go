@@ -0,0 +1,199 @@
+package receivepack
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/pack"
+	"git.wyat.me/git-storage/protocol/pktline"
+	"git.wyat.me/git-storage/store"
+	"git.wyat.me/git-storage/store/sqlite"
+)
+
+func TestServePush(t *testing.T) {
+	src, commit, shas := newTestCommit(t)
+	dst := newTestStore(t)
+
+	var req bytes.Buffer
+	pw := pktline.NewWriter(&req)
+	pw.WriteLine(zeroSHA + " " + commit + " refs/heads/main\x00report-status side-band-64k quiet")
+	pw.Flush()
+	if _, err := pack.Write(t.Context(), &req, src, shas, pack.WriteOptions{}); err != nil {
+		t.Fatalf("pack.Write failed: %v", err)
+	}
+
+	var resp bytes.Buffer
+	if err := Serve(t.Context(), &resp, &req, dst, dst); err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+
+	report := readReport(t, pktline.NewSidebandReader(pktline.NewReader(&resp), nil))
+	want := []string{"unpack ok", "ok refs/heads/main"}
+	if len(report) != len(want) || report[0] != want[0] || report[1] != want[1] {
+		t.Errorf("report %q, want %q", report, want)
+	}
+	if ref, err := dst.GetRef(t.Context(), "refs/heads/main"); err != nil || ref.SHA != commit {
+		t.Errorf("main is %+v (%v), want %s", ref, err, commit)
+	}
+	for _, sha := range shas {
+		if ok, _ := dst.Exists(t.Context(), sha); !ok {
+			t.Errorf("object %s was not stored", sha)
+		}
+	}
+}
+
+func TestServeRejectsStaleAndDisconnected(t *testing.T) {
+	dst, commit, _ := newTestCommit(t)
+	if err := dst.UpdateRef(t.Context(), "refs/heads/main", "", commit); err != nil {
+		t.Fatalf("UpdateRef failed: %v", err)
+	}
+
+	ident := "Test <test@example.com> 1700000000 +0000"
+	broken, err := dst.Put(t.Context(), &object.Object{
+		Type: object.TypeCommit,
+		Data: []byte("tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\nauthor " + ident + "\ncommitter " + ident + "\n\nbroken\n"),
+	})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	var req bytes.Buffer
+	pw := pktline.NewWriter(&req)
+	// main already exists, and dev points at a commit whose tree was never
+	// sent.
+	pw.WriteLine(zeroSHA + " " + commit + " refs/heads/main\x00report-status")
+	pw.WriteLine(zeroSHA + " " + broken + " refs/heads/dev")
+	pw.WriteLine(zeroSHA + " " + commit + " refs/heads/bad..name")
+	pw.Flush()
+
+	var resp bytes.Buffer
+	if err := Serve(t.Context(), &resp, &req, dst, dst); err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+
+	report := readReport(t, &resp)
+	want := []string{
+		"unpack ok",
+		"ng refs/heads/main stale info",
+		"ng refs/heads/dev missing necessary objects",
+		"ng refs/heads/bad..name funny refname",
+	}
+	if len(report) != len(want) {
+		t.Fatalf("report %q, want %q", report, want)
+	}
+	for i := range want {
+		if report[i] != want[i] {
+			t.Errorf("report line %d: got %q, want %q", i, report[i], want[i])
+		}
+	}
+	if _, err := dst.GetRef(t.Context(), "refs/heads/dev"); !errors.Is(err, store.ErrRefNotFound) {
+		t.Error("dev should not have been created")
+	}
+}
+
+func TestServeAtomic(t *testing.T) {
+	dst, commit, _ := newTestCommit(t)
+	if err := dst.UpdateRef(t.Context(), "refs/heads/main", "", commit); err != nil {
+		t.Fatalf("UpdateRef failed: %v", err)
+	}
+
+	var req bytes.Buffer
+	pw := pktline.NewWriter(&req)
+	// dev on its own is fine, but main is stale so neither may be applied.
+	pw.WriteLine(zeroSHA + " " + commit + " refs/heads/dev\x00report-status atomic")
+	pw.WriteLine(zeroSHA + " " + commit + " refs/heads/main")
+	pw.Flush()
+
+	var resp bytes.Buffer
+	if err := Serve(t.Context(), &resp, &req, dst, dst); err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+
+	report := readReport(t, &resp)
+	want := []string{
+		"unpack ok",
+		"ng refs/heads/dev atomic push failure",
+		"ng refs/heads/main stale info",
+	}
+	if len(report) != len(want) {
+		t.Fatalf("report %q, want %q", report, want)
+	}
+	for i := range want {
+		if report[i] != want[i] {
+			t.Errorf("report line %d: got %q, want %q", i, report[i], want[i])
+		}
+	}
+	if _, err := dst.GetRef(t.Context(), "refs/heads/dev"); !errors.Is(err, store.ErrRefNotFound) {
+		t.Error("dev should not have been created")
+	}
+}
+
+func TestAdvertiseEmptyRepo(t *testing.T) {
+	var buf bytes.Buffer
+	if err := AdvertiseRefs(&buf, nil); err != nil {
+		t.Fatalf("AdvertiseRefs failed: %v", err)
+	}
+	_, line, err := pktline.NewReader(&buf).ReadLine()
+	if err != nil {
+		t.Fatalf("ReadLine failed: %v", err)
+	}
+	if line[:40] != zeroSHA || !bytes.Contains([]byte(line), []byte("report-status-v2")) {
+		t.Errorf("unexpected advertisement %q", line)
+	}
+}
+
+func newTestStore(t *testing.T) *sqlite.SQLiteStore {
+	t.Helper()
+	s, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// newTestCommit stores a commit with a single file and returns the store,
+// the commit SHA and every object SHA in pack order.
+func newTestCommit(t *testing.T) (*sqlite.SQLiteStore, string, []string) {
+	t.Helper()
+	s := newTestStore(t)
+
+	blob, err := s.Put(t.Context(), &object.Object{Type: object.TypeBlob, Data: []byte("hello\n")})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	raw, _ := hex.DecodeString(blob)
+	tree, err := s.Put(t.Context(), &object.Object{Type: object.TypeTree, Data: []byte("100644 hello.txt\x00" + string(raw))})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	ident := "Test <test@example.com> 1700000000 +0000"
+	commit, err := s.Put(t.Context(), &object.Object{
+		Type: object.TypeCommit,
+		Data: []byte("tree " + tree + "\nauthor " + ident + "\ncommitter " + ident + "\n\ninitial\n"),
+	})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	return s, commit, []string{commit, tree, blob}
+}
+
+func readReport(t *testing.T, r io.Reader) []string {
+	t.Helper()
+	pr := pktline.NewReader(r)
+	var lines []string
+	for {
+		typ, line, err := pr.ReadLine()
+		if err != nil {
+			t.Fatalf("ReadLine failed: %v", err)
+		}
+		if typ == pktline.Flush {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
@@ -0,0 +1,482 @@
+package uploadpack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/pack"
+	"git.wyat.me/git-storage/protocol/pktline"
+	"git.wyat.me/git-storage/store"
+)
+
+const (
+	zeroSHA = "0000000000000000000000000000000000000000"
+	agent   = "agent=git-storage"
+)
+
+var capabilities = []string{
+	"multi_ack",
+	"multi_ack_detailed",
+	"no-done",
+	"side-band",
+	"side-band-64k",
+	"ofs-delta",
+	"no-progress",
+	"include-tag",
+	agent,
+}
+
+// AdvertiseRefs writes the protocol v0 ref advertisement, HEAD first,
+// followed by every ref and the peeled value of annotated tags. Symbolic
+// refs such as HEAD are expected to carry both Target and the resolved SHA.
+func AdvertiseRefs(ctx context.Context, w io.Writer, s store.ObjectStore, refs []store.Ref) error {
+	pw := pktline.NewWriter(w)
+
+	caps := append([]string{}, capabilities...)
+	for _, ref := range refs {
+		if ref.Target != "" && ref.SHA != "" {
+			caps = append(caps, "symref="+ref.Name+":"+ref.Target)
+		}
+	}
+	capLine := strings.Join(caps, " ")
+
+	first := true
+	writeRef := func(sha, name string) error {
+		if first {
+			first = false
+			return pw.Linef("%s %s\x00%s", sha, name, capLine)
+		}
+		return pw.Linef("%s %s", sha, name)
+	}
+
+	for _, ref := range refs {
+		if ref.SHA == "" {
+			continue
+		}
+		if err := writeRef(ref.SHA, ref.Name); err != nil {
+			return err
+		}
+		if !strings.HasPrefix(ref.Name, "refs/tags/") {
+			continue
+		}
+		peeled, err := peel(ctx, s, ref.SHA)
+		if err != nil {
+			return fmt.Errorf("peel %s: %w", ref.Name, err)
+		}
+		if peeled != ref.SHA {
+			if err := writeRef(peeled, ref.Name+"^{}"); err != nil {
+				return err
+			}
+		}
+	}
+
+	if first {
+		if err := writeRef(zeroSHA, "capabilities^{}"); err != nil {
+			return err
+		}
+	}
+	return pw.Flush()
+}
+
+// Serve handles a single stateless-RPC upload-pack request: it reads the
+// client's wants and haves from r, answers the negotiation, and streams a
+// packfile to w once the client is done or the server is ready.
+func Serve(ctx context.Context, w io.Writer, r io.Reader, s store.ObjectStore, refs []store.Ref) error {
+	pr := pktline.NewReader(r)
+	pw := pktline.NewWriter(w)
+
+	req, err := readWants(pr)
+	if err != nil {
+		return err
+	}
+	if len(req.wants) == 0 {
+		return nil
+	}
+
+	tips, err := advertisedTips(ctx, s, refs)
+	if err != nil {
+		return err
+	}
+	for _, want := range req.wants {
+		if !tips[want] {
+			return fmt.Errorf("not our ref %s", want)
+		}
+	}
+
+	n := &negotiator{ctx: ctx, store: s, wants: req.wants, parents: make(map[string][]string)}
+	done, err := n.negotiate(pr, pw, req.caps)
+	if err != nil {
+		return err
+	}
+	if !done {
+		return nil
+	}
+
+	return sendPack(ctx, w, pw, s, refs, req, n.common)
+}
+
+type request struct {
+	wants []string
+	caps  map[string]bool
+}
+
+func readWants(pr *pktline.Reader) (*request, error) {
+	req := &request{caps: make(map[string]bool)}
+	for {
+		typ, line, err := pr.ReadLine()
+		if err != nil {
+			return nil, fmt.Errorf("read want: %w", err)
+		}
+		if typ == pktline.Flush {
+			return req, nil
+		}
+
+		rest, ok := strings.CutPrefix(line, "want ")
+		if !ok {
+			if strings.HasPrefix(line, "shallow ") || strings.HasPrefix(line, "deepen") {
+				return nil, errors.New("shallow clones are not supported")
+			}
+			return nil, fmt.Errorf("unexpected line %q", line)
+		}
+		sha, caps, _ := strings.Cut(rest, " ")
+		if len(req.wants) == 0 {
+			for _, c := range strings.Fields(caps) {
+				req.caps[c] = true
+			}
+		}
+		req.wants = append(req.wants, sha)
+	}
+}
+
+type negotiator struct {
+	ctx     context.Context
+	store   store.ObjectStore
+	wants   []string
+	common  []string
+	parents map[string][]string
+	// reachable records wants already known to reach a common commit.
+	reachable map[string]bool
+}
+
+// negotiate follows git's get_common_commits for stateless RPC, answering
+// one round of haves. It reports true when the pack should be sent in this
+// response.
+func (n *negotiator) negotiate(pr *pktline.Reader, pw *pktline.Writer, caps map[string]bool) (bool, error) {
+	multiAck := caps["multi_ack"] || caps["multi_ack_detailed"]
+	detailed := caps["multi_ack_detailed"]
+	noDone := caps["no-done"]
+
+	var lastCommon string
+	var gotCommon, gotOther, sentReady bool
+	haves, done, err := readHaves(pr)
+	if err != nil {
+		return false, err
+	}
+	found, err := store.ExistsMany(n.ctx, n.store, haves)
+	if err != nil {
+		return false, fmt.Errorf("exists: %w", err)
+	}
+
+	for i, sha := range haves {
+		if !found[i] {
+			gotOther = true
+			if multiAck {
+				ok, err := n.okToGiveUp()
+				if err != nil {
+					return false, err
+				}
+				if ok {
+					if detailed {
+						sentReady = true
+						err = pw.Linef("ACK %s ready", sha)
+					} else {
+						err = pw.Linef("ACK %s continue", sha)
+					}
+					if err != nil {
+						return false, err
+					}
+				}
+			}
+			continue
+		}
+
+		gotCommon = true
+		lastCommon = sha
+		n.common = append(n.common, sha)
+		switch {
+		case detailed:
+			err = pw.Linef("ACK %s common", sha)
+		case multiAck:
+			err = pw.Linef("ACK %s continue", sha)
+		case len(n.common) == 1:
+			err = pw.Linef("ACK %s", sha)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if done {
+		if len(n.common) > 0 {
+			if multiAck {
+				return true, pw.Linef("ACK %s", lastCommon)
+			}
+			return true, nil
+		}
+		return true, pw.WriteLine("NAK")
+	}
+
+	if detailed && gotCommon && !gotOther {
+		ok, err := n.okToGiveUp()
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			sentReady = true
+			if err := pw.Linef("ACK %s ready", lastCommon); err != nil {
+				return false, err
+			}
+		}
+	}
+	if len(n.common) == 0 || multiAck {
+		if err := pw.WriteLine("NAK"); err != nil {
+			return false, err
+		}
+	}
+	if noDone && sentReady {
+		return true, pw.Linef("ACK %s", lastCommon)
+	}
+	return false, nil
+}
+
+// readHaves reads one round of have lines, up to a flush or "done", so
+// that the whole round can be checked against the store in one batch.
+func readHaves(pr *pktline.Reader) (haves []string, done bool, err error) {
+	for {
+		typ, line, err := pr.ReadLine()
+		if err != nil {
+			return nil, false, fmt.Errorf("read have: %w", err)
+		}
+		if typ == pktline.Flush {
+			return haves, false, nil
+		}
+		if line == "done" {
+			return haves, true, nil
+		}
+		sha, ok := strings.CutPrefix(line, "have ")
+		if !ok {
+			return nil, false, fmt.Errorf("unexpected line %q", line)
+		}
+		haves = append(haves, sha)
+	}
+}
+
+// okToGiveUp reports whether every wanted commit can reach a commit the
+// client has, meaning further haves cannot shrink the pack much.
+func (n *negotiator) okToGiveUp() (bool, error) {
+	if len(n.common) == 0 {
+		return false, nil
+	}
+	if n.reachable == nil {
+		n.reachable = make(map[string]bool)
+	}
+	common := make(map[string]bool, len(n.common))
+	for _, sha := range n.common {
+		common[sha] = true
+	}
+
+	for _, want := range n.wants {
+		if n.reachable[want] {
+			continue
+		}
+		ok, err := n.reaches(want, common)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, nil
+		}
+		n.reachable[want] = true
+	}
+	return true, nil
+}
+
+func (n *negotiator) reaches(from string, common map[string]bool) (bool, error) {
+	seen := map[string]bool{from: true}
+	queue := []string{from}
+	for len(queue) > 0 {
+		sha := queue[0]
+		queue = queue[1:]
+		if common[sha] {
+			return true, nil
+		}
+		parents, err := n.commitParents(sha)
+		if err != nil {
+			return false, err
+		}
+		for _, p := range parents {
+			if !seen[p] {
+				seen[p] = true
+				queue = append(queue, p)
+			}
+		}
+	}
+	return false, nil
+}
+
+func (n *negotiator) commitParents(sha string) ([]string, error) {
+	if parents, ok := n.parents[sha]; ok {
+		return parents, nil
+	}
+	obj, err := n.store.Get(n.ctx, sha)
+	if err != nil {
+		return nil, fmt.Errorf("get %s: %w", sha, err)
+	}
+	var parents []string
+	if obj.Type == object.TypeCommit {
+		links, err := object.ScanCommit(obj.Data)
+		if err != nil {
+			return nil, fmt.Errorf("object %s: %w", sha, err)
+		}
+		parents = links.Parents
+	}
+	n.parents[sha] = parents
+	return parents, nil
+}
+
+func sendPack(ctx context.Context, w io.Writer, pw *pktline.Writer, s store.ObjectStore, refs []store.Ref, req *request, common []string) error {
+	var data, progress io.Writer = w, nil
+	switch {
+	case req.caps["side-band-64k"]:
+		data = pktline.NewSidebandWriter(pw, pktline.BandData, pktline.MaxSidebandLen)
+		progress = pktline.NewSidebandWriter(pw, pktline.BandProgress, pktline.MaxSidebandLen)
+	case req.caps["side-band"]:
+		data = pktline.NewSidebandWriter(pw, pktline.BandData, pktline.MaxSmallSidebandLen)
+		progress = pktline.NewSidebandWriter(pw, pktline.BandProgress, pktline.MaxSmallSidebandLen)
+	}
+	if req.caps["no-progress"] {
+		progress = nil
+	}
+
+	fail := func(err error) error {
+		if data != w {
+			pktline.NewSidebandWriter(pw, pktline.BandError, pktline.MaxSmallSidebandLen).Write([]byte(err.Error()))
+		}
+		return err
+	}
+
+	shas, err := pack.ListObjects(ctx, s, req.wants, common)
+	if err != nil {
+		return fail(err)
+	}
+	if req.caps["include-tag"] {
+		if shas, err = includeTags(ctx, s, refs, shas); err != nil {
+			return fail(err)
+		}
+	}
+	if progress != nil {
+		fmt.Fprintf(progress, "Enumerating objects: %d, done.\n", len(shas))
+	}
+
+	opts := pack.WriteOptions{}
+	if req.caps["ofs-delta"] {
+		opts.DeltaWindow = pack.DefaultDeltaWindow
+	}
+	if progress != nil {
+		opts.Progress = writeProgress(progress)
+	}
+	if _, err := pack.Write(ctx, data, s, shas, opts); err != nil {
+		return fail(err)
+	}
+
+	if data != w {
+		return pw.Flush()
+	}
+	return nil
+}
+
+// includeTags adds annotated tags whose target is already being sent.
+func includeTags(ctx context.Context, s store.ObjectStore, refs []store.Ref, shas []string) ([]string, error) {
+	sending := make(map[string]bool, len(shas))
+	for _, sha := range shas {
+		sending[sha] = true
+	}
+	for _, ref := range refs {
+		if !strings.HasPrefix(ref.Name, "refs/tags/") || sending[ref.SHA] {
+			continue
+		}
+		peeled, err := peel(ctx, s, ref.SHA)
+		if err != nil {
+			return nil, err
+		}
+		if peeled != ref.SHA && sending[peeled] {
+			sending[ref.SHA] = true
+			shas = append(shas, ref.SHA)
+		}
+	}
+	return shas, nil
+}
+
+func writeProgress(w io.Writer) pack.ProgressFunc {
+	last := -1
+	return func(done, total int) {
+		pct := done * 100 / max(total, 1)
+		if pct == last && done != total {
+			return
+		}
+		last = pct
+		if done == total {
+			fmt.Fprintf(w, "Writing objects: 100%% (%d/%d), done.\n", done, total)
+			return
+		}
+		fmt.Fprintf(w, "Writing objects: %3d%% (%d/%d)\r", pct, done, total)
+	}
+}
+
+// advertisedTips returns every SHA a client may ask for: the advertised
+// refs and their peeled values.
+func advertisedTips(ctx context.Context, s store.ObjectStore, refs []store.Ref) (map[string]bool, error) {
+	tips := make(map[string]bool, len(refs))
+	for _, ref := range refs {
+		if ref.SHA == "" {
+			continue
+		}
+		tips[ref.SHA] = true
+		if strings.HasPrefix(ref.Name, "refs/tags/") {
+			peeled, err := peel(ctx, s, ref.SHA)
+			if err != nil {
+				return nil, err
+			}
+			tips[peeled] = true
+		}
+	}
+	return tips, nil
+}
+
+// peel follows annotated tags from sha to the object they point at. Only
+// the type of each object is read until it is known to be a tag, so a tag
+// ref naming a large blob or tree costs no more than one naming a commit.
+func peel(ctx context.Context, s store.ObjectStore, sha string) (string, error) {
+	for {
+		typ, _, err := store.Stat(ctx, s, sha)
+		if err != nil {
+			return "", fmt.Errorf("stat %s: %w", sha, err)
+		}
+		if typ != object.TypeTag {
+			return sha, nil
+		}
+		obj, err := s.Get(ctx, sha)
+		if err != nil {
+			return "", fmt.Errorf("get %s: %w", sha, err)
+		}
+		target, err := object.TagObject(obj.Data)
+		if err != nil {
+			return "", fmt.Errorf("object %s: %w", sha, err)
+		}
+		sha = target
+	}
+}
@@ -0,0 +1,229 @@
+package uploadpack
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/pack"
+	"git.wyat.me/git-storage/protocol/pktline"
+	"git.wyat.me/git-storage/store"
+	"git.wyat.me/git-storage/store/sqlite"
+)
+
+func TestAdvertiseRefs(t *testing.T) {
+	s, refs := newTestRepo(t)
+
+	var buf bytes.Buffer
+	if err := AdvertiseRefs(t.Context(), &buf, s, refs); err != nil {
+		t.Fatalf("AdvertiseRefs failed: %v", err)
+	}
+
+	lines := readLines(t, &buf)
+	if len(lines) != 2 {
+		t.Fatalf("got %d ref lines, want 2: %q", len(lines), lines)
+	}
+	head, caps, _ := strings.Cut(lines[0], "\x00")
+	if head != refs[0].SHA+" HEAD" {
+		t.Errorf("first line %q, want HEAD", head)
+	}
+	if !strings.Contains(caps, "symref=HEAD:refs/heads/main") || !strings.Contains(caps, "multi_ack_detailed") {
+		t.Errorf("capabilities missing symref or multi_ack_detailed: %q", caps)
+	}
+	if lines[1] != refs[1].SHA+" refs/heads/main" {
+		t.Errorf("second line %q, want main", lines[1])
+	}
+}
+
+// tagBodiesOnly fails every Get of anything but a tag, so that peeling shows
+// whether it read the body of an object it only needed the type of.
+type tagBodiesOnly struct{ *sqlite.SQLiteStore }
+
+func (s tagBodiesOnly) Get(ctx context.Context, sha string) (*object.Object, error) {
+	obj, err := s.SQLiteStore.Get(ctx, sha)
+	if err == nil && obj.Type != object.TypeTag {
+		return nil, fmt.Errorf("read the body of %s %s", obj.Type, sha)
+	}
+	return obj, err
+}
+
+func TestAdvertiseTagsReadsOnlyTypes(t *testing.T) {
+	s, refs := newTestRepo(t)
+	blob, err := s.Put(t.Context(), &object.Object{Type: object.TypeBlob, Data: bytes.Repeat([]byte("large\n"), 1000)})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	tag, err := s.Put(t.Context(), &object.Object{
+		Type: object.TypeTag,
+		Data: []byte("object " + blob + "\ntype blob\ntag annotated\ntagger Test <test@example.com> 1700000000 +0000\n\nblob\n"),
+	})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	refs = append(refs, store.Ref{Name: "refs/tags/annotated", SHA: tag}, store.Ref{Name: "refs/tags/lightweight", SHA: blob})
+
+	var buf bytes.Buffer
+	if err := AdvertiseRefs(t.Context(), &buf, tagBodiesOnly{s}, refs); err != nil {
+		t.Fatalf("AdvertiseRefs failed: %v", err)
+	}
+	lines := readLines(t, &buf)
+	want := []string{tag + " refs/tags/annotated", blob + " refs/tags/annotated^{}", blob + " refs/tags/lightweight"}
+	if len(lines) != 5 || !slices.Equal(lines[2:], want) {
+		t.Errorf("got ref lines %q, want the tags %q", lines, want)
+	}
+}
+
+func TestAdvertiseEmptyRepo(t *testing.T) {
+	s, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+
+	var buf bytes.Buffer
+	if err := AdvertiseRefs(t.Context(), &buf, s, []store.Ref{{Name: "HEAD", Target: "refs/heads/main"}}); err != nil {
+		t.Fatalf("AdvertiseRefs failed: %v", err)
+	}
+	lines := readLines(t, &buf)
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], zeroSHA+" capabilities^{}\x00") {
+		t.Errorf("unexpected empty advertisement: %q", lines)
+	}
+}
+
+func TestServeClone(t *testing.T) {
+	s, refs := newTestRepo(t)
+	tip := refs[1].SHA
+
+	var req bytes.Buffer
+	pw := pktline.NewWriter(&req)
+	pw.WriteLine("want " + tip + " multi_ack_detailed side-band-64k ofs-delta")
+	pw.Flush()
+	pw.WriteLine("done")
+
+	var resp bytes.Buffer
+	if err := Serve(t.Context(), &resp, &req, s, refs); err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+
+	pr := pktline.NewReader(&resp)
+	_, line, err := pr.ReadLine()
+	if err != nil || line != "NAK" {
+		t.Fatalf("expected NAK, got %q (%v)", line, err)
+	}
+
+	dst, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer dst.Close()
+
+	result, err := pack.Unpack(t.Context(), pktline.NewSidebandReader(pr, io.Discard), dst, nil)
+	if err != nil {
+		t.Fatalf("Unpack failed: %v", err)
+	}
+	if result.Objects != 3 {
+		t.Errorf("got %d objects, want commit, tree and blob", result.Objects)
+	}
+	if ok, _ := dst.Exists(t.Context(), tip); !ok {
+		t.Error("cloned pack is missing the tip commit")
+	}
+}
+
+func TestServeNegotiatesCommon(t *testing.T) {
+	s, refs := newTestRepo(t)
+	tip := refs[1].SHA
+
+	var req bytes.Buffer
+	pw := pktline.NewWriter(&req)
+	pw.WriteLine("want " + tip + " multi_ack_detailed no-done side-band-64k")
+	pw.Flush()
+	pw.WriteLine("have " + tip)
+	pw.Flush()
+
+	var resp bytes.Buffer
+	if err := Serve(t.Context(), &resp, &req, s, refs); err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+
+	pr := pktline.NewReader(&resp)
+	for _, want := range []string{"ACK " + tip + " common", "ACK " + tip + " ready", "NAK", "ACK " + tip} {
+		_, line, err := pr.ReadLine()
+		if err != nil {
+			t.Fatalf("ReadLine failed: %v", err)
+		}
+		if line != want {
+			t.Errorf("got %q, want %q", line, want)
+		}
+	}
+}
+
+func TestServeRejectsUnadvertisedWant(t *testing.T) {
+	s, refs := newTestRepo(t)
+
+	var req bytes.Buffer
+	pw := pktline.NewWriter(&req)
+	pw.WriteLine("want " + strings.Repeat("1", 40))
+	pw.Flush()
+	pw.WriteLine("done")
+
+	if err := Serve(t.Context(), io.Discard, &req, s, refs); err == nil {
+		t.Error("expected error for want that is not an advertised ref")
+	}
+}
+
+// newTestRepo stores a single commit with one file and returns refs for
+// HEAD and refs/heads/main pointing at it.
+func newTestRepo(t *testing.T) (*sqlite.SQLiteStore, []store.Ref) {
+	t.Helper()
+
+	s, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	blob, err := s.Put(t.Context(), &object.Object{Type: object.TypeBlob, Data: []byte("hello\n")})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	raw, _ := hex.DecodeString(blob)
+	tree, err := s.Put(t.Context(), &object.Object{Type: object.TypeTree, Data: []byte("100644 hello.txt\x00" + string(raw))})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	ident := "Test <test@example.com> 1700000000 +0000"
+	commit, err := s.Put(t.Context(), &object.Object{
+		Type: object.TypeCommit,
+		Data: []byte("tree " + tree + "\nauthor " + ident + "\ncommitter " + ident + "\n\ninitial\n"),
+	})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	return s, []store.Ref{
+		{Name: "HEAD", SHA: commit, Target: "refs/heads/main"},
+		{Name: "refs/heads/main", SHA: commit},
+	}
+}
+
+func readLines(t *testing.T, r io.Reader) []string {
+	t.Helper()
+	pr := pktline.NewReader(r)
+	var lines []string
+	for {
+		typ, line, err := pr.ReadLine()
+		if err != nil {
+			t.Fatalf("ReadLine failed: %v", err)
+		}
+		if typ == pktline.Flush {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
@@ -0,0 +1,273 @@
+package uploadpack
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"git.wyat.me/git-storage/protocol/pktline"
+	"git.wyat.me/git-storage/store"
+)
+
+var v2Capabilities = []string{
+	agent,
+	"ls-refs=unborn",
+	"fetch",
+	"object-info",
+	"object-format=sha1",
+}
+
+// AdvertiseV2 writes the protocol v2 capability advertisement. Unlike v0,
+// no refs are sent up front; clients ask for them with ls-refs.
+func AdvertiseV2(w io.Writer) error {
+	pw := pktline.NewWriter(w)
+	if err := pw.WriteLine("version 2"); err != nil {
+		return err
+	}
+	for _, c := range v2Capabilities {
+		if err := pw.WriteLine(c); err != nil {
+			return err
+		}
+	}
+	return pw.Flush()
+}
+
+// ServeV2 dispatches a single protocol v2 command request.
+func ServeV2(ctx context.Context, w io.Writer, r io.Reader, s store.ObjectStore, refs []store.Ref) error {
+	pr := pktline.NewReader(r)
+	pw := pktline.NewWriter(w)
+
+	command, args, err := readCommand(pr)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "ls-refs":
+		return lsRefs(ctx, pw, s, refs, args)
+	case "fetch":
+		return fetch(ctx, w, pw, s, refs, args)
+	case "object-info":
+		return objectInfo(ctx, pw, s, args)
+	case "":
+		return nil
+	}
+	return fmt.Errorf("unknown command %q", command)
+}
+
+// readCommand reads "command=<name>", the capability list and, after the
+// delimiter, the command's arguments up to the closing flush.
+func readCommand(pr *pktline.Reader) (string, []string, error) {
+	var command string
+	var args []string
+	inArgs := false
+	for {
+		typ, line, err := pr.ReadLine()
+		if err == io.EOF && command == "" {
+			return "", nil, nil
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("read command: %w", err)
+		}
+
+		switch typ {
+		case pktline.Flush:
+			return command, args, nil
+		case pktline.Delim:
+			inArgs = true
+			continue
+		}
+
+		if inArgs {
+			args = append(args, line)
+		} else if name, ok := strings.CutPrefix(line, "command="); ok {
+			command = name
+		} else if format, ok := strings.CutPrefix(line, "object-format="); ok && format != "sha1" {
+			return "", nil, fmt.Errorf("unsupported object format %q", format)
+		}
+	}
+}
+
+func lsRefs(ctx context.Context, pw *pktline.Writer, s store.ObjectStore, refs []store.Ref, args []string) error {
+	var symrefs, peelTags, unborn bool
+	var prefixes []string
+	for _, arg := range args {
+		switch {
+		case arg == "symrefs":
+			symrefs = true
+		case arg == "peel":
+			peelTags = true
+		case arg == "unborn":
+			unborn = true
+		case strings.HasPrefix(arg, "ref-prefix "):
+			prefixes = append(prefixes, strings.TrimPrefix(arg, "ref-prefix "))
+		}
+	}
+
+	for _, ref := range refs {
+		if !matchesPrefix(ref.Name, prefixes) {
+			continue
+		}
+
+		var line string
+		switch {
+		case ref.SHA != "":
+			line = ref.SHA + " " + ref.Name
+		case unborn && ref.Target != "":
+			line = "unborn " + ref.Name
+		default:
+			continue
+		}
+		if symrefs && ref.Target != "" {
+			line += " symref-target:" + ref.Target
+		}
+		if peelTags && ref.SHA != "" && strings.HasPrefix(ref.Name, "refs/tags/") {
+			peeled, err := peel(ctx, s, ref.SHA)
+			if err != nil {
+				return fmt.Errorf("peel %s: %w", ref.Name, err)
+			}
+			if peeled != ref.SHA {
+				line += " peeled:" + peeled
+			}
+		}
+		if err := pw.WriteLine(line); err != nil {
+			return err
+		}
+	}
+	return pw.Flush()
+}
+
+func matchesPrefix(name string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, p := range prefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func fetch(ctx context.Context, w io.Writer, pw *pktline.Writer, s store.ObjectStore, refs []store.Ref, args []string) error {
+	// v2 always multiplexes the packfile section, so side-band-64k is
+	// implied rather than negotiated.
+	req := &request{caps: map[string]bool{"side-band-64k": true}}
+	var haves []string
+	done := false
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "want "):
+			req.wants = append(req.wants, strings.TrimPrefix(arg, "want "))
+		case strings.HasPrefix(arg, "have "):
+			haves = append(haves, strings.TrimPrefix(arg, "have "))
+		case arg == "done":
+			done = true
+		case arg == "ofs-delta", arg == "include-tag", arg == "no-progress", arg == "thin-pack":
+			req.caps[arg] = true
+		case strings.HasPrefix(arg, "shallow "), strings.HasPrefix(arg, "deepen"):
+			return fmt.Errorf("shallow clones are not supported")
+		}
+	}
+
+	tips, err := advertisedTips(ctx, s, refs)
+	if err != nil {
+		return err
+	}
+	for _, want := range req.wants {
+		if !tips[want] {
+			return fmt.Errorf("not our ref %s", want)
+		}
+	}
+
+	n := &negotiator{ctx: ctx, store: s, wants: req.wants, parents: make(map[string][]string)}
+	found, err := store.ExistsMany(ctx, s, haves)
+	if err != nil {
+		return fmt.Errorf("exists: %w", err)
+	}
+	for i, sha := range haves {
+		if found[i] {
+			n.common = append(n.common, sha)
+		}
+	}
+
+	if !done {
+		if err := pw.WriteLine("acknowledgments"); err != nil {
+			return err
+		}
+		if len(n.common) == 0 {
+			if err := pw.WriteLine("NAK"); err != nil {
+				return err
+			}
+		}
+		for _, sha := range n.common {
+			if err := pw.WriteLine("ACK " + sha); err != nil {
+				return err
+			}
+		}
+		ready, err := n.okToGiveUp()
+		if err != nil {
+			return err
+		}
+		if !ready {
+			return pw.Flush()
+		}
+		if err := pw.WriteLine("ready"); err != nil {
+			return err
+		}
+		if err := pw.Delim(); err != nil {
+			return err
+		}
+	}
+
+	if err := pw.WriteLine("packfile"); err != nil {
+		return err
+	}
+	return sendPack(ctx, w, pw, s, refs, req, n.common)
+}
+
+// objectInfo answers size queries without sending or reading object
+// contents.
+func objectInfo(ctx context.Context, pw *pktline.Writer, s store.ObjectStore, args []string) error {
+	wantSize := false
+	var oids []string
+	for _, arg := range args {
+		switch {
+		case arg == "size":
+			wantSize = true
+		case strings.HasPrefix(arg, "oid "):
+			oids = append(oids, strings.TrimPrefix(arg, "oid "))
+		}
+	}
+
+	if wantSize {
+		if err := pw.WriteLine("size"); err != nil {
+			return err
+		}
+	}
+	var found []bool
+	if wantSize {
+		var err error
+		if found, err = store.ExistsMany(ctx, s, oids); err != nil {
+			return fmt.Errorf("exists: %w", err)
+		}
+	}
+	for i, oid := range oids {
+		line := oid
+		if wantSize {
+			line += " "
+			if found[i] {
+				_, size, err := store.Stat(ctx, s, oid)
+				if err != nil {
+					return fmt.Errorf("stat %s: %w", oid, err)
+				}
+				line += fmt.Sprint(size)
+			}
+		}
+		if err := pw.WriteLine(line); err != nil {
+			return err
+		}
+	}
+	return pw.Flush()
+}
@@ -0,0 +1,124 @@
+package uploadpack
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"git.wyat.me/git-storage/pack"
+	"git.wyat.me/git-storage/protocol/pktline"
+	"git.wyat.me/git-storage/store"
+	"git.wyat.me/git-storage/store/sqlite"
+)
+
+func TestLsRefsPrefixAndSymrefs(t *testing.T) {
+	s, refs := newTestRepo(t)
+	refs = append(refs, store.Ref{Name: "refs/tags/v1", SHA: refs[1].SHA})
+	tip := refs[1].SHA
+
+	resp := serveV2(t, s, refs, "ls-refs", "symrefs", "peel", "ref-prefix HEAD", "ref-prefix refs/heads/")
+	lines := readLines(t, resp)
+	want := []string{
+		tip + " HEAD symref-target:refs/heads/main",
+		tip + " refs/heads/main",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("ls-refs got %q, want %q", lines, want)
+	}
+}
+
+func TestLsRefsUnborn(t *testing.T) {
+	s, _ := newTestRepo(t)
+	refs := []store.Ref{{Name: "HEAD", Target: "refs/heads/main"}}
+
+	lines := readLines(t, serveV2(t, s, refs, "ls-refs", "symrefs", "unborn"))
+	if len(lines) != 1 || lines[0] != "unborn HEAD symref-target:refs/heads/main" {
+		t.Errorf("ls-refs got %q, want unborn HEAD", lines)
+	}
+}
+
+func TestFetchV2(t *testing.T) {
+	s, refs := newTestRepo(t)
+	tip := refs[1].SHA
+
+	resp := serveV2(t, s, refs, "fetch", "want "+tip, "ofs-delta", "done")
+	pr := pktline.NewReader(resp)
+	if _, line, err := pr.ReadLine(); err != nil || line != "packfile" {
+		t.Fatalf("expected packfile section, got %q (%v)", line, err)
+	}
+
+	dst, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer dst.Close()
+
+	result, err := pack.Unpack(t.Context(), pktline.NewSidebandReader(pr, io.Discard), dst, nil)
+	if err != nil {
+		t.Fatalf("Unpack failed: %v", err)
+	}
+	if result.Objects != 3 {
+		t.Errorf("got %d objects, want 3", result.Objects)
+	}
+}
+
+func TestFetchV2Acknowledgments(t *testing.T) {
+	s, refs := newTestRepo(t)
+	tip := refs[1].SHA
+	unknown := strings.Repeat("1", 40)
+
+	lines := readLines(t, serveV2(t, s, refs, "fetch", "want "+tip, "have "+unknown))
+	if strings.Join(lines, "\n") != "acknowledgments\nNAK" {
+		t.Errorf("got %q, want acknowledgments with NAK", lines)
+	}
+
+	resp := serveV2(t, s, refs, "fetch", "want "+tip, "have "+tip)
+	pr := pktline.NewReader(resp)
+	for _, want := range []string{"acknowledgments", "ACK " + tip, "ready"} {
+		if _, line, err := pr.ReadLine(); err != nil || line != want {
+			t.Fatalf("got %q (%v), want %q", line, err, want)
+		}
+	}
+	if typ, _, err := pr.ReadPacket(); err != nil || typ != pktline.Delim {
+		t.Fatalf("expected delim after ready, got %v (%v)", typ, err)
+	}
+}
+
+func TestObjectInfo(t *testing.T) {
+	s, refs := newTestRepo(t)
+	tip := refs[1].SHA
+	missing := strings.Repeat("1", 40)
+
+	obj, err := s.Get(t.Context(), tip)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	lines := readLines(t, serveV2(t, s, refs, "object-info", "size", "oid "+tip, "oid "+missing))
+	want := []string{"size", tip + " " + strconv.Itoa(len(obj.Data)), missing + " "}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("object-info got %q, want %q", lines, want)
+	}
+}
+
+func serveV2(t *testing.T, s store.ObjectStore, refs []store.Ref, command string, args ...string) *bytes.Buffer {
+	t.Helper()
+
+	var req bytes.Buffer
+	pw := pktline.NewWriter(&req)
+	pw.WriteLine("command=" + command)
+	pw.WriteLine("object-format=sha1")
+	pw.Delim()
+	for _, arg := range args {
+		pw.WriteLine(arg)
+	}
+	pw.Flush()
+
+	var resp bytes.Buffer
+	if err := ServeV2(t.Context(), &resp, &req, s, refs); err != nil {
+		t.Fatalf("ServeV2 %s failed: %v", command, err)
+	}
+	return &resp
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -12,8 +13,12 @@ import (
 	"time"
 
 	"git.wyat.me/git-storage/bench"
+	"git.wyat.me/git-storage/store"
 	"git.wyat.me/git-storage/store/badger"
+	"git.wyat.me/git-storage/store/fs"
 	ministore "git.wyat.me/git-storage/store/minio"
+	"git.wyat.me/git-storage/store/packfs"
+	"git.wyat.me/git-storage/store/s3pack"
 	"git.wyat.me/git-storage/store/sqlite"
 )
 
@@ -24,6 +29,44 @@ type benchHistory struct {
 
 var history = &benchHistory{}
 
+// backendTimeout bounds a single backend's run, so a slow or unreachable
+// remote store cannot hold the request open indefinitely.
+const backendTimeout = 5 * time.Minute
+
+// runBackend runs the benchmark for one backend under backendTimeout. The
+// run is also cancelled if the client goes away.
+func runBackend(ctx context.Context, name string, s store.ObjectStore) bench.BackendResult {
+	ctx, cancel := context.WithTimeout(ctx, backendTimeout)
+	defer cancel()
+	return bench.RunBackend(ctx, name, s)
+}
+
+// deltaStore is a backend the delta benchmark can measure and then close.
+type deltaStore interface {
+	store.SizeStore
+	Close() error
+}
+
+// runDeltas runs the delta storage benchmark for one backend under
+// backendTimeout, opening each store at a fresh path under dir.
+func runDeltas(ctx context.Context, name, dir string, open func(path string, opts ...store.Option) (deltaStore, error)) bench.DeltaResult {
+	ctx, cancel := context.WithTimeout(ctx, backendTimeout)
+	defer cancel()
+	var opened []deltaStore
+	defer func() {
+		for _, s := range opened {
+			s.Close()
+		}
+	}()
+	return bench.RunDeltas(ctx, name, func(opts ...store.Option) (store.SizeStore, error) {
+		s, err := open(filepath.Join(dir, fmt.Sprint(len(opened))), opts...)
+		if err == nil {
+			opened = append(opened, s)
+		}
+		return s, err
+	})
+}
+
 func (s *Server) handleBenchRun(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -63,7 +106,7 @@ func (s *Server) handleBenchRun(w http.ResponseWriter, r *http.Request) {
 	}
 	defer sqliteStore.Close()
 	sendEvent("progress", map[string]string{"backend": "SQLite", "status": "running"})
-	result := bench.RunBackend("SQLite", sqliteStore)
+	result := runBackend(r.Context(), "SQLite", sqliteStore)
 	run.Backends = append(run.Backends, result)
 	sendEvent("backend", result)
 
@@ -82,7 +125,45 @@ func (s *Server) handleBenchRun(w http.ResponseWriter, r *http.Request) {
 	}
 	defer badgerStore.Close()
 	sendEvent("progress", map[string]string{"backend": "BadgerDB", "status": "running"})
-	result = bench.RunBackend("BadgerDB", badgerStore)
+	result = runBackend(r.Context(), "BadgerDB", badgerStore)
+	run.Backends = append(run.Backends, result)
+	sendEvent("backend", result)
+
+	// git's loose objects — temp dir
+	fsDir, err := os.MkdirTemp("", "fs-bench-*")
+	if err != nil {
+		sendEvent("error", map[string]string{"message": "failed to create fs temp dir"})
+		return
+	}
+	defer os.RemoveAll(fsDir)
+
+	fsStore, err := fs.New(fsDir)
+	if err != nil {
+		sendEvent("error", map[string]string{"message": "failed to create fs store"})
+		return
+	}
+	defer fsStore.Close()
+	sendEvent("progress", map[string]string{"backend": "Loose files", "status": "running"})
+	result = runBackend(r.Context(), "Loose files", fsStore)
+	run.Backends = append(run.Backends, result)
+	sendEvent("backend", result)
+
+	// git's packfiles — temp dir
+	packDir, err := os.MkdirTemp("", "packfs-bench-*")
+	if err != nil {
+		sendEvent("error", map[string]string{"message": "failed to create packfs temp dir"})
+		return
+	}
+	defer os.RemoveAll(packDir)
+
+	packStore, err := packfs.New(packDir)
+	if err != nil {
+		sendEvent("error", map[string]string{"message": "failed to create packfs store"})
+		return
+	}
+	defer packStore.Close()
+	sendEvent("progress", map[string]string{"backend": "Packfiles", "status": "running"})
+	result = runBackend(r.Context(), "Packfiles", packStore)
 	run.Backends = append(run.Backends, result)
 	sendEvent("backend", result)
 
@@ -119,14 +200,65 @@ func (s *Server) handleBenchRun(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			log.Printf("minio init failed (skipping): %v", err)
 		} else {
-			defer minioStore.Flush()
+			// clean up the bucket even if the client disconnected mid-run
+			defer minioStore.Flush(context.WithoutCancel(r.Context()))
 			sendEvent("progress", map[string]string{"backend": "MinIO/S3", "status": "running"})
-			result = bench.RunBackend("MinIO/S3", minioStore)
+			result = runBackend(r.Context(), "MinIO/S3", minioStore)
+			run.Backends = append(run.Backends, result)
+			sendEvent("backend", result)
+		}
+	}
+
+	// packs in the same bucket, with the indexes cached in a temp dir
+	if minioEndpoint != "" {
+		cacheDir, err := os.MkdirTemp("", "s3pack-bench-*")
+		if err != nil {
+			sendEvent("error", map[string]string{"message": "failed to create s3pack temp dir"})
+			return
+		}
+		defer os.RemoveAll(cacheDir)
+
+		s3packStore, err := s3pack.New(
+			minioEndpoint,
+			accessKey,
+			secretKey,
+			bucket,
+			true, // Railway buckets use SSL
+			cacheDir,
+		)
+		if err != nil {
+			log.Printf("s3pack init failed (skipping): %v", err)
+		} else {
+			defer s3packStore.Flush(context.WithoutCancel(r.Context()))
+			defer s3packStore.Close()
+			sendEvent("progress", map[string]string{"backend": "S3 packs", "status": "running"})
+			result = runBackend(r.Context(), "S3 packs", s3packStore)
 			run.Backends = append(run.Backends, result)
 			sendEvent("backend", result)
 		}
 	}
 
+	deltaDir, err := os.MkdirTemp("", "delta-bench-*")
+	if err != nil {
+		sendEvent("error", map[string]string{"message": "failed to create delta temp dir"})
+		return
+	}
+	defer os.RemoveAll(deltaDir)
+	sendEvent("progress", map[string]string{"backend": "deltas", "status": "running"})
+	run.Deltas = []bench.DeltaResult{
+		runDeltas(r.Context(), "SQLite", deltaDir, func(path string, opts ...store.Option) (deltaStore, error) {
+			return sqlite.New(path+".db", opts...)
+		}),
+		runDeltas(r.Context(), "BadgerDB", deltaDir, func(path string, opts ...store.Option) (deltaStore, error) {
+			return badger.New(path, opts...)
+		}),
+	}
+	sendEvent("deltas", run.Deltas)
+
+	sendEvent("progress", map[string]string{"backend": "codecs", "status": "running"})
+	run.Codecs = bench.RunCodecs(r.Context())
+	sendEvent("codecs", run.Codecs)
+
 	history.mu.Lock()
 	history.results = append(history.results, run)
 	history.mu.Unlock()
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"git.wyat.me/git-storage/protocol/pktline"
+	"git.wyat.me/git-storage/protocol/receivepack"
+	"git.wyat.me/git-storage/protocol/uploadpack"
+	"git.wyat.me/git-storage/store"
+)
+
+func (s *Server) handleInfoRefs(w http.ResponseWriter, r *http.Request, repoName string) {
+	service := r.URL.Query().Get("service")
+	if service != "git-upload-pack" && service != "git-receive-pack" {
+		http.Error(w, "unsupported service", http.StatusForbidden)
+		return
+	}
+
+	repo, err := s.openRepo(r.Context(), repoName)
+	if err != nil {
+		log.Printf("open repo %s: %v", repoName, err)
+		http.Error(w, "failed to open repo", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-"+service+"-advertisement")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	// git http-backend omits the service header for v2, and so do we.
+	if service == "git-upload-pack" && wantsV2(r) {
+		if err := uploadpack.AdvertiseV2(w); err != nil {
+			log.Printf("advertise v2 %s: %v", repoName, err)
+		}
+		return
+	}
+
+	refs, err := repo.listRefs(r.Context())
+	if err != nil {
+		log.Printf("list refs %s: %v", repoName, err)
+		http.Error(w, "failed to list refs", http.StatusInternalServerError)
+		return
+	}
+
+	pw := pktline.NewWriter(w)
+	if err := pw.WriteLine("# service=" + service); err != nil {
+		return
+	}
+	if err := pw.Flush(); err != nil {
+		return
+	}
+	if service == "git-receive-pack" {
+		err = receivepack.AdvertiseRefs(w, refs)
+	} else {
+		err = uploadpack.AdvertiseRefs(r.Context(), w, repo.store, refs)
+	}
+	if err != nil {
+		log.Printf("advertise refs %s: %v", repoName, err)
+	}
+}
+
+func (s *Server) handleUploadPack(w http.ResponseWriter, r *http.Request, repoName string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, err := s.openRepo(r.Context(), repoName)
+	if err != nil {
+		log.Printf("open repo %s: %v", repoName, err)
+		http.Error(w, "failed to open repo", http.StatusInternalServerError)
+		return
+	}
+
+	body, err := requestBody(r)
+	if err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer body.Close()
+
+	refs, err := repo.listRefs(r.Context())
+	if err != nil {
+		log.Printf("list refs %s: %v", repoName, err)
+		http.Error(w, "failed to list refs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	serve := uploadpack.Serve
+	if wantsV2(r) {
+		serve = uploadpack.ServeV2
+	}
+	if err := serve(r.Context(), w, body, repo.store, refs); err != nil {
+		log.Printf("upload-pack %s: %v", repoName, err)
+	}
+}
+
+func (s *Server) handleReceivePack(w http.ResponseWriter, r *http.Request, repoName string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, err := s.openRepo(r.Context(), repoName)
+	if err != nil {
+		log.Printf("open repo %s: %v", repoName, err)
+		http.Error(w, "failed to open repo", http.StatusInternalServerError)
+		return
+	}
+
+	body, err := requestBody(r)
+	if err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", "application/x-git-receive-pack-result")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	refs := store.NewLoggingRefStore(repo.store, repo.store, committer(r), "push")
+	refs.LogFailed = func(name string, err error) {
+		log.Printf("receive-pack %s: %v", repoName, err)
+	}
+	if err := receivepack.Serve(r.Context(), w, body, repo.store, refs); err != nil {
+		log.Printf("receive-pack %s: %v", repoName, err)
+	}
+}
+
+// committer names the user a push is attributed to in the reflog. The
+// server does no authentication, so a Basic Auth username is only what
+// the client claims to be and is recorded as such.
+func committer(r *http.Request) string {
+	if user, _, ok := r.BasicAuth(); ok && user != "" {
+		return user + " (unauthenticated)"
+	}
+	return "anonymous"
+}
+
+// wantsV2 reports whether the client asked for protocol v2 through the
+// Git-Protocol header, a colon-separated list of key=value parameters.
+func wantsV2(r *http.Request) bool {
+	for _, param := range strings.Split(r.Header.Get("Git-Protocol"), ":") {
+		if param == "version=2" {
+			return true
+		}
+	}
+	return false
+}
+
+// requestBody undoes the gzip encoding git applies to large requests.
+func requestBody(r *http.Request) (io.ReadCloser, error) {
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		return gzip.NewReader(r.Body)
+	}
+	return r.Body, nil
+}
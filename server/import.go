@@ -0,0 +1,251 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/pack"
+	"git.wyat.me/git-storage/store"
+	"git.wyat.me/git-storage/store/badger"
+)
+
+// importedDir is where bare repositories are moved once imported, under
+// the repo root. Its name is not a valid repo name, so it is never served.
+const importedDir = "_imported"
+
+// importBareRepos imports every bare repository that git http-backend
+// left under the repo root, from before the server spoke git itself, into
+// the object store that now serves it. An imported repository is moved
+// into importedDir rather than deleted, so it is imported only once and
+// can still be inspected or restored by hand.
+//
+// Any repository that cannot be imported is an error: starting without
+// it would serve an empty repository under its name.
+func (s *Server) importBareRepos(ctx context.Context) error {
+	entries, err := os.ReadDir(s.repoRoot)
+	if err != nil {
+		return fmt.Errorf("read repo root: %w", err)
+	}
+	for _, e := range entries {
+		name := e.Name()
+		dir := filepath.Join(s.repoRoot, name)
+		if !e.IsDir() || !isValidRepoName(name) || !isBareRepo(dir) {
+			continue
+		}
+		log.Printf("importing bare repo %s", dir)
+		if err := s.importBareRepo(ctx, name, dir); err != nil {
+			return fmt.Errorf("import %s: %w", name, err)
+		}
+		if err := os.MkdirAll(filepath.Join(s.repoRoot, importedDir), 0755); err != nil {
+			return fmt.Errorf("create %s: %w", importedDir, err)
+		}
+		if err := os.Rename(dir, filepath.Join(s.repoRoot, importedDir, name)); err != nil {
+			return fmt.Errorf("move imported %s: %w", name, err)
+		}
+		log.Printf("imported %s", name)
+	}
+	return nil
+}
+
+func isBareRepo(dir string) bool {
+	for _, name := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) importBareRepo(ctx context.Context, name, dir string) error {
+	// the history was accepted by git when it was pushed, so it is
+	// imported as it is rather than checked again
+	path := filepath.Join(s.repoRoot, "_objects", name)
+	db, err := badger.New(path)
+	if err != nil {
+		return fmt.Errorf("open object store: %w", err)
+	}
+	defer db.Close()
+
+	if err := importObjects(ctx, db, filepath.Join(dir, "objects")); err != nil {
+		return err
+	}
+	return importRefs(ctx, db, dir)
+}
+
+// importObjects stores every packed and loose object under objects.
+func importObjects(ctx context.Context, db *badger.BadgerStore, objects string) error {
+	packs, err := filepath.Glob(filepath.Join(objects, "pack", "*.pack"))
+	if err != nil {
+		return err
+	}
+	for _, path := range packs {
+		if err := importPack(ctx, db, path); err != nil {
+			return err
+		}
+	}
+
+	loose, err := filepath.Glob(filepath.Join(objects, "[0-9a-f][0-9a-f]", "*"))
+	if err != nil {
+		return err
+	}
+	for _, path := range loose {
+		if err := importLoose(ctx, db, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func importPack(ctx context.Context, db *badger.BadgerStore, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := pack.Unpack(ctx, bufio.NewReader(f), db, nil); err != nil {
+		return fmt.Errorf("unpack %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// importLoose stores a loose object, which is already in the zlib format
+// object.NewReader reads.
+func importLoose(ctx context.Context, db *badger.BadgerStore, path string) error {
+	want := filepath.Base(filepath.Dir(path)) + filepath.Base(path)
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := object.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return fmt.Errorf("loose object %s: %w", want, err)
+	}
+	sha, err := store.PutStream(ctx, db, r.Type, r.Size, r)
+	if err != nil {
+		return fmt.Errorf("loose object %s: %w", want, err)
+	}
+	if sha != want {
+		return fmt.Errorf("loose object %s: hashes to %s", want, sha)
+	}
+	return nil
+}
+
+// importRefs copies HEAD and the refs under refs/, loose or packed. A ref
+// the store already has, pushed since the server stopped using the bare
+// repository, is newer and is kept; so is HEAD, which openRepo sets as
+// soon as it opens a store.
+func importRefs(ctx context.Context, db *badger.BadgerStore, dir string) error {
+	refs, symrefs, err := readBareRefs(dir)
+	if err != nil {
+		return err
+	}
+	exists := func(name string) (bool, error) {
+		_, err := db.GetRef(ctx, name)
+		if errors.Is(err, store.ErrRefNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("read %s: %w", name, err)
+		}
+		log.Printf("import %s: keeping %s as already pushed", dir, name)
+		return true, nil
+	}
+
+	var updates []store.RefUpdate
+	for name, sha := range refs {
+		ok, err := exists(name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			updates = append(updates, store.RefUpdate{Name: name, NewSHA: sha})
+		}
+	}
+	if len(updates) > 0 {
+		entry := store.ReflogEntry{Committer: "import", Time: time.Now(), Message: "import from " + dir}
+		if err := db.UpdateRefsLogged(ctx, updates, entry); err != nil {
+			return fmt.Errorf("import refs: %w", err)
+		}
+	}
+
+	for name, target := range symrefs {
+		ok, err := exists(name)
+		if err != nil {
+			return err
+		}
+		if ok {
+			continue
+		}
+		if err := db.SetSymbolicRef(ctx, name, target); err != nil {
+			return fmt.Errorf("import %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// readBareRefs reads a bare repository's refs, returning those that point
+// at objects and the symbolic ones separately. Loose refs take precedence
+// over packed-refs, as they do in git.
+func readBareRefs(dir string) (map[string]string, map[string]string, error) {
+	refs := map[string]string{}
+	symrefs := map[string]string{}
+
+	packed, err := os.ReadFile(filepath.Join(dir, "packed-refs"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, err
+	}
+	for _, line := range strings.Split(string(packed), "\n") {
+		// "# pack-refs with: ..." and "^<sha>", the peeled value of the
+		// tag above, carry nothing to import
+		if line == "" || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+		sha, name, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, nil, fmt.Errorf("malformed packed-refs line %q", line)
+		}
+		refs[name] = sha
+	}
+
+	read := func(name string) error {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return err
+		}
+		value := strings.TrimSpace(string(data))
+		if target, ok := strings.CutPrefix(value, "ref: "); ok {
+			delete(refs, name)
+			symrefs[name] = target
+		} else {
+			refs[name] = value
+		}
+		return nil
+	}
+	if err := read("HEAD"); err != nil {
+		return nil, nil, err
+	}
+	err = filepath.WalkDir(filepath.Join(dir, "refs"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		return read(filepath.ToSlash(rel))
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return refs, symrefs, nil
+}
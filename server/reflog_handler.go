@@ -0,0 +1,37 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// handleReflog returns the history of a ref as JSON, newest first, so
+// that commits lost to a force-push can be found and restored.
+func (s *Server) handleReflog(w http.ResponseWriter, r *http.Request, repoName, ref string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, err := s.openRepo(r.Context(), repoName)
+	if err != nil {
+		log.Printf("open repo %s: %v", repoName, err)
+		http.Error(w, "failed to open repo", http.StatusInternalServerError)
+		return
+	}
+
+	entries, err := repo.store.Reflog(r.Context(), ref)
+	if err != nil {
+		log.Printf("reflog %s %s: %v", repoName, ref, err)
+		http.Error(w, "failed to read reflog", http.StatusInternalServerError)
+		return
+	}
+	if len(entries) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"git.wyat.me/git-storage/store"
+	"git.wyat.me/git-storage/store/badger"
+)
+
+// defaultBranch is where HEAD points in a newly created repository.
+const defaultBranch = "refs/heads/main"
+
+// repo is the storage behind a single repository served natively. The
+// Badger store holds both its objects and its refs.
+type repo struct {
+	store *badger.BadgerStore
+}
+
+// listRefs returns HEAD followed by every ref under refs/ in name order.
+// HEAD keeps its Target even when the branch it points at is unborn.
+func (r *repo) listRefs(ctx context.Context) ([]store.Ref, error) {
+	head, err := store.ResolveRef(ctx, r.store, "HEAD")
+	if errors.Is(err, store.ErrRefNotFound) {
+		head, err = r.store.GetRef(ctx, "HEAD")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read HEAD: %w", err)
+	}
+	refs, err := r.store.ListRefs(ctx, "refs/")
+	if err != nil {
+		return nil, err
+	}
+	return append([]store.Ref{*head}, refs...), nil
+}
+
+func (s *Server) openRepo(ctx context.Context, name string) (*repo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.repos[name]; ok {
+		return r, nil
+	}
+
+	// pushed objects are checked as git's receive.fsckObjects would, so
+	// a malformed tree never reaches a client's checkout.
+	db, err := badger.New(filepath.Join(s.repoRoot, "_objects", name), store.WithValidation())
+	if err != nil {
+		return nil, fmt.Errorf("open object store: %w", err)
+	}
+	if _, err := db.GetRef(ctx, "HEAD"); errors.Is(err, store.ErrRefNotFound) {
+		err = db.SetSymbolicRef(ctx, "HEAD", defaultBranch)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("init HEAD: %w", err)
+		}
+	} else if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("read HEAD: %w", err)
+	}
+
+	r := &repo{store: db}
+	s.repos[name] = r
+	return r, nil
+}
+
+// Close releases every open repository store.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var firstErr error
+	for name, r := range s.repos {
+		if err := r.store.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("close %s: %w", name, err)
+		}
+		delete(s.repos, name)
+	}
+	return firstErr
+}
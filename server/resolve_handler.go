@@ -0,0 +1,49 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"git.wyat.me/git-storage/store"
+)
+
+// handleResolve expands an abbreviated SHA to the full SHA of the one
+// object it names. An ambiguous prefix gets a 409 listing the candidates.
+func (s *Server) handleResolve(w http.ResponseWriter, r *http.Request, repoName, prefix string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repo, err := s.openRepo(r.Context(), repoName)
+	if err != nil {
+		log.Printf("open repo %s: %v", repoName, err)
+		http.Error(w, "failed to open repo", http.StatusInternalServerError)
+		return
+	}
+
+	sha, err := store.Resolve(r.Context(), repo.store, prefix)
+	var amb *store.AmbiguousError
+	switch {
+	case errors.As(err, &amb):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]any{"error": amb.Error(), "candidates": amb.Candidates})
+		return
+	case errors.Is(err, store.ErrInvalidAbbrev):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, store.ErrNotFound):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		log.Printf("resolve %s %s: %v", repoName, prefix, err)
+		http.Error(w, "failed to resolve", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"sha": sha})
+}
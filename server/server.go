@@ -1,19 +1,21 @@
 package server
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"net/http"
-	"net/http/cgi"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"unicode"
 )
 
 type Server struct {
 	repoRoot string
+
+	mu    sync.Mutex
+	repos map[string]*repo
 }
 
 func New(repoRoot string) (*Server, error) {
@@ -24,7 +26,11 @@ func New(repoRoot string) (*Server, error) {
 	if err := os.MkdirAll(absRoot, 0755); err != nil {
 		return nil, fmt.Errorf("create repo root: %w", err)
 	}
-	return &Server{repoRoot: absRoot}, nil
+	s := &Server{repoRoot: absRoot, repos: make(map[string]*repo)}
+	if err := s.importBareRepos(context.Background()); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
 func (s *Server) Handler() http.Handler {
@@ -59,40 +65,29 @@ func (s *Server) handleGit(w http.ResponseWriter, r *http.Request) {
 	}
 
 	repoName := parts[0]
-	repoPath := filepath.Join(s.repoRoot, repoName)
-
 	if !isValidRepoName(repoName) {
 		http.NotFound(w, r)
 		return
 	}
 
-	if _, err := os.Stat(repoPath); os.IsNotExist(err) {
-		log.Printf("creating bare repo at %s", repoPath)
-		if err := initBareRepo(repoPath); err != nil {
-			log.Printf("init bare repo failed: %v", err)
-			http.Error(w, "failed to init repo", http.StatusInternalServerError)
+	switch parts[1] {
+	case "info/refs":
+		s.handleInfoRefs(w, r, repoName)
+	case "git-upload-pack":
+		s.handleUploadPack(w, r, repoName)
+	case "git-receive-pack":
+		s.handleReceivePack(w, r, repoName)
+	default:
+		if ref, ok := strings.CutPrefix(parts[1], "reflog/"); ok {
+			s.handleReflog(w, r, repoName, ref)
 			return
 		}
-		log.Printf("bare repo created successfully")
-	}
-
-	gitPath, err := exec.LookPath("git")
-	if err != nil {
-		http.Error(w, "git not found", http.StatusInternalServerError)
-		return
-	}
-
-	handler := &cgi.Handler{
-		Path: gitPath,
-		Args: []string{"http-backend"},
-		Env: []string{
-			"GIT_PROJECT_ROOT=" + s.repoRoot,
-			"GIT_HTTP_EXPORT_ALL=1",
-		},
-		InheritEnv: []string{"PATH", "HOME", "USER"},
+		if prefix, ok := strings.CutPrefix(parts[1], "resolve/"); ok {
+			s.handleResolve(w, r, repoName, prefix)
+			return
+		}
+		http.NotFound(w, r)
 	}
-
-	handler.ServeHTTP(w, r)
 }
 
 func isValidRepoName(name string) bool {
@@ -107,15 +102,3 @@ func isValidRepoName(name string) bool {
 	}
 	return true
 }
-
-func initBareRepo(path string) error {
-	cmd := exec.Command("git", "init", "--bare", path)
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("git init --bare: %w\n%s", err, out)
-	}
-	cmd = exec.Command("git", "-C", path, "config", "http.receivepack", "true")
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("git config http.receivepack: %w\n%s", err, out)
-	}
-	return nil
-}
@@ -1,79 +1,102 @@
 package badger
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
 	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
 	"github.com/dgraph-io/badger/v4"
 )
 
+// BadgerStore keeps objects, refs and reflogs in one Badger database.
+// Badger calls cannot be interrupted, so methods only check their context
+// before starting and between iterations.
 type BadgerStore struct {
-	db *badger.DB
+	db   *badger.DB
+	opts store.Options
 }
 
-func New(path string) (*BadgerStore, error) {
-	opts := badger.DefaultOptions(path).WithLogger(nil)
-	db, err := badger.Open(opts)
+func New(path string, opts ...store.Option) (*BadgerStore, error) {
+	db, err := badger.Open(badger.DefaultOptions(path).WithLogger(nil))
 	if err != nil {
 		return nil, fmt.Errorf("open badger: %w", err)
 	}
-	return &BadgerStore{db: db}, nil
+	return &BadgerStore{db: db, opts: store.NewOptions(opts...)}, nil
 }
 
-func (s *BadgerStore) Put(obj *object.Object) (string, error) {
-	compressed, sha, err := object.Serialize(obj)
+func (s *BadgerStore) Put(ctx context.Context, obj *object.Object) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if err := s.opts.Check(obj); err != nil {
+		return "", err
+	}
+	compressed, sha, err := s.opts.Serialize(obj)
 	if err != nil {
 		return "", fmt.Errorf("serialize: %w", err)
 	}
+	if err := s.put(badger.NewEntry([]byte(sha), compressed)); err != nil {
+		return "", err
+	}
+	return sha, nil
+}
 
-	err = s.db.Update(func(txn *badger.Txn) error {
-		_, err := txn.Get([]byte(sha))
+// put sets e unless its key is already stored.
+func (s *BadgerStore) put(e *badger.Entry) error {
+	err := s.db.Update(func(txn *badger.Txn) error {
+		_, err := txn.Get(e.Key)
 		if err == nil {
 			return nil // already exists, nothing to do
 		}
 		if err != badger.ErrKeyNotFound {
 			return err
 		}
-		return txn.Set([]byte(sha), compressed)
+		return txn.SetEntry(e)
 	})
 	if err != nil {
-		return "", fmt.Errorf("put: %w", err)
+		return fmt.Errorf("put: %w", store.Unavailable(err))
 	}
-
-	return sha, nil
+	return nil
 }
 
-func (s *BadgerStore) Get(sha string) (*object.Object, error) {
-	var compressed []byte
+func (s *BadgerStore) Get(ctx context.Context, sha string) (*object.Object, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var obj *object.Object
 
 	err := s.db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(sha))
-		if err == badger.ErrKeyNotFound {
-			return fmt.Errorf("object not found: %s", sha)
-		}
-		if err != nil {
-			return err
-		}
-		compressed, err = item.ValueCopy(nil)
+		var err error
+		obj, err = s.readObject(txn, sha)
 		return err
 	})
-	if err != nil {
+	if err == badger.ErrKeyNotFound {
+		return nil, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
+	}
+	if errors.Is(err, store.ErrCorrupt) {
 		return nil, err
 	}
-
-	return object.Deserialize(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("get %s: %w", sha, store.Unavailable(err))
+	}
+	return obj, nil
 }
 
-func (s *BadgerStore) Exists(sha string) (bool, error) {
+func (s *BadgerStore) Exists(ctx context.Context, sha string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
 	err := s.db.View(func(txn *badger.Txn) error {
-		_, err := txn.Get([]byte(sha))
+		_, err := s.getObject(txn, sha)
 		return err
 	})
 	if err == badger.ErrKeyNotFound {
 		return false, nil
 	}
 	if err != nil {
-		return false, fmt.Errorf("exists: %w", err)
+		return false, fmt.Errorf("exists: %w", store.Unavailable(err))
 	}
 	return true, nil
 }
@@ -1,9 +1,15 @@
 package badger
 
 import (
+	"bytes"
+	"crypto/rand"
+	"errors"
 	"testing"
 
 	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+	"git.wyat.me/git-storage/store/storetest"
+	"github.com/dgraph-io/badger/v4"
 )
 
 func TestPutAndGet(t *testing.T) {
@@ -18,7 +24,7 @@ func TestPutAndGet(t *testing.T) {
 		Data: []byte("hello\n"),
 	}
 
-	sha, err := store.Put(obj)
+	sha, err := store.Put(t.Context(), obj)
 	if err != nil {
 		t.Fatalf("Put failed: %v", err)
 	}
@@ -28,7 +34,7 @@ func TestPutAndGet(t *testing.T) {
 		t.Errorf("SHA mismatch: got %s, want %s", sha, expectedSHA)
 	}
 
-	got, err := store.Get(sha)
+	got, err := store.Get(t.Context(), sha)
 	if err != nil {
 		t.Fatalf("Get failed: %v", err)
 	}
@@ -53,12 +59,12 @@ func TestExists(t *testing.T) {
 		Data: []byte("hello\n"),
 	}
 
-	sha, err := store.Put(obj)
+	sha, err := store.Put(t.Context(), obj)
 	if err != nil {
 		t.Fatalf("Put failed: %v", err)
 	}
 
-	exists, err := store.Exists(sha)
+	exists, err := store.Exists(t.Context(), sha)
 	if err != nil {
 		t.Fatalf("Exists failed: %v", err)
 	}
@@ -66,7 +72,7 @@ func TestExists(t *testing.T) {
 		t.Error("expected object to exist after Put")
 	}
 
-	exists, err = store.Exists("0000000000000000000000000000000000000000")
+	exists, err = store.Exists(t.Context(), "0000000000000000000000000000000000000000")
 	if err != nil {
 		t.Fatalf("Exists failed: %v", err)
 	}
@@ -87,12 +93,12 @@ func TestDuplicatePut(t *testing.T) {
 		Data: []byte("hello\n"),
 	}
 
-	sha1, err := store.Put(obj)
+	sha1, err := store.Put(t.Context(), obj)
 	if err != nil {
 		t.Fatalf("first Put failed: %v", err)
 	}
 
-	sha2, err := store.Put(obj)
+	sha2, err := store.Put(t.Context(), obj)
 	if err != nil {
 		t.Fatalf("second Put failed: %v", err)
 	}
@@ -101,3 +107,307 @@ func TestDuplicatePut(t *testing.T) {
 		t.Errorf("duplicate Put returned different SHAs: %s vs %s", sha1, sha2)
 	}
 }
+
+func TestCancelledContext(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Cancelled(t, s)
+}
+
+func TestErrors(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+
+	closed, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	closed.Close()
+
+	storetest.Errors(t, storetest.Faults{
+		Store: s,
+		Corrupt: func(sha string) {
+			err := s.db.Update(func(txn *badger.Txn) error {
+				return txn.Set([]byte(sha), []byte("not zlib"))
+			})
+			if err != nil {
+				t.Fatalf("corrupt %s: %v", sha, err)
+			}
+		},
+		Unavailable: closed,
+	})
+}
+
+func TestRefStore(t *testing.T) {
+	storetest.RefStore(t, func(t *testing.T) store.RefStore {
+		s, err := New(t.TempDir())
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func TestReflog(t *testing.T) {
+	storetest.Reflog(t, func(t *testing.T) storetest.LoggedRefStore {
+		s, err := New(t.TempDir())
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func TestBatch(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Batch(t, s)
+}
+
+func TestStream(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Stream(t, s)
+}
+
+func TestMutable(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Mutable(t, s)
+}
+
+func TestResolve(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Resolve(t, s)
+}
+
+func TestStat(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Stat(t, s)
+}
+
+func TestValidation(t *testing.T) {
+	s, err := New(t.TempDir(), store.WithValidation())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Validation(t, s)
+}
+
+func TestSHA256(t *testing.T) {
+	s, err := New(t.TempDir(), store.WithFormat(object.SHA256), store.WithValidation())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.SHA256(t, s)
+}
+
+func TestCodecs(t *testing.T) {
+	dir := t.TempDir()
+	var s *BadgerStore
+	storetest.Codecs(t, func(t *testing.T, c object.Codec) store.ObjectStore {
+		if s != nil {
+			s.Close()
+		}
+		var err error
+		if s, err = New(dir, store.WithCodec(c)); err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		return s
+	})
+	s.Close()
+}
+
+func TestDeltas(t *testing.T) {
+	const maxDepth = 3
+	s, err := New(t.TempDir(), store.WithDeltas(maxDepth))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Deltas(t, s, maxDepth)
+
+	// the versions are stored as deltas, in chains no deeper than allowed
+	deltas := 0
+	err = s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if it.Item().UserMeta()&metaDelta == 0 {
+				continue
+			}
+			value, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			h, _, err := parseDelta(value)
+			if err != nil {
+				return err
+			}
+			if h.depth > maxDepth {
+				t.Errorf("object %s has delta depth %d, want at most %d", it.Item().Key(), h.depth, maxDepth)
+			}
+			deltas++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	if deltas == 0 {
+		t.Error("no objects were stored as deltas")
+	}
+}
+
+func TestStoredSize(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+
+	if size, err := s.StoredSize(t.Context()); err != nil || size != 0 {
+		t.Fatalf("StoredSize of an empty store = %d, %v", size, err)
+	}
+	obj := &object.Object{Type: object.TypeBlob, Data: []byte("hello\n")}
+	if _, err := s.Put(t.Context(), obj); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := s.UpdateRef(t.Context(), "refs/heads/main", "", "ce013625030ba8dba906f756967f9e9ca394464a"); err != nil {
+		t.Fatalf("UpdateRef failed: %v", err)
+	}
+	compressed, _, err := object.Serialize(obj)
+	if err != nil {
+		t.Fatalf("Serialize failed: %v", err)
+	}
+	if size, err := s.StoredSize(t.Context()); err != nil || size != int64(len(compressed)) {
+		t.Errorf("StoredSize = %d, %v, want %d", size, err, len(compressed))
+	}
+}
+
+func TestRefKeysAreNotObjects(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+
+	const sha = "ce013625030ba8dba906f756967f9e9ca394464a"
+	if _, err := s.Put(t.Context(), &object.Object{Type: object.TypeBlob, Data: []byte("hello\n")}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := s.UpdateRef(t.Context(), "refs/heads/main", "", sha); err != nil {
+		t.Fatalf("UpdateRef failed: %v", err)
+	}
+	if err := s.AppendReflog(t.Context(), "refs/heads/main", store.ReflogEntry{NewSHA: sha}); err != nil {
+		t.Fatalf("AppendReflog failed: %v", err)
+	}
+
+	for _, key := range []string{"ref:refs/heads/main", "reflog:refs/heads/main:", "CE013625030BA8DBA906F756967F9E9CA394464A", sha[:39]} {
+		if ok, err := s.Exists(t.Context(), key); err != nil || ok {
+			t.Errorf("Exists(%q) = %v, %v, want false", key, ok, err)
+		}
+		if _, err := s.Get(t.Context(), key); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Get(%q): expected ErrNotFound, got %v", key, err)
+		}
+		if _, _, err := s.Stat(t.Context(), key); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Stat(%q): expected ErrNotFound, got %v", key, err)
+		}
+		if err := s.Delete(t.Context(), key); err != nil {
+			t.Errorf("Delete(%q) failed: %v", key, err)
+		}
+	}
+	if ref, err := s.GetRef(t.Context(), "refs/heads/main"); err != nil || ref.SHA != sha {
+		t.Errorf("Delete removed a ref: got %v, %v", ref, err)
+	}
+}
+
+func TestPutManyKeepsDeltas(t *testing.T) {
+	s, err := New(t.TempDir(), store.WithDeltas(3))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+
+	base := &object.Object{Type: object.TypeBlob, Data: bytes.Repeat([]byte("base line\n"), 500)}
+	next := &object.Object{Type: object.TypeBlob, Data: append(bytes.Clone(base.Data), "one more\n"...)}
+	shas, err := s.PutDeltas(t.Context(), []*object.Object{base, next}, []string{"", object.Hash(base)})
+	if err != nil {
+		t.Fatalf("PutDeltas failed: %v", err)
+	}
+
+	// writing next again whole must not replace its delta, whose reverse
+	// record would then be stale
+	if _, err := s.PutMany(t.Context(), []*object.Object{next}); err != nil {
+		t.Fatalf("PutMany failed: %v", err)
+	}
+	err = s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(shas[1]))
+		if err != nil {
+			return err
+		}
+		if item.UserMeta()&metaDelta == 0 {
+			t.Error("PutMany overwrote an object stored as a delta")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View failed: %v", err)
+	}
+}
+
+func TestPutManyLargeBatch(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+
+	// more than fits in one Badger transaction
+	objs := make([]*object.Object, 24)
+	for i := range objs {
+		data := make([]byte, 900<<10)
+		rand.Read(data)
+		objs[i] = &object.Object{Type: object.TypeBlob, Data: data}
+	}
+	shas, err := s.PutMany(t.Context(), objs)
+	if err != nil {
+		t.Fatalf("PutMany failed: %v", err)
+	}
+	found, err := s.ExistsMany(t.Context(), shas)
+	if err != nil {
+		t.Fatalf("ExistsMany failed: %v", err)
+	}
+	for i, ok := range found {
+		if !ok {
+			t.Errorf("object %d missing after PutMany", i)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package badger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// PutMany writes objs in as few read-write transactions as Badger allows.
+// Each object is only written if the same transaction finds it missing:
+// overwriting one that is stored chunked or as a delta would orphan its
+// chunks or leave a stale delta record behind. That check is why this does
+// not use a WriteBatch, which can only write blindly: it cannot read keys,
+// so it could neither skip stored objects nor keep their delta UserMeta.
+func (s *BadgerStore) PutMany(ctx context.Context, objs []*object.Object) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for _, obj := range objs {
+		if err := s.opts.Check(obj); err != nil {
+			return nil, err
+		}
+	}
+	shas := make([]string, len(objs))
+	err := s.updateBatch(ctx, len(objs), func(txn *badger.Txn, lo, hi int) error {
+		for i := lo; i < hi; i++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			compressed, sha, err := s.opts.Serialize(objs[i])
+			if err != nil {
+				return fmt.Errorf("serialize: %w", err)
+			}
+			shas[i] = sha
+			if _, err := txn.Get([]byte(sha)); err == nil {
+				continue
+			} else if err != badger.ErrKeyNotFound {
+				return err
+			}
+			if err := txn.Set([]byte(sha), compressed); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return shas, nil
+}
+
+// maxConflicts is how many times updateBatch retries a transaction that
+// conflicted with a concurrent one, such as a Delete of a delta base it
+// read.
+const maxConflicts = 3
+
+// updateBatch calls fn over the n items of a batch in read-write
+// transactions, starting with all of them in one. A transaction that
+// grows too big for Badger is discarded and its items retried in halves,
+// so whatever fn reads and writes for an item always commits together.
+func (s *BadgerStore) updateBatch(ctx context.Context, n int, fn func(txn *badger.Txn, lo, hi int) error) error {
+	for lo := 0; lo < n; {
+		size := n - lo
+		for conflicts := 0; ; {
+			err := s.db.Update(func(txn *badger.Txn) error {
+				return fn(txn, lo, lo+size)
+			})
+			if err == badger.ErrTxnTooBig && size > 1 {
+				size /= 2
+				continue
+			}
+			if err == badger.ErrConflict && conflicts < maxConflicts {
+				conflicts++
+				continue
+			}
+			if err == nil {
+				break
+			}
+			if errors.Is(err, store.ErrCorrupt) || errors.Is(err, store.ErrNotFound) || ctx.Err() != nil {
+				return err
+			}
+			return fmt.Errorf("put: %w", store.Unavailable(err))
+		}
+		lo += size
+	}
+	return nil
+}
+
+// GetMany reads shas in a single read transaction.
+func (s *BadgerStore) GetMany(ctx context.Context, shas []string) ([]*object.Object, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	objs := make([]*object.Object, len(shas))
+	var missing string
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		for i, sha := range shas {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			var err error
+			objs[i], err = s.readObject(txn, sha)
+			if err == badger.ErrKeyNotFound {
+				missing = sha
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == badger.ErrKeyNotFound {
+		return nil, fmt.Errorf("object %s: %w", missing, store.ErrNotFound)
+	}
+	if errors.Is(err, store.ErrCorrupt) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get: %w", store.Unavailable(err))
+	}
+	return objs, nil
+}
+
+// ExistsMany checks shas in a single read transaction.
+func (s *BadgerStore) ExistsMany(ctx context.Context, shas []string) ([]bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	found := make([]bool, len(shas))
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		for i, sha := range shas {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			_, err := s.getObject(txn, sha)
+			if err == badger.ErrKeyNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			found[i] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("exists: %w", store.Unavailable(err))
+	}
+	return found, nil
+}
@@ -0,0 +1,240 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/pack"
+	"git.wyat.me/git-storage/store"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// Objects written by PutDeltas may be stored as a git delta against a
+// base object. Their value is flagged with metaDelta and holds "<base>
+// <depth> <type> <size>\0" followed by the compressed delta, where depth
+// counts the deltas down to a whole object. Each delta is also recorded
+// under "delta:" + base + ":" + SHA, so that deleting a base can first
+// store the objects depending on it whole.
+const (
+	deltaPrefix      = "delta:"
+	metaDelta   byte = 2
+)
+
+func deltaKey(base, sha string) []byte {
+	return []byte(deltaPrefix + base + ":" + sha)
+}
+
+type deltaHeader struct {
+	base  string
+	depth int
+	typ   object.ObjectType
+	size  int64
+}
+
+func deltaValue(h deltaHeader, compressed []byte) []byte {
+	return append(fmt.Appendf(nil, "%s %d %s %d\x00", h.base, h.depth, h.typ, h.size), compressed...)
+}
+
+// parseDelta splits a delta value into its header and compressed delta.
+func parseDelta(value []byte) (deltaHeader, []byte, error) {
+	header, compressed, ok := bytes.Cut(value, []byte{0})
+	fields := strings.Fields(string(header))
+	if !ok || len(fields) != 4 {
+		return deltaHeader{}, nil, fmt.Errorf("invalid delta header %q", header)
+	}
+	depth, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return deltaHeader{}, nil, fmt.Errorf("invalid delta header %q: %w", header, err)
+	}
+	size, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return deltaHeader{}, nil, fmt.Errorf("invalid delta header %q: %w", header, err)
+	}
+	return deltaHeader{base: fields[0], depth: depth, typ: object.ObjectType(fields[2]), size: size}, compressed, nil
+}
+
+// readObject reads sha in txn, rebuilding it from its delta chain if it
+// was stored as a delta. A missing base or any other damage along the
+// chain is reported as store.ErrCorrupt, and a missing sha as
+// badger.ErrKeyNotFound.
+func (s *BadgerStore) readObject(txn *badger.Txn, sha string) (*object.Object, error) {
+	var deltas [][]byte
+	depth := -1
+	current := sha
+	for {
+		item, err := s.getObject(txn, current)
+		if err == badger.ErrKeyNotFound && current != sha {
+			return nil, fmt.Errorf("object %s: %w: delta base %s missing", sha, store.ErrCorrupt, current)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if item.UserMeta()&metaDelta == 0 {
+			break
+		}
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		h, compressed, err := parseDelta(value)
+		if err != nil {
+			return nil, fmt.Errorf("object %s: %w: %w", current, store.ErrCorrupt, err)
+		}
+		if depth < 0 {
+			depth = h.depth
+		}
+		if len(deltas) == depth {
+			return nil, fmt.Errorf("object %s: %w: delta chain longer than its depth %d", sha, store.ErrCorrupt, depth)
+		}
+		delta, err := s.opts.Decompress(compressed)
+		if err != nil {
+			return nil, fmt.Errorf("object %s: %w: %w", current, store.ErrCorrupt, err)
+		}
+		deltas = append(deltas, delta)
+		current = h.base
+	}
+
+	compressed, err := readValue(txn, current)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := s.opts.Deserialize(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("object %s: %w: %w", current, store.ErrCorrupt, err)
+	}
+	for i := len(deltas) - 1; i >= 0; i-- {
+		if obj.Data, err = pack.ApplyDelta(obj.Data, deltas[i]); err != nil {
+			return nil, fmt.Errorf("object %s: %w: %w", sha, store.ErrCorrupt, err)
+		}
+	}
+	return obj, nil
+}
+
+// PutDeltas writes objs like PutMany, through store.WriteDeltas, which
+// stores an object as a delta against its hinted base when that is
+// worthwhile. Whether each object and its base are stored is checked in
+// the transaction that writes it, so a base deleted meanwhile makes the
+// commit conflict instead of leaving a delta with nothing to apply to.
+func (s *BadgerStore) PutDeltas(ctx context.Context, objs []*object.Object, bases []string) ([]string, error) {
+	if s.opts.MaxDeltaDepth <= 0 {
+		return s.PutMany(ctx, objs)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for _, obj := range objs {
+		if err := s.opts.Check(obj); err != nil {
+			return nil, err
+		}
+	}
+	shas := make([]string, len(objs))
+	err := s.updateBatch(ctx, len(objs), func(txn *badger.Txn, lo, hi int) error {
+		written, err := store.WriteDeltas(ctx, &deltaTx{s: s, txn: txn}, s.opts, pack.Delta, objs[lo:hi], bases[lo:hi])
+		copy(shas[lo:hi], written)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return shas, nil
+}
+
+// deltaTx is a store.DeltaTx over a Badger transaction. Its errors are
+// Badger's own, or store.ErrCorrupt; callers classify the rest.
+type deltaTx struct {
+	s   *BadgerStore
+	txn *badger.Txn
+}
+
+func (t *deltaTx) Exists(ctx context.Context, sha string) (bool, error) {
+	_, err := t.s.getObject(t.txn, sha)
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Base reads sha as a base. Chunked objects are never used as bases: they
+// are too large to hold in memory for every read of an object based on
+// them.
+func (t *deltaTx) Base(ctx context.Context, sha string) (*object.Object, int, bool, error) {
+	item, err := t.s.getObject(t.txn, sha)
+	if err == badger.ErrKeyNotFound {
+		return nil, 0, false, nil
+	}
+	if err != nil {
+		return nil, 0, false, err
+	}
+	depth := 0
+	switch {
+	case item.UserMeta()&metaChunked != 0:
+		return nil, 0, false, nil
+	case item.UserMeta()&metaDelta != 0:
+		if err := item.Value(func(value []byte) error {
+			h, _, err := parseDelta(value)
+			depth = h.depth
+			return err
+		}); err != nil {
+			return nil, 0, false, fmt.Errorf("object %s: %w: %w", sha, store.ErrCorrupt, err)
+		}
+	}
+	obj, err := t.s.readObject(t.txn, sha)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	return obj, depth, true, nil
+}
+
+func (t *deltaTx) Put(ctx context.Context, sha string, obj *object.Object, base string, depth int, compressed []byte) error {
+	if base == "" {
+		return t.txn.Set([]byte(sha), compressed)
+	}
+	h := deltaHeader{base: base, depth: depth, typ: obj.Type, size: int64(len(obj.Data))}
+	if err := t.txn.Set(deltaKey(base, sha), nil); err != nil {
+		return err
+	}
+	return t.txn.SetEntry(badger.NewEntry([]byte(sha), deltaValue(h, compressed)).WithMeta(metaDelta))
+}
+
+// Dependents lists the objects recorded as deltas against base. A record
+// left by a dependent that was deleted first is dropped instead.
+func (t *deltaTx) Dependents(ctx context.Context, base string) ([]string, error) {
+	prefix := []byte(deltaPrefix + base + ":")
+	var recorded []string
+	it := t.txn.NewIterator(badger.IteratorOptions{Prefix: prefix})
+	for it.Rewind(); it.Valid(); it.Next() {
+		recorded = append(recorded, string(it.Item().Key()[len(prefix):]))
+	}
+	it.Close()
+
+	var dependents []string
+	for _, sha := range recorded {
+		exists, err := t.Exists(ctx, sha)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			if err := t.txn.Delete(deltaKey(base, sha)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		dependents = append(dependents, sha)
+	}
+	return dependents, nil
+}
+
+func (t *deltaTx) Get(ctx context.Context, sha string) (*object.Object, error) {
+	return t.s.readObject(t.txn, sha)
+}
+
+func (t *deltaTx) Rewrite(ctx context.Context, sha, base string, compressed []byte) error {
+	if err := t.txn.Set([]byte(sha), compressed); err != nil {
+		return err
+	}
+	return t.txn.Delete(deltaKey(base, sha))
+}
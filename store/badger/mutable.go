@@ -0,0 +1,116 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"git.wyat.me/git-storage/store"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// isObjectKey reports whether key holds an object. Objects are keyed by
+// their bare hex SHA, while refs, reflogs and chunks all have a prefix
+// ending in ':', which no SHA contains.
+func isObjectKey(key []byte) bool {
+	return bytes.IndexByte(key, ':') < 0
+}
+
+// getObject looks sha up in txn, reporting badger.ErrKeyNotFound for a
+// name that is not a SHA in the store's format. Refs, reflogs and chunks
+// share the keyspace with objects, and without the check a name such as
+// "ref:refs/heads/main" would be read as an object.
+func (s *BadgerStore) getObject(txn *badger.Txn, sha string) (*badger.Item, error) {
+	if !s.opts.Format.IsSHA(sha) {
+		return nil, badger.ErrKeyNotFound
+	}
+	return txn.Get([]byte(sha))
+}
+
+// Iterate walks the object keys that start with prefix in a single read
+// transaction, without reading their values.
+func (s *BadgerStore) Iterate(ctx context.Context, prefix string, fn func(sha string) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var fnErr error
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(prefix)})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			key := it.Item().Key()
+			if !isObjectKey(key) {
+				continue
+			}
+			if fnErr = fn(string(key)); fnErr != nil {
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("iterate: %w", store.Unavailable(err))
+	}
+	if fnErr == store.ErrStopIteration {
+		return nil
+	}
+	return fnErr
+}
+
+// Delete removes sha, and its chunks if it was streamed in pieces. Objects
+// stored as deltas against sha are first rewritten whole.
+func (s *BadgerStore) Delete(ctx context.Context, sha string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := s.db.Update(func(txn *badger.Txn) error {
+		item, err := s.getObject(txn, sha)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if item.UserMeta()&metaDelta != 0 {
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if h, _, err := parseDelta(value); err == nil {
+				if err := txn.Delete(deltaKey(h.base, sha)); err != nil {
+					return err
+				}
+			}
+		}
+		if err := store.Undelta(ctx, &deltaTx{s: s, txn: txn}, s.opts, sha); err != nil {
+			return err
+		}
+		if item.UserMeta()&metaChunked != 0 {
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			// a damaged manifest leaves its chunks behind, but the object
+			// itself can still be deleted.
+			if id, n, err := parseManifest(value); err == nil {
+				for seq := range n {
+					if err := txn.Delete(chunkKey(id, seq)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return txn.Delete([]byte(sha))
+	})
+	if errors.Is(err, store.ErrCorrupt) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("delete %s: %w", sha, store.Unavailable(err))
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package badger
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"git.wyat.me/git-storage/store"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// reflogPrefix keys entries by ref name and then a big-endian sequence
+// number, so a prefix scan returns a ref's history in order. ':' cannot
+// appear in a ref name, which keeps the history of refs/heads/a apart
+// from that of refs/heads/a/b.
+const reflogPrefix = "reflog:"
+
+const maxReflogRetries = 10
+
+func reflogKeyPrefix(name string) []byte {
+	return []byte(reflogPrefix + name + ":")
+}
+
+func (s *BadgerStore) AppendReflog(ctx context.Context, name string, entry store.ReflogEntry) error {
+	// concurrent appends to the same ref conflict on the last key, so
+	// retry with the new end of the log.
+	var err error
+	for range maxReflogRetries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err = s.db.Update(func(txn *badger.Txn) error {
+			return appendReflog(txn, name, entry)
+		})
+		if err != badger.ErrConflict {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("append reflog %s: %w", name, store.Unavailable(err))
+	}
+	return nil
+}
+
+// appendReflog writes entry after the last one in name's log in txn.
+func appendReflog(txn *badger.Txn, name string, entry store.ReflogEntry) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("encode reflog entry: %w", err)
+	}
+	prefix := reflogKeyPrefix(name)
+	var seq uint64
+	it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix, Reverse: true})
+	defer it.Close()
+	it.Seek(append(prefix, 0xff))
+	if it.Valid() {
+		seq = binary.BigEndian.Uint64(it.Item().Key()[len(prefix):]) + 1
+	}
+	key := binary.BigEndian.AppendUint64(append([]byte{}, prefix...), seq)
+	return txn.Set(key, value)
+}
+
+func (s *BadgerStore) Reflog(ctx context.Context, name string) ([]store.ReflogEntry, error) {
+	prefix := reflogKeyPrefix(name)
+	var entries []store.ReflogEntry
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix, Reverse: true, PrefetchValues: true})
+		defer it.Close()
+		for it.Seek(append(prefix, 0xff)); it.Valid(); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			var entry store.ReflogEntry
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &entry)
+			})
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read reflog %s: %w", name, store.Unavailable(err))
+	}
+	return entries, nil
+}
@@ -0,0 +1,262 @@
+package badger
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"git.wyat.me/git-storage/store"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// refPrefix keeps refs out of the object keyspace, where every key is a
+// 40-character hex SHA.
+const refPrefix = "ref:"
+
+// symrefPrefix marks a symbolic ref value, as in git's own HEAD file.
+const symrefPrefix = "ref: "
+
+func refKey(name string) []byte {
+	return []byte(refPrefix + name)
+}
+
+func decodeRef(name string, value []byte) *store.Ref {
+	if target, ok := strings.CutPrefix(string(value), symrefPrefix); ok {
+		return &store.Ref{Name: name, Target: target}
+	}
+	return &store.Ref{Name: name, SHA: string(value)}
+}
+
+func (s *BadgerStore) GetRef(ctx context.Context, name string) (*store.Ref, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var ref *store.Ref
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(refKey(name))
+		if err == badger.ErrKeyNotFound {
+			return store.ErrRefNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			ref = decodeRef(name, val)
+			return nil
+		})
+	})
+	if err == store.ErrRefNotFound {
+		return nil, fmt.Errorf("get ref %s: %w", name, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get ref %s: %w", name, store.Unavailable(err))
+	}
+	return ref, nil
+}
+
+func (s *BadgerStore) ListRefs(ctx context.Context, prefix string) ([]store.Ref, error) {
+	var refs []store.Ref
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: refKey(prefix), PrefetchValues: true})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			item := it.Item()
+			name := strings.TrimPrefix(string(item.Key()), refPrefix)
+			err := item.Value(func(val []byte) error {
+				refs = append(refs, *decodeRef(name, val))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list refs: %w", store.Unavailable(err))
+	}
+	return refs, nil
+}
+
+func (s *BadgerStore) UpdateRef(ctx context.Context, name, oldSHA, newSHA string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := s.db.Update(func(txn *badger.Txn) error {
+		if err := checkRef(txn, name, oldSHA); err != nil {
+			return err
+		}
+		return txn.Set(refKey(name), []byte(newSHA))
+	})
+	return refTxnError("update", name, err)
+}
+
+func (s *BadgerStore) DeleteRef(ctx context.Context, name, oldSHA string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := s.db.Update(func(txn *badger.Txn) error {
+		if oldSHA != "" {
+			if err := checkRef(txn, name, oldSHA); err != nil {
+				return err
+			}
+		}
+		return txn.Delete(refKey(name))
+	})
+	return refTxnError("delete", name, err)
+}
+
+func (s *BadgerStore) SetSymbolicRef(ctx context.Context, name, target string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(refKey(name), []byte(symrefPrefix+target))
+	})
+	return refTxnError("set symbolic", name, err)
+}
+
+// checkRef fails with store.ErrRefConflict unless name currently holds
+// oldSHA, or is absent when oldSHA is empty.
+func checkRef(txn *badger.Txn, name, oldSHA string) error {
+	item, err := txn.Get(refKey(name))
+	if err == badger.ErrKeyNotFound {
+		if oldSHA == "" {
+			return nil
+		}
+		return store.ErrRefConflict
+	}
+	if err != nil {
+		return err
+	}
+	current, err := item.ValueCopy(nil)
+	if err != nil {
+		return err
+	}
+	if oldSHA == "" || string(current) != oldSHA {
+		return store.ErrRefConflict
+	}
+	return nil
+}
+
+// refTxnError maps badger's optimistic-concurrency conflict onto
+// store.ErrRefConflict: another writer changed the ref under us.
+func refTxnError(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	switch err {
+	case badger.ErrConflict:
+		err = store.ErrRefConflict
+	case store.ErrRefConflict:
+	default:
+		err = store.Unavailable(err)
+	}
+	return fmt.Errorf("%s ref %s: %w", op, name, err)
+}
+
+func (s *BadgerStore) UpdateRefs(ctx context.Context, updates []store.RefUpdate) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := store.CheckDuplicates(updates); err != nil {
+		return err
+	}
+	err := s.db.Update(func(txn *badger.Txn) error {
+		return applyRefs(txn, updates)
+	})
+	return refsTxnError(updates, err)
+}
+
+// UpdateRefsLogged is UpdateRefs that appends the reflog entries in the
+// same transaction. Since the ref checks are made again on every attempt,
+// a conflict, which may come from another append to the same reflog, is
+// retried rather than rejecting the refs.
+func (s *BadgerStore) UpdateRefsLogged(ctx context.Context, updates []store.RefUpdate, entry store.ReflogEntry) error {
+	if err := store.CheckDuplicates(updates); err != nil {
+		return err
+	}
+	var err error
+	for range maxReflogRetries {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		err = s.db.Update(func(txn *badger.Txn) error {
+			if err := applyRefs(txn, updates); err != nil {
+				return err
+			}
+			for _, u := range updates {
+				if e, ok := entry.Record(u); ok {
+					if err := appendReflog(txn, u.Name, e); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+		if err != badger.ErrConflict {
+			break
+		}
+	}
+	return refsTxnError(updates, err)
+}
+
+// applyRefs checks every update's precondition in txn and, if all hold,
+// applies them.
+func applyRefs(txn *badger.Txn, updates []store.RefUpdate) error {
+	var txErr store.TransactionError
+	for _, u := range updates {
+		if u.IsDelete() && u.OldSHA == "" {
+			continue
+		}
+		err := checkRef(txn, u.Name, u.OldSHA)
+		if err == store.ErrRefConflict {
+			txErr.Rejected = append(txErr.Rejected, store.RefRejection{Name: u.Name, Err: err})
+		} else if err != nil {
+			return err
+		}
+	}
+	if len(txErr.Rejected) > 0 {
+		return &txErr
+	}
+
+	for _, u := range updates {
+		var err error
+		if u.IsDelete() {
+			err = txn.Delete(refKey(u.Name))
+		} else {
+			err = txn.Set(refKey(u.Name), []byte(u.NewSHA))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refsTxnError classifies the error from a transaction of updates.
+func refsTxnError(updates []store.RefUpdate, err error) error {
+	if err == badger.ErrConflict {
+		// another transaction moved one of our refs between the checks
+		// and the commit; which one is unknown, so reject them all.
+		return conflictAll(updates)
+	}
+	if err != nil {
+		if _, ok := err.(*store.TransactionError); ok {
+			return err
+		}
+		return fmt.Errorf("update refs: %w", store.Unavailable(err))
+	}
+	return nil
+}
+
+func conflictAll(updates []store.RefUpdate) error {
+	txErr := &store.TransactionError{}
+	for _, u := range updates {
+		txErr.Rejected = append(txErr.Rejected, store.RefRejection{Name: u.Name, Err: store.ErrRefConflict})
+	}
+	return txErr
+}
@@ -0,0 +1,38 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"git.wyat.me/git-storage/store"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// StoredSize adds up the values of objects and their chunks in a single
+// read transaction. Badger only estimates the size of values it keeps in
+// its value log, to within a few bytes each, rather than reading them.
+func (s *BadgerStore) StoredSize(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	var size int64
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			key := it.Item().Key()
+			if isObjectKey(key) || bytes.HasPrefix(key, []byte(chunkPrefix)) {
+				size += it.Item().ValueSize()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("stored size: %w", store.Unavailable(err))
+	}
+	return size, nil
+}
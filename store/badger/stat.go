@@ -0,0 +1,66 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// Stat reads the type and size of a chunked object from its manifest, and
+// of a delta from its header. Objects stored whole are at most one chunk, and only their header is
+// inflated, straight from Badger's copy of the value.
+func (s *BadgerStore) Stat(ctx context.Context, sha string) (object.ObjectType, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return "", 0, err
+	}
+	var typ object.ObjectType
+	var size int64
+	var ok bool
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := s.getObject(txn, sha)
+		if err != nil {
+			return err
+		}
+		meta := item.UserMeta()
+		return item.Value(func(value []byte) error {
+			switch {
+			case meta&metaChunked != 0:
+				typ, size, ok = manifestStat(value)
+				return nil
+			case meta&metaDelta != 0:
+				h, _, err := parseDelta(value)
+				if err != nil {
+					return fmt.Errorf("object %s: %w: %w", sha, store.ErrCorrupt, err)
+				}
+				typ, size, ok = h.typ, h.size, true
+				return nil
+			}
+			r, err := s.opts.NewReader(bytes.NewReader(value))
+			if err != nil {
+				return fmt.Errorf("object %s: %w: %w", sha, store.ErrCorrupt, err)
+			}
+			r.Close()
+			typ, size, ok = r.Type, r.Size, true
+			return nil
+		})
+	})
+	if err == badger.ErrKeyNotFound {
+		return "", 0, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
+	}
+	if errors.Is(err, store.ErrCorrupt) {
+		return "", 0, err
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("stat %s: %w", sha, store.Unavailable(err))
+	}
+	if !ok {
+		// a manifest from before chunked objects recorded their size
+		return store.StatHeader(ctx, s, sha)
+	}
+	return typ, size, nil
+}
@@ -0,0 +1,293 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// Objects whose compressed form is larger than chunkSize are written by
+// PutStream as a series of chunk keys, "chunk:" + upload ID + big-endian
+// sequence number, and the object's own key holds a manifest flagged with
+// metaChunked in its user metadata. The upload ID is random because the
+// SHA is only known once the whole body has been read.
+const (
+	chunkPrefix      = "chunk:"
+	chunkSize        = 4 << 20
+	metaChunked byte = 1
+)
+
+func chunkKey(id string, seq uint32) []byte {
+	return binary.BigEndian.AppendUint32([]byte(chunkPrefix+id+":"), seq)
+}
+
+// manifest is the value of a chunked object: "<upload ID> <chunk count>
+// <type> <size>". The type and size let Stat answer without reading a
+// chunk; manifests written before they were added have only two fields.
+func manifest(id string, chunks uint32, typ object.ObjectType, size int64) []byte {
+	return fmt.Appendf(nil, "%s %d %s %d", id, chunks, typ, size)
+}
+
+func parseManifest(value []byte) (string, uint32, error) {
+	fields := strings.Fields(string(value))
+	if len(fields) != 2 && len(fields) != 4 {
+		return "", 0, fmt.Errorf("invalid chunk manifest %q", value)
+	}
+	n, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid chunk manifest %q: %w", value, err)
+	}
+	return fields[0], uint32(n), nil
+}
+
+// manifestStat returns the type and size recorded in a manifest, if it
+// has them.
+func manifestStat(value []byte) (object.ObjectType, int64, bool) {
+	fields := strings.Fields(string(value))
+	if len(fields) != 4 {
+		return "", 0, false
+	}
+	size, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return object.ObjectType(fields[2]), size, true
+}
+
+// readValue returns the compressed object stored under sha, joining its
+// chunks if it has any. A missing or damaged chunk is reported as
+// store.ErrCorrupt.
+func readValue(txn *badger.Txn, sha string) ([]byte, error) {
+	item, err := txn.Get([]byte(sha))
+	if err != nil {
+		return nil, err
+	}
+	value, err := item.ValueCopy(nil)
+	if err != nil || item.UserMeta()&metaChunked == 0 {
+		return value, err
+	}
+
+	id, n, err := parseManifest(value)
+	if err != nil {
+		return nil, fmt.Errorf("object %s: %w: %w", sha, store.ErrCorrupt, err)
+	}
+	var buf bytes.Buffer
+	for seq := range n {
+		item, err := txn.Get(chunkKey(id, seq))
+		if err == badger.ErrKeyNotFound {
+			return nil, fmt.Errorf("object %s: %w: chunk %d missing", sha, store.ErrCorrupt, seq)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := item.Value(func(v []byte) error {
+			buf.Write(v)
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// PutStream compresses the body read from r into chunks as it arrives.
+// Objects that turn out to fit in one chunk are stored whole, as Put
+// would store them.
+func (s *BadgerStore) PutStream(ctx context.Context, typ object.ObjectType, size int64, r io.Reader) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if s.opts.CheckStream(typ) {
+		return store.PutWhole(ctx, s, typ, size, r)
+	}
+	var id [8]byte
+	rand.Read(id[:])
+	cw := &chunkWriter{ctx: ctx, db: s.db, id: hex.EncodeToString(id[:])}
+
+	w := s.opts.NewWriter(cw, typ, size)
+	_, err := io.Copy(w, r)
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil && cw.chunks > 0 && len(cw.buf) > 0 {
+		err = cw.flush()
+	}
+	if err != nil {
+		cw.discard()
+		return "", fmt.Errorf("put stream: %w", err)
+	}
+	sha := w.SHA()
+
+	if cw.chunks == 0 {
+		return sha, s.put(badger.NewEntry([]byte(sha), cw.buf))
+	}
+	var exists bool
+	err = s.db.Update(func(txn *badger.Txn) error {
+		_, err := txn.Get([]byte(sha))
+		if err == nil {
+			exists = true
+			return nil
+		}
+		if err != badger.ErrKeyNotFound {
+			return err
+		}
+		return txn.SetEntry(badger.NewEntry([]byte(sha), manifest(cw.id, cw.chunks, typ, size)).WithMeta(metaChunked))
+	})
+	if exists || err != nil {
+		cw.discard()
+	}
+	if err != nil {
+		return "", fmt.Errorf("put: %w", store.Unavailable(err))
+	}
+	return sha, nil
+}
+
+// GetStream reads a chunked object one chunk at a time. Objects stored
+// whole are small enough to read at once, and deltas are rebuilt with Get.
+func (s *BadgerStore) GetStream(ctx context.Context, sha string) (*object.Reader, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var value []byte
+	var meta byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := s.getObject(txn, sha)
+		if err != nil {
+			return err
+		}
+		meta = item.UserMeta()
+		value, err = item.ValueCopy(nil)
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		return nil, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get %s: %w", sha, store.Unavailable(err))
+	}
+
+	if meta&metaDelta != 0 {
+		return store.GetWhole(ctx, s, sha)
+	}
+	var src io.Reader = bytes.NewReader(value)
+	if meta&metaChunked != 0 {
+		id, n, err := parseManifest(value)
+		if err != nil {
+			return nil, fmt.Errorf("object %s: %w: %w", sha, store.ErrCorrupt, err)
+		}
+		src = &chunkReader{ctx: ctx, db: s.db, sha: sha, id: id, chunks: n}
+	}
+	r, err := s.opts.NewReader(src)
+	if err != nil {
+		if errors.Is(err, store.ErrUnavailable) || errors.Is(err, store.ErrCorrupt) || ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("object %s: %w: %w", sha, store.ErrCorrupt, err)
+	}
+	return r, nil
+}
+
+// chunkWriter buffers compressed output and writes it out a chunk at a
+// time.
+type chunkWriter struct {
+	ctx    context.Context
+	db     *badger.DB
+	id     string
+	buf    []byte
+	chunks uint32
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		take := min(len(p), chunkSize-len(w.buf))
+		w.buf = append(w.buf, p[:take]...)
+		p = p[take:]
+		if len(w.buf) == chunkSize {
+			if err := w.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *chunkWriter) flush() error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	err := w.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(chunkKey(w.id, w.chunks), w.buf)
+	})
+	if err != nil {
+		return fmt.Errorf("write chunk: %w", store.Unavailable(err))
+	}
+	w.chunks++
+	w.buf = nil
+	return nil
+}
+
+// discard deletes the chunks written so far. It is best effort: chunks
+// left behind by a failure are unreferenced and never read.
+func (w *chunkWriter) discard() {
+	wb := w.db.NewWriteBatch()
+	defer wb.Cancel()
+	for seq := range w.chunks {
+		if wb.Delete(chunkKey(w.id, seq)) != nil {
+			return
+		}
+	}
+	wb.Flush()
+}
+
+// chunkReader reads a chunked object's compressed bytes, loading one chunk
+// at a time in its own read transaction.
+type chunkReader struct {
+	ctx    context.Context
+	db     *badger.DB
+	sha    string
+	id     string
+	chunks uint32
+	next   uint32
+	buf    []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.next == r.chunks {
+			return 0, io.EOF
+		}
+		if err := r.ctx.Err(); err != nil {
+			return 0, err
+		}
+		err := r.db.View(func(txn *badger.Txn) error {
+			item, err := txn.Get(chunkKey(r.id, r.next))
+			if err != nil {
+				return err
+			}
+			r.buf, err = item.ValueCopy(nil)
+			return err
+		})
+		if err == badger.ErrKeyNotFound {
+			return 0, fmt.Errorf("object %s: %w: chunk %d missing", r.sha, store.ErrCorrupt, r.next)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("read chunk: %w", store.Unavailable(err))
+		}
+		r.next++
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
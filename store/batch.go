@@ -0,0 +1,70 @@
+package store
+
+import (
+	"context"
+
+	"git.wyat.me/git-storage/object"
+)
+
+// BatchStore is implemented by object stores that can read and write many
+// objects in one round trip. Pushes and fetch negotiation touch thousands
+// of objects at a time, so callers go through PutMany, GetMany and
+// ExistsMany, which use the batch methods when the store has them.
+type BatchStore interface {
+	ObjectStore
+	// PutMany stores objs and returns their SHAs in the same order.
+	PutMany(ctx context.Context, objs []*object.Object) ([]string, error)
+	// GetMany returns the objects for shas in the same order. It fails
+	// with ErrNotFound if any of them is missing.
+	GetMany(ctx context.Context, shas []string) ([]*object.Object, error)
+	// ExistsMany reports, in order, whether each of shas is stored.
+	ExistsMany(ctx context.Context, shas []string) ([]bool, error)
+}
+
+// PutMany stores objs in s, in one batch if s is a BatchStore.
+func PutMany(ctx context.Context, s ObjectStore, objs []*object.Object) ([]string, error) {
+	if bs, ok := s.(BatchStore); ok {
+		return bs.PutMany(ctx, objs)
+	}
+	shas := make([]string, len(objs))
+	for i, obj := range objs {
+		sha, err := s.Put(ctx, obj)
+		if err != nil {
+			return nil, err
+		}
+		shas[i] = sha
+	}
+	return shas, nil
+}
+
+// GetMany reads shas from s, in one batch if s is a BatchStore.
+func GetMany(ctx context.Context, s ObjectStore, shas []string) ([]*object.Object, error) {
+	if bs, ok := s.(BatchStore); ok {
+		return bs.GetMany(ctx, shas)
+	}
+	objs := make([]*object.Object, len(shas))
+	for i, sha := range shas {
+		obj, err := s.Get(ctx, sha)
+		if err != nil {
+			return nil, err
+		}
+		objs[i] = obj
+	}
+	return objs, nil
+}
+
+// ExistsMany checks shas against s, in one batch if s is a BatchStore.
+func ExistsMany(ctx context.Context, s ObjectStore, shas []string) ([]bool, error) {
+	if bs, ok := s.(BatchStore); ok {
+		return bs.ExistsMany(ctx, shas)
+	}
+	found := make([]bool, len(shas))
+	for i, sha := range shas {
+		ok, err := s.Exists(ctx, sha)
+		if err != nil {
+			return nil, err
+		}
+		found[i] = ok
+	}
+	return found, nil
+}
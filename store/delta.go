@@ -0,0 +1,143 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"git.wyat.me/git-storage/object"
+)
+
+// DeltaStore is implemented by object stores that can keep an object as a
+// delta against another, trading read latency for space. A pushed pack
+// already says which object each of its deltas was made against, so the
+// unpacker passes those along as hints through PutDeltas.
+type DeltaStore interface {
+	ObjectStore
+	// PutDeltas stores objs like PutMany. Where bases[i] is not empty,
+	// objs[i] may be stored as a delta against it; the base must already
+	// be stored or come earlier in objs. The store decides whether a delta
+	// is worth keeping.
+	PutDeltas(ctx context.Context, objs []*object.Object, bases []string) ([]string, error)
+}
+
+// PutDeltas stores objs in s with PutMany, passing the base hints on if s
+// is a DeltaStore.
+func PutDeltas(ctx context.Context, s ObjectStore, objs []*object.Object, bases []string) ([]string, error) {
+	if ds, ok := s.(DeltaStore); ok {
+		return ds.PutDeltas(ctx, objs, bases)
+	}
+	return PutMany(ctx, s, objs)
+}
+
+// DeltaTx is one read-write transaction of a DeltaStore backend. Backends
+// implement it over their own storage so that WriteDeltas and Undelta can
+// share the rules for building and taking apart delta chains. Every read
+// must see the transaction's own writes, and nothing it reads may change
+// before it commits.
+type DeltaTx interface {
+	// Exists reports whether sha is stored.
+	Exists(ctx context.Context, sha string) (bool, error)
+	// Base reads sha for use as a delta base, with the number of deltas
+	// between it and a whole object. It reports false if sha is missing or
+	// is stored in a way that makes it a poor base.
+	Base(ctx context.Context, sha string) (obj *object.Object, depth int, ok bool, err error)
+	// Put stores obj under sha. If base is empty, compressed is the whole
+	// object; otherwise it is a delta against base, depth deltas deep.
+	Put(ctx context.Context, sha string, obj *object.Object, base string, depth int, compressed []byte) error
+	// Dependents lists the objects stored as deltas against base.
+	Dependents(ctx context.Context, base string) ([]string, error)
+	// Get reads sha, applying its deltas.
+	Get(ctx context.Context, sha string) (*object.Object, error)
+	// Rewrite replaces sha, stored as a delta against base, with
+	// compressed, the whole object.
+	Rewrite(ctx context.Context, sha, base string, compressed []byte) error
+}
+
+// DeltaFunc returns a delta that rebuilds target from base, reporting
+// false if one is not worth storing. pack.Delta is the one backends use;
+// this package cannot import it.
+type DeltaFunc func(base, target []byte) ([]byte, bool)
+
+// WriteDeltas is PutDeltas for a backend, run inside tx. Each object not
+// already stored is written as a delta against its hinted base when
+// delta finds one worthwhile and the base's chain leaves room under
+// o.MaxDeltaDepth, and whole otherwise. Objects that are already stored
+// are left alone, since rewriting one as a delta could make a chain lead
+// back to itself.
+func WriteDeltas(ctx context.Context, tx DeltaTx, o Options, delta DeltaFunc, objs []*object.Object, bases []string) ([]string, error) {
+	type written struct {
+		obj   *object.Object
+		depth int
+	}
+	// batch holds the objects written so far, which later ones may be
+	// based on
+	batch := map[string]written{}
+	shas := make([]string, len(objs))
+	for i, obj := range objs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		compressed, sha, err := o.Serialize(obj)
+		if err != nil {
+			return nil, fmt.Errorf("serialize: %w", err)
+		}
+		shas[i] = sha
+		if _, ok := batch[sha]; ok {
+			continue
+		}
+		exists, err := tx.Exists(ctx, sha)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			continue
+		}
+
+		var base string
+		depth := 0
+		if bases[i] != "" && bases[i] != sha {
+			b, ok := batch[bases[i]]
+			if !ok {
+				if b.obj, b.depth, ok, err = tx.Base(ctx, bases[i]); err != nil {
+					return nil, err
+				}
+			}
+			if ok && b.obj.Type == obj.Type && b.depth < o.MaxDeltaDepth {
+				if d, ok := delta(b.obj.Data, obj.Data); ok {
+					if compressed, err = o.Compress(d); err != nil {
+						return nil, err
+					}
+					base, depth = bases[i], b.depth+1
+				}
+			}
+		}
+		if err := tx.Put(ctx, sha, obj, base, depth, compressed); err != nil {
+			return nil, err
+		}
+		batch[sha] = written{obj: obj, depth: depth}
+	}
+	return shas, nil
+}
+
+// Undelta rewrites every object stored in tx as a delta against base
+// whole, so that base can be deleted.
+func Undelta(ctx context.Context, tx DeltaTx, o Options, base string) error {
+	dependents, err := tx.Dependents(ctx, base)
+	if err != nil {
+		return err
+	}
+	for _, sha := range dependents {
+		obj, err := tx.Get(ctx, sha)
+		if err != nil {
+			return err
+		}
+		compressed, _, err := o.Serialize(obj)
+		if err != nil {
+			return fmt.Errorf("serialize: %w", err)
+		}
+		if err := tx.Rewrite(ctx, sha, base, compressed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+// Package fs stores objects as git's own loose objects, so that a store
+// rooted at a bare repository can be read with git cat-file and the
+// key-value backends can be benchmarked against git's native format.
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+// FSStore keeps each object in its own file, objects/ab/cdef... under the
+// git directory it was opened on, zlib-compressed exactly as git writes
+// it. Files are written to a temporary name and renamed into place, so a
+// reader never sees a partial object, and are left read-only as git
+// leaves them. Like git by default, it does not fsync loose objects.
+type FSStore struct {
+	objects string
+	opts    store.Options
+}
+
+// tempPattern names objects being written, as git names its own.
+const tempPattern = "tmp_obj_*"
+
+// New opens the loose objects of the git directory dir, creating its
+// objects directory if need be. git only reads zlib loose objects, so
+// any other codec is refused.
+func New(dir string, opts ...store.Option) (*FSStore, error) {
+	o := store.NewOptions(opts...)
+	if o.Codec != nil && o.Codec != object.Zlib {
+		return nil, fmt.Errorf("git reads loose objects only as zlib, not %s", o.Codec.Name())
+	}
+	objects := filepath.Join(dir, "objects")
+	if err := os.MkdirAll(objects, 0o777); err != nil {
+		return nil, fmt.Errorf("create objects directory: %w", err)
+	}
+	return &FSStore{objects: objects, opts: o}, nil
+}
+
+// path returns the file sha is kept in, or false if sha is not a SHA in
+// the store's format and so cannot name a stored object.
+func (s *FSStore) path(sha string) (string, bool) {
+	if len(sha) != 2*s.opts.Format.Size() || !isHex(sha) {
+		return "", false
+	}
+	return filepath.Join(s.objects, sha[:2], sha[2:]), true
+}
+
+func isHex(s string) bool {
+	for i := range len(s) {
+		if c := s[i]; (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *FSStore) Put(ctx context.Context, obj *object.Object) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if err := s.opts.Check(obj); err != nil {
+		return "", err
+	}
+	compressed, sha, err := s.opts.Serialize(obj)
+	if err != nil {
+		return "", fmt.Errorf("serialize: %w", err)
+	}
+	exists, err := s.Exists(ctx, sha)
+	if err != nil || exists {
+		return sha, err
+	}
+
+	f, err := s.createTemp()
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(compressed); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", fmt.Errorf("put: %w", store.Unavailable(err))
+	}
+	if err := s.commit(f, sha); err != nil {
+		return "", err
+	}
+	return sha, nil
+}
+
+// createTemp creates a file to write an object to until it is complete.
+func (s *FSStore) createTemp() (*os.File, error) {
+	f, err := os.CreateTemp(s.objects, tempPattern)
+	if err != nil {
+		return nil, fmt.Errorf("put: %w", store.Unavailable(err))
+	}
+	return f, nil
+}
+
+// commit closes f, makes it read-only and moves it to sha's path. Like
+// git, it hard-links the file into place, so that an object someone else
+// stored meanwhile is left as it is, and renames it where links are not
+// supported.
+func (s *FSStore) commit(f *os.File, sha string) error {
+	path, _ := s.path(sha)
+	err := f.Close()
+	if err == nil {
+		err = os.Chmod(f.Name(), 0o444)
+	}
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0o777)
+	}
+	if err == nil {
+		err = os.Link(f.Name(), path)
+		if errors.Is(err, fs.ErrExist) {
+			err = nil
+		} else if err != nil {
+			err = os.Rename(f.Name(), path)
+		}
+	}
+	// after a rename there is nothing left to remove
+	os.Remove(f.Name())
+	if err != nil {
+		return fmt.Errorf("put: %w", store.Unavailable(err))
+	}
+	return nil
+}
+
+func (s *FSStore) Get(ctx context.Context, sha string) (*object.Object, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	path, ok := s.path(sha)
+	if !ok {
+		return nil, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
+	}
+	compressed, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get %s: %w", sha, store.Unavailable(err))
+	}
+
+	obj, err := s.opts.Deserialize(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("object %s: %w: %w", sha, store.ErrCorrupt, err)
+	}
+	return obj, nil
+}
+
+func (s *FSStore) Exists(ctx context.Context, sha string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	path, ok := s.path(sha)
+	if !ok {
+		return false, nil
+	}
+	_, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("exists: %w", store.Unavailable(err))
+	}
+	return true, nil
+}
+
+// Close does nothing, as every file is closed once it has been read or
+// written.
+func (s *FSStore) Close() error {
+	return nil
+}
@@ -0,0 +1,302 @@
+package fs
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+	"git.wyat.me/git-storage/store/storetest"
+)
+
+func TestPutAndGet(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+
+	obj := &object.Object{
+		Type: object.TypeBlob,
+		Data: []byte("hello\n"),
+	}
+
+	sha, err := s.Put(t.Context(), obj)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	const expectedSHA = "ce013625030ba8dba906f756967f9e9ca394464a"
+	if sha != expectedSHA {
+		t.Errorf("SHA mismatch: got %s, want %s", sha, expectedSHA)
+	}
+
+	// stored where git keeps it, read-only, with nothing left behind
+	info, err := os.Stat(filepath.Join(dir, "objects", "ce", "013625030ba8dba906f756967f9e9ca394464a"))
+	if err != nil {
+		t.Fatalf("loose object missing: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o444 {
+		t.Errorf("loose object mode %v, want -r--r--r--", perm)
+	}
+	if temps, _ := filepath.Glob(filepath.Join(dir, "objects", tempPattern)); len(temps) > 0 {
+		t.Errorf("temporary files left behind: %v", temps)
+	}
+
+	got, err := s.Get(t.Context(), sha)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	if got.Type != obj.Type {
+		t.Errorf("Type mismatch: got %s, want %s", got.Type, obj.Type)
+	}
+	if string(got.Data) != string(obj.Data) {
+		t.Errorf("Data mismatch: got %q, want %q", got.Data, obj.Data)
+	}
+}
+
+func TestExists(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+
+	sha, err := s.Put(t.Context(), &object.Object{Type: object.TypeBlob, Data: []byte("hello\n")})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		sha  string
+		want bool
+	}{
+		{sha, true},
+		{"0000000000000000000000000000000000000000", false},
+		// not SHAs, so never looked up as paths
+		{"ce", false},
+		{"../../../etc/passwd", false},
+		{strings.ToUpper(sha), false},
+	} {
+		exists, err := s.Exists(t.Context(), tc.sha)
+		if err != nil {
+			t.Fatalf("Exists %q failed: %v", tc.sha, err)
+		}
+		if exists != tc.want {
+			t.Errorf("Exists %q = %v, want %v", tc.sha, exists, tc.want)
+		}
+	}
+}
+
+func TestDuplicatePut(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+
+	obj := &object.Object{
+		Type: object.TypeBlob,
+		Data: []byte("hello\n"),
+	}
+
+	sha1, err := s.Put(t.Context(), obj)
+	if err != nil {
+		t.Fatalf("first Put failed: %v", err)
+	}
+
+	sha2, err := s.Put(t.Context(), obj)
+	if err != nil {
+		t.Fatalf("second Put failed: %v", err)
+	}
+
+	if sha1 != sha2 {
+		t.Errorf("duplicate Put returned different SHAs: %s vs %s", sha1, sha2)
+	}
+}
+
+func TestCodecRefused(t *testing.T) {
+	if _, err := New(t.TempDir(), store.WithCodec(object.Zstd)); err == nil {
+		t.Error("New accepted a codec git cannot read")
+	}
+}
+
+// TestGit checks that git reads what the store writes, and the store reads
+// what git writes.
+func TestGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	git := func(stdin []byte, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"--git-dir", dir}, args...)...)
+		cmd.Stdin = bytes.NewReader(stdin)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("git %s: %v", strings.Join(args, " "), err)
+		}
+		return string(out)
+	}
+	git(nil, "init", "--bare", "--quiet")
+
+	s, err := New(dir)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+
+	blob := &object.Object{Type: object.TypeBlob, Data: []byte("written by the store\n")}
+	sha, err := s.Put(t.Context(), blob)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	large := bytes.Repeat([]byte("streamed by the store\n"), 1<<16)
+	streamed, err := s.PutStream(t.Context(), object.TypeBlob, int64(len(large)), bytes.NewReader(large))
+	if err != nil {
+		t.Fatalf("PutStream failed: %v", err)
+	}
+	if got := git(nil, "cat-file", "-p", sha); got != string(blob.Data) {
+		t.Errorf("git cat-file %s = %q, want %q", sha, got, blob.Data)
+	}
+	if got := git(nil, "cat-file", "-p", streamed); got != string(large) {
+		t.Errorf("git cat-file %s: streamed object did not round-trip", streamed)
+	}
+	git(nil, "fsck", "--strict")
+
+	data := []byte("written by git\n")
+	sha = strings.TrimSpace(git(data, "hash-object", "-w", "--stdin"))
+	obj, err := s.Get(t.Context(), sha)
+	if err != nil {
+		t.Fatalf("Get %s failed: %v", sha, err)
+	}
+	if obj.Type != object.TypeBlob || !bytes.Equal(obj.Data, data) {
+		t.Errorf("Get %s: got %s %q, want blob %q", sha, obj.Type, obj.Data, data)
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Cancelled(t, s)
+}
+
+func TestErrors(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+
+	// a store whose objects directory has been replaced by a file
+	broken, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err := os.Remove(broken.objects); err != nil {
+		t.Fatalf("remove objects directory: %v", err)
+	}
+	if err := os.WriteFile(broken.objects, nil, 0o644); err != nil {
+		t.Fatalf("write objects file: %v", err)
+	}
+
+	storetest.Errors(t, storetest.Faults{
+		Store: s,
+		Corrupt: func(sha string) {
+			path, _ := s.path(sha)
+			if err := os.Chmod(path, 0o644); err != nil {
+				t.Fatalf("corrupt %s: %v", sha, err)
+			}
+			if err := os.WriteFile(path, []byte("not zlib"), 0o644); err != nil {
+				t.Fatalf("corrupt %s: %v", sha, err)
+			}
+		},
+		Unavailable: broken,
+	})
+}
+
+func TestStream(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Stream(t, s)
+}
+
+func TestMutable(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Mutable(t, s)
+}
+
+func TestResolve(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Resolve(t, s)
+}
+
+func TestStat(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Stat(t, s)
+}
+
+func TestValidation(t *testing.T) {
+	s, err := New(t.TempDir(), store.WithValidation())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Validation(t, s)
+}
+
+func TestSHA256(t *testing.T) {
+	s, err := New(t.TempDir(), store.WithFormat(object.SHA256), store.WithValidation())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.SHA256(t, s)
+}
+
+func TestStoredSize(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+
+	if size, err := s.StoredSize(t.Context()); err != nil || size != 0 {
+		t.Fatalf("StoredSize of an empty store = %d, %v", size, err)
+	}
+	obj := &object.Object{Type: object.TypeBlob, Data: []byte("hello\n")}
+	if _, err := s.Put(t.Context(), obj); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	compressed, _, err := object.Serialize(obj)
+	if err != nil {
+		t.Fatalf("Serialize failed: %v", err)
+	}
+	if size, err := s.StoredSize(t.Context()); err != nil || size != int64(len(compressed)) {
+		t.Errorf("StoredSize = %d, %v, want %d", size, err, len(compressed))
+	}
+}
@@ -0,0 +1,86 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"git.wyat.me/git-storage/store"
+)
+
+// Iterate reads the fan-out directories that can hold SHAs starting with
+// prefix, in order, skipping git's pack and info directories and any
+// temporary files. Each directory is read in full before fn is called for
+// its objects.
+func (s *FSStore) Iterate(ctx context.Context, prefix string, fn func(sha string) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	dirs, err := s.fanout()
+	if err != nil {
+		return fmt.Errorf("iterate: %w", store.Unavailable(err))
+	}
+	for _, dir := range dirs {
+		if !strings.HasPrefix(dir, prefix) && !strings.HasPrefix(prefix, dir) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(filepath.Join(s.objects, dir))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("iterate: %w", store.Unavailable(err))
+		}
+		for _, e := range entries {
+			sha := dir + e.Name()
+			if _, ok := s.path(sha); !ok || !strings.HasPrefix(sha, prefix) {
+				continue
+			}
+			if err := fn(sha); err == store.ErrStopIteration {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fanout returns the names of the objects directory's fan-out
+// directories, in order.
+func (s *FSStore) fanout() ([]string, error) {
+	entries, err := os.ReadDir(s.objects)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, e := range entries {
+		if e.IsDir() && len(e.Name()) == 2 && isHex(e.Name()) {
+			dirs = append(dirs, e.Name())
+		}
+	}
+	return dirs, nil
+}
+
+// Delete removes sha's file, leaving its fan-out directory behind as git
+// does until it prunes.
+func (s *FSStore) Delete(ctx context.Context, sha string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	path, ok := s.path(sha)
+	if !ok {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("delete %s: %w", sha, store.Unavailable(err))
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"git.wyat.me/git-storage/store"
+)
+
+// StoredSize adds up the sizes of the loose object files.
+func (s *FSStore) StoredSize(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	dirs, err := s.fanout()
+	if err != nil {
+		return 0, fmt.Errorf("stored size: %w", store.Unavailable(err))
+	}
+	var size int64
+	for _, dir := range dirs {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		entries, err := os.ReadDir(filepath.Join(s.objects, dir))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("stored size: %w", store.Unavailable(err))
+		}
+		for _, e := range entries {
+			info, err := e.Info()
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return 0, fmt.Errorf("stored size: %w", store.Unavailable(err))
+			}
+			size += info.Size()
+		}
+	}
+	return size, nil
+}
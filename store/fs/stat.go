@@ -0,0 +1,17 @@
+package fs
+
+import (
+	"context"
+
+	"git.wyat.me/git-storage/object"
+)
+
+// Stat inflates only as much of sha's file as its header takes.
+func (s *FSStore) Stat(ctx context.Context, sha string) (object.ObjectType, int64, error) {
+	r, err := s.GetStream(ctx, sha)
+	if err != nil {
+		return "", 0, err
+	}
+	r.Close()
+	return r.Type, r.Size, nil
+}
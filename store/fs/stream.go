@@ -0,0 +1,85 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+// PutStream compresses the body read from r into a temporary file as it
+// arrives, and moves the file into place once the SHA is known.
+func (s *FSStore) PutStream(ctx context.Context, typ object.ObjectType, size int64, r io.Reader) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if s.opts.CheckStream(typ) {
+		return store.PutWhole(ctx, s, typ, size, r)
+	}
+	f, err := s.createTemp()
+	if err != nil {
+		return "", err
+	}
+	w := s.opts.NewWriter(&fileWriter{ctx: ctx, f: f}, typ, size)
+	_, err = io.Copy(w, r)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", fmt.Errorf("put stream: %w", err)
+	}
+	sha := w.SHA()
+	if err := s.commit(f, sha); err != nil {
+		return "", err
+	}
+	return sha, nil
+}
+
+// fileWriter writes to a temporary object file, giving up once ctx is
+// done.
+type fileWriter struct {
+	ctx context.Context
+	f   *os.File
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := w.f.Write(p)
+	if err != nil {
+		return n, store.Unavailable(err)
+	}
+	return n, nil
+}
+
+// GetStream opens sha's file and inflates it as it is read.
+func (s *FSStore) GetStream(ctx context.Context, sha string) (*object.Reader, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	path, ok := s.path(sha)
+	if !ok {
+		return nil, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get %s: %w", sha, store.Unavailable(err))
+	}
+	r, err := s.opts.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("object %s: %w: %w", sha, store.ErrCorrupt, err)
+	}
+	return r, nil
+}
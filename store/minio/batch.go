@@ -0,0 +1,131 @@
+package minio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+// batchWorkers bounds how many requests a batch keeps in flight at once.
+const batchWorkers = 16
+
+// PutMany uploads, in parallel, those of objs that ExistsMany does not
+// find. Like Put it never overwrites a key: a chunked object's key holds
+// its manifest, and rewriting it whole would orphan its chunks.
+func (s *MinioStore) PutMany(ctx context.Context, objs []*object.Object) ([]string, error) {
+	for _, obj := range objs {
+		if err := s.opts.Check(obj); err != nil {
+			return nil, err
+		}
+	}
+	shas := make([]string, len(objs))
+	compressed := make([][]byte, len(objs))
+	for i, obj := range objs {
+		var err error
+		if compressed[i], shas[i], err = s.opts.Serialize(obj); err != nil {
+			return nil, fmt.Errorf("serialize: %w", err)
+		}
+	}
+	found, err := s.ExistsMany(ctx, shas)
+	if err != nil {
+		return nil, err
+	}
+	var missing []int
+	for i, ok := range found {
+		if !ok {
+			missing = append(missing, i)
+		}
+	}
+	err = parallel(ctx, len(missing), func(ctx context.Context, j int) error {
+		i := missing[j]
+		_, err := s.client.PutObject(
+			ctx,
+			s.bucket,
+			shas[i],
+			bytes.NewReader(compressed[i]),
+			int64(len(compressed[i])),
+			putOptions(objs[i].Type, int64(len(objs[i].Data))),
+		)
+		if err != nil {
+			return fmt.Errorf("put object: %w", store.Unavailable(err))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return shas, nil
+}
+
+// GetMany downloads shas in parallel.
+func (s *MinioStore) GetMany(ctx context.Context, shas []string) ([]*object.Object, error) {
+	objs := make([]*object.Object, len(shas))
+	err := parallel(ctx, len(shas), func(ctx context.Context, i int) error {
+		obj, err := s.Get(ctx, shas[i])
+		if err != nil {
+			return err
+		}
+		objs[i] = obj
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objs, nil
+}
+
+// ExistsMany stats shas in parallel.
+func (s *MinioStore) ExistsMany(ctx context.Context, shas []string) ([]bool, error) {
+	found := make([]bool, len(shas))
+	err := parallel(ctx, len(shas), func(ctx context.Context, i int) error {
+		ok, err := s.Exists(ctx, shas[i])
+		if err != nil {
+			return err
+		}
+		found[i] = ok
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// parallel calls fn for every index below n on up to batchWorkers
+// goroutines. The first error cancels the calls still running and is the
+// one returned.
+func parallel(ctx context.Context, n int, fn func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range min(n, batchWorkers) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := fn(ctx, i); err != nil {
+					cancel(err)
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := range n {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	return context.Cause(ctx)
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 
 	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 )
@@ -14,9 +15,10 @@ import (
 type MinioStore struct {
 	client *minio.Client
 	bucket string
+	opts   store.Options
 }
 
-func New(endpoint, accessKey, secretKey, bucket string, useSSL bool) (*MinioStore, error) {
+func New(endpoint, accessKey, secretKey, bucket string, useSSL bool, opts ...store.Option) (*MinioStore, error) {
 	client, err := minio.New(endpoint, &minio.Options{
 		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
 		Secure: useSSL,
@@ -36,84 +38,105 @@ func New(endpoint, accessKey, secretKey, bucket string, useSSL bool) (*MinioStor
 		}
 	}
 
-	return &MinioStore{client: client, bucket: bucket}, nil
+	return &MinioStore{client: client, bucket: bucket, opts: store.NewOptions(opts...)}, nil
 }
 
-func (s *MinioStore) Put(obj *object.Object) (string, error) {
-	compressed, sha, err := object.Serialize(obj)
+func (s *MinioStore) Put(ctx context.Context, obj *object.Object) (string, error) {
+	if err := s.opts.Check(obj); err != nil {
+		return "", err
+	}
+	compressed, sha, err := s.opts.Serialize(obj)
 	if err != nil {
 		return "", fmt.Errorf("serialize: %w", err)
 	}
+	if err := s.put(ctx, sha, obj.Type, int64(len(obj.Data)), compressed); err != nil {
+		return "", err
+	}
+	return sha, nil
+}
 
-	exists, err := s.Exists(sha)
+// put uploads compressed under sha unless it is already stored.
+func (s *MinioStore) put(ctx context.Context, sha string, typ object.ObjectType, size int64, compressed []byte) error {
+	exists, err := s.Exists(ctx, sha)
 	if err != nil {
-		return "", err
+		return err
 	}
 	if exists {
-		return sha, nil
+		return nil
 	}
 
 	_, err = s.client.PutObject(
-		context.Background(),
+		ctx,
 		s.bucket,
 		sha,
 		bytes.NewReader(compressed),
 		int64(len(compressed)),
-		minio.PutObjectOptions{ContentType: "application/octet-stream"},
+		putOptions(typ, size),
 	)
 	if err != nil {
-		return "", fmt.Errorf("put object: %w", err)
+		return fmt.Errorf("put object: %w", store.Unavailable(err))
 	}
-
-	return sha, nil
+	return nil
 }
 
-func (s *MinioStore) Get(sha string) (*object.Object, error) {
+func (s *MinioStore) Get(ctx context.Context, sha string) (*object.Object, error) {
+	if !s.opts.Format.IsSHA(sha) {
+		// refs and reflogs share the bucket under "_"-prefixed keys
+		return nil, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
+	}
 	obj, err := s.client.GetObject(
-		context.Background(),
+		ctx,
 		s.bucket,
 		sha,
 		minio.GetObjectOptions{},
 	)
 	if err != nil {
-		return nil, fmt.Errorf("get object: %w", err)
+		return nil, fmt.Errorf("get object: %w", store.Unavailable(err))
 	}
 	defer obj.Close()
 
 	compressed, err := io.ReadAll(obj)
 	if err != nil {
-		return nil, fmt.Errorf("read object: %w", err)
+		if isNotFound(err) {
+			return nil, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
+		}
+		return nil, fmt.Errorf("read object: %w", store.Unavailable(err))
 	}
 
-	return object.Deserialize(compressed)
+	decoded, err := s.opts.Deserialize(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("object %s: %w: %w", sha, store.ErrCorrupt, err)
+	}
+	return decoded, nil
 }
 
-func (s *MinioStore) Exists(sha string) (bool, error) {
+func (s *MinioStore) Exists(ctx context.Context, sha string) (bool, error) {
+	if !s.opts.Format.IsSHA(sha) {
+		return false, nil
+	}
 	_, err := s.client.StatObject(
-		context.Background(),
+		ctx,
 		s.bucket,
 		sha,
 		minio.StatObjectOptions{},
 	)
 	if err != nil {
-		errResp := minio.ToErrorResponse(err)
-		if errResp.Code == "NoSuchKey" {
+		if isNotFound(err) {
 			return false, nil
 		}
-		return false, fmt.Errorf("stat object: %w", err)
+		return false, fmt.Errorf("stat object: %w", store.Unavailable(err))
 	}
 	return true, nil
 }
 
 // Flush removes all objects from the bucket. Used after benchmarks to avoid
 // leaving test data in the bucket.
-func (s *MinioStore) Flush() error {
-	ctx := context.Background()
+func (s *MinioStore) Flush(ctx context.Context) error {
 
 	objectsCh := make(chan minio.ObjectInfo)
 	go func() {
 		defer close(objectsCh)
-		for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{}) {
+		for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Recursive: true}) {
 			if obj.Err != nil {
 				return
 			}
@@ -129,3 +152,8 @@ func (s *MinioStore) Flush() error {
 
 	return nil
 }
+
+// isNotFound reports whether err is S3's answer for a missing key.
+func isNotFound(err error) bool {
+	return minio.ToErrorResponse(err).Code == "NoSuchKey"
+}
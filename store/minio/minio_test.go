@@ -1,10 +1,17 @@
 package minio
 
 import (
-	"os"
+	"bytes"
+	"errors"
+	"net/http"
+	"strings"
 	"testing"
 
 	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+	"git.wyat.me/git-storage/store/storetest"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
 func TestPutAndGet(t *testing.T) {
@@ -15,7 +22,7 @@ func TestPutAndGet(t *testing.T) {
 		Data: []byte("hello\n"),
 	}
 
-	sha, err := store.Put(obj)
+	sha, err := store.Put(t.Context(), obj)
 	if err != nil {
 		t.Fatalf("Put failed: %v", err)
 	}
@@ -25,7 +32,7 @@ func TestPutAndGet(t *testing.T) {
 		t.Errorf("SHA mismatch: got %s, want %s", sha, expectedSHA)
 	}
 
-	got, err := store.Get(sha)
+	got, err := store.Get(t.Context(), sha)
 	if err != nil {
 		t.Fatalf("Get failed: %v", err)
 	}
@@ -46,12 +53,12 @@ func TestExists(t *testing.T) {
 		Data: []byte("hello\n"),
 	}
 
-	sha, err := store.Put(obj)
+	sha, err := store.Put(t.Context(), obj)
 	if err != nil {
 		t.Fatalf("Put failed: %v", err)
 	}
 
-	exists, err := store.Exists(sha)
+	exists, err := store.Exists(t.Context(), sha)
 	if err != nil {
 		t.Fatalf("Exists failed: %v", err)
 	}
@@ -59,7 +66,7 @@ func TestExists(t *testing.T) {
 		t.Error("expected object to exist after Put")
 	}
 
-	exists, err = store.Exists("0000000000000000000000000000000000000000")
+	exists, err = store.Exists(t.Context(), "0000000000000000000000000000000000000000")
 	if err != nil {
 		t.Fatalf("Exists failed: %v", err)
 	}
@@ -76,12 +83,12 @@ func TestDuplicatePut(t *testing.T) {
 		Data: []byte("hello\n"),
 	}
 
-	sha1, err := store.Put(obj)
+	sha1, err := store.Put(t.Context(), obj)
 	if err != nil {
 		t.Fatalf("first Put failed: %v", err)
 	}
 
-	sha2, err := store.Put(obj)
+	sha2, err := store.Put(t.Context(), obj)
 	if err != nil {
 		t.Fatalf("second Put failed: %v", err)
 	}
@@ -91,20 +98,62 @@ func TestDuplicatePut(t *testing.T) {
 	}
 }
 
-func newTestStore(t *testing.T) *MinioStore {
-	t.Helper()
+func TestRefKeysAreNotObjects(t *testing.T) {
+	s := newTestStore(t)
+	if err := s.Flush(t.Context()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	const sha = "ce013625030ba8dba906f756967f9e9ca394464a"
+	if _, err := s.Put(t.Context(), &object.Object{Type: object.TypeBlob, Data: []byte("hello\n")}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := s.UpdateRef(t.Context(), "refs/heads/main", "", sha); err != nil {
+		t.Fatalf("UpdateRef failed: %v", err)
+	}
+	if err := s.AppendReflog(t.Context(), "refs/heads/main", store.ReflogEntry{NewSHA: sha}); err != nil {
+		t.Fatalf("AppendReflog failed: %v", err)
+	}
 
-	endpoint := os.Getenv("MINIO_ENDPOINT")
-	if endpoint == "" {
-		t.Skip("MINIO_ENDPOINT not set, skipping minio tests")
+	keys := []string{refKey("refs/heads/main"), "CE013625030BA8DBA906F756967F9E9CA394464A", sha[:39]}
+	for info := range s.client.ListObjects(t.Context(), s.bucket, minio.ListObjectsOptions{Prefix: reflogPrefix, Recursive: true}) {
+		keys = append(keys, info.Key)
+	}
+	for _, key := range keys {
+		if ok, err := s.Exists(t.Context(), key); err != nil || ok {
+			t.Errorf("Exists(%q) = %v, %v, want false", key, ok, err)
+		}
+		if _, err := s.Get(t.Context(), key); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Get(%q): expected ErrNotFound, got %v", key, err)
+		}
+		if _, err := s.GetStream(t.Context(), key); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("GetStream(%q): expected ErrNotFound, got %v", key, err)
+		}
+		if _, _, err := s.Stat(t.Context(), key); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Stat(%q): expected ErrNotFound, got %v", key, err)
+		}
+		if err := s.Delete(t.Context(), key); err != nil {
+			t.Errorf("Delete(%q) failed: %v", key, err)
+		}
 	}
+	if ref, err := s.GetRef(t.Context(), "refs/heads/main"); err != nil || ref.SHA != sha {
+		t.Errorf("Delete removed a ref: got %v, %v", ref, err)
+	}
+	if log, err := s.Reflog(t.Context(), "refs/heads/main"); err != nil || len(log) != 1 {
+		t.Errorf("Delete removed a reflog entry: got %v, %v", log, err)
+	}
+}
+
+func newTestStore(t *testing.T, opts ...store.Option) *MinioStore {
+	t.Helper()
 
 	store, err := New(
-		endpoint,
+		storetest.S3Endpoint(),
 		"minioadmin",
 		"minioadmin",
 		"test-git-objects",
 		false,
+		opts...,
 	)
 	if err != nil {
 		t.Fatalf("New failed: %v", err)
@@ -112,3 +161,158 @@ func newTestStore(t *testing.T) *MinioStore {
 
 	return store
 }
+
+func TestCancelledContext(t *testing.T) {
+	s := newTestStore(t)
+	storetest.Cancelled(t, s)
+}
+
+func TestErrors(t *testing.T) {
+	s := newTestStore(t)
+
+	// minio.New does not connect, so this client only fails once used.
+	client, err := minio.New("127.0.0.1:1", &minio.Options{
+		Creds:      credentials.NewStaticV4("minioadmin", "minioadmin", ""),
+		MaxRetries: 1,
+	})
+	if err != nil {
+		t.Fatalf("minio.New failed: %v", err)
+	}
+
+	storetest.Errors(t, storetest.Faults{
+		Store: s,
+		Corrupt: func(sha string) {
+			data := []byte("not zlib")
+			_, err := s.client.PutObject(t.Context(), s.bucket, sha, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+			if err != nil {
+				t.Fatalf("corrupt %s: %v", sha, err)
+			}
+		},
+		Unavailable: &MinioStore{client: client, bucket: s.bucket},
+	})
+}
+
+func TestRefStore(t *testing.T) {
+	storetest.RefStore(t, func(t *testing.T) store.RefStore {
+		s := newTestStore(t)
+		if err := s.Flush(t.Context()); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		return s
+	})
+}
+
+// TestRollbackFailed checks that a ref a failed transaction wrote and
+// could not restore is reported rather than left silently moved.
+func TestRollbackFailed(t *testing.T) {
+	s3 := storetest.NewS3(t)
+	s, err := New(s3.Endpoint(), "minioadmin", "minioadmin", "test-git-objects", false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	const old, next = "1111111111111111111111111111111111111111", "2222222222222222222222222222222222222222"
+	for _, name := range []string{"refs/heads/a", "refs/heads/b"} {
+		if err := s.UpdateRef(t.Context(), name, "", old); err != nil {
+			t.Fatalf("UpdateRef %s failed: %v", name, err)
+		}
+	}
+
+	// the first write goes through, and every one after it fails
+	puts := 0
+	s3.FailWhen(func(r *http.Request) bool {
+		if r.Method != http.MethodPut || !strings.Contains(r.URL.Path, "/"+refPrefix) {
+			return false
+		}
+		puts++
+		return puts > 1
+	})
+	err = s.UpdateRefs(t.Context(), []store.RefUpdate{
+		{Name: "refs/heads/a", OldSHA: old, NewSHA: next},
+		{Name: "refs/heads/b", OldSHA: old, NewSHA: next},
+	})
+	var txErr *store.TransactionError
+	if !errors.As(err, &txErr) || len(txErr.Rejected) != 1 || txErr.Rejected[0].Name != "refs/heads/a" {
+		t.Fatalf("UpdateRefs: got %v, want refs/heads/a reported as not rolled back", err)
+	}
+	if errors.Is(err, store.ErrRefConflict) {
+		t.Errorf("UpdateRefs reported a conflict for a failed write: %v", err)
+	}
+
+	s3.FailWhen(nil)
+	if ref, err := s.GetRef(t.Context(), "refs/heads/a"); err != nil || ref.SHA != next {
+		t.Errorf("refs/heads/a: got %v, %v, want it left at %s", ref, err, next)
+	}
+}
+
+func TestReflog(t *testing.T) {
+	storetest.Reflog(t, func(t *testing.T) storetest.LoggedRefStore {
+		s := newTestStore(t)
+		if err := s.Flush(t.Context()); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		return s
+	})
+}
+
+func TestBatch(t *testing.T) {
+	s := newTestStore(t)
+	storetest.Batch(t, s)
+}
+
+func TestStream(t *testing.T) {
+	s := newTestStore(t)
+	storetest.Stream(t, s)
+}
+
+func TestMutable(t *testing.T) {
+	s := newTestStore(t)
+	storetest.Mutable(t, s)
+}
+
+func TestResolve(t *testing.T) {
+	s := newTestStore(t)
+	storetest.Resolve(t, s)
+}
+
+func TestStat(t *testing.T) {
+	s := newTestStore(t)
+	storetest.Stat(t, s)
+}
+
+func TestStatWithoutMetadata(t *testing.T) {
+	s := newTestStore(t)
+
+	// an object uploaded before type and size were recorded
+	compressed, sha, err := object.Serialize(&object.Object{Type: object.TypeCommit, Data: []byte("old upload")})
+	if err != nil {
+		t.Fatalf("Serialize failed: %v", err)
+	}
+	_, err = s.client.PutObject(t.Context(), s.bucket, sha, bytes.NewReader(compressed), int64(len(compressed)), minio.PutObjectOptions{})
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	typ, size, err := s.Stat(t.Context(), sha)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if typ != object.TypeCommit || size != 10 {
+		t.Errorf("got (%s, %d), want (commit, 10)", typ, size)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	s := newTestStore(t, store.WithValidation())
+	storetest.Validation(t, s)
+}
+
+func TestSHA256(t *testing.T) {
+	s := newTestStore(t, store.WithFormat(object.SHA256), store.WithValidation())
+	storetest.SHA256(t, s)
+}
+
+func TestCodecs(t *testing.T) {
+	storetest.Codecs(t, func(t *testing.T, c object.Codec) store.ObjectStore {
+		return newTestStore(t, store.WithCodec(c))
+	})
+}
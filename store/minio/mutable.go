@@ -0,0 +1,48 @@
+package minio
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"git.wyat.me/git-storage/store"
+	"github.com/minio/minio-go/v7"
+)
+
+// Iterate lists the top level of the bucket, where objects live under
+// their bare SHA. Refs, reflogs and in-progress uploads sit under
+// "_"-prefixed directories, which a non-recursive listing reports as
+// single entries and which are skipped.
+func (s *MinioStore) Iterate(ctx context.Context, prefix string, fn func(sha string) error) error {
+	// cancelling stops the listing goroutine if fn ends the iteration.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for info := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if info.Err != nil {
+			return fmt.Errorf("iterate: %w", store.Unavailable(info.Err))
+		}
+		if strings.HasPrefix(info.Key, "_") || strings.HasSuffix(info.Key, "/") {
+			continue
+		}
+		if err := fn(info.Key); err == store.ErrStopIteration {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// Delete removes sha. S3 deletes are idempotent, so a missing key needs no
+// special case. A name that is not a SHA is never an object, and deleting
+// it does nothing, so refs and reflogs cannot be removed through it.
+func (s *MinioStore) Delete(ctx context.Context, sha string) error {
+	if !s.opts.Format.IsSHA(sha) {
+		return nil
+	}
+	if err := s.client.RemoveObject(ctx, s.bucket, sha, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("delete %s: %w", sha, store.Unavailable(err))
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package minio
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+
+	"git.wyat.me/git-storage/store"
+	"github.com/minio/minio-go/v7"
+)
+
+// reflogPrefix holds one object per entry, named after the ref and the
+// entry's timestamp so that listing returns a ref's history in order.
+const reflogPrefix = "_logs/"
+
+func reflogKeyPrefix(name string) string {
+	// ':' cannot appear in a ref name, so refs/heads/a and refs/heads/a/b
+	// never share a prefix.
+	return reflogPrefix + name + ":"
+}
+
+func (s *MinioStore) AppendReflog(ctx context.Context, name string, entry store.ReflogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("encode reflog entry: %w", err)
+	}
+	// S3 has no append, so every entry is its own object. The random
+	// suffix keeps entries written in the same nanosecond apart.
+	var suffix [4]byte
+	rand.Read(suffix[:])
+	key := fmt.Sprintf("%s%016x-%s", reflogKeyPrefix(name), entry.Time.UnixNano(), hex.EncodeToString(suffix[:]))
+
+	_, err = s.client.PutObject(ctx, s.bucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/json",
+	})
+	if err != nil {
+		return fmt.Errorf("append reflog %s: %w", name, store.Unavailable(err))
+	}
+	return nil
+}
+
+func (s *MinioStore) Reflog(ctx context.Context, name string) ([]store.ReflogEntry, error) {
+	var keys []string
+	for info := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    reflogKeyPrefix(name),
+		Recursive: true,
+	}) {
+		if info.Err != nil {
+			return nil, fmt.Errorf("list reflog %s: %w", name, store.Unavailable(info.Err))
+		}
+		keys = append(keys, info.Key)
+	}
+	slices.Sort(keys)
+	slices.Reverse(keys)
+
+	entries := make([]store.ReflogEntry, 0, len(keys))
+	for _, key := range keys {
+		obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("get reflog entry: %w", store.Unavailable(err))
+		}
+		data, err := io.ReadAll(obj)
+		obj.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read reflog entry: %w", store.Unavailable(err))
+		}
+		var entry store.ReflogEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, fmt.Errorf("decode reflog entry %s: %w", key, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
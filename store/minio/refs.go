@@ -0,0 +1,261 @@
+package minio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"git.wyat.me/git-storage/store"
+	"github.com/minio/minio-go/v7"
+)
+
+// refPrefix keeps refs apart from objects, which are stored under their
+// bare SHA at the top of the bucket.
+const refPrefix = "_refs/"
+
+const symrefPrefix = "ref: "
+
+func refKey(name string) string {
+	return refPrefix + name
+}
+
+// readRef returns the stored value of name together with its ETag, which
+// later conditional writes use to detect concurrent updates. Deleted refs
+// are kept as empty tombstones (see DeleteRef) and read as not found, but
+// their ETag is still returned so a create can replace them safely.
+func (s *MinioStore) readRef(ctx context.Context, name string) (*store.Ref, string, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, refKey(name), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, "", fmt.Errorf("get ref object: %w", store.Unavailable(err))
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, "", store.ErrRefNotFound
+		}
+		return nil, "", fmt.Errorf("read ref object: %w", store.Unavailable(err))
+	}
+	info, err := obj.Stat()
+	if err != nil {
+		return nil, "", fmt.Errorf("stat ref object: %w", store.Unavailable(err))
+	}
+	if len(data) == 0 {
+		return nil, info.ETag, store.ErrRefNotFound
+	}
+	return decodeRef(name, data), info.ETag, nil
+}
+
+func decodeRef(name string, data []byte) *store.Ref {
+	if target, ok := strings.CutPrefix(string(data), symrefPrefix); ok {
+		return &store.Ref{Name: name, Target: target}
+	}
+	return &store.Ref{Name: name, SHA: string(data)}
+}
+
+func encodeRef(ref *store.Ref) []byte {
+	if ref.Target != "" {
+		return []byte(symrefPrefix + ref.Target)
+	}
+	return []byte(ref.SHA)
+}
+
+func (s *MinioStore) GetRef(ctx context.Context, name string) (*store.Ref, error) {
+	ref, _, err := s.readRef(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("get ref %s: %w", name, err)
+	}
+	return ref, nil
+}
+
+func (s *MinioStore) ListRefs(ctx context.Context, prefix string) ([]store.Ref, error) {
+	var refs []store.Ref
+	for info := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    refKey(prefix),
+		Recursive: true,
+	}) {
+		if info.Err != nil {
+			return nil, fmt.Errorf("list refs: %w", store.Unavailable(info.Err))
+		}
+		if info.Size == 0 {
+			continue
+		}
+		name := strings.TrimPrefix(info.Key, refPrefix)
+		ref, _, err := s.readRef(ctx, name)
+		if err == store.ErrRefNotFound {
+			continue // deleted between listing and reading
+		}
+		if err != nil {
+			return nil, fmt.Errorf("list refs: %w", err)
+		}
+		refs = append(refs, *ref)
+	}
+	return refs, nil
+}
+
+func (s *MinioStore) UpdateRef(ctx context.Context, name, oldSHA, newSHA string) error {
+	return s.writeRef(ctx, name, oldSHA, []byte(newSHA), true)
+}
+
+// DeleteRef overwrites the ref with an empty tombstone instead of removing
+// the S3 object. S3 has no conditional delete, and a tombstone can be
+// written with If-Match just like any other update.
+func (s *MinioStore) DeleteRef(ctx context.Context, name, oldSHA string) error {
+	return s.writeRef(ctx, name, oldSHA, nil, oldSHA != "")
+}
+
+func (s *MinioStore) SetSymbolicRef(ctx context.Context, name, target string) error {
+	return s.writeRef(ctx, name, "", []byte(symrefPrefix+target), false)
+}
+
+// writeRef stores data under name. When check is set the write only
+// succeeds if the ref still holds oldSHA (or is absent for an empty
+// oldSHA), enforced with an If-Match or If-None-Match conditional PUT.
+func (s *MinioStore) writeRef(ctx context.Context, name, oldSHA string, data []byte, check bool) error {
+	etag := ""
+	if check {
+		var err error
+		if etag, err = s.checkRef(ctx, name, oldSHA); err != nil {
+			return fmt.Errorf("update ref %s: %w", name, err)
+		}
+	}
+	if _, err := s.putRef(ctx, name, data, etag, check); err != nil {
+		return fmt.Errorf("update ref %s: %w", name, err)
+	}
+	return nil
+}
+
+// checkRef fails with store.ErrRefConflict unless name currently holds
+// oldSHA, or is absent when oldSHA is empty. It returns the ETag to make
+// the following write conditional on, which is empty if the ref object
+// does not exist at all.
+func (s *MinioStore) checkRef(ctx context.Context, name, oldSHA string) (string, error) {
+	ref, etag, err := s.readRef(ctx, name)
+	switch {
+	case err == store.ErrRefNotFound:
+		if oldSHA != "" {
+			return "", store.ErrRefConflict
+		}
+		return etag, nil
+	case err != nil:
+		return "", err
+	case oldSHA == "" || ref.SHA != oldSHA:
+		return "", store.ErrRefConflict
+	}
+	return etag, nil
+}
+
+// putRef writes the ref object and returns its new ETag. With check set
+// the PUT carries If-Match etag, or If-None-Match * for an empty etag, and
+// a failed precondition is reported as store.ErrRefConflict.
+func (s *MinioStore) putRef(ctx context.Context, name string, data []byte, etag string, check bool) (string, error) {
+	opts := minio.PutObjectOptions{ContentType: "text/plain"}
+	if check {
+		if etag == "" {
+			opts.SetMatchETagExcept("*")
+		} else {
+			opts.SetMatchETag(etag)
+		}
+	}
+	info, err := s.client.PutObject(ctx, s.bucket, refKey(name), bytes.NewReader(data), int64(len(data)), opts)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "PreconditionFailed" {
+			return "", store.ErrRefConflict
+		}
+		return "", fmt.Errorf("put ref object: %w", store.Unavailable(err))
+	}
+	return info.ETag, nil
+}
+
+// refWrite is one planned PUT of a ref transaction. prev holds the value
+// to restore on rollback, and etag the ETag the PUT is conditional on.
+type refWrite struct {
+	name string
+	data []byte
+	prev []byte
+	etag string
+}
+
+// UpdateRefs checks every precondition up front and then applies the
+// updates one conditional PUT at a time. S3 has no multi-object
+// transaction, so if a ref moves between the checks and its write, the
+// updates already written are rolled back, again with conditional PUTs.
+// Readers may briefly observe a partially applied transaction.
+func (s *MinioStore) UpdateRefs(ctx context.Context, updates []store.RefUpdate) error {
+	if err := store.CheckDuplicates(updates); err != nil {
+		return err
+	}
+
+	var txErr store.TransactionError
+	plan := make([]refWrite, 0, len(updates))
+	for _, u := range updates {
+		ref, etag, err := s.readRef(ctx, u.Name)
+		if err != nil && err != store.ErrRefNotFound {
+			return fmt.Errorf("update refs: %w", err)
+		}
+		exists := err == nil
+		ok := true
+		switch {
+		case u.IsDelete() && u.OldSHA == "":
+		case u.OldSHA == "":
+			ok = !exists
+		default:
+			ok = exists && ref.Target == "" && ref.SHA == u.OldSHA
+		}
+		if !ok {
+			txErr.Rejected = append(txErr.Rejected, store.RefRejection{Name: u.Name, Err: store.ErrRefConflict})
+			continue
+		}
+
+		w := refWrite{name: u.Name, etag: etag}
+		if !u.IsDelete() {
+			w.data = []byte(u.NewSHA)
+		}
+		if exists {
+			w.prev = encodeRef(ref)
+		}
+		plan = append(plan, w)
+	}
+	if len(txErr.Rejected) > 0 {
+		return &txErr
+	}
+
+	for i, w := range plan {
+		etag, err := s.putRef(ctx, w.name, w.data, w.etag, true)
+		if err != nil {
+			failed := s.rollbackRefs(ctx, plan[:i])
+			if err == store.ErrRefConflict {
+				rejected := append([]store.RefRejection{{Name: w.name, Err: err}}, failed...)
+				return &store.TransactionError{Rejected: rejected}
+			}
+			if len(failed) > 0 {
+				return fmt.Errorf("update refs: %w", errors.Join(err, &store.TransactionError{Rejected: failed}))
+			}
+			return fmt.Errorf("update refs: %w", err)
+		}
+		plan[i].etag = etag
+	}
+	return nil
+}
+
+// rollbackRefs restores the previous value of each applied write in
+// reverse order, skipping any ref that another writer has replaced since.
+// It runs even if ctx was cancelled, since that is one way to get here.
+// It returns the refs it could not restore, which may still hold the
+// transaction's value.
+func (s *MinioStore) rollbackRefs(ctx context.Context, applied []refWrite) []store.RefRejection {
+	ctx = context.WithoutCancel(ctx)
+	var failed []store.RefRejection
+	for i := len(applied) - 1; i >= 0; i-- {
+		w := applied[i]
+		_, err := s.putRef(ctx, w.name, w.prev, w.etag, true)
+		if err != nil && err != store.ErrRefConflict {
+			failed = append(failed, store.RefRejection{Name: w.name, Err: fmt.Errorf("roll back: %w", err)})
+		}
+	}
+	return failed
+}
@@ -0,0 +1,47 @@
+package minio
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+	"github.com/minio/minio-go/v7"
+)
+
+// Every object is uploaded with its type and size as user metadata, which
+// S3 returns from a HEAD request, so Stat costs the same as Exists.
+const (
+	metaType = "Git-Type"
+	metaSize = "Git-Size"
+)
+
+func statMetadata(typ object.ObjectType, size int64) map[string]string {
+	return map[string]string{metaType: string(typ), metaSize: strconv.FormatInt(size, 10)}
+}
+
+func putOptions(typ object.ObjectType, size int64) minio.PutObjectOptions {
+	return minio.PutObjectOptions{ContentType: "application/octet-stream", UserMetadata: statMetadata(typ, size)}
+}
+
+// Stat reads the type and size from sha's metadata.
+func (s *MinioStore) Stat(ctx context.Context, sha string) (object.ObjectType, int64, error) {
+	if !s.opts.Format.IsSHA(sha) {
+		return "", 0, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
+	}
+	info, err := s.client.StatObject(ctx, s.bucket, sha, minio.StatObjectOptions{})
+	if err != nil {
+		if isNotFound(err) {
+			return "", 0, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
+		}
+		return "", 0, fmt.Errorf("stat object: %w", store.Unavailable(err))
+	}
+	typ := info.UserMetadata[metaType]
+	size, err := strconv.ParseInt(info.UserMetadata[metaSize], 10, 64)
+	if typ == "" || err != nil {
+		// uploaded before the metadata was added
+		return store.StatHeader(ctx, s, sha)
+	}
+	return object.ObjectType(typ), size, nil
+}
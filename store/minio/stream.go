@@ -0,0 +1,155 @@
+package minio
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+	"github.com/minio/minio-go/v7"
+)
+
+// Streamed objects larger than partSize once compressed are uploaded in
+// parts of that size to a temporary "_tmp/" key, because the SHA is only
+// known once the whole body has been read, and then copied server-side to
+// their SHA. 16MiB is minio-go's default part size for uploads of unknown
+// length.
+const (
+	tmpPrefix = "_tmp/"
+	partSize  = 16 << 20
+)
+
+// PutStream compresses the body read from r as it is uploaded, holding at
+// most one part in memory.
+func (s *MinioStore) PutStream(ctx context.Context, typ object.ObjectType, size int64, r io.Reader) (string, error) {
+	if s.opts.CheckStream(typ) {
+		return store.PutWhole(ctx, s, typ, size, r)
+	}
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	w := s.opts.NewWriter(pw, typ, size)
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(w, r)
+		if err == nil {
+			err = w.Close()
+		}
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	// objects that fit in one part are stored whole under their SHA, as
+	// Put would store them.
+	var first bytes.Buffer
+	if _, err := io.CopyN(&first, pr, partSize); err == io.EOF {
+		if err := <-done; err != nil {
+			return "", fmt.Errorf("put stream: %w", err)
+		}
+		sha := w.SHA()
+		return sha, s.put(ctx, sha, typ, size, first.Bytes())
+	} else if err != nil {
+		return "", fmt.Errorf("put stream: %w", err)
+	}
+
+	var id [8]byte
+	rand.Read(id[:])
+	tmp := tmpPrefix + hex.EncodeToString(id[:])
+	_, err := s.client.PutObject(
+		ctx,
+		s.bucket,
+		tmp,
+		io.MultiReader(&first, pr),
+		-1,
+		minio.PutObjectOptions{ContentType: "application/octet-stream", PartSize: partSize},
+	)
+	// if the upload failed first, closing the pipe stops the compressor,
+	// which then fails with io.ErrClosedPipe.
+	pr.Close()
+	if werr := <-done; werr != nil && !errors.Is(werr, io.ErrClosedPipe) {
+		return "", fmt.Errorf("put stream: %w", werr)
+	}
+	if err != nil {
+		return "", fmt.Errorf("put object: %w", store.Unavailable(err))
+	}
+	defer s.client.RemoveObject(context.WithoutCancel(ctx), s.bucket, tmp, minio.RemoveObjectOptions{})
+
+	sha := w.SHA()
+	exists, err := s.Exists(ctx, sha)
+	if err != nil || exists {
+		return sha, err
+	}
+	// ComposeObject copies in parts when the source is over S3's 5GiB
+	// limit for a single copy.
+	_, err = s.client.ComposeObject(
+		ctx,
+		minio.CopyDestOptions{
+			Bucket:          s.bucket,
+			Object:          sha,
+			UserMetadata:    statMetadata(typ, size),
+			ReplaceMetadata: true,
+		},
+		minio.CopySrcOptions{Bucket: s.bucket, Object: tmp},
+	)
+	if err != nil {
+		return "", fmt.Errorf("copy object: %w", store.Unavailable(err))
+	}
+	return sha, nil
+}
+
+// GetStream downloads sha as it is read.
+func (s *MinioStore) GetStream(ctx context.Context, sha string) (*object.Reader, error) {
+	if !s.opts.Format.IsSHA(sha) {
+		return nil, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
+	}
+	obj, err := s.client.GetObject(
+		ctx,
+		s.bucket,
+		sha,
+		minio.GetObjectOptions{},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get object: %w", store.Unavailable(err))
+	}
+	// Stat sends the request, so a missing key is reported here rather
+	// than on the first read.
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		if isNotFound(err) {
+			return nil, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
+		}
+		return nil, fmt.Errorf("get object: %w", store.Unavailable(err))
+	}
+
+	r, err := s.opts.NewReader(&bodyReader{obj})
+	if err != nil {
+		obj.Close()
+		if errors.Is(err, store.ErrUnavailable) || ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("object %s: %w: %w", sha, store.ErrCorrupt, err)
+	}
+	return r, nil
+}
+
+// bodyReader marks errors reading an object's body from S3 as
+// store.ErrUnavailable, so they are not mistaken for corrupt data.
+type bodyReader struct {
+	obj *minio.Object
+}
+
+func (r *bodyReader) Read(p []byte) (int, error) {
+	n, err := r.obj.Read(p)
+	if err != nil && err != io.EOF {
+		err = store.Unavailable(err)
+	}
+	return n, err
+}
+
+func (r *bodyReader) Close() error {
+	return r.obj.Close()
+}
@@ -0,0 +1,26 @@
+package store
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrStopIteration can be returned by an Iterate callback to end the
+// iteration early without Iterate reporting an error.
+var ErrStopIteration = errors.New("stop iteration")
+
+// MutableStore is implemented by object stores that can enumerate and
+// remove the objects they hold, which garbage collection, fsck, migration
+// and abbreviated SHA lookup need.
+type MutableStore interface {
+	ObjectStore
+	// Iterate calls fn with the SHA of every stored object that starts
+	// with prefix, in ascending order, skipping the backend's refs and
+	// other bookkeeping. An error from fn stops the iteration and is
+	// returned, unless it is ErrStopIteration. fn may call other methods
+	// of the store, including Delete.
+	Iterate(ctx context.Context, prefix string, fn func(sha string) error) error
+	// Delete removes sha. Deleting an object that is not stored is not an
+	// error.
+	Delete(ctx context.Context, sha string) error
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"fmt"
+	"io"
+
+	"git.wyat.me/git-storage/object"
+)
+
+// Options holds the settings every backend's constructor accepts.
+type Options struct {
+	// Validate makes Put, PutMany and PutStream reject objects that fail
+	// object.Validate, as git does with receive.fsckObjects.
+	Validate bool
+	// Format is the hash objects are named by. A store must be opened
+	// with the same format every time.
+	Format object.Format
+	// Codec compresses objects as they are written, and nil means zlib.
+	// Objects stay readable after the codec changes, except that values
+	// written with a dictionary need that dictionary to be passed to
+	// object.NewZstdDict again.
+	Codec object.Codec
+	// MaxDeltaDepth, when above zero, lets a DeltaStore keep objects as
+	// deltas, with at most this many deltas between an object and the
+	// whole object its chain ends at. Each one is another object to read
+	// and apply on Get.
+	MaxDeltaDepth int
+}
+
+type Option func(*Options)
+
+// WithValidation rejects malformed objects on write.
+func WithValidation() Option {
+	return func(o *Options) { o.Validate = true }
+}
+
+// WithFormat names objects with format f's hash instead of SHA-1.
+func WithFormat(f object.Format) Option {
+	return func(o *Options) { o.Format = f }
+}
+
+// WithCodec compresses newly written objects with c instead of zlib.
+func WithCodec(c object.Codec) Option {
+	return func(o *Options) { o.Codec = c }
+}
+
+// WithDeltas lets a DeltaStore keep objects as deltas in chains of at
+// most maxDepth. git's own pack.depth defaults to 50.
+func WithDeltas(maxDepth int) Option {
+	return func(o *Options) { o.MaxDeltaDepth = maxDepth }
+}
+
+// NewOptions applies opts to the defaults.
+func NewOptions(opts ...Option) Options {
+	var o Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Check validates obj if o asks for it. Backends call it before writing.
+func (o Options) Check(obj *object.Object) error {
+	if !o.Validate {
+		return nil
+	}
+	if err := o.Format.Validate(obj); err != nil {
+		return fmt.Errorf("object %s: %w", o.Format.Hash(obj), err)
+	}
+	return nil
+}
+
+func (o Options) codec() object.Codec {
+	if o.Codec == nil {
+		return object.Zlib
+	}
+	return o.Codec
+}
+
+// Serialize compresses obj with o's codec and names it with o's format.
+func (o Options) Serialize(obj *object.Object) (compressed []byte, sha string, err error) {
+	return o.Format.SerializeCodec(obj, o.codec())
+}
+
+// NewWriter is object.NewWriter with o's format and codec.
+func (o Options) NewWriter(w io.Writer, typ object.ObjectType, size int64) *object.Writer {
+	return o.Format.NewCodecWriter(w, o.codec(), typ, size)
+}
+
+// Deserialize decompresses a stored object, whichever codec wrote it.
+func (o Options) Deserialize(compressed []byte) (*object.Object, error) {
+	return object.Deserialize(compressed, o.codec())
+}
+
+// NewReader is object.NewReader, also reading objects written by o's
+// codec.
+func (o Options) NewReader(r io.Reader) (*object.Reader, error) {
+	return object.NewReader(r, o.codec())
+}
+
+// Compress compresses a delta or other value that is not an object with
+// o's codec.
+func (o Options) Compress(data []byte) ([]byte, error) {
+	return object.Compress(data, o.codec())
+}
+
+// Decompress reverses Compress, whichever codec wrote the value.
+func (o Options) Decompress(compressed []byte) ([]byte, error) {
+	return object.Decompress(compressed, o.codec())
+}
+
+// CheckStream reports whether a streamed object of type typ must instead
+// be read whole so it can be checked. Blobs are always valid, and are the
+// only objects large enough to need streaming.
+func (o Options) CheckStream(typ object.ObjectType) bool {
+	return o.Validate && typ != object.TypeBlob
+}
@@ -0,0 +1,82 @@
+package packfs
+
+import (
+	"context"
+	"fmt"
+
+	"git.wyat.me/git-storage/object"
+)
+
+// PutMany appends objs to the pending pack under a single hold of the
+// store's lock.
+func (s *PackStore) PutMany(ctx context.Context, objs []*object.Object) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	shas := make([]string, len(objs))
+	for i, obj := range objs {
+		if err := s.opts.Check(obj); err != nil {
+			return nil, err
+		}
+		sha, err := s.name(obj)
+		if err != nil {
+			return nil, err
+		}
+		shas[i] = sha
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.usable(); err != nil {
+		return nil, fmt.Errorf("put: %w", err)
+	}
+	for i, obj := range objs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := s.add(ctx, shas[i], obj); err != nil {
+			return nil, err
+		}
+	}
+	return shas, nil
+}
+
+// GetMany reads shas under a single hold of the store's lock.
+func (s *PackStore) GetMany(ctx context.Context, shas []string) ([]*object.Object, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if err := s.usable(); err != nil {
+		return nil, fmt.Errorf("get: %w", err)
+	}
+	objs := make([]*object.Object, len(shas))
+	for i, sha := range shas {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		obj, err := s.get(sha)
+		if err != nil {
+			return nil, err
+		}
+		objs[i] = obj
+	}
+	return objs, nil
+}
+
+// ExistsMany looks shas up in the indexes, which are already in memory.
+func (s *PackStore) ExistsMany(ctx context.Context, shas []string) ([]bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if err := s.usable(); err != nil {
+		return nil, fmt.Errorf("exists: %w", err)
+	}
+	exists := make([]bool, len(shas))
+	for i, sha := range shas {
+		exists[i] = s.has(sha)
+	}
+	return exists, nil
+}
@@ -0,0 +1,63 @@
+package packfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"git.wyat.me/git-storage/store"
+)
+
+// mapping is a file mapped into memory. Reads through ReadAt fail once it
+// is closed rather than touching unmapped memory, since readers from
+// GetStream can outlive the store.
+type mapping struct {
+	mu   sync.RWMutex
+	data []byte
+}
+
+// mapFile maps the whole of the file at path, read-only.
+func mapFile(path string) (*mapping, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data, err := mmap(f, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("map %s: %w", path, err)
+	}
+	return &mapping{data: data}, nil
+}
+
+func (m *mapping) ReadAt(p []byte, off int64) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.data == nil {
+		return 0, store.Unavailable(errClosed)
+	}
+	if off < 0 || off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *mapping) close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.data == nil {
+		return nil
+	}
+	err := munmap(m.data)
+	m.data = nil
+	return err
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package packfs
+
+import (
+	"io"
+	"os"
+)
+
+// mmap reads the file into memory where it cannot be mapped.
+func mmap(f *os.File, size int64) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(f, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func munmap([]byte) error {
+	return nil
+}
@@ -0,0 +1,292 @@
+// Package packfs stores objects in git packfiles, as git itself does once
+// a repository has been repacked, so that the key-value backends can be
+// benchmarked against git's native format. Objects are found through each
+// pack's .idx, with the packs and indexes memory-mapped.
+package packfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/pack"
+	"git.wyat.me/git-storage/store"
+)
+
+// PackStore reads objects from the packs in objects/pack under the git
+// directory it was opened on, including packs git wrote, and appends new
+// objects to a pack of its own. That pack is finished, checksummed and
+// indexed once it is large enough or the store is closed, and only then
+// can git see it. Objects are written whole, so a PackStore's packs are
+// larger than those git repack writes. Only one PackStore may have a
+// directory open at a time.
+type PackStore struct {
+	dir  string
+	opts store.Options
+
+	mu sync.RWMutex
+	// packs is newest first, as new objects are the likeliest to be read.
+	packs []*packFile
+	// pending is the pack new objects are appended to.
+	pending *pack.Appender
+	closed  bool
+}
+
+// packFile is a finished pack and its index, both memory-mapped.
+type packFile struct {
+	*pack.Packfile
+	idx  *mapping
+	data *mapping
+}
+
+var errClosed = errors.New("pack store closed")
+
+// New opens the packs of the git directory dir, creating its objects/pack
+// directory if need be. A pack left unfinished by a store that was not
+// closed is finished first, keeping every object that was completely
+// written to it. git reads packs only as zlib and SHA-1, so any other
+// codec or format is refused.
+func New(dir string, opts ...store.Option) (*PackStore, error) {
+	o := store.NewOptions(opts...)
+	if o.Codec != nil && o.Codec != object.Zlib {
+		return nil, fmt.Errorf("git reads packs only as zlib, not %s", o.Codec.Name())
+	}
+	if o.Format != object.SHA1 {
+		return nil, fmt.Errorf("packs are only written in sha1, not %s", o.Format)
+	}
+	s := &PackStore{dir: filepath.Join(dir, "objects", "pack"), opts: o}
+	if err := os.MkdirAll(s.dir, 0o777); err != nil {
+		return nil, fmt.Errorf("create pack directory: %w", err)
+	}
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+	if err := s.load(); err != nil {
+		s.unmap()
+		return nil, err
+	}
+	return s, nil
+}
+
+// load opens every indexed pack, newest first. An index whose pack is
+// missing was left by a store that stopped while finishing the pack, which
+// recover has since finished again, and is removed.
+func (s *PackStore) load() error {
+	idxs, err := filepath.Glob(filepath.Join(s.dir, "pack-*.idx"))
+	if err != nil {
+		return fmt.Errorf("list packs: %w", err)
+	}
+	type found struct {
+		name  string
+		mtime time.Time
+	}
+	var packs []found
+	for _, idx := range idxs {
+		name := strings.TrimSuffix(idx, ".idx")
+		info, err := os.Stat(name + ".pack")
+		if errors.Is(err, fs.ErrNotExist) {
+			os.Remove(idx)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("stat pack: %w", err)
+		}
+		packs = append(packs, found{name, info.ModTime()})
+	}
+	slices.SortFunc(packs, func(a, b found) int {
+		return b.mtime.Compare(a.mtime)
+	})
+	for _, f := range packs {
+		p, err := openPack(f.name)
+		if err != nil {
+			return err
+		}
+		s.packs = append(s.packs, p)
+	}
+	return nil
+}
+
+// openPack maps name's .idx and .pack and checks that they belong
+// together.
+func openPack(name string) (*packFile, error) {
+	idx, err := mapFile(name + ".idx")
+	if err != nil {
+		return nil, fmt.Errorf("open pack index: %w", err)
+	}
+	index, err := pack.ParseIndex(idx.data)
+	if err != nil {
+		idx.close()
+		return nil, fmt.Errorf("%s: %w", filepath.Base(name), err)
+	}
+	data, err := mapFile(name + ".pack")
+	if err != nil {
+		idx.close()
+		return nil, fmt.Errorf("open pack: %w", err)
+	}
+	if n := len(data.data); n < 32 || string(data.data[:4]) != "PACK" ||
+		hex.EncodeToString(data.data[n-20:]) != index.PackChecksum() {
+		idx.close()
+		data.close()
+		return nil, fmt.Errorf("%s: %w: pack does not match its index", filepath.Base(name), store.ErrCorrupt)
+	}
+	return &packFile{
+		Packfile: pack.NewPackfile(data, index, int64(len(data.data))),
+		idx:      idx,
+		data:     data,
+	}, nil
+}
+
+// usable fails once the store is closed. The caller must hold s.mu.
+func (s *PackStore) usable() error {
+	if s.closed {
+		return store.Unavailable(errClosed)
+	}
+	return nil
+}
+
+// find returns the pack holding sha, or nil if no finished pack does.
+func (s *PackStore) find(sha string) *packFile {
+	for _, p := range s.packs {
+		if _, ok := p.Index().Find(sha); ok {
+			return p
+		}
+	}
+	return nil
+}
+
+// has reports whether sha is in a finished pack or the pending one.
+func (s *PackStore) has(sha string) bool {
+	if s.pending != nil && s.pending.Has(sha) {
+		return true
+	}
+	return s.find(sha) != nil
+}
+
+// name hashes obj as Serialize would, failing if it is half of a SHA-1
+// collision, without compressing it, since the pack's Appender does that.
+func (s *PackStore) name(obj *object.Object) (string, error) {
+	w := s.opts.Format.NewCodecWriter(io.Discard, object.None, obj.Type, int64(len(obj.Data)))
+	if _, err := w.Write(obj.Data); err != nil {
+		return "", fmt.Errorf("hash: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("hash: %w", err)
+	}
+	return w.SHA(), nil
+}
+
+func (s *PackStore) Put(ctx context.Context, obj *object.Object) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if err := s.opts.Check(obj); err != nil {
+		return "", err
+	}
+	sha, err := s.name(obj)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.usable(); err != nil {
+		return "", fmt.Errorf("put: %w", err)
+	}
+	if err := s.add(ctx, sha, obj); err != nil {
+		return "", err
+	}
+	return sha, nil
+}
+
+// add appends obj to the pending pack unless it is already stored, and
+// finishes the pending pack if that fills it. The caller must hold s.mu.
+func (s *PackStore) add(ctx context.Context, sha string, obj *object.Object) error {
+	if s.has(sha) {
+		return nil
+	}
+	if s.pending == nil {
+		a, err := pack.NewAppender(s.dir, tempPattern)
+		if err != nil {
+			return fmt.Errorf("put: %w", err)
+		}
+		s.pending = a
+	}
+	if err := s.pending.Add(ctx, sha, obj.Type, int64(len(obj.Data)), bytes.NewReader(obj.Data)); err != nil {
+		return fmt.Errorf("put: %w", err)
+	}
+	if s.pending.Count() < maxPackObjects && s.pending.Size() < maxPackSize {
+		return nil
+	}
+	return s.finishPending()
+}
+
+func (s *PackStore) Get(ctx context.Context, sha string) (*object.Object, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if err := s.usable(); err != nil {
+		return nil, fmt.Errorf("get %s: %w", sha, err)
+	}
+	return s.get(sha)
+}
+
+// get reads sha from whichever pack holds it. The caller must hold s.mu.
+func (s *PackStore) get(sha string) (*object.Object, error) {
+	if s.pending != nil && s.pending.Has(sha) {
+		return s.pending.Get(sha)
+	}
+	if p := s.find(sha); p != nil {
+		return p.Get(sha)
+	}
+	return nil, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
+}
+
+func (s *PackStore) Exists(ctx context.Context, sha string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if err := s.usable(); err != nil {
+		return false, fmt.Errorf("exists: %w", err)
+	}
+	return s.has(sha), nil
+}
+
+// Close finishes the pending pack, so that git can read it, and unmaps
+// every pack. Readers from GetStream fail once the store is closed.
+func (s *PackStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	var err error
+	if s.pending != nil {
+		err = s.finishPending()
+	}
+	s.closed = true
+	s.unmap()
+	return err
+}
+
+// unmap releases every pack's mappings.
+func (s *PackStore) unmap() {
+	for _, p := range s.packs {
+		p.idx.close()
+		p.data.close()
+	}
+	s.packs = nil
+}
@@ -0,0 +1,357 @@
+package packfs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+	"git.wyat.me/git-storage/store/storetest"
+)
+
+func TestPutAndGet(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	obj := &object.Object{
+		Type: object.TypeBlob,
+		Data: []byte("hello\n"),
+	}
+
+	sha, err := s.Put(t.Context(), obj)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	const expectedSHA = "ce013625030ba8dba906f756967f9e9ca394464a"
+	if sha != expectedSHA {
+		t.Errorf("SHA mismatch: got %s, want %s", sha, expectedSHA)
+	}
+
+	// readable from the pending pack, and from the finished one after the
+	// store is reopened
+	for _, when := range []string{"before Close", "after reopening"} {
+		got, err := s.Get(t.Context(), sha)
+		if err != nil {
+			t.Fatalf("Get %s failed: %v", when, err)
+		}
+		if got.Type != obj.Type || string(got.Data) != string(obj.Data) {
+			t.Errorf("Get %s: got %s %q, want %s %q", when, got.Type, got.Data, obj.Type, obj.Data)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		if s, err = New(dir); err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+	}
+	defer s.Close()
+
+	packs, _ := filepath.Glob(filepath.Join(s.dir, "pack-*"))
+	if len(packs) != 2 {
+		t.Fatalf("got files %v, want one pack and its index", packs)
+	}
+	for _, path := range packs {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("stat %s: %v", path, err)
+		}
+		if perm := info.Mode().Perm(); perm != 0o444 {
+			t.Errorf("%s mode %v, want -r--r--r--", filepath.Base(path), perm)
+		}
+	}
+	if temps, _ := filepath.Glob(filepath.Join(s.dir, "tmp_*")); len(temps) > 0 {
+		t.Errorf("temporary files left behind: %v", temps)
+	}
+}
+
+func TestDuplicatePut(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+
+	obj := &object.Object{
+		Type: object.TypeBlob,
+		Data: []byte("hello\n"),
+	}
+
+	sha1, err := s.Put(t.Context(), obj)
+	if err != nil {
+		t.Fatalf("first Put failed: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if s, err = New(dir); err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	// already in a finished pack, so not written again
+	sha2, err := s.Put(t.Context(), obj)
+	if err != nil {
+		t.Fatalf("second Put failed: %v", err)
+	}
+	if sha1 != sha2 {
+		t.Errorf("duplicate Put returned different SHAs: %s vs %s", sha1, sha2)
+	}
+	if s.pending != nil {
+		t.Error("duplicate Put appended to a new pack")
+	}
+}
+
+func TestRefused(t *testing.T) {
+	if _, err := New(t.TempDir(), store.WithCodec(object.Zstd)); err == nil {
+		t.Error("New accepted a codec git cannot read")
+	}
+	if _, err := New(t.TempDir(), store.WithFormat(object.SHA256)); err == nil {
+		t.Error("New accepted a format packs are not written in")
+	}
+}
+
+// TestRecover checks that the objects a store wrote before it stopped
+// without being closed are found when the directory is opened again, up to
+// an entry it was part way through writing.
+func TestRecover(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	var shas []string
+	for i := range 3 {
+		sha, err := s.Put(t.Context(), &object.Object{Type: object.TypeBlob, Data: fmt.Appendf(nil, "recover %d\n", i)})
+		if err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		shas = append(shas, sha)
+	}
+	// the start of an entry that was never finished
+	f, err := os.OpenFile(s.pending.Name(), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("open pending pack: %v", err)
+	}
+	if _, err := f.Write([]byte{0xb5, 0x01, 0x78}); err != nil {
+		t.Fatalf("write partial entry: %v", err)
+	}
+	f.Close()
+
+	s, err = New(dir)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	for _, sha := range shas {
+		if _, err := s.Get(t.Context(), sha); err != nil {
+			t.Errorf("Get %s after recovery failed: %v", sha, err)
+		}
+	}
+	if temps, _ := filepath.Glob(filepath.Join(s.dir, "tmp_*")); len(temps) > 0 {
+		t.Errorf("temporary files left behind: %v", temps)
+	}
+}
+
+// TestGit checks that git reads the packs the store writes, and the store
+// reads the packs git writes, deltas and all.
+func TestGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	git := func(stdin []byte, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"--git-dir", dir}, args...)...)
+		cmd.Stdin = bytes.NewReader(stdin)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("git %s: %v", strings.Join(args, " "), err)
+		}
+		return string(out)
+	}
+	git(nil, "init", "--bare", "--quiet")
+
+	s, err := New(dir)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	blob := &object.Object{Type: object.TypeBlob, Data: []byte("written by the store\n")}
+	sha, err := s.Put(t.Context(), blob)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	large := bytes.Repeat([]byte("streamed by the store\n"), 1<<16)
+	streamed, err := s.PutStream(t.Context(), object.TypeBlob, int64(len(large)), bytes.NewReader(large))
+	if err != nil {
+		t.Fatalf("PutStream failed: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	idxs, _ := filepath.Glob(filepath.Join(dir, "objects", "pack", "pack-*.idx"))
+	for _, idx := range idxs {
+		git(nil, "verify-pack", idx)
+	}
+	if got := git(nil, "cat-file", "-p", sha); got != string(blob.Data) {
+		t.Errorf("git cat-file %s = %q, want %q", sha, got, blob.Data)
+	}
+	if got := git(nil, "cat-file", "-p", streamed); got != string(large) {
+		t.Errorf("git cat-file %s: streamed object did not round-trip", streamed)
+	}
+	git(nil, "fsck", "--strict")
+
+	// versions of a file that git will store as deltas of each other
+	var written []string
+	data := bytes.Repeat([]byte("written by git\n"), 1000)
+	for i := range 10 {
+		data = append(data, fmt.Sprintf("version %d\n", i)...)
+		written = append(written, strings.TrimSpace(git(data, "hash-object", "-w", "--stdin")))
+	}
+	list := []byte(strings.Join(written, "\n") + "\n")
+	sum := strings.TrimSpace(git(list, "pack-objects", "--quiet", filepath.Join(dir, "objects", "pack", "pack")))
+	idx := filepath.Join(dir, "objects", "pack", "pack-"+sum+".idx")
+	if !strings.Contains(git(nil, "verify-pack", "-v", idx), "chain length") {
+		t.Fatal("git pack-objects wrote no deltas")
+	}
+
+	s, err = New(dir)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	for _, sha := range append(written, sha, streamed) {
+		want := git(nil, "cat-file", "blob", sha)
+		obj, err := s.Get(t.Context(), sha)
+		if err != nil {
+			t.Fatalf("Get %s failed: %v", sha, err)
+		}
+		if obj.Type != object.TypeBlob || string(obj.Data) != want {
+			t.Errorf("Get %s: got %s of %d bytes, want blob of %d", sha, obj.Type, len(obj.Data), len(want))
+		}
+		typ, size, err := s.Stat(t.Context(), sha)
+		if err != nil || typ != object.TypeBlob || size != int64(len(want)) {
+			t.Errorf("Stat %s = %s, %d, %v, want blob, %d", sha, typ, size, err, len(want))
+		}
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Cancelled(t, s)
+}
+
+func TestErrors(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+
+	closed, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	closed.Close()
+
+	storetest.Errors(t, storetest.Faults{
+		Store: s,
+		Corrupt: func(sha string) {
+			// the entry's header is one byte, followed by its zlib header
+			offset, _ := s.pending.Find(sha)
+			f, err := os.OpenFile(s.pending.Name(), os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatalf("corrupt %s: %v", sha, err)
+			}
+			defer f.Close()
+			if _, err := f.WriteAt([]byte{0xff, 0xff}, offset+1); err != nil {
+				t.Fatalf("corrupt %s: %v", sha, err)
+			}
+		},
+		Unavailable: closed,
+	})
+}
+
+func TestBatch(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Batch(t, s)
+}
+
+func TestStream(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Stream(t, s)
+}
+
+func TestStat(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Stat(t, s)
+}
+
+func TestValidation(t *testing.T) {
+	s, err := New(t.TempDir(), store.WithValidation())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Validation(t, s)
+}
+
+func TestStoredSize(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	if size, err := s.StoredSize(t.Context()); err != nil || size != 0 {
+		t.Fatalf("StoredSize of an empty store = %d, %v", size, err)
+	}
+	if _, err := s.Put(t.Context(), &object.Object{Type: object.TypeBlob, Data: []byte("hello\n")}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if s, err = New(dir); err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+
+	var want int64
+	files, _ := filepath.Glob(filepath.Join(s.dir, "pack-*"))
+	for _, path := range files {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("stat %s: %v", path, err)
+		}
+		want += info.Size()
+	}
+	if size, err := s.StoredSize(t.Context()); err != nil || size != want {
+		t.Errorf("StoredSize = %d, %v, want %d", size, err, want)
+	}
+}
@@ -0,0 +1,142 @@
+package packfs
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"git.wyat.me/git-storage/pack"
+	"git.wyat.me/git-storage/store"
+)
+
+// A pending pack is finished once it holds maxPackObjects objects or
+// maxPackSize bytes, so that no pack has to be recovered entry by entry
+// after a crash, or re-read to checksum it, for too long.
+const (
+	maxPackObjects = 10000
+	maxPackSize    = 64 << 20
+)
+
+// Packs and their indexes are written to temporary names, as git names
+// its own, and renamed into place once complete.
+const (
+	tempPattern    = "tmp_pack_*"
+	tempIdxPattern = "tmp_idx_*"
+)
+
+// finishPending finishes the pending pack and starts reading it as a
+// finished one. The caller must hold s.mu.
+func (s *PackStore) finishPending() error {
+	name, err := s.finish(s.pending)
+	if err != nil {
+		return fmt.Errorf("finish pack: %w", err)
+	}
+	s.pending = nil
+	return s.install(name)
+}
+
+// install starts reading the finished pack name, ahead of the older ones.
+// The caller must hold s.mu.
+func (s *PackStore) install(name string) error {
+	packed, err := openPack(name)
+	if err != nil {
+		return fmt.Errorf("open finished pack: %w", store.Unavailable(err))
+	}
+	s.packs = append([]*packFile{packed}, s.packs...)
+	return nil
+}
+
+// finish finishes the temporary pack a, writes its index and moves both
+// into place, returning their path without the extension,
+// pack-<checksum>. Like git, it syncs both files first. The index is moved
+// into place before the pack, so that a crash in between leaves only an
+// index without its pack, which load removes, and the temporary pack,
+// which recover finishes again. If finish fails, the pack is left for
+// recover.
+func (s *PackStore) finish(a *pack.Appender) (string, error) {
+	sum, entries, err := a.Finish()
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Join(s.dir, "pack-"+sum)
+	if err := s.writeIndex(name+".idx", entries, sum); err != nil {
+		return "", err
+	}
+	err = os.Chmod(a.Name(), 0o444)
+	if err == nil {
+		err = os.Rename(a.Name(), name+".pack")
+	}
+	if err != nil {
+		return "", store.Unavailable(err)
+	}
+	return name, nil
+}
+
+// writeIndex writes the index of a pack to path, by way of a temporary
+// file.
+func (s *PackStore) writeIndex(path string, entries []pack.IndexEntry, sum string) error {
+	f, err := os.CreateTemp(s.dir, tempIdxPattern)
+	if err != nil {
+		return store.Unavailable(err)
+	}
+	bw := bufio.NewWriter(f)
+	err = pack.WriteIndex(bw, entries, sum)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0o444)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return store.Unavailable(err)
+	}
+	return nil
+}
+
+// recover finishes the temporary packs of stores that were not closed,
+// keeping every entry up to the first one that was not completely
+// written, and removes temporary indexes, which are rewritten.
+func (s *PackStore) recover() error {
+	idxs, err := filepath.Glob(filepath.Join(s.dir, tempIdxPattern))
+	if err != nil {
+		return fmt.Errorf("list temporary indexes: %w", err)
+	}
+	for _, idx := range idxs {
+		os.Remove(idx)
+	}
+	temps, err := filepath.Glob(filepath.Join(s.dir, tempPattern))
+	if err != nil {
+		return fmt.Errorf("list temporary packs: %w", err)
+	}
+	for _, temp := range temps {
+		if err := s.recoverPack(temp); err != nil {
+			return fmt.Errorf("recover %s: %w", filepath.Base(temp), err)
+		}
+	}
+	return nil
+}
+
+func (s *PackStore) recoverPack(path string) error {
+	a, err := pack.RecoverAppender(path)
+	if err != nil {
+		return err
+	}
+	if a.Count() == 0 {
+		a.Abort()
+		return nil
+	}
+	// load opens it along with the rest
+	_, err = s.finish(a)
+	return err
+}
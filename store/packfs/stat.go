@@ -0,0 +1,31 @@
+package packfs
+
+import (
+	"context"
+	"fmt"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+// Stat reads only the entry headers along sha's delta chain, and answers
+// from memory for objects in the pending pack.
+func (s *PackStore) Stat(ctx context.Context, sha string) (object.ObjectType, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return "", 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if err := s.usable(); err != nil {
+		return "", 0, fmt.Errorf("stat %s: %w", sha, err)
+	}
+	if s.pending != nil {
+		if typ, size, ok := s.pending.Stat(sha); ok {
+			return typ, size, nil
+		}
+	}
+	if p := s.find(sha); p != nil {
+		return p.Stat(sha)
+	}
+	return "", 0, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
+}
@@ -0,0 +1,77 @@
+package packfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/pack"
+	"git.wyat.me/git-storage/store"
+)
+
+// Objects of at least bigObject bytes are streamed into a pack of their
+// own rather than read into memory to join the pending one.
+const bigObject = 1 << 20
+
+// PutStream compresses a large body into a temporary pack as it arrives,
+// without holding the store's lock, and finishes that pack once the SHA
+// is known. Smaller objects join the pending pack.
+func (s *PackStore) PutStream(ctx context.Context, typ object.ObjectType, size int64, r io.Reader) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if size < bigObject || s.opts.CheckStream(typ) {
+		return store.PutWhole(ctx, s, typ, size, r)
+	}
+	a, err := pack.NewAppender(s.dir, tempPattern)
+	if err != nil {
+		return "", fmt.Errorf("put stream: %w", err)
+	}
+	sha, err := a.AddStream(ctx, typ, size, r)
+	if err != nil {
+		a.Abort()
+		return "", fmt.Errorf("put stream: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err = s.usable(); err != nil || s.has(sha) {
+		a.Abort()
+		if err != nil {
+			return "", fmt.Errorf("put stream: %w", err)
+		}
+		return sha, nil
+	}
+	name, err := s.finish(a)
+	if err != nil {
+		return "", fmt.Errorf("put stream: %w", err)
+	}
+	if err := s.install(name); err != nil {
+		return "", fmt.Errorf("put stream: %w", err)
+	}
+	return sha, nil
+}
+
+// GetStream inflates whole objects from their pack as they are read, and
+// applies deltas in memory.
+func (s *PackStore) GetStream(ctx context.Context, sha string) (*object.Reader, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if err := s.usable(); err != nil {
+		return nil, fmt.Errorf("get %s: %w", sha, err)
+	}
+	if p := s.find(sha); p != nil {
+		return p.Open(sha)
+	}
+	obj, err := s.get(sha)
+	if err != nil {
+		return nil, err
+	}
+	body := io.NopCloser(bytes.NewReader(obj.Data))
+	return object.NewBodyReader(obj.Type, int64(len(obj.Data)), body), nil
+}
@@ -0,0 +1,151 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// AtomicReflogStore is implemented by backends that can apply ref updates
+// and append the reflog entries recording them in one transaction, so a
+// ref never moves without its entry being written.
+type AtomicReflogStore interface {
+	RefStore
+	ReflogStore
+	// UpdateRefsLogged is UpdateRefs that also appends, for each update,
+	// entry with the update's old and new SHA filled in.
+	UpdateRefsLogged(ctx context.Context, updates []RefUpdate, entry ReflogEntry) error
+}
+
+// Record returns e filled in with u's old and new SHA, reporting false if
+// u deletes a ref that did not exist and so changes nothing to record.
+func (e ReflogEntry) Record(u RefUpdate) (ReflogEntry, bool) {
+	e.OldSHA, e.NewSHA = u.OldSHA, u.NewSHA
+	return e, u.OldSHA != "" || u.NewSHA != ""
+}
+
+// LoggingRefStore is a RefStore that appends a reflog entry for every
+// successful update, attributed to Committer with the given Message.
+//
+// When refs and log are the same AtomicReflogStore, each update and its
+// entries are written in one transaction. Otherwise the entries are
+// appended after the update, and an append that fails is reported to
+// LogFailed rather than failing an update that has already gone through.
+type LoggingRefStore struct {
+	RefStore
+	log       ReflogStore
+	logged    AtomicReflogStore
+	committer string
+	message   string
+
+	// LogFailed, if set, is called when the entry for a ref that was
+	// updated could not be appended.
+	LogFailed func(name string, err error)
+}
+
+// NewLoggingRefStore wraps refs so that its updates are recorded in log.
+// refs and log are usually the same backend.
+func NewLoggingRefStore(refs RefStore, log ReflogStore, committer, message string) *LoggingRefStore {
+	s := &LoggingRefStore{RefStore: refs, log: log, committer: committer, message: message}
+	if ars, ok := refs.(AtomicReflogStore); ok && reflect.TypeOf(refs).Comparable() && any(refs) == any(log) {
+		s.logged = ars
+	}
+	return s
+}
+
+func (s *LoggingRefStore) UpdateRef(ctx context.Context, name, oldSHA, newSHA string) error {
+	if s.logged != nil {
+		err := s.logged.UpdateRefsLogged(ctx, []RefUpdate{{Name: name, OldSHA: oldSHA, NewSHA: newSHA}}, s.entry(time.Now()))
+		return singleError("update", name, err)
+	}
+	if err := s.RefStore.UpdateRef(ctx, name, oldSHA, newSHA); err != nil {
+		return err
+	}
+	s.append(ctx, time.Now(), RefUpdate{Name: name, OldSHA: oldSHA, NewSHA: newSHA})
+	return nil
+}
+
+func (s *LoggingRefStore) DeleteRef(ctx context.Context, name, oldSHA string) error {
+	if oldSHA == "" {
+		var err error
+		if oldSHA, err = s.currentSHA(ctx, name); err != nil {
+			return err
+		}
+	}
+	if s.logged != nil {
+		err := s.logged.UpdateRefsLogged(ctx, []RefUpdate{{Name: name, OldSHA: oldSHA}}, s.entry(time.Now()))
+		return singleError("delete", name, err)
+	}
+	if err := s.RefStore.DeleteRef(ctx, name, oldSHA); err != nil {
+		return err
+	}
+	s.append(ctx, time.Now(), RefUpdate{Name: name, OldSHA: oldSHA})
+	return nil
+}
+
+func (s *LoggingRefStore) UpdateRefs(ctx context.Context, updates []RefUpdate) error {
+	// pin unconditional deletes to the value we are about to log, so the
+	// entry cannot name a tip that was replaced in the meantime.
+	logged := make([]RefUpdate, len(updates))
+	for i, u := range updates {
+		if u.IsDelete() && u.OldSHA == "" {
+			sha, err := s.currentSHA(ctx, u.Name)
+			if err != nil {
+				return err
+			}
+			u.OldSHA = sha
+		}
+		logged[i] = u
+	}
+	now := time.Now()
+	if s.logged != nil {
+		return s.logged.UpdateRefsLogged(ctx, logged, s.entry(now))
+	}
+	if err := s.RefStore.UpdateRefs(ctx, logged); err != nil {
+		return err
+	}
+	for _, u := range logged {
+		s.append(ctx, now, u)
+	}
+	return nil
+}
+
+// currentSHA returns the value name holds, or an empty string if it does
+// not exist.
+func (s *LoggingRefStore) currentSHA(ctx context.Context, name string) (string, error) {
+	ref, err := s.RefStore.GetRef(ctx, name)
+	if errors.Is(err, ErrRefNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return ref.SHA, nil
+}
+
+func (s *LoggingRefStore) entry(now time.Time) ReflogEntry {
+	return ReflogEntry{Committer: s.committer, Time: now, Message: s.message}
+}
+
+// append records u, which has already been applied, in a separate write.
+func (s *LoggingRefStore) append(ctx context.Context, now time.Time, u RefUpdate) {
+	entry, ok := s.entry(now).Record(u)
+	if !ok {
+		return
+	}
+	if err := s.log.AppendReflog(ctx, u.Name, entry); err != nil && s.LogFailed != nil {
+		s.LogFailed(u.Name, fmt.Errorf("ref %s updated but not logged: %w", u.Name, err))
+	}
+}
+
+// singleError turns the *TransactionError from a one-ref transaction back
+// into the error UpdateRef or DeleteRef would have returned.
+func singleError(op, name string, err error) error {
+	var txErr *TransactionError
+	if errors.As(err, &txErr) && len(txErr.Rejected) == 1 {
+		return fmt.Errorf("%s ref %s: %w", op, name, txErr.Rejected[0].Err)
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MinAbbrev is the shortest abbreviated SHA Resolve accepts, matching
+// git's own minimum.
+const MinAbbrev = 4
+
+// maxCandidates bounds how many matches an AmbiguousError lists.
+const maxCandidates = 10
+
+var (
+	// ErrAmbiguous is returned when an abbreviated SHA matches more than
+	// one object.
+	ErrAmbiguous = errors.New("ambiguous short SHA")
+	// ErrInvalidAbbrev is returned for prefixes that are too short or are
+	// not hex.
+	ErrInvalidAbbrev = errors.New("invalid short SHA")
+)
+
+// AmbiguousError lists the objects an abbreviated SHA could mean. It
+// matches ErrAmbiguous with errors.Is.
+type AmbiguousError struct {
+	Prefix string
+	// Candidates holds the first matches in SHA order, at most
+	// maxCandidates of them.
+	Candidates []string
+	// More is set when there were further matches beyond Candidates.
+	More bool
+}
+
+func (e *AmbiguousError) Error() string {
+	msg := fmt.Sprintf("short SHA %s is ambiguous: %s", e.Prefix, strings.Join(e.Candidates, ", "))
+	if e.More {
+		msg += ", ..."
+	}
+	return msg
+}
+
+func (e *AmbiguousError) Unwrap() error {
+	return ErrAmbiguous
+}
+
+// Resolve expands an abbreviated SHA to the one object in s it names,
+// using the backend's prefix scan. It fails with ErrNotFound if nothing
+// matches and with an *AmbiguousError if several objects do.
+func Resolve(ctx context.Context, s MutableStore, prefix string) (string, error) {
+	prefix = strings.ToLower(prefix)
+	if len(prefix) < MinAbbrev || strings.Trim(prefix, "0123456789abcdef") != "" {
+		return "", fmt.Errorf("%w: %q", ErrInvalidAbbrev, prefix)
+	}
+
+	var matches []string
+	more := false
+	err := s.Iterate(ctx, prefix, func(sha string) error {
+		if len(matches) == maxCandidates {
+			more = true
+			return ErrStopIteration
+		}
+		matches = append(matches, sha)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("object %s: %w", prefix, ErrNotFound)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", &AmbiguousError{Prefix: prefix, Candidates: matches, More: more}
+	}
+}
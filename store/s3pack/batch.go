@@ -0,0 +1,89 @@
+package s3pack
+
+import (
+	"context"
+	"fmt"
+
+	"git.wyat.me/git-storage/object"
+)
+
+// PutMany appends objs to the pending pack under a single hold of the
+// store's lock, sealing it as often as it fills, and then uploads the
+// packs it sealed.
+func (s *S3PackStore) PutMany(ctx context.Context, objs []*object.Object) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	shas := make([]string, len(objs))
+	for i, obj := range objs {
+		if err := s.opts.Check(obj); err != nil {
+			return nil, err
+		}
+		sha, err := s.name(obj)
+		if err != nil {
+			return nil, err
+		}
+		shas[i] = sha
+	}
+	s.mu.Lock()
+	if err := s.usable(); err != nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("put: %w", err)
+	}
+	var full []*sealed
+	var err error
+	for i, obj := range objs {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		var sp *sealed
+		if sp, err = s.add(ctx, shas[i], obj); err != nil {
+			break
+		}
+		if sp != nil {
+			full = append(full, sp)
+		}
+	}
+	s.mu.Unlock()
+
+	// packs sealed before a failure are uploaded all the same
+	for _, sp := range full {
+		if uerr := s.upload(ctx, sp); err == nil {
+			err = uerr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return shas, nil
+}
+
+// GetMany reads shas one at a time, as each may be in a different pack.
+func (s *S3PackStore) GetMany(ctx context.Context, shas []string) ([]*object.Object, error) {
+	objs := make([]*object.Object, len(shas))
+	for i, sha := range shas {
+		obj, err := s.Get(ctx, sha)
+		if err != nil {
+			return nil, err
+		}
+		objs[i] = obj
+	}
+	return objs, nil
+}
+
+// ExistsMany looks shas up in the indexes, which are already in memory.
+func (s *S3PackStore) ExistsMany(ctx context.Context, shas []string) ([]bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if err := s.usable(); err != nil {
+		return nil, fmt.Errorf("exists: %w", err)
+	}
+	exists := make([]bool, len(shas))
+	for i, sha := range shas {
+		exists[i] = s.has(sha)
+	}
+	return exists, nil
+}
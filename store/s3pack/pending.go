@@ -0,0 +1,162 @@
+package s3pack
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"git.wyat.me/git-storage/pack"
+	"git.wyat.me/git-storage/store"
+	"github.com/minio/minio-go/v7"
+)
+
+// A pending pack is uploaded once it holds maxPackObjects objects or
+// maxPackSize bytes. Each upload is a handful of requests however many
+// objects the pack holds, which is what makes this store faster to write
+// to than one with a key per object.
+const (
+	maxPackObjects = 10000
+	maxPackSize    = 64 << 20
+)
+
+// Packs are built, and indexes written, under temporary names in the
+// cache directory.
+const (
+	tempPattern    = "tmp_pack_*"
+	tempIdxPattern = "tmp_idx_*"
+)
+
+// sealed is a pack that has been sealed in the cache directory but may
+// not be in the bucket yet. idx is its index, which is uploaded after it.
+type sealed struct {
+	p   *remotePack
+	idx []byte
+}
+
+// seal seals the temporary pack a and writes its index. The pack is read
+// from the cache directory until it has been uploaded.
+func seal(a *pack.Appender) (*sealed, error) {
+	sum, entries, err := a.Finish()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := pack.WriteIndex(&buf, entries, sum); err != nil {
+		return nil, err
+	}
+	idx, err := pack.ParseIndex(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	// the trailing checksum follows the entries
+	p := &remotePack{name: "pack-" + sum, idx: idx, size: a.Size() + 20, idxSize: int64(buf.Len()), local: a.Name()}
+	return &sealed{p: p, idx: buf.Bytes()}, nil
+}
+
+// sealPending seals the pending pack and lists it with the others, so its
+// objects stay readable while it is uploaded. If sealing fails, the pack
+// is reopened to be appended to, so its objects can still be read. The
+// caller must hold s.mu, and must pass the sealed pack to upload once it
+// has released it.
+func (s *S3PackStore) sealPending() (*sealed, error) {
+	sp, err := seal(s.pending)
+	if err != nil {
+		if a, rerr := pack.RecoverAppender(s.pending.Name()); rerr == nil {
+			s.pending = a
+		}
+		return nil, fmt.Errorf("finish pack: %w", err)
+	}
+	s.pending = nil
+	s.packs = append([]*remotePack{sp.p}, s.packs...)
+	s.uploads.Add(1)
+	return sp, nil
+}
+
+// upload sends a pack sealed by sealPending to the bucket without holding
+// s.mu, which it takes again only to switch the pack to being read from
+// the bucket. If the upload fails, the pack stays in the cache directory,
+// where it can still be read, and Close tries again.
+func (s *S3PackStore) upload(ctx context.Context, sp *sealed) error {
+	defer s.uploads.Done()
+	if err := s.send(ctx, sp); err != nil {
+		s.mu.Lock()
+		s.failed = append(s.failed, sp)
+		s.mu.Unlock()
+		return fmt.Errorf("finish pack: %w", err)
+	}
+	remote := *sp.p
+	remote.local = ""
+	s.mu.Lock()
+	if i := slices.Index(s.packs, sp.p); i >= 0 {
+		s.packs[i] = &remote
+	}
+	s.mu.Unlock()
+	// a read that had already found the pack falls back to the bucket
+	// once the file is gone
+	os.Remove(sp.p.local)
+	return nil
+}
+
+// send uploads a sealed pack, then its index, which it also caches. The
+// index is uploaded last, so that a pack is never listed until it is
+// complete. The temporary pack is left in place; if send fails, recover
+// uploads it when the cache directory is next opened.
+func (s *S3PackStore) send(ctx context.Context, sp *sealed) error {
+	name := sp.p.name
+	_, err := s.client.FPutObject(ctx, s.bucket, packPrefix+name+".pack", sp.p.local, minio.PutObjectOptions{
+		ContentType: "application/x-git-packed-objects",
+	})
+	if err != nil {
+		return fmt.Errorf("upload pack: %w", store.Unavailable(err))
+	}
+	_, err = s.client.PutObject(ctx, s.bucket, packPrefix+name+".idx", bytes.NewReader(sp.idx), int64(len(sp.idx)), minio.PutObjectOptions{
+		ContentType: "application/x-git-packed-objects-toc",
+	})
+	if err != nil {
+		return fmt.Errorf("upload index: %w", store.Unavailable(err))
+	}
+	if err := s.writeCache(filepath.Join(s.cache, name+".idx"), sp.idx); err != nil {
+		return fmt.Errorf("cache index: %w", store.Unavailable(err))
+	}
+	return nil
+}
+
+// recover uploads the temporary packs of stores that were not closed,
+// keeping every entry up to the first one that was not completely
+// written, and removes temporary indexes. load then finds the uploaded
+// packs along with the rest.
+func (s *S3PackStore) recover(ctx context.Context) error {
+	idxs, err := filepath.Glob(filepath.Join(s.cache, tempIdxPattern))
+	if err != nil {
+		return fmt.Errorf("list temporary indexes: %w", err)
+	}
+	for _, idx := range idxs {
+		os.Remove(idx)
+	}
+	temps, err := filepath.Glob(filepath.Join(s.cache, tempPattern))
+	if err != nil {
+		return fmt.Errorf("list temporary packs: %w", err)
+	}
+	for _, temp := range temps {
+		a, err := pack.RecoverAppender(temp)
+		if err != nil {
+			return fmt.Errorf("recover %s: %w", filepath.Base(temp), err)
+		}
+		if a.Count() == 0 {
+			a.Abort()
+			continue
+		}
+		sp, err := seal(a)
+		if err == nil {
+			err = s.send(ctx, sp)
+		}
+		if err != nil {
+			return fmt.Errorf("recover %s: %w", filepath.Base(temp), err)
+		}
+		os.Remove(temp)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package s3pack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"git.wyat.me/git-storage/store"
+	"github.com/minio/minio-go/v7"
+)
+
+// rangeReader reads a pack in the bucket with a ranged GET for each
+// ReadAt, so that a Packfile over it fetches only the entries it needs.
+type rangeReader struct {
+	ctx    context.Context
+	client *minio.Client
+	bucket string
+	key    string
+}
+
+// ReadAt reports a read that runs past the end of the pack as io.EOF,
+// which the Packfile takes to mean the pack does not match its index.
+func (r *rangeReader) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetRange(off, off+int64(len(p))-1); err != nil {
+		return 0, err
+	}
+	obj, err := r.client.GetObject(r.ctx, r.bucket, r.key, opts)
+	if err != nil {
+		return 0, fmt.Errorf("get %s: %w", r.key, store.Unavailable(err))
+	}
+	defer obj.Close()
+	n, err := io.ReadFull(obj, p)
+	switch {
+	case err == nil:
+		return n, nil
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		minio.ToErrorResponse(err).Code == "InvalidRange":
+		return n, io.EOF
+	}
+	return n, fmt.Errorf("get %s: %w", r.key, store.Unavailable(err))
+}
+
+// localReader reads a pack from the cache directory while it is being
+// uploaded. Once the upload is done and the file removed, it reads from
+// remote instead.
+type localReader struct {
+	path   string
+	remote io.ReaderAt
+}
+
+func (r *localReader) ReadAt(p []byte, off int64) (int, error) {
+	f, err := os.Open(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r.remote.ReadAt(p, off)
+	}
+	if err != nil {
+		return 0, store.Unavailable(err)
+	}
+	defer f.Close()
+	n, err := f.ReadAt(p, off)
+	if err != nil && err != io.EOF {
+		err = store.Unavailable(err)
+	}
+	return n, err
+}
@@ -0,0 +1,424 @@
+// Package s3pack stores objects in S3 as git packfiles, each uploaded
+// whole with its .idx, rather than as one key per object as the minio
+// package does. Writes are batched into a local pack that is uploaded once
+// full, indexes are cached on local disk and held in memory so that Exists
+// costs no request at all, and objects are read with ranged GETs of just
+// their entries.
+package s3pack
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/pack"
+	"git.wyat.me/git-storage/store"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Packs are kept under the keys git would give them in a bare repository.
+const packPrefix = "objects/pack/"
+
+// S3PackStore keeps objects in packs in an S3 bucket. New objects are
+// appended to a pack in the cache directory, which is uploaded with its
+// index once it is large enough or the store is closed; until then the
+// objects live only on local disk, and a store reopened on the same cache
+// directory uploads whatever a store that was not closed left behind.
+// Uploads are made without holding the store's lock, so reads and writes
+// carry on while a full pack is sent. Only one S3PackStore may have a
+// cache directory open at a time, but any number may read the same bucket.
+type S3PackStore struct {
+	client *minio.Client
+	bucket string
+	cache  string
+	opts   store.Options
+
+	mu sync.RWMutex
+	// packs is newest first, as new objects are the likeliest to be read.
+	packs []*remotePack
+	// pending is the pack new objects are appended to.
+	pending *pack.Appender
+	// failed holds sealed packs whose upload failed, for Close to retry.
+	failed  []*sealed
+	uploads sync.WaitGroup
+	closed  bool
+}
+
+// remotePack is a pack in the bucket, whose index is in memory. name is
+// pack-<checksum>, as for git. A pack that is still being uploaded has
+// local set to its file in the cache directory, which it is read from.
+type remotePack struct {
+	name    string
+	idx     *pack.Index
+	size    int64
+	idxSize int64
+	local   string
+}
+
+var errClosed = errors.New("s3 pack store closed")
+
+// New opens the packs in bucket, creating the bucket if need be, and
+// caches their indexes in cacheDir. git reads packs only as zlib and
+// SHA-1, so any other codec or format is refused.
+func New(endpoint, accessKey, secretKey, bucket string, useSSL bool, cacheDir string, opts ...store.Option) (*S3PackStore, error) {
+	o := store.NewOptions(opts...)
+	if o.Codec != nil && o.Codec != object.Zlib {
+		return nil, fmt.Errorf("git reads packs only as zlib, not %s", o.Codec.Name())
+	}
+	if o.Format != object.SHA1 {
+		return nil, fmt.Errorf("packs are only written in sha1, not %s", o.Format)
+	}
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: useSSL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create minio client: %w", err)
+	}
+
+	ctx := context.Background()
+	exists, err := client.BucketExists(ctx, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("check bucket: %w", err)
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}); err != nil {
+			return nil, fmt.Errorf("create bucket: %w", err)
+		}
+	}
+	if err := os.MkdirAll(cacheDir, 0o777); err != nil {
+		return nil, fmt.Errorf("create cache directory: %w", err)
+	}
+
+	s := &S3PackStore{client: client, bucket: bucket, cache: cacheDir, opts: o}
+	if err := s.recover(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.load(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load lists the packs in the bucket, newest first, and reads their
+// indexes from the cache, downloading those it does not have yet. A pack
+// is only listed once its index has been uploaded after it, so a pack
+// without one is skipped. Cached indexes of packs that have gone from the
+// bucket are removed.
+func (s *S3PackStore) load(ctx context.Context) error {
+	packs := make(map[string]minio.ObjectInfo)
+	idxs := make(map[string]minio.ObjectInfo)
+	for info := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: packPrefix}) {
+		if info.Err != nil {
+			return fmt.Errorf("list packs: %w", store.Unavailable(info.Err))
+		}
+		name, ok := strings.CutPrefix(info.Key, packPrefix+"pack-")
+		if !ok {
+			continue
+		}
+		if sum, ok := strings.CutSuffix(name, ".pack"); ok {
+			packs[sum] = info
+		} else if sum, ok := strings.CutSuffix(name, ".idx"); ok {
+			idxs[sum] = info
+		}
+	}
+	var loaded []*remotePack
+	mtimes := make(map[*remotePack]time.Time)
+	for sum, idxInfo := range idxs {
+		info, ok := packs[sum]
+		if !ok {
+			continue
+		}
+		idx, err := s.index(ctx, sum)
+		if err != nil {
+			return err
+		}
+		p := &remotePack{name: "pack-" + sum, idx: idx, size: info.Size, idxSize: idxInfo.Size}
+		loaded = append(loaded, p)
+		mtimes[p] = info.LastModified
+	}
+	slices.SortFunc(loaded, func(a, b *remotePack) int {
+		return mtimes[b].Compare(mtimes[a])
+	})
+	s.packs = loaded
+
+	cached, err := filepath.Glob(filepath.Join(s.cache, "pack-*.idx"))
+	if err != nil {
+		return fmt.Errorf("list cached indexes: %w", err)
+	}
+	for _, path := range cached {
+		sum := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "pack-"), ".idx")
+		if _, ok := packs[sum]; !ok {
+			os.Remove(path)
+		}
+	}
+	return nil
+}
+
+// index returns the index of the pack named sum, from the cache if it is
+// there.
+func (s *S3PackStore) index(ctx context.Context, sum string) (*pack.Index, error) {
+	path := filepath.Join(s.cache, "pack-"+sum+".idx")
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if data, err = s.download(ctx, packPrefix+"pack-"+sum+".idx"); err != nil {
+			return nil, err
+		}
+		err = s.writeCache(path, data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read index: %w", err)
+	}
+	idx, err := pack.ParseIndex(data)
+	if err != nil {
+		return nil, fmt.Errorf("pack-%s.idx: %w", sum, err)
+	}
+	if idx.PackChecksum() != sum {
+		return nil, fmt.Errorf("pack-%s.idx: %w: index is of pack %s", sum, store.ErrCorrupt, idx.PackChecksum())
+	}
+	return idx, nil
+}
+
+// download reads the whole of key.
+func (s *S3PackStore) download(ctx context.Context, key string) ([]byte, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get %s: %w", key, store.Unavailable(err))
+	}
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return nil, fmt.Errorf("get %s: %w", key, store.Unavailable(err))
+	}
+	return data, nil
+}
+
+// writeCache writes data to path in the cache, by way of a temporary
+// file, so that a crash never leaves part of an index there.
+func (s *S3PackStore) writeCache(path string, data []byte) error {
+	f, err := os.CreateTemp(s.cache, tempIdxPattern)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// usable fails once the store is closed. The caller must hold s.mu.
+func (s *S3PackStore) usable() error {
+	if s.closed {
+		return store.Unavailable(errClosed)
+	}
+	return nil
+}
+
+// find returns the pack holding sha, or nil if no uploaded pack does.
+func (s *S3PackStore) find(sha string) *remotePack {
+	for _, p := range s.packs {
+		if _, ok := p.idx.Find(sha); ok {
+			return p
+		}
+	}
+	return nil
+}
+
+// has reports whether sha is in an uploaded pack or the pending one.
+func (s *S3PackStore) has(sha string) bool {
+	if s.pending != nil && s.pending.Has(sha) {
+		return true
+	}
+	return s.find(sha) != nil
+}
+
+// open returns a Packfile reading p with ranged GETs made with ctx, or
+// from the cache directory while p is being uploaded.
+func (s *S3PackStore) open(ctx context.Context, p *remotePack) *pack.Packfile {
+	var r io.ReaderAt = &rangeReader{ctx: ctx, client: s.client, bucket: s.bucket, key: packPrefix + p.name + ".pack"}
+	if p.local != "" {
+		r = &localReader{path: p.local, remote: r}
+	}
+	return pack.NewPackfile(r, p.idx, p.size)
+}
+
+// name hashes obj as Serialize would, failing if it is half of a SHA-1
+// collision, without compressing it, since the pack's Appender does that.
+func (s *S3PackStore) name(obj *object.Object) (string, error) {
+	w := s.opts.Format.NewCodecWriter(io.Discard, object.None, obj.Type, int64(len(obj.Data)))
+	if _, err := w.Write(obj.Data); err != nil {
+		return "", fmt.Errorf("hash: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("hash: %w", err)
+	}
+	return w.SHA(), nil
+}
+
+func (s *S3PackStore) Put(ctx context.Context, obj *object.Object) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if err := s.opts.Check(obj); err != nil {
+		return "", err
+	}
+	sha, err := s.name(obj)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	if err := s.usable(); err != nil {
+		s.mu.Unlock()
+		return "", fmt.Errorf("put: %w", err)
+	}
+	sp, err := s.add(ctx, sha, obj)
+	s.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	if sp != nil {
+		if err := s.upload(ctx, sp); err != nil {
+			return "", err
+		}
+	}
+	return sha, nil
+}
+
+// add appends obj to the pending pack unless it is already stored. If that
+// fills the pending pack, add seals it and returns it, and the caller must
+// pass it to upload once it has released s.mu. The caller must hold s.mu.
+func (s *S3PackStore) add(ctx context.Context, sha string, obj *object.Object) (*sealed, error) {
+	if s.has(sha) {
+		return nil, nil
+	}
+	if s.pending == nil {
+		a, err := pack.NewAppender(s.cache, tempPattern)
+		if err != nil {
+			return nil, fmt.Errorf("put: %w", err)
+		}
+		s.pending = a
+	}
+	if err := s.pending.Add(ctx, sha, obj.Type, int64(len(obj.Data)), bytes.NewReader(obj.Data)); err != nil {
+		return nil, fmt.Errorf("put: %w", err)
+	}
+	if s.pending.Count() < maxPackObjects && s.pending.Size() < maxPackSize {
+		return nil, nil
+	}
+	return s.sealPending()
+}
+
+// Get reads sha from the pending pack, or with one ranged GET for each
+// entry along its delta chain, made without holding the store's lock.
+func (s *S3PackStore) Get(ctx context.Context, sha string) (*object.Object, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	if err := s.usable(); err != nil {
+		s.mu.RUnlock()
+		return nil, fmt.Errorf("get %s: %w", sha, err)
+	}
+	if s.pending != nil && s.pending.Has(sha) {
+		defer s.mu.RUnlock()
+		return s.pending.Get(sha)
+	}
+	p := s.find(sha)
+	s.mu.RUnlock()
+	if p == nil {
+		return nil, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
+	}
+	return s.open(ctx, p).Get(sha)
+}
+
+// Exists is answered from the indexes in memory.
+func (s *S3PackStore) Exists(ctx context.Context, sha string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if err := s.usable(); err != nil {
+		return false, fmt.Errorf("exists: %w", err)
+	}
+	return s.has(sha), nil
+}
+
+// Close uploads the pending pack, tries again to upload any pack whose
+// upload failed, and waits for uploads in progress. A pack that still
+// cannot be uploaded is left in the cache directory for the next store
+// opened on it to upload.
+func (s *S3PackStore) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	var errs []error
+	retry := s.failed
+	s.failed = nil
+	s.uploads.Add(len(retry))
+	if s.pending != nil {
+		sp, err := s.sealPending()
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			retry = append(retry, sp)
+		}
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	for _, sp := range retry {
+		errs = append(errs, s.upload(context.Background(), sp))
+	}
+	s.uploads.Wait()
+	return errors.Join(errs...)
+}
+
+// Flush removes every pack from the bucket and every index from the
+// cache. Used after benchmarks to avoid leaving test data in the bucket.
+func (s *S3PackStore) Flush(ctx context.Context) error {
+	// a pack still being uploaded would land after the bucket was emptied
+	s.uploads.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	objectsCh := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objectsCh)
+		for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: packPrefix}) {
+			if obj.Err != nil {
+				return
+			}
+			objectsCh <- obj
+		}
+	}()
+
+	for result := range s.client.RemoveObjects(ctx, s.bucket, objectsCh, minio.RemoveObjectsOptions{}) {
+		if result.Err != nil {
+			return fmt.Errorf("remove object %s: %w", result.ObjectName, result.Err)
+		}
+	}
+	for _, p := range s.packs {
+		os.Remove(filepath.Join(s.cache, p.name+".idx"))
+	}
+	s.packs = nil
+	return nil
+}
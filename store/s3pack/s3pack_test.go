@@ -0,0 +1,372 @@
+package s3pack
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+	"git.wyat.me/git-storage/store/storetest"
+	"github.com/minio/minio-go/v7"
+)
+
+func TestPutAndGet(t *testing.T) {
+	cache := t.TempDir()
+	s := newTestStore(t, cache)
+
+	obj := &object.Object{
+		Type: object.TypeBlob,
+		Data: []byte("hello\n"),
+	}
+
+	sha, err := s.Put(t.Context(), obj)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	const expectedSHA = "ce013625030ba8dba906f756967f9e9ca394464a"
+	if sha != expectedSHA {
+		t.Errorf("SHA mismatch: got %s, want %s", sha, expectedSHA)
+	}
+
+	// readable from the pending pack, then with ranged reads of the
+	// uploaded one, whose index is cached or, with a new cache, downloaded
+	for _, when := range []string{"before Close", "after reopening", "with an empty cache"} {
+		got, err := s.Get(t.Context(), sha)
+		if err != nil {
+			t.Fatalf("Get %s failed: %v", when, err)
+		}
+		if got.Type != obj.Type || string(got.Data) != string(obj.Data) {
+			t.Errorf("Get %s: got %s %q, want %s %q", when, got.Type, got.Data, obj.Type, obj.Data)
+		}
+		if exists, err := s.Exists(t.Context(), sha); err != nil || !exists {
+			t.Errorf("Exists %s = %v, %v, want true", when, exists, err)
+		}
+		if typ, size, err := s.Stat(t.Context(), sha); err != nil || typ != obj.Type || size != int64(len(obj.Data)) {
+			t.Errorf("Stat %s = %s, %d, %v", when, typ, size, err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		if when == "after reopening" {
+			cache = t.TempDir()
+		}
+		s = openTestStore(t, cache)
+	}
+	defer s.Close()
+
+	var keys []string
+	for info := range s.client.ListObjects(t.Context(), s.bucket, minio.ListObjectsOptions{Prefix: packPrefix}) {
+		keys = append(keys, info.Key)
+	}
+	if len(keys) != 2 {
+		t.Errorf("got keys %v, want one pack and its index", keys)
+	}
+	if idxs, _ := filepath.Glob(filepath.Join(cache, "pack-*.idx")); len(idxs) != 1 {
+		t.Errorf("got cached indexes %v, want one", idxs)
+	}
+	if temps, _ := filepath.Glob(filepath.Join(cache, "tmp_*")); len(temps) > 0 {
+		t.Errorf("temporary files left behind: %v", temps)
+	}
+}
+
+func TestDuplicatePut(t *testing.T) {
+	cache := t.TempDir()
+	s := newTestStore(t, cache)
+
+	obj := &object.Object{
+		Type: object.TypeBlob,
+		Data: []byte("hello\n"),
+	}
+
+	sha1, err := s.Put(t.Context(), obj)
+	if err != nil {
+		t.Fatalf("first Put failed: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	s = openTestStore(t, cache)
+	defer s.Close()
+
+	// already in an uploaded pack, so not written again
+	sha2, err := s.Put(t.Context(), obj)
+	if err != nil {
+		t.Fatalf("second Put failed: %v", err)
+	}
+	if sha1 != sha2 {
+		t.Errorf("duplicate Put returned different SHAs: %s vs %s", sha1, sha2)
+	}
+	if s.pending != nil {
+		t.Error("duplicate Put appended to a new pack")
+	}
+}
+
+func TestRefused(t *testing.T) {
+	if _, err := New("localhost:1", "", "", "unused", false, t.TempDir(), store.WithCodec(object.Zstd)); err == nil {
+		t.Error("New accepted a codec git cannot read")
+	}
+	if _, err := New("localhost:1", "", "", "unused", false, t.TempDir(), store.WithFormat(object.SHA256)); err == nil {
+		t.Error("New accepted a format packs are not written in")
+	}
+}
+
+// TestRecover checks that the objects a store wrote before it stopped
+// without being closed are uploaded when its cache is opened again, up to
+// an entry it was part way through writing.
+func TestRecover(t *testing.T) {
+	cache := t.TempDir()
+	s := newTestStore(t, cache)
+	var shas []string
+	for i := range 3 {
+		sha, err := s.Put(t.Context(), &object.Object{Type: object.TypeBlob, Data: fmt.Appendf(nil, "recover %d\n", i)})
+		if err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		shas = append(shas, sha)
+	}
+	// the start of an entry that was never finished
+	f, err := os.OpenFile(s.pending.Name(), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("open pending pack: %v", err)
+	}
+	if _, err := f.Write([]byte{0xb5, 0x01, 0x78}); err != nil {
+		t.Fatalf("write partial entry: %v", err)
+	}
+	f.Close()
+
+	s = openTestStore(t, cache)
+	defer s.Close()
+	if s.pending != nil || len(s.packs) != 1 {
+		t.Fatalf("recovered into %d packs, want the one uploaded", len(s.packs))
+	}
+	for _, sha := range shas {
+		if _, err := s.Get(t.Context(), sha); err != nil {
+			t.Errorf("Get %s after recovery failed: %v", sha, err)
+		}
+	}
+	if temps, _ := filepath.Glob(filepath.Join(cache, "tmp_*")); len(temps) > 0 {
+		t.Errorf("temporary files left behind: %v", temps)
+	}
+}
+
+// TestFailedUpload checks that a pack whose upload fails is still read
+// from the cache directory, and is uploaded when the store is closed.
+func TestFailedUpload(t *testing.T) {
+	s3 := storetest.NewS3(t)
+	s, err := New(s3.Endpoint(), "minioadmin", "minioadmin", "test-git-packs", false, t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	s3.FailWhen(func(r *http.Request) bool {
+		return r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, ".pack")
+	})
+	// large enough to be streamed into a pack of its own
+	data := bytes.Repeat([]byte("uploaded late\n"), bigObject/8)
+	sha := object.Hash(&object.Object{Type: object.TypeBlob, Data: data})
+	if _, err := s.PutStream(t.Context(), object.TypeBlob, int64(len(data)), bytes.NewReader(data)); err == nil {
+		t.Fatal("PutStream succeeded although the upload failed")
+	}
+	if obj, err := s.Get(t.Context(), sha); err != nil || !bytes.Equal(obj.Data, data) {
+		t.Fatalf("Get before the upload: got %v", err)
+	}
+
+	s3.FailWhen(nil)
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	s, err = New(s3.Endpoint(), "minioadmin", "minioadmin", "test-git-packs", false, t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	if obj, err := s.Get(t.Context(), sha); err != nil || !bytes.Equal(obj.Data, data) {
+		t.Errorf("Get after Close uploaded the pack: got %v", err)
+	}
+}
+
+// TestDamagedPack checks that ranged reads of a pack that no longer
+// matches its index report ErrCorrupt.
+func TestDamagedPack(t *testing.T) {
+	cache := t.TempDir()
+	s := newTestStore(t, cache)
+	obj := &object.Object{Type: object.TypeBlob, Data: []byte("hello\n")}
+	sha, err := s.Put(t.Context(), obj)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	s = openTestStore(t, cache)
+	defer s.Close()
+
+	key := packPrefix + s.packs[0].name + ".pack"
+	data, err := s.download(t.Context(), key)
+	if err != nil {
+		t.Fatalf("download pack: %v", err)
+	}
+	upload := func(data []byte) {
+		t.Helper()
+		_, err := s.client.PutObject(t.Context(), s.bucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+		if err != nil {
+			t.Fatalf("upload pack: %v", err)
+		}
+	}
+
+	// the entry's header is one byte, followed by its zlib header
+	offset, _ := s.packs[0].idx.Find(sha)
+	damaged := bytes.Clone(data)
+	damaged[offset+1] ^= 0xff
+	upload(damaged)
+	if _, err := s.Get(t.Context(), sha); !errors.Is(err, store.ErrCorrupt) {
+		t.Errorf("Get from a damaged pack: got %v, want ErrCorrupt", err)
+	}
+
+	upload(data[:offset])
+	if _, err := s.Get(t.Context(), sha); !errors.Is(err, store.ErrCorrupt) {
+		t.Errorf("Get from a truncated pack: got %v, want ErrCorrupt", err)
+	}
+}
+
+// TestGit checks that git reads the packs the store uploads.
+func TestGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	s := newTestStore(t, t.TempDir())
+	blob := &object.Object{Type: object.TypeBlob, Data: []byte("written by the store\n")}
+	sha, err := s.Put(t.Context(), blob)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	large := bytes.Repeat([]byte("streamed by the store\n"), 1<<16)
+	streamed, err := s.PutStream(t.Context(), object.TypeBlob, int64(len(large)), bytes.NewReader(large))
+	if err != nil {
+		t.Fatalf("PutStream failed: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		out, err := exec.Command("git", append([]string{"--git-dir", dir}, args...)...).Output()
+		if err != nil {
+			t.Fatalf("git %s: %v", strings.Join(args, " "), err)
+		}
+		return string(out)
+	}
+	git("init", "--bare", "--quiet")
+	for info := range s.client.ListObjects(t.Context(), s.bucket, minio.ListObjectsOptions{Prefix: packPrefix}) {
+		if err := s.client.FGetObject(t.Context(), s.bucket, info.Key, filepath.Join(dir, info.Key), minio.GetObjectOptions{}); err != nil {
+			t.Fatalf("download %s: %v", info.Key, err)
+		}
+	}
+	idxs, _ := filepath.Glob(filepath.Join(dir, "objects", "pack", "pack-*.idx"))
+	if len(idxs) != 2 {
+		t.Fatalf("got indexes %v, want one for each pack", idxs)
+	}
+	for _, idx := range idxs {
+		git("verify-pack", idx)
+	}
+	if got := git("cat-file", "-p", sha); got != string(blob.Data) {
+		t.Errorf("git cat-file %s = %q, want %q", sha, got, blob.Data)
+	}
+	if got := git("cat-file", "-p", streamed); got != string(large) {
+		t.Errorf("git cat-file %s: streamed object did not round-trip", streamed)
+	}
+}
+
+// newTestStore opens a store on an emptied bucket, caching indexes in
+// cache.
+func newTestStore(t *testing.T, cache string, opts ...store.Option) *S3PackStore {
+	t.Helper()
+	s := openTestStore(t, cache, opts...)
+	if err := s.Flush(t.Context()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	return s
+}
+
+// openTestStore opens a store on the bucket as it is.
+func openTestStore(t *testing.T, cache string, opts ...store.Option) *S3PackStore {
+	t.Helper()
+
+	s, err := New(
+		storetest.S3Endpoint(),
+		"minioadmin",
+		"minioadmin",
+		"test-git-packs",
+		false,
+		cache,
+		opts...,
+	)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return s
+}
+
+func TestCancelledContext(t *testing.T) {
+	s := newTestStore(t, t.TempDir())
+	defer s.Close()
+	storetest.Cancelled(t, s)
+}
+
+func TestErrors(t *testing.T) {
+	s := newTestStore(t, t.TempDir())
+	defer s.Close()
+
+	closed := openTestStore(t, t.TempDir())
+	closed.Close()
+
+	storetest.Errors(t, storetest.Faults{
+		Store: s,
+		Corrupt: func(sha string) {
+			// the entry's header is one byte, followed by its zlib header
+			offset, _ := s.pending.Find(sha)
+			f, err := os.OpenFile(s.pending.Name(), os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatalf("corrupt %s: %v", sha, err)
+			}
+			defer f.Close()
+			if _, err := f.WriteAt([]byte{0xff, 0xff}, offset+1); err != nil {
+				t.Fatalf("corrupt %s: %v", sha, err)
+			}
+		},
+		Unavailable: closed,
+	})
+}
+
+func TestBatch(t *testing.T) {
+	s := newTestStore(t, t.TempDir())
+	defer s.Close()
+	storetest.Batch(t, s)
+}
+
+func TestStream(t *testing.T) {
+	s := newTestStore(t, t.TempDir())
+	defer s.Close()
+	storetest.Stream(t, s)
+}
+
+func TestStat(t *testing.T) {
+	s := newTestStore(t, t.TempDir())
+	defer s.Close()
+	storetest.Stat(t, s)
+}
+
+func TestValidation(t *testing.T) {
+	s := newTestStore(t, t.TempDir(), store.WithValidation())
+	defer s.Close()
+	storetest.Validation(t, s)
+}
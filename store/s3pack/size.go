@@ -0,0 +1,27 @@
+package s3pack
+
+import (
+	"context"
+	"fmt"
+)
+
+// StoredSize adds up the sizes of the packs in the bucket, their indexes
+// and the pending pack.
+func (s *S3PackStore) StoredSize(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if err := s.usable(); err != nil {
+		return 0, fmt.Errorf("stored size: %w", err)
+	}
+	var size int64
+	for _, p := range s.packs {
+		size += p.size + p.idxSize
+	}
+	if s.pending != nil {
+		size += s.pending.Size()
+	}
+	return size, nil
+}
@@ -0,0 +1,34 @@
+package s3pack
+
+import (
+	"context"
+	"fmt"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+// Stat answers from memory for objects in the pending pack, and otherwise
+// reads only the entry headers along sha's delta chain.
+func (s *S3PackStore) Stat(ctx context.Context, sha string) (object.ObjectType, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return "", 0, err
+	}
+	s.mu.RLock()
+	if err := s.usable(); err != nil {
+		s.mu.RUnlock()
+		return "", 0, fmt.Errorf("stat %s: %w", sha, err)
+	}
+	if s.pending != nil {
+		if typ, size, ok := s.pending.Stat(sha); ok {
+			s.mu.RUnlock()
+			return typ, size, nil
+		}
+	}
+	p := s.find(sha)
+	s.mu.RUnlock()
+	if p == nil {
+		return "", 0, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
+	}
+	return s.open(ctx, p).Stat(sha)
+}
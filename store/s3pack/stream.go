@@ -0,0 +1,87 @@
+package s3pack
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/pack"
+	"git.wyat.me/git-storage/store"
+)
+
+// Objects of at least bigObject bytes are streamed into a pack of their
+// own rather than read into memory to join the pending one.
+const bigObject = 1 << 20
+
+// PutStream compresses a large body into a temporary pack as it arrives
+// and uploads that pack once the SHA is known, without holding the
+// store's lock for either. Smaller objects join the pending pack.
+func (s *S3PackStore) PutStream(ctx context.Context, typ object.ObjectType, size int64, r io.Reader) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if size < bigObject || s.opts.CheckStream(typ) {
+		return store.PutWhole(ctx, s, typ, size, r)
+	}
+	a, err := pack.NewAppender(s.cache, tempPattern)
+	if err != nil {
+		return "", fmt.Errorf("put stream: %w", err)
+	}
+	sha, err := a.AddStream(ctx, typ, size, r)
+	if err != nil {
+		a.Abort()
+		return "", fmt.Errorf("put stream: %w", err)
+	}
+	exists, err := s.Exists(ctx, sha)
+	if err != nil || exists {
+		a.Abort()
+		if err != nil {
+			return "", fmt.Errorf("put stream: %w", err)
+		}
+		return sha, nil
+	}
+	sp, err := seal(a)
+	if err != nil {
+		return "", fmt.Errorf("put stream: %w", err)
+	}
+
+	s.mu.Lock()
+	if err := s.usable(); err != nil {
+		s.mu.Unlock()
+		// left for the next store opened on the cache directory
+		return "", fmt.Errorf("put stream: %w", err)
+	}
+	s.packs = append([]*remotePack{sp.p}, s.packs...)
+	s.uploads.Add(1)
+	s.mu.Unlock()
+	if err := s.upload(ctx, sp); err != nil {
+		return "", fmt.Errorf("put stream: %w", err)
+	}
+	return sha, nil
+}
+
+// GetStream inflates whole objects from ranged GETs as they are read, and
+// applies deltas in memory.
+func (s *S3PackStore) GetStream(ctx context.Context, sha string) (*object.Reader, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	if err := s.usable(); err != nil {
+		s.mu.RUnlock()
+		return nil, fmt.Errorf("get %s: %w", sha, err)
+	}
+	p := s.find(sha)
+	s.mu.RUnlock()
+	if p != nil {
+		return s.open(ctx, p).Open(sha)
+	}
+	obj, err := s.Get(ctx, sha)
+	if err != nil {
+		return nil, err
+	}
+	body := io.NopCloser(bytes.NewReader(obj.Data))
+	return object.NewBodyReader(obj.Type, int64(len(obj.Data)), body), nil
+}
@@ -0,0 +1,13 @@
+package store
+
+import "context"
+
+// SizeStore is implemented by object stores that can report how much
+// space their objects take, so that codecs and delta storage can be
+// compared by what they save.
+type SizeStore interface {
+	ObjectStore
+	// StoredSize returns the bytes held for objects as stored, after
+	// compression and deltas, not counting the backend's own overhead.
+	StoredSize(ctx context.Context) (int64, error)
+}
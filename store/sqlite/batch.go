@@ -0,0 +1,119 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+// PutMany inserts objs in a single transaction, so SQLite syncs once for
+// the whole batch instead of once per object.
+func (s *SQLiteStore) PutMany(ctx context.Context, objs []*object.Object) ([]string, error) {
+	for _, obj := range objs {
+		if err := s.opts.Check(obj); err != nil {
+			return nil, err
+		}
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin batch: %w", store.Unavailable(err))
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT OR IGNORE INTO objects (sha, data, type, size) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return nil, fmt.Errorf("prepare insert: %w", store.Unavailable(err))
+	}
+	defer stmt.Close()
+
+	shas := make([]string, len(objs))
+	for i, obj := range objs {
+		compressed, sha, err := s.opts.Serialize(obj)
+		if err != nil {
+			return nil, fmt.Errorf("serialize: %w", err)
+		}
+		if _, err := stmt.ExecContext(ctx, sha, compressed, obj.Type, len(obj.Data)); err != nil {
+			return nil, fmt.Errorf("insert: %w", store.Unavailable(err))
+		}
+		shas[i] = sha
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit batch: %w", store.Unavailable(err))
+	}
+	return shas, nil
+}
+
+// GetMany reads shas in a single transaction with one prepared statement.
+func (s *SQLiteStore) GetMany(ctx context.Context, shas []string) ([]*object.Object, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("begin batch: %w", store.Unavailable(err))
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `SELECT data, chunks, base FROM objects WHERE sha = ?`)
+	if err != nil {
+		return nil, fmt.Errorf("prepare select: %w", store.Unavailable(err))
+	}
+	defer stmt.Close()
+
+	objs := make([]*object.Object, len(shas))
+	for i, sha := range shas {
+		var compressed []byte
+		var chunks int
+		var base string
+		err := stmt.QueryRowContext(ctx, sha).Scan(&compressed, &chunks, &base)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("select: %w", store.Unavailable(err))
+		}
+		if base != "" {
+			if objs[i], err = s.readObject(ctx, tx, sha); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if chunks > 0 {
+			if compressed, err = readChunks(ctx, tx, sha, chunks); err != nil {
+				return nil, err
+			}
+		}
+		obj, err := s.opts.Deserialize(compressed)
+		if err != nil {
+			return nil, fmt.Errorf("object %s: %w: %w", sha, store.ErrCorrupt, err)
+		}
+		objs[i] = obj
+	}
+	return objs, nil
+}
+
+// ExistsMany checks shas in a single transaction with one prepared
+// statement.
+func (s *SQLiteStore) ExistsMany(ctx context.Context, shas []string) ([]bool, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("begin batch: %w", store.Unavailable(err))
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `SELECT COUNT(1) FROM objects WHERE sha = ?`)
+	if err != nil {
+		return nil, fmt.Errorf("prepare exists: %w", store.Unavailable(err))
+	}
+	defer stmt.Close()
+
+	found := make([]bool, len(shas))
+	for i, sha := range shas {
+		var count int
+		if err := stmt.QueryRowContext(ctx, sha).Scan(&count); err != nil {
+			return nil, fmt.Errorf("exists query: %w", store.Unavailable(err))
+		}
+		found[i] = count > 0
+	}
+	return found, nil
+}
@@ -0,0 +1,171 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/pack"
+	"git.wyat.me/git-storage/store"
+)
+
+// Objects written by PutDeltas may be stored as a git delta against a
+// base object. Their row names the base, and its data column holds the
+// compressed delta rather than the object. Depth counts the deltas down to
+// a whole object, which has an empty base and a depth of 0.
+
+// readObject reads sha, rebuilding it from its delta chain if it was
+// stored as a delta. A missing base or any other damage along the chain
+// is reported as store.ErrCorrupt.
+func (s *SQLiteStore) readObject(ctx context.Context, q querier, sha string) (*object.Object, error) {
+	var deltas [][]byte
+	depth := -1
+	current := sha
+	for {
+		var data []byte
+		var chunks, d int
+		var base string
+		err := q.QueryRowContext(ctx, `SELECT data, chunks, base, depth FROM objects WHERE sha = ?`, current).Scan(&data, &chunks, &base, &d)
+		if err == sql.ErrNoRows && current == sha {
+			return nil, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
+		}
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("object %s: %w: delta base %s missing", sha, store.ErrCorrupt, current)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("select: %w", store.Unavailable(err))
+		}
+		if base == "" {
+			if chunks > 0 {
+				if data, err = readChunks(ctx, q, current, chunks); err != nil {
+					return nil, err
+				}
+			}
+			obj, err := s.opts.Deserialize(data)
+			if err != nil {
+				return nil, fmt.Errorf("object %s: %w: %w", current, store.ErrCorrupt, err)
+			}
+			for i := len(deltas) - 1; i >= 0; i-- {
+				if obj.Data, err = pack.ApplyDelta(obj.Data, deltas[i]); err != nil {
+					return nil, fmt.Errorf("object %s: %w: %w", sha, store.ErrCorrupt, err)
+				}
+			}
+			return obj, nil
+		}
+
+		if depth < 0 {
+			depth = d
+		}
+		if len(deltas) == depth {
+			return nil, fmt.Errorf("object %s: %w: delta chain longer than its depth %d", sha, store.ErrCorrupt, depth)
+		}
+		delta, err := s.opts.Decompress(data)
+		if err != nil {
+			return nil, fmt.Errorf("object %s: %w: %w", current, store.ErrCorrupt, err)
+		}
+		deltas = append(deltas, delta)
+		current = base
+	}
+}
+
+// PutDeltas inserts objs in a single transaction like PutMany, through
+// store.WriteDeltas, which stores an object as a delta against its hinted
+// base when that is worthwhile.
+func (s *SQLiteStore) PutDeltas(ctx context.Context, objs []*object.Object, bases []string) ([]string, error) {
+	if s.opts.MaxDeltaDepth <= 0 {
+		return s.PutMany(ctx, objs)
+	}
+	for _, obj := range objs {
+		if err := s.opts.Check(obj); err != nil {
+			return nil, err
+		}
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin batch: %w", store.Unavailable(err))
+	}
+	defer tx.Rollback()
+
+	shas, err := store.WriteDeltas(ctx, &deltaTx{s: s, tx: tx}, s.opts, pack.Delta, objs, bases)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit batch: %w", store.Unavailable(err))
+	}
+	return shas, nil
+}
+
+// deltaTx is a store.DeltaTx over a SQLite transaction.
+type deltaTx struct {
+	s  *SQLiteStore
+	tx *sql.Tx
+}
+
+func (t *deltaTx) Exists(ctx context.Context, sha string) (bool, error) {
+	var count int
+	if err := t.tx.QueryRowContext(ctx, `SELECT COUNT(1) FROM objects WHERE sha = ?`, sha).Scan(&count); err != nil {
+		return false, fmt.Errorf("exists query: %w", store.Unavailable(err))
+	}
+	return count > 0, nil
+}
+
+// Base reads sha as a base. Chunked objects are never used as bases: they
+// are too large to hold in memory for every read of an object based on
+// them.
+func (t *deltaTx) Base(ctx context.Context, sha string) (*object.Object, int, bool, error) {
+	var chunks, depth int
+	err := t.tx.QueryRowContext(ctx, `SELECT chunks, depth FROM objects WHERE sha = ?`, sha).Scan(&chunks, &depth)
+	if err == sql.ErrNoRows || chunks > 0 {
+		return nil, 0, false, nil
+	}
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("select: %w", store.Unavailable(err))
+	}
+	obj, err := t.s.readObject(ctx, t.tx, sha)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	return obj, depth, true, nil
+}
+
+func (t *deltaTx) Put(ctx context.Context, sha string, obj *object.Object, base string, depth int, compressed []byte) error {
+	_, err := t.tx.ExecContext(ctx, `INSERT OR IGNORE INTO objects (sha, data, type, size, base, depth) VALUES (?, ?, ?, ?, ?, ?)`,
+		sha, compressed, obj.Type, len(obj.Data), base, depth)
+	if err != nil {
+		return fmt.Errorf("insert: %w", store.Unavailable(err))
+	}
+	return nil
+}
+
+func (t *deltaTx) Dependents(ctx context.Context, base string) ([]string, error) {
+	rows, err := t.tx.QueryContext(ctx, `SELECT sha FROM objects WHERE base = ?`, base)
+	if err != nil {
+		return nil, fmt.Errorf("select dependents: %w", store.Unavailable(err))
+	}
+	defer rows.Close()
+	var dependents []string
+	for rows.Next() {
+		var sha string
+		if err := rows.Scan(&sha); err != nil {
+			return nil, fmt.Errorf("scan sha: %w", err)
+		}
+		dependents = append(dependents, sha)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("select dependents: %w", store.Unavailable(err))
+	}
+	return dependents, nil
+}
+
+func (t *deltaTx) Get(ctx context.Context, sha string) (*object.Object, error) {
+	return t.s.readObject(ctx, t.tx, sha)
+}
+
+func (t *deltaTx) Rewrite(ctx context.Context, sha, base string, compressed []byte) error {
+	if _, err := t.tx.ExecContext(ctx, `UPDATE objects SET data = ?, base = '', depth = 0 WHERE sha = ?`, compressed, sha); err != nil {
+		return fmt.Errorf("rewrite %s: %w", sha, store.Unavailable(err))
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+
+	"git.wyat.me/git-storage/store"
+)
+
+// iteratePage is how many SHAs Iterate reads per query.
+const iteratePage = 1000
+
+// Iterate range-scans the primary key a page at a time. No statement is
+// held open while fn runs, so fn can use the store despite its single
+// connection.
+func (s *SQLiteStore) Iterate(ctx context.Context, prefix string, fn func(sha string) error) error {
+	// the first page starts at the prefix itself, later pages after the
+	// last SHA seen.
+	op, from, end := ">=", prefix, prefixEnd(prefix)
+	for {
+		rows, err := s.db.QueryContext(
+			ctx,
+			`SELECT sha FROM objects WHERE sha `+op+` ? AND sha < ? ORDER BY sha LIMIT ?`,
+			from, end, iteratePage,
+		)
+		if err != nil {
+			return fmt.Errorf("iterate: %w", store.Unavailable(err))
+		}
+		var page []string
+		for rows.Next() {
+			var sha string
+			if err := rows.Scan(&sha); err != nil {
+				rows.Close()
+				return fmt.Errorf("scan sha: %w", err)
+			}
+			page = append(page, sha)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("iterate: %w", store.Unavailable(err))
+		}
+
+		for _, sha := range page {
+			if err := fn(sha); err == store.ErrStopIteration {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+		if len(page) < iteratePage {
+			return nil
+		}
+		op, from = ">", page[len(page)-1]
+	}
+}
+
+// Delete removes sha, and its chunks if it was streamed in pieces. Objects
+// stored as deltas against sha are first rewritten whole.
+func (s *SQLiteStore) Delete(ctx context.Context, sha string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin delete: %w", store.Unavailable(err))
+	}
+	defer tx.Rollback()
+
+	if err := store.Undelta(ctx, &deltaTx{s: s, tx: tx}, s.opts, sha); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM objects WHERE sha = ?`, sha); err != nil {
+		return fmt.Errorf("delete %s: %w", sha, store.Unavailable(err))
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM object_chunks WHERE id = ?`, sha); err != nil {
+		return fmt.Errorf("delete %s chunks: %w", sha, store.Unavailable(err))
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("delete %s: %w", sha, store.Unavailable(err))
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"git.wyat.me/git-storage/store"
+)
+
+const createReflogTable = `
+    CREATE TABLE IF NOT EXISTS reflog (
+        id        INTEGER PRIMARY KEY AUTOINCREMENT,
+        name      TEXT NOT NULL,
+        old_sha   TEXT NOT NULL,
+        new_sha   TEXT NOT NULL,
+        committer TEXT NOT NULL,
+        time      INTEGER NOT NULL,
+        message   TEXT NOT NULL
+    );
+    CREATE INDEX IF NOT EXISTS reflog_name ON reflog (name, id)
+`
+
+func (s *SQLiteStore) AppendReflog(ctx context.Context, name string, entry store.ReflogEntry) error {
+	return appendReflog(ctx, s.db, name, entry)
+}
+
+// execer is the part of *sql.DB and *sql.Tx that appendReflog needs.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func appendReflog(ctx context.Context, q execer, name string, entry store.ReflogEntry) error {
+	_, err := q.ExecContext(
+		ctx,
+		`INSERT INTO reflog (name, old_sha, new_sha, committer, time, message) VALUES (?, ?, ?, ?, ?, ?)`,
+		name, entry.OldSHA, entry.NewSHA, entry.Committer, entry.Time.UnixNano(), entry.Message,
+	)
+	if err != nil {
+		return fmt.Errorf("append reflog %s: %w", name, store.Unavailable(err))
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Reflog(ctx context.Context, name string) ([]store.ReflogEntry, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT old_sha, new_sha, committer, time, message FROM reflog WHERE name = ? ORDER BY id DESC`,
+		name,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("read reflog %s: %w", name, store.Unavailable(err))
+	}
+	defer rows.Close()
+
+	var entries []store.ReflogEntry
+	for rows.Next() {
+		var entry store.ReflogEntry
+		var nanos int64
+		if err := rows.Scan(&entry.OldSHA, &entry.NewSHA, &entry.Committer, &nanos, &entry.Message); err != nil {
+			return nil, fmt.Errorf("scan reflog entry: %w", err)
+		}
+		entry.Time = time.Unix(0, nanos)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
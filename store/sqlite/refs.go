@@ -0,0 +1,200 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"git.wyat.me/git-storage/store"
+)
+
+const createRefsTable = `
+    CREATE TABLE IF NOT EXISTS refs (
+        name   TEXT PRIMARY KEY,
+        sha    TEXT NOT NULL DEFAULT '',
+        target TEXT NOT NULL DEFAULT ''
+    )
+`
+
+func (s *SQLiteStore) GetRef(ctx context.Context, name string) (*store.Ref, error) {
+	ref := &store.Ref{Name: name}
+	err := s.db.QueryRowContext(ctx, `SELECT sha, target FROM refs WHERE name = ?`, name).Scan(&ref.SHA, &ref.Target)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("get ref %s: %w", name, store.ErrRefNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("select ref: %w", store.Unavailable(err))
+	}
+	return ref, nil
+}
+
+func (s *SQLiteStore) ListRefs(ctx context.Context, prefix string) ([]store.Ref, error) {
+	query := `SELECT name, sha, target FROM refs ORDER BY name`
+	args := []any{}
+	if prefix != "" {
+		// a range scan on the primary key instead of LIKE, which would treat
+		// '_' and '%' in ref names as wildcards.
+		query = `SELECT name, sha, target FROM refs WHERE name >= ? AND name < ? ORDER BY name`
+		args = append(args, prefix, prefixEnd(prefix))
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list refs: %w", store.Unavailable(err))
+	}
+	defer rows.Close()
+
+	var refs []store.Ref
+	for rows.Next() {
+		var ref store.Ref
+		if err := rows.Scan(&ref.Name, &ref.SHA, &ref.Target); err != nil {
+			return nil, fmt.Errorf("scan ref: %w", err)
+		}
+		refs = append(refs, ref)
+	}
+	return refs, rows.Err()
+}
+
+func (s *SQLiteStore) UpdateRef(ctx context.Context, name, oldSHA, newSHA string) error {
+	var res sql.Result
+	var err error
+	if oldSHA == "" {
+		res, err = s.db.ExecContext(ctx, `INSERT OR IGNORE INTO refs (name, sha) VALUES (?, ?)`, name, newSHA)
+	} else {
+		res, err = s.db.ExecContext(
+			ctx,
+			`UPDATE refs SET sha = ?, target = '' WHERE name = ? AND sha = ? AND target = ''`,
+			newSHA, name, oldSHA,
+		)
+	}
+	return checkAffected("update", name, res, err)
+}
+
+func (s *SQLiteStore) DeleteRef(ctx context.Context, name, oldSHA string) error {
+	if oldSHA == "" {
+		if _, err := s.db.ExecContext(ctx, `DELETE FROM refs WHERE name = ?`, name); err != nil {
+			return fmt.Errorf("delete ref %s: %w", name, store.Unavailable(err))
+		}
+		return nil
+	}
+	res, err := s.db.ExecContext(ctx, `DELETE FROM refs WHERE name = ? AND sha = ? AND target = ''`, name, oldSHA)
+	return checkAffected("delete", name, res, err)
+}
+
+func (s *SQLiteStore) SetSymbolicRef(ctx context.Context, name, target string) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO refs (name, sha, target) VALUES (?, '', ?)
+         ON CONFLICT(name) DO UPDATE SET sha = '', target = excluded.target`,
+		name, target,
+	)
+	if err != nil {
+		return fmt.Errorf("set symbolic ref %s: %w", name, store.Unavailable(err))
+	}
+	return nil
+}
+
+func (s *SQLiteStore) UpdateRefs(ctx context.Context, updates []store.RefUpdate) error {
+	return s.updateRefs(ctx, updates, nil)
+}
+
+// UpdateRefsLogged is UpdateRefs that inserts the reflog entries in the
+// same transaction.
+func (s *SQLiteStore) UpdateRefsLogged(ctx context.Context, updates []store.RefUpdate, entry store.ReflogEntry) error {
+	return s.updateRefs(ctx, updates, &entry)
+}
+
+// updateRefs applies updates in one transaction, also recording each in
+// the reflog if entry is not nil.
+func (s *SQLiteStore) updateRefs(ctx context.Context, updates []store.RefUpdate, entry *store.ReflogEntry) error {
+	if err := store.CheckDuplicates(updates); err != nil {
+		return err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin ref transaction: %w", store.Unavailable(err))
+	}
+	defer tx.Rollback()
+
+	var txErr store.TransactionError
+	for _, u := range updates {
+		if u.IsDelete() && u.OldSHA == "" {
+			continue
+		}
+		var sha, target string
+		err := tx.QueryRowContext(ctx, `SELECT sha, target FROM refs WHERE name = ?`, u.Name).Scan(&sha, &target)
+		var ok bool
+		switch {
+		case err == sql.ErrNoRows:
+			ok = u.OldSHA == ""
+		case err != nil:
+			return fmt.Errorf("select ref: %w", store.Unavailable(err))
+		default:
+			ok = u.OldSHA != "" && target == "" && sha == u.OldSHA
+		}
+		if !ok {
+			txErr.Rejected = append(txErr.Rejected, store.RefRejection{Name: u.Name, Err: store.ErrRefConflict})
+		}
+	}
+	if len(txErr.Rejected) > 0 {
+		return &txErr
+	}
+
+	// the single connection serializes transactions, so nothing can move
+	// a ref between the checks above and these writes.
+	for _, u := range updates {
+		if u.IsDelete() {
+			_, err = tx.ExecContext(ctx, `DELETE FROM refs WHERE name = ?`, u.Name)
+		} else {
+			_, err = tx.ExecContext(
+				ctx,
+				`INSERT INTO refs (name, sha, target) VALUES (?, ?, '')
+                 ON CONFLICT(name) DO UPDATE SET sha = excluded.sha, target = ''`,
+				u.Name, u.NewSHA,
+			)
+		}
+		if err != nil {
+			return fmt.Errorf("update ref %s: %w", u.Name, store.Unavailable(err))
+		}
+		if entry == nil {
+			continue
+		}
+		if e, ok := entry.Record(u); ok {
+			if err := appendReflog(ctx, tx, u.Name, e); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit ref transaction: %w", store.Unavailable(err))
+	}
+	return nil
+}
+
+// checkAffected turns a conditional statement that matched no row into
+// store.ErrRefConflict.
+func checkAffected(op, name string, res sql.Result, err error) error {
+	if err != nil {
+		return fmt.Errorf("%s ref %s: %w", op, name, store.Unavailable(err))
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s ref %s: %w", op, name, store.Unavailable(err))
+	}
+	if n == 0 {
+		return fmt.Errorf("%s ref %s: %w", op, name, store.ErrRefConflict)
+	}
+	return nil
+}
+
+// prefixEnd returns the smallest string greater than every string that
+// starts with prefix.
+func prefixEnd(prefix string) string {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1])
+		}
+	}
+	return prefix + "\xff"
+}
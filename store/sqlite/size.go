@@ -0,0 +1,21 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+
+	"git.wyat.me/git-storage/store"
+)
+
+// StoredSize adds up the data columns of objects and their chunks.
+func (s *SQLiteStore) StoredSize(ctx context.Context) (int64, error) {
+	var size int64
+	err := s.db.QueryRowContext(ctx, `
+        SELECT (SELECT COALESCE(SUM(LENGTH(data)), 0) FROM objects) +
+               (SELECT COALESCE(SUM(LENGTH(data)), 0) FROM object_chunks)
+    `).Scan(&size)
+	if err != nil {
+		return 0, fmt.Errorf("stored size: %w", store.Unavailable(err))
+	}
+	return size, nil
+}
@@ -1,18 +1,21 @@
 package sqlite
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
 	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
 	_ "modernc.org/sqlite"
 )
 
 type SQLiteStore struct {
-	db *sql.DB
+	db   *sql.DB
+	opts store.Options
 }
 
-func New(path string) (*SQLiteStore, error) {
+func New(path string, opts ...store.Option) (*SQLiteStore, error) {
 	db, err := sql.Open("sqlite", path)
 	if err != nil {
 		return nil, fmt.Errorf("open sqlite: %w", err)
@@ -36,51 +39,110 @@ func New(path string) (*SQLiteStore, error) {
 
 	_, err = db.Exec(`
         CREATE TABLE IF NOT EXISTS objects (
-            sha  TEXT PRIMARY KEY,
-            data BLOB NOT NULL
+            sha    TEXT PRIMARY KEY,
+            data   BLOB NOT NULL,
+            chunks INTEGER NOT NULL DEFAULT 0,
+            type   TEXT NOT NULL DEFAULT '',
+            size   INTEGER NOT NULL DEFAULT 0,
+            base   TEXT NOT NULL DEFAULT '',
+            depth  INTEGER NOT NULL DEFAULT 0
         )
     `)
 	if err != nil {
 		return nil, fmt.Errorf("create table: %w", err)
 	}
+	for _, col := range [][2]string{
+		{"chunks", "INTEGER NOT NULL DEFAULT 0"},
+		// rows from before type and size were recorded keep the defaults
+		{"type", "TEXT NOT NULL DEFAULT ''"},
+		{"size", "INTEGER NOT NULL DEFAULT 0"},
+		{"base", "TEXT NOT NULL DEFAULT ''"},
+		{"depth", "INTEGER NOT NULL DEFAULT 0"},
+	} {
+		if err := addColumn(db, "objects", col[0], col[1]); err != nil {
+			return nil, fmt.Errorf("migrate objects table: %w", err)
+		}
+	}
+	// Delete looks up the deltas against an object before removing it
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS objects_base ON objects (base) WHERE base != ''`); err != nil {
+		return nil, fmt.Errorf("create base index: %w", err)
+	}
+	if _, err := db.Exec(createChunksTable); err != nil {
+		return nil, fmt.Errorf("create chunks table: %w", err)
+	}
+	if _, err := db.Exec(createRefsTable); err != nil {
+		return nil, fmt.Errorf("create refs table: %w", err)
+	}
+	if _, err := db.Exec(createReflogTable); err != nil {
+		return nil, fmt.Errorf("create reflog table: %w", err)
+	}
+
+	return &SQLiteStore{db: db, opts: store.NewOptions(opts...)}, nil
+}
 
-	return &SQLiteStore{db: db}, nil
+// addColumn adds a column to a table created before the column existed.
+func addColumn(db *sql.DB, table, column, decl string) error {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(1) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, decl))
+	return err
 }
 
-func (s *SQLiteStore) Put(obj *object.Object) (sha string, err error) {
-	compressed, sha, err := object.Serialize(obj)
+func (s *SQLiteStore) Put(ctx context.Context, obj *object.Object) (sha string, err error) {
+	if err := s.opts.Check(obj); err != nil {
+		return "", err
+	}
+	compressed, sha, err := s.opts.Serialize(obj)
 	if err != nil {
 		return "", fmt.Errorf("serialize: %w", err)
 	}
-	_, err = s.db.Exec(
-		`INSERT OR IGNORE INTO objects (sha, data) VALUES (?, ?)`,
-		sha, compressed,
+	_, err = s.db.ExecContext(
+		ctx,
+		`INSERT OR IGNORE INTO objects (sha, data, type, size) VALUES (?, ?, ?, ?)`,
+		sha, compressed, obj.Type, len(obj.Data),
 	)
 	if err != nil {
-		return "", fmt.Errorf("insert: %w", err)
+		return "", fmt.Errorf("insert: %w", store.Unavailable(err))
 	}
 
 	return sha, nil
 }
 
-func (s *SQLiteStore) Get(sha string) (*object.Object, error) {
+func (s *SQLiteStore) Get(ctx context.Context, sha string) (*object.Object, error) {
 	var compressed []byte
-	err := s.db.QueryRow(`SELECT data FROM objects WHERE sha = ?`, sha).Scan(&compressed)
+	var chunks int
+	var base string
+	err := s.db.QueryRowContext(ctx, `SELECT data, chunks, base FROM objects WHERE sha = ?`, sha).Scan(&compressed, &chunks, &base)
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("object not found %s", sha)
+		return nil, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
 	}
 	if err != nil {
-		return nil, fmt.Errorf("select: %w", err)
+		return nil, fmt.Errorf("select: %w", store.Unavailable(err))
+	}
+	if base != "" {
+		return s.readObject(ctx, s.db, sha)
+	}
+	if chunks > 0 {
+		if compressed, err = readChunks(ctx, s.db, sha, chunks); err != nil {
+			return nil, err
+		}
 	}
 
-	return object.Deserialize(compressed)
+	obj, err := s.opts.Deserialize(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("object %s: %w: %w", sha, store.ErrCorrupt, err)
+	}
+	return obj, nil
 }
 
-func (s *SQLiteStore) Exists(sha string) (bool, error) {
+func (s *SQLiteStore) Exists(ctx context.Context, sha string) (bool, error) {
 	var count int
-	err := s.db.QueryRow(`SELECT COUNT(1) FROM objects WHERE sha = ?`, sha).Scan(&count)
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(1) FROM objects WHERE sha = ?`, sha).Scan(&count)
 	if err != nil {
-		return false, fmt.Errorf("exists query: %w", err)
+		return false, fmt.Errorf("exists query: %w", store.Unavailable(err))
 	}
 	return count > 0, nil
 }
@@ -1,9 +1,12 @@
 package sqlite
 
 import (
+	"path/filepath"
 	"testing"
 
 	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+	"git.wyat.me/git-storage/store/storetest"
 )
 
 func TestPutAndGet(t *testing.T) {
@@ -18,7 +21,7 @@ func TestPutAndGet(t *testing.T) {
 		Data: []byte("hello\n"),
 	}
 
-	sha, err := store.Put(obj)
+	sha, err := store.Put(t.Context(), obj)
 	if err != nil {
 		t.Fatalf("Put failed: %v", err)
 	}
@@ -28,7 +31,7 @@ func TestPutAndGet(t *testing.T) {
 		t.Fatalf("Put returned %s, expected %s", sha, expectedSHA)
 	}
 
-	got, err := store.Get(sha)
+	got, err := store.Get(t.Context(), sha)
 	if err != nil {
 		t.Fatalf("Get failed: %v", err)
 	}
@@ -53,12 +56,12 @@ func TestExists(t *testing.T) {
 		Data: []byte("hello\n"),
 	}
 
-	sha, err := store.Put(obj)
+	sha, err := store.Put(t.Context(), obj)
 	if err != nil {
 		t.Fatalf("Put failed: %v", err)
 	}
 
-	exists, err := store.Exists(sha)
+	exists, err := store.Exists(t.Context(), sha)
 	if err != nil {
 		t.Fatalf("Exists failed: %v", err)
 	}
@@ -67,7 +70,7 @@ func TestExists(t *testing.T) {
 		t.Error("expected object to exist after Put")
 	}
 
-	exists, err = store.Exists("0000000000000000000000000000000000000000")
+	exists, err = store.Exists(t.Context(), "0000000000000000000000000000000000000000")
 	if err != nil {
 		t.Fatalf("Exists failed: %v", err)
 	}
@@ -89,12 +92,12 @@ func TestDuplicatePut(t *testing.T) {
 		Data: []byte("hello\n"),
 	}
 
-	sha1, err := store.Put(obj)
+	sha1, err := store.Put(t.Context(), obj)
 	if err != nil {
 		t.Fatalf("Put failed: %v", err)
 	}
 
-	sha2, err := store.Put(obj)
+	sha2, err := store.Put(t.Context(), obj)
 	if err != nil {
 		t.Fatalf("Put failed: %v", err)
 	}
@@ -103,3 +106,211 @@ func TestDuplicatePut(t *testing.T) {
 		t.Errorf("Put returned %s and %s, expected them to be the same", sha1, sha2)
 	}
 }
+
+func TestCancelledContext(t *testing.T) {
+	s, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Cancelled(t, s)
+}
+
+func TestErrors(t *testing.T) {
+	s, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+
+	closed, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	closed.Close()
+
+	storetest.Errors(t, storetest.Faults{
+		Store: s,
+		Corrupt: func(sha string) {
+			if _, err := s.db.Exec(`UPDATE objects SET data = ? WHERE sha = ?`, []byte("not zlib"), sha); err != nil {
+				t.Fatalf("corrupt %s: %v", sha, err)
+			}
+		},
+		Unavailable: closed,
+	})
+}
+
+func TestRefStore(t *testing.T) {
+	storetest.RefStore(t, func(t *testing.T) store.RefStore {
+		s, err := New(":memory:")
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func TestReflog(t *testing.T) {
+	storetest.Reflog(t, func(t *testing.T) storetest.LoggedRefStore {
+		s, err := New(":memory:")
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func TestBatch(t *testing.T) {
+	s, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Batch(t, s)
+}
+
+func TestStream(t *testing.T) {
+	s, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Stream(t, s)
+}
+
+func TestMutable(t *testing.T) {
+	s, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Mutable(t, s)
+}
+
+func TestResolve(t *testing.T) {
+	s, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Resolve(t, s)
+}
+
+func TestStat(t *testing.T) {
+	s, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Stat(t, s)
+}
+
+func TestStatWithoutColumns(t *testing.T) {
+	s, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+
+	// a row written before the type and size columns existed
+	compressed, sha, err := object.Serialize(&object.Object{Type: object.TypeCommit, Data: []byte("old row")})
+	if err != nil {
+		t.Fatalf("Serialize failed: %v", err)
+	}
+	if _, err := s.db.Exec(`INSERT INTO objects (sha, data) VALUES (?, ?)`, sha, compressed); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+
+	typ, size, err := s.Stat(t.Context(), sha)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if typ != object.TypeCommit || size != 7 {
+		t.Errorf("got (%s, %d), want (commit, 7)", typ, size)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	s, err := New(":memory:", store.WithValidation())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Validation(t, s)
+}
+
+func TestSHA256(t *testing.T) {
+	s, err := New(":memory:", store.WithFormat(object.SHA256), store.WithValidation())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.SHA256(t, s)
+}
+
+func TestCodecs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "objects.db")
+	var s *SQLiteStore
+	storetest.Codecs(t, func(t *testing.T, c object.Codec) store.ObjectStore {
+		if s != nil {
+			s.Close()
+		}
+		var err error
+		if s, err = New(path, store.WithCodec(c)); err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		return s
+	})
+	s.Close()
+}
+
+func TestDeltas(t *testing.T) {
+	const maxDepth = 3
+	s, err := New(":memory:", store.WithDeltas(maxDepth))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	storetest.Deltas(t, s, maxDepth)
+
+	// the versions are stored as deltas, in chains no deeper than allowed
+	var deltas, depth int
+	err = s.db.QueryRow(`SELECT COUNT(1), COALESCE(MAX(depth), 0) FROM objects WHERE base != ''`).Scan(&deltas, &depth)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if deltas == 0 {
+		t.Error("no objects were stored as deltas")
+	}
+	if depth > maxDepth {
+		t.Errorf("delta depth %d, want at most %d", depth, maxDepth)
+	}
+}
+
+func TestStoredSize(t *testing.T) {
+	s, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+
+	if size, err := s.StoredSize(t.Context()); err != nil || size != 0 {
+		t.Fatalf("StoredSize of an empty store = %d, %v", size, err)
+	}
+	obj := &object.Object{Type: object.TypeBlob, Data: []byte("hello\n")}
+	if _, err := s.Put(t.Context(), obj); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := s.UpdateRef(t.Context(), "refs/heads/main", "", "ce013625030ba8dba906f756967f9e9ca394464a"); err != nil {
+		t.Fatalf("UpdateRef failed: %v", err)
+	}
+	compressed, _, err := object.Serialize(obj)
+	if err != nil {
+		t.Fatalf("Serialize failed: %v", err)
+	}
+	if size, err := s.StoredSize(t.Context()); err != nil || size != int64(len(compressed)) {
+		t.Errorf("StoredSize = %d, %v, want %d", size, err, len(compressed))
+	}
+}
@@ -0,0 +1,29 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+// Stat reads the type and size columns, leaving the data and its chunks
+// untouched.
+func (s *SQLiteStore) Stat(ctx context.Context, sha string) (object.ObjectType, int64, error) {
+	var typ string
+	var size int64
+	err := s.db.QueryRowContext(ctx, `SELECT type, size FROM objects WHERE sha = ?`, sha).Scan(&typ, &size)
+	if err == sql.ErrNoRows {
+		return "", 0, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("stat: %w", store.Unavailable(err))
+	}
+	if typ == "" {
+		// stored before the columns were added
+		return store.StatHeader(ctx, s, sha)
+	}
+	return object.ObjectType(typ), size, nil
+}
@@ -0,0 +1,252 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+// Objects whose compressed form is larger than chunkSize are written by
+// PutStream as rows of object_chunks, and their objects row has an empty
+// data column and a chunk count. Chunks are inserted under a random
+// upload ID, because the SHA is only known once the whole body has been
+// read, and re-keyed to the SHA when the object is committed.
+const createChunksTable = `
+    CREATE TABLE IF NOT EXISTS object_chunks (
+        id   TEXT NOT NULL,
+        seq  INTEGER NOT NULL,
+        data BLOB NOT NULL,
+        PRIMARY KEY (id, seq)
+    )
+`
+
+const chunkSize = 4 << 20
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// readChunks joins the chunks of a chunked object.
+func readChunks(ctx context.Context, q querier, sha string, chunks int) ([]byte, error) {
+	rows, err := q.QueryContext(ctx, `SELECT data FROM object_chunks WHERE id = ? ORDER BY seq`, sha)
+	if err != nil {
+		return nil, fmt.Errorf("select chunks: %w", store.Unavailable(err))
+	}
+	defer rows.Close()
+
+	var buf bytes.Buffer
+	n := 0
+	for rows.Next() {
+		var chunk []byte
+		if err := rows.Scan(&chunk); err != nil {
+			return nil, fmt.Errorf("scan chunk: %w", err)
+		}
+		buf.Write(chunk)
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("select chunks: %w", store.Unavailable(err))
+	}
+	if n != chunks {
+		return nil, fmt.Errorf("object %s: %w: %d of %d chunks", sha, store.ErrCorrupt, n, chunks)
+	}
+	return buf.Bytes(), nil
+}
+
+// PutStream compresses the body read from r into chunks as it arrives.
+// Objects that turn out to fit in one chunk are stored whole, as Put
+// would store them.
+func (s *SQLiteStore) PutStream(ctx context.Context, typ object.ObjectType, size int64, r io.Reader) (string, error) {
+	if s.opts.CheckStream(typ) {
+		return store.PutWhole(ctx, s, typ, size, r)
+	}
+	var id [8]byte
+	rand.Read(id[:])
+	cw := &chunkWriter{ctx: ctx, db: s.db, id: "upload-" + hex.EncodeToString(id[:])}
+
+	w := s.opts.NewWriter(cw, typ, size)
+	_, err := io.Copy(w, r)
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil && cw.chunks > 0 && len(cw.buf) > 0 {
+		err = cw.flush()
+	}
+	if err != nil {
+		cw.discard()
+		return "", fmt.Errorf("put stream: %w", err)
+	}
+	sha := w.SHA()
+
+	if cw.chunks == 0 {
+		_, err := s.db.ExecContext(
+			ctx,
+			`INSERT OR IGNORE INTO objects (sha, data, type, size) VALUES (?, ?, ?, ?)`,
+			sha, cw.buf, typ, size,
+		)
+		if err != nil {
+			return "", fmt.Errorf("insert: %w", store.Unavailable(err))
+		}
+		return sha, nil
+	}
+	if err := s.commitChunks(ctx, sha, typ, size, cw); err != nil {
+		cw.discard()
+		return "", err
+	}
+	return sha, nil
+}
+
+// commitChunks records the chunks written by cw as the object sha, or
+// drops them if sha is already stored.
+func (s *SQLiteStore) commitChunks(ctx context.Context, sha string, typ object.ObjectType, size int64, cw *chunkWriter) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin chunks: %w", store.Unavailable(err))
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(
+		ctx,
+		`INSERT OR IGNORE INTO objects (sha, data, chunks, type, size) VALUES (?, x'', ?, ?, ?)`,
+		sha, cw.chunks, typ, size,
+	)
+	if err != nil {
+		return fmt.Errorf("insert: %w", store.Unavailable(err))
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("insert: %w", store.Unavailable(err))
+	}
+	query := `UPDATE object_chunks SET id = ? WHERE id = ?`
+	args := []any{sha, cw.id}
+	if inserted == 0 {
+		query = `DELETE FROM object_chunks WHERE id = ?`
+		args = args[1:]
+	}
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("commit chunks: %w", store.Unavailable(err))
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit chunks: %w", store.Unavailable(err))
+	}
+	return nil
+}
+
+// GetStream reads a chunked object one chunk at a time. Objects stored
+// whole are small enough to read at once, and deltas are rebuilt with Get.
+func (s *SQLiteStore) GetStream(ctx context.Context, sha string) (*object.Reader, error) {
+	var compressed []byte
+	var chunks int
+	var base string
+	err := s.db.QueryRowContext(ctx, `SELECT data, chunks, base FROM objects WHERE sha = ?`, sha).Scan(&compressed, &chunks, &base)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("object %s: %w", sha, store.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("select: %w", store.Unavailable(err))
+	}
+	if base != "" {
+		return store.GetWhole(ctx, s, sha)
+	}
+
+	var src io.Reader = bytes.NewReader(compressed)
+	if chunks > 0 {
+		src = &chunkReader{ctx: ctx, db: s.db, sha: sha, chunks: chunks}
+	}
+	r, err := s.opts.NewReader(src)
+	if err != nil {
+		if errors.Is(err, store.ErrUnavailable) || errors.Is(err, store.ErrCorrupt) || ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("object %s: %w: %w", sha, store.ErrCorrupt, err)
+	}
+	return r, nil
+}
+
+// chunkWriter buffers compressed output and inserts it a chunk at a time.
+type chunkWriter struct {
+	ctx    context.Context
+	db     *sql.DB
+	id     string
+	buf    []byte
+	chunks int
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		take := min(len(p), chunkSize-len(w.buf))
+		w.buf = append(w.buf, p[:take]...)
+		p = p[take:]
+		if len(w.buf) == chunkSize {
+			if err := w.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *chunkWriter) flush() error {
+	_, err := w.db.ExecContext(
+		w.ctx,
+		`INSERT INTO object_chunks (id, seq, data) VALUES (?, ?, ?)`,
+		w.id, w.chunks, w.buf,
+	)
+	if err != nil {
+		return fmt.Errorf("insert chunk: %w", store.Unavailable(err))
+	}
+	w.chunks++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// discard deletes the chunks written so far. It is best effort: chunks
+// left behind by a failure are never read.
+func (w *chunkWriter) discard() {
+	w.db.ExecContext(context.WithoutCancel(w.ctx), `DELETE FROM object_chunks WHERE id = ?`, w.id)
+}
+
+// chunkReader reads a chunked object's compressed bytes, querying one
+// chunk at a time so that no statement is held open between reads.
+type chunkReader struct {
+	ctx    context.Context
+	db     *sql.DB
+	sha    string
+	chunks int
+	next   int
+	buf    []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.next == r.chunks {
+			return 0, io.EOF
+		}
+		err := r.db.QueryRowContext(
+			r.ctx,
+			`SELECT data FROM object_chunks WHERE id = ? AND seq = ?`,
+			r.sha, r.next,
+		).Scan(&r.buf)
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("object %s: %w: chunk %d missing", r.sha, store.ErrCorrupt, r.next)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("select chunk: %w", store.Unavailable(err))
+		}
+		r.next++
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,35 @@
+package store
+
+import (
+	"context"
+
+	"git.wyat.me/git-storage/object"
+)
+
+// StatStore is implemented by object stores that can report an object's
+// type and size without reading its body. Callers go through Stat, which
+// falls back to reading the object's header for other stores.
+type StatStore interface {
+	ObjectStore
+	Stat(ctx context.Context, sha string) (object.ObjectType, int64, error)
+}
+
+// Stat returns the type and size of sha in s. Stores that cannot stat
+// objects are asked for a stream, of which only the header is read.
+func Stat(ctx context.Context, s ObjectStore, sha string) (object.ObjectType, int64, error) {
+	if ss, ok := s.(StatStore); ok {
+		return ss.Stat(ctx, sha)
+	}
+	return StatHeader(ctx, s, sha)
+}
+
+// StatHeader reads the type and size from the header of sha's stored
+// form. Backends use it for objects stored before they recorded either.
+func StatHeader(ctx context.Context, s ObjectStore, sha string) (object.ObjectType, int64, error) {
+	r, err := GetStream(ctx, s, sha)
+	if err != nil {
+		return "", 0, err
+	}
+	r.Close()
+	return r.Type, r.Size, nil
+}
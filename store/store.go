@@ -1,9 +1,173 @@
 package store
 
-import "git.wyat.me/git-storage/object"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
+	"git.wyat.me/git-storage/object"
+)
+
+// ObjectStore holds git objects keyed by SHA. Every method honours ctx
+// cancellation and deadlines where the backend can interrupt the call,
+// and otherwise checks ctx before starting.
 type ObjectStore interface {
-	Put(obj *object.Object) (sha string, err error)
-	Get(sha string) (*object.Object, error)
-	Exists(sha string) (bool, error)
+	Put(ctx context.Context, obj *object.Object) (sha string, err error)
+	Get(ctx context.Context, sha string) (*object.Object, error)
+	Exists(ctx context.Context, sha string) (bool, error)
+}
+
+// Every backend reports failures through these sentinels, so callers can
+// tell a missing object from a damaged one or from an outage with
+// errors.Is.
+var (
+	ErrNotFound = errors.New("not found")
+	// ErrCorrupt is returned when stored bytes cannot be decoded into the
+	// object they are keyed by.
+	ErrCorrupt = errors.New("corrupt object")
+	// ErrUnavailable is returned when the backend itself fails: a closed
+	// database, a network error or an S3 server error.
+	ErrUnavailable = errors.New("store unavailable")
+)
+
+// Unavailable marks an error from a backend's database or network layer
+// as ErrUnavailable. Context cancellation and deadlines are passed through
+// as they are, since it was the caller that gave up.
+func Unavailable(err error) error {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrUnavailable, err)
+}
+
+var (
+	// ErrRefNotFound is an ErrNotFound for refs.
+	ErrRefNotFound = fmt.Errorf("ref %w", ErrNotFound)
+	// ErrRefConflict is returned when a ref does not hold the value an
+	// update or delete expected, including creating a ref that exists.
+	ErrRefConflict = errors.New("ref changed concurrently")
+)
+
+// Ref is a named pointer to an object. Symbolic refs such as HEAD set
+// Target to the name of another ref and leave SHA empty.
+type Ref struct {
+	Name   string
+	SHA    string
+	Target string
+}
+
+// RefStore holds a repository's refs. Updates are compare-and-swap: oldSHA
+// must match the stored value, and an empty oldSHA means the ref must not
+// exist yet. DeleteRef with an empty oldSHA deletes unconditionally.
+type RefStore interface {
+	GetRef(ctx context.Context, name string) (*Ref, error)
+	ListRefs(ctx context.Context, prefix string) ([]Ref, error)
+	UpdateRef(ctx context.Context, name, oldSHA, newSHA string) error
+	DeleteRef(ctx context.Context, name, oldSHA string) error
+	SetSymbolicRef(ctx context.Context, name, target string) error
+	// UpdateRefs applies every update or none of them. When any
+	// precondition fails it returns a *TransactionError naming each
+	// rejected ref.
+	UpdateRefs(ctx context.Context, updates []RefUpdate) error
+}
+
+// RefUpdate is one operation in a ref transaction. OldSHA follows the
+// UpdateRef and DeleteRef rules, and an empty NewSHA deletes the ref.
+type RefUpdate struct {
+	Name   string
+	OldSHA string
+	NewSHA string
+}
+
+func (u RefUpdate) IsDelete() bool { return u.NewSHA == "" }
+
+// ErrDuplicateRef rejects a transaction that touches the same ref twice.
+var ErrDuplicateRef = errors.New("ref updated more than once")
+
+// RefRejection records why a single ref in a transaction was refused.
+type RefRejection struct {
+	Name string
+	Err  error
+}
+
+// TransactionError is returned when a ref transaction is rejected. Refs
+// that are not listed were fine on their own but were not applied either.
+type TransactionError struct {
+	Rejected []RefRejection
+}
+
+func (e *TransactionError) Error() string {
+	parts := make([]string, len(e.Rejected))
+	for i, r := range e.Rejected {
+		parts[i] = r.Name + ": " + r.Err.Error()
+	}
+	return "ref transaction rejected: " + strings.Join(parts, "; ")
+}
+
+func (e *TransactionError) Unwrap() []error {
+	errs := make([]error, len(e.Rejected))
+	for i, r := range e.Rejected {
+		errs[i] = r.Err
+	}
+	return errs
+}
+
+// CheckDuplicates rejects updates that name the same ref more than once,
+// which no backend can apply atomically in a meaningful order.
+func CheckDuplicates(updates []RefUpdate) error {
+	seen := make(map[string]bool, len(updates))
+	var txErr TransactionError
+	for _, u := range updates {
+		if seen[u.Name] {
+			txErr.Rejected = append(txErr.Rejected, RefRejection{Name: u.Name, Err: ErrDuplicateRef})
+		}
+		seen[u.Name] = true
+	}
+	if len(txErr.Rejected) > 0 {
+		return &txErr
+	}
+	return nil
+}
+
+// ReflogEntry records one change to a ref. An empty OldSHA means the ref
+// was created and an empty NewSHA that it was deleted.
+type ReflogEntry struct {
+	OldSHA    string
+	NewSHA    string
+	Committer string
+	Time      time.Time
+	Message   string
+}
+
+// ReflogStore keeps the history of every ref, including refs that have
+// since been deleted, so that overwritten commits can be recovered.
+type ReflogStore interface {
+	AppendReflog(ctx context.Context, name string, entry ReflogEntry) error
+	// Reflog returns the entries for name, newest first.
+	Reflog(ctx context.Context, name string) ([]ReflogEntry, error)
+}
+
+const maxSymrefDepth = 5
+
+// ResolveRef follows symbolic refs until it reaches one that points at an
+// object. The returned ref keeps the original name and Target, with SHA
+// filled in from the final ref.
+func ResolveRef(ctx context.Context, rs RefStore, name string) (*Ref, error) {
+	ref, err := rs.GetRef(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	resolved := *ref
+	for range maxSymrefDepth {
+		if ref.Target == "" {
+			resolved.SHA = ref.SHA
+			return &resolved, nil
+		}
+		if ref, err = rs.GetRef(ctx, ref.Target); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("symbolic ref %s nested too deeply", name)
 }
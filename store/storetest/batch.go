@@ -0,0 +1,85 @@
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+// Batch runs the BatchStore conformance suite against an empty store.
+func Batch(t *testing.T, s store.BatchStore) {
+	objs := make([]*object.Object, 50)
+	for i := range objs {
+		objs[i] = &object.Object{Type: object.TypeBlob, Data: fmt.Appendf(nil, "batch %d\n", i)}
+	}
+
+	t.Run("PutMany", func(t *testing.T) {
+		shas, err := s.PutMany(t.Context(), objs)
+		if err != nil {
+			t.Fatalf("PutMany failed: %v", err)
+		}
+		if len(shas) != len(objs) {
+			t.Fatalf("got %d SHAs, want %d", len(shas), len(objs))
+		}
+		for i, sha := range shas {
+			if want := object.Hash(objs[i]); sha != want {
+				t.Errorf("SHA %d: got %s, want %s", i, sha, want)
+			}
+			obj, err := s.Get(t.Context(), sha)
+			if err != nil {
+				t.Fatalf("Get %s failed: %v", sha, err)
+			}
+			if string(obj.Data) != string(objs[i].Data) {
+				t.Errorf("object %d: got %q, want %q", i, obj.Data, objs[i].Data)
+			}
+		}
+
+		// storing the same objects again is not an error.
+		if _, err := s.PutMany(t.Context(), objs[:5]); err != nil {
+			t.Errorf("second PutMany failed: %v", err)
+		}
+	})
+
+	t.Run("GetMany", func(t *testing.T) {
+		shas := []string{object.Hash(objs[3]), object.Hash(objs[1]), object.Hash(objs[3])}
+		got, err := s.GetMany(t.Context(), shas)
+		if err != nil {
+			t.Fatalf("GetMany failed: %v", err)
+		}
+		for i, want := range []*object.Object{objs[3], objs[1], objs[3]} {
+			if got[i].Type != want.Type || string(got[i].Data) != string(want.Data) {
+				t.Errorf("object %d: got %s %q, want %s %q", i, got[i].Type, got[i].Data, want.Type, want.Data)
+			}
+		}
+
+		_, err = s.GetMany(t.Context(), []string{shas[0], missingSHA})
+		if !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected ErrNotFound with a missing object, got %v", err)
+		}
+	})
+
+	t.Run("ExistsMany", func(t *testing.T) {
+		found, err := s.ExistsMany(t.Context(), []string{object.Hash(objs[0]), missingSHA, object.Hash(objs[49])})
+		if err != nil {
+			t.Fatalf("ExistsMany failed: %v", err)
+		}
+		if want := []bool{true, false, true}; fmt.Sprint(found) != fmt.Sprint(want) {
+			t.Errorf("got %v, want %v", found, want)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		if shas, err := s.PutMany(t.Context(), nil); err != nil || len(shas) != 0 {
+			t.Errorf("PutMany(nil): got (%v, %v)", shas, err)
+		}
+		if objs, err := s.GetMany(t.Context(), nil); err != nil || len(objs) != 0 {
+			t.Errorf("GetMany(nil): got (%v, %v)", objs, err)
+		}
+		if found, err := s.ExistsMany(t.Context(), nil); err != nil || len(found) != 0 {
+			t.Errorf("ExistsMany(nil): got (%v, %v)", found, err)
+		}
+	})
+}
@@ -0,0 +1,99 @@
+package storetest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"testing"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+// Codecs writes objects through every write path with each built-in codec
+// and a dictionary codec in turn, then checks that they all read back
+// from a store opened with the dictionary codec. open must return a store
+// over the same data on every call, created with store.WithCodec(c) and
+// closing whatever store it returned before.
+func Codecs(t *testing.T, open func(t *testing.T, c object.Codec) store.ObjectStore) {
+	samples := make([]*object.Object, 50)
+	for i := range samples {
+		samples[i] = &object.Object{Type: object.TypeCommit, Data: fmt.Appendf(nil,
+			"tree %040x\nauthor A U Thor <author@example.com> %d +0000\ncommitter A U Thor <author@example.com> %d +0000\n\ncommit %d\n",
+			i, 1700000000+i, 1700000000+i, i)}
+	}
+	dict, err := object.TrainZstdDict(samples, 1024)
+	if err != nil {
+		t.Fatalf("TrainZstdDict failed: %v", err)
+	}
+	dictCodec, err := object.NewZstdDict(dict)
+	if err != nil {
+		t.Fatalf("NewZstdDict failed: %v", err)
+	}
+
+	written := map[string]*object.Object{}
+	for _, c := range []object.Codec{object.Zlib, object.Zstd, object.None, dictCodec} {
+		s := open(t, c)
+
+		obj := &object.Object{Type: object.TypeCommit, Data: fmt.Appendf(nil,
+			"tree %040x\nauthor A U Thor <author@example.com> 1700000000 +0000\ncommitter A U Thor <author@example.com> 1700000000 +0000\n\n%s\n",
+			0, c.Name())}
+		sha, err := s.Put(t.Context(), obj)
+		if err != nil {
+			t.Fatalf("%s: Put failed: %v", c.Name(), err)
+		}
+		written[sha] = obj
+
+		if bs, ok := s.(store.BatchStore); ok {
+			objs := []*object.Object{{Type: object.TypeBlob, Data: []byte(c.Name() + " batch\n")}}
+			shas, err := bs.PutMany(t.Context(), objs)
+			if err != nil {
+				t.Fatalf("%s: PutMany failed: %v", c.Name(), err)
+			}
+			written[shas[0]] = objs[0]
+		}
+		if ss, ok := s.(store.StreamStore); ok {
+			// large enough to be chunked whatever the codec
+			obj := &object.Object{Type: object.TypeBlob, Data: make([]byte, 20<<20)}
+			rand.Read(obj.Data)
+			sha, err := ss.PutStream(t.Context(), obj.Type, int64(len(obj.Data)), bytes.NewReader(obj.Data))
+			if err != nil {
+				t.Fatalf("%s: PutStream failed: %v", c.Name(), err)
+			}
+			written[sha] = obj
+		}
+	}
+
+	s := open(t, dictCodec)
+	for sha, obj := range written {
+		got, err := s.Get(t.Context(), sha)
+		if err != nil {
+			t.Fatalf("Get %s failed: %v", sha, err)
+		}
+		if got.Type != obj.Type || !bytes.Equal(got.Data, obj.Data) {
+			t.Errorf("Get %s: object did not round-trip", sha)
+		}
+
+		r, err := store.GetStream(t.Context(), s, sha)
+		if err != nil {
+			t.Fatalf("GetStream %s failed: %v", sha, err)
+		}
+		body, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("GetStream %s: read failed: %v", sha, err)
+		}
+		if !bytes.Equal(body, obj.Data) {
+			t.Errorf("GetStream %s: body did not round-trip", sha)
+		}
+
+		typ, size, err := store.Stat(t.Context(), s, sha)
+		if err != nil {
+			t.Fatalf("Stat %s failed: %v", sha, err)
+		}
+		if typ != obj.Type || size != int64(len(obj.Data)) {
+			t.Errorf("Stat %s: got (%s, %d), want (%s, %d)", sha, typ, size, obj.Type, len(obj.Data))
+		}
+	}
+}
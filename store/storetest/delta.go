@@ -0,0 +1,169 @@
+package storetest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+// fileVersions returns n versions of a text file, each differing from the one
+// before it in a single line, so that each makes a small delta against its
+// predecessor.
+func fileVersions(n, size int) []*object.Object {
+	var lines [][]byte
+	for len(lines)*48 < size {
+		line := make([]byte, 20)
+		rand.Read(line)
+		lines = append(lines, fmt.Appendf(nil, "line %4d: %x\n", len(lines), line))
+	}
+	versions := make([]*object.Object, n)
+	for i := range versions {
+		if i > 0 {
+			lines[i*7%len(lines)] = fmt.Appendf(nil, "line %4d: version %d\n", i*7%len(lines), i)
+		}
+		versions[i] = &object.Object{Type: object.TypeBlob, Data: bytes.Join(lines, nil)}
+	}
+	return versions
+}
+
+// Deltas checks that s, created with store.WithDeltas(maxDepth), reads
+// back objects written through PutDeltas as chains of versions longer
+// than maxDepth, with bases from the same batch, from an earlier batch,
+// and missing altogether. If s is a MutableStore it also checks that
+// deleting a base leaves the objects based on it readable.
+func Deltas(t *testing.T, s store.ObjectStore, maxDepth int) {
+	versions := fileVersions(3*maxDepth+2, 8<<10)
+	var shas []string
+	for _, obj := range versions {
+		shas = append(shas, object.Hash(obj))
+	}
+	bases := append([]string{"0123456789abcdef0123456789abcdef01234567"}, shas[:len(shas)-1]...)
+
+	// the first half is based within its batch, the second on the first
+	half := len(versions) / 2
+	for _, batch := range [][2]int{{0, half}, {half, len(versions)}} {
+		got, err := store.PutDeltas(t.Context(), s, versions[batch[0]:batch[1]], bases[batch[0]:batch[1]])
+		if err != nil {
+			t.Fatalf("PutDeltas failed: %v", err)
+		}
+		if !slices.Equal(got, shas[batch[0]:batch[1]]) {
+			t.Fatalf("PutDeltas returned %v, want %v", got, shas[batch[0]:batch[1]])
+		}
+	}
+
+	// a commit hinted against a blob, and a repeat of what is stored
+	commit := &object.Object{Type: object.TypeCommit, Data: versions[1].Data}
+	got, err := store.PutDeltas(t.Context(), s, []*object.Object{commit, versions[2]}, []string{shas[0], shas[1]})
+	if err != nil {
+		t.Fatalf("PutDeltas failed: %v", err)
+	}
+	if got[1] != shas[2] {
+		t.Fatalf("PutDeltas returned %s for a stored object, want %s", got[1], shas[2])
+	}
+	versions = append(versions, commit)
+	shas = append(shas, got[0])
+
+	assertVersions := func(t *testing.T, skip ...string) {
+		t.Helper()
+		for i, sha := range shas {
+			if slices.Contains(skip, sha) {
+				continue
+			}
+			obj, err := s.Get(t.Context(), sha)
+			if err != nil {
+				t.Fatalf("Get version %d failed: %v", i, err)
+			}
+			if obj.Type != versions[i].Type || !bytes.Equal(obj.Data, versions[i].Data) {
+				t.Fatalf("Get version %d: object did not round-trip", i)
+			}
+
+			r, err := store.GetStream(t.Context(), s, sha)
+			if err != nil {
+				t.Fatalf("GetStream version %d failed: %v", i, err)
+			}
+			body, err := io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				t.Fatalf("GetStream version %d: read failed: %v", i, err)
+			}
+			if !bytes.Equal(body, versions[i].Data) {
+				t.Fatalf("GetStream version %d: body did not round-trip", i)
+			}
+
+			typ, size, err := store.Stat(t.Context(), s, sha)
+			if err != nil {
+				t.Fatalf("Stat version %d failed: %v", i, err)
+			}
+			if typ != versions[i].Type || size != int64(len(versions[i].Data)) {
+				t.Errorf("Stat version %d: got (%s, %d), want (%s, %d)", i, typ, size, versions[i].Type, len(versions[i].Data))
+			}
+		}
+	}
+	assertVersions(t)
+
+	objs, err := store.GetMany(t.Context(), s, shas)
+	if err != nil {
+		t.Fatalf("GetMany failed: %v", err)
+	}
+	for i, obj := range objs {
+		if !bytes.Equal(obj.Data, versions[i].Data) {
+			t.Fatalf("GetMany version %d: object did not round-trip", i)
+		}
+	}
+
+	ms, ok := s.(store.MutableStore)
+	if !ok {
+		return
+	}
+	t.Run("Delete", func(t *testing.T) {
+		deleted := []string{shas[0], shas[maxDepth], shas[len(shas)-2]}
+		for _, sha := range deleted {
+			if err := ms.Delete(t.Context(), sha); err != nil {
+				t.Fatalf("Delete %s failed: %v", sha, err)
+			}
+			if ok, err := s.Exists(t.Context(), sha); err != nil || ok {
+				t.Fatalf("Exists %s after Delete = %v, %v", sha, ok, err)
+			}
+		}
+		assertVersions(t, deleted...)
+	})
+	t.Run("DeleteBaseConcurrently", func(t *testing.T) {
+		// whichever of PutDeltas and Delete wins, the new object must
+		// stay readable
+		for i := range 20 {
+			pair := fileVersions(2, 4<<10)
+			pair[0].Data = fmt.Appendf(pair[0].Data, "round %d\n", i)
+			pair[1].Data = fmt.Appendf(pair[1].Data, "round %d\n", i)
+			base, err := s.Put(t.Context(), pair[0])
+			if err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+			var wg sync.WaitGroup
+			wg.Go(func() {
+				if _, err := store.PutDeltas(t.Context(), s, pair[1:], []string{base}); err != nil {
+					t.Errorf("PutDeltas failed: %v", err)
+				}
+			})
+			wg.Go(func() {
+				if err := ms.Delete(t.Context(), base); err != nil {
+					t.Errorf("Delete failed: %v", err)
+				}
+			})
+			wg.Wait()
+			obj, err := s.Get(t.Context(), object.Hash(pair[1]))
+			if err != nil {
+				t.Fatalf("round %d: Get failed: %v", i, err)
+			}
+			if !bytes.Equal(obj.Data, pair[1].Data) {
+				t.Fatalf("round %d: object did not round-trip", i)
+			}
+		}
+	})
+}
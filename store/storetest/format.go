@@ -0,0 +1,85 @@
+package storetest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+// SHA256 checks that s, created with store.WithFormat(object.SHA256) and
+// store.WithValidation, names objects as a SHA-256 repository does on every
+// write path and reads them back under those names.
+func SHA256(t *testing.T, s store.ObjectStore) {
+	// SHAs from git hash-object and git mktree in a repository created with
+	// git init --object-format=sha256
+	const (
+		blobSHA = "2cf8d83d9ee29543b34a87727421fdecb7e3f3a183d337639025de576db9ebb4"
+		treeSHA = "c7187e8fdb691b3a692e5f3f0bbcb6359e5046285225f18f9773d4fe54268c55"
+	)
+	blob := &object.Object{Type: object.TypeBlob, Data: []byte("hello\n")}
+	entry, _ := hex.DecodeString(blobSHA)
+	tree := &object.Object{Type: object.TypeTree, Data: append([]byte("100644 hello.txt\x00"), entry...)}
+
+	assertStored := func(t *testing.T, sha, want string, obj *object.Object) {
+		t.Helper()
+		if sha != want {
+			t.Fatalf("got SHA %s, want %s", sha, want)
+		}
+		got, err := s.Get(t.Context(), sha)
+		if err != nil {
+			t.Fatalf("Get %s failed: %v", sha, err)
+		}
+		if got.Type != obj.Type || !bytes.Equal(got.Data, obj.Data) {
+			t.Errorf("Get %s: object did not round-trip", sha)
+		}
+	}
+
+	t.Run("Put", func(t *testing.T) {
+		sha, err := s.Put(t.Context(), blob)
+		if err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		assertStored(t, sha, blobSHA, blob)
+
+		// validated as a SHA-256 tree, whose entries hold 32-byte SHAs
+		sha, err = s.Put(t.Context(), tree)
+		if err != nil {
+			t.Fatalf("Put tree failed: %v", err)
+		}
+		assertStored(t, sha, treeSHA, tree)
+	})
+
+	t.Run("PutMany", func(t *testing.T) {
+		bs, ok := s.(store.BatchStore)
+		if !ok {
+			t.Skip("not a BatchStore")
+		}
+		shas, err := bs.PutMany(t.Context(), []*object.Object{blob, tree})
+		if err != nil {
+			t.Fatalf("PutMany failed: %v", err)
+		}
+		assertStored(t, shas[0], blobSHA, blob)
+		assertStored(t, shas[1], treeSHA, tree)
+	})
+
+	t.Run("PutStream", func(t *testing.T) {
+		ss, ok := s.(store.StreamStore)
+		if !ok {
+			t.Skip("not a StreamStore")
+		}
+		// one object stored whole and one large enough to be chunked
+		for _, size := range []int{100, 20 << 20} {
+			obj := &object.Object{Type: object.TypeBlob, Data: make([]byte, size)}
+			rand.Read(obj.Data)
+			sha, err := ss.PutStream(t.Context(), obj.Type, int64(size), bytes.NewReader(obj.Data))
+			if err != nil {
+				t.Fatalf("PutStream failed: %v", err)
+			}
+			assertStored(t, sha, object.SHA256.Hash(obj), obj)
+		}
+	})
+}
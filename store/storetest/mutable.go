@@ -0,0 +1,138 @@
+package storetest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+// Mutable runs the MutableStore conformance suite against s. If s also
+// keeps refs, reflogs or streamed chunks, some are written first to check
+// that Iterate skips them. s may hold objects from other tests.
+func Mutable(t *testing.T, s store.MutableStore) {
+	var shas []string
+	for i := range 5 {
+		sha, err := s.Put(t.Context(), &object.Object{Type: object.TypeBlob, Data: fmt.Appendf(nil, "mutable %d\n", i)})
+		if err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		shas = append(shas, sha)
+	}
+	if ss, ok := s.(store.StreamStore); ok {
+		// big enough to be chunked by every backend
+		large := make([]byte, 5<<20)
+		rand.Read(large)
+		sha, err := ss.PutStream(t.Context(), object.TypeBlob, int64(len(large)), bytes.NewReader(large))
+		if err != nil {
+			t.Fatalf("PutStream failed: %v", err)
+		}
+		shas = append(shas, sha)
+	}
+	if rs, ok := s.(store.RefStore); ok {
+		mustUpdate(t, rs, "refs/heads/main", "", shas[0])
+	}
+	if ls, ok := s.(store.ReflogStore); ok {
+		entry := store.ReflogEntry{NewSHA: shas[0], Committer: "test", Time: time.Now()}
+		if err := ls.AppendReflog(t.Context(), "refs/heads/main", entry); err != nil {
+			t.Fatalf("AppendReflog failed: %v", err)
+		}
+	}
+
+	t.Run("Iterate", func(t *testing.T) {
+		var got []string
+		err := s.Iterate(t.Context(), "", func(sha string) error {
+			got = append(got, sha)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Iterate failed: %v", err)
+		}
+		for _, sha := range got {
+			if len(sha) != len(shaA) || strings.Trim(sha, "0123456789abcdef") != "" {
+				t.Errorf("Iterate reported %q, which is not a SHA", sha)
+			}
+		}
+		for _, sha := range shas {
+			if !slices.Contains(got, sha) {
+				t.Errorf("Iterate did not report %s", sha)
+			}
+		}
+		if !slices.IsSorted(got) {
+			t.Error("Iterate did not report SHAs in order")
+		}
+	})
+
+	t.Run("IteratePrefix", func(t *testing.T) {
+		prefix := shas[1][:10]
+		var got []string
+		err := s.Iterate(t.Context(), prefix, func(sha string) error {
+			got = append(got, sha)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Iterate failed: %v", err)
+		}
+		if !slices.Equal(got, []string{shas[1]}) {
+			t.Errorf("got %v, want [%s]", got, shas[1])
+		}
+	})
+
+	t.Run("IterateStop", func(t *testing.T) {
+		calls := 0
+		err := s.Iterate(t.Context(), "", func(sha string) error {
+			calls++
+			return store.ErrStopIteration
+		})
+		if err != nil || calls != 1 {
+			t.Errorf("ErrStopIteration: got %d calls and %v, want 1 and nil", calls, err)
+		}
+
+		errBoom := errors.New("boom")
+		err = s.Iterate(t.Context(), "", func(sha string) error { return errBoom })
+		if err != errBoom {
+			t.Errorf("expected the callback's error, got %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		// deleting from inside the callback must not disturb the walk.
+		deleted := map[string]bool{shas[2]: true, shas[len(shas)-1]: true}
+		err := s.Iterate(t.Context(), "", func(sha string) error {
+			if deleted[sha] {
+				return s.Delete(t.Context(), sha)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Iterate with Delete failed: %v", err)
+		}
+
+		for sha := range deleted {
+			if exists, err := s.Exists(t.Context(), sha); err != nil || exists {
+				t.Errorf("Exists %s after Delete: got (%v, %v), want (false, nil)", sha, exists, err)
+			}
+			if _, err := s.Get(t.Context(), sha); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("Get %s after Delete: expected ErrNotFound, got %v", sha, err)
+			}
+			if err := s.Delete(t.Context(), sha); err != nil {
+				t.Errorf("deleting %s again: %v", sha, err)
+			}
+		}
+		if exists, err := s.Exists(t.Context(), shas[3]); err != nil || !exists {
+			t.Errorf("Delete removed the wrong object: Exists %s got (%v, %v)", shas[3], exists, err)
+		}
+		if rs, ok := s.(store.RefStore); ok {
+			if _, err := rs.GetRef(t.Context(), "refs/heads/main"); err != nil {
+				t.Errorf("GetRef after deleting objects: %v", err)
+			}
+		}
+	})
+}
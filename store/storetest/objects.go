@@ -0,0 +1,95 @@
+package storetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+// Cancelled checks that every ObjectStore method gives up with the
+// context's error once the context is cancelled.
+func Cancelled(t *testing.T, s store.ObjectStore) {
+	sha, err := s.Put(t.Context(), &object.Object{Type: object.TypeBlob, Data: []byte("hello\n")})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	if _, err := s.Put(ctx, &object.Object{Type: object.TypeBlob, Data: []byte("other\n")}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Put: expected context.Canceled, got %v", err)
+	}
+	if _, err := s.Get(ctx, sha); !errors.Is(err, context.Canceled) {
+		t.Errorf("Get: expected context.Canceled, got %v", err)
+	}
+	if _, err := s.Exists(ctx, sha); !errors.Is(err, context.Canceled) {
+		t.Errorf("Exists: expected context.Canceled, got %v", err)
+	}
+}
+
+// Faults gives the error suite a way to break each backend.
+type Faults struct {
+	// Store is a working, empty store.
+	Store store.ObjectStore
+	// Corrupt overwrites the stored bytes of sha in Store with garbage.
+	Corrupt func(sha string)
+	// Unavailable is a store whose backend cannot be reached, such as a
+	// closed database or an S3 endpoint nothing listens on.
+	Unavailable store.ObjectStore
+}
+
+// Errors checks that a backend reports missing objects, corrupt objects
+// and outages with store.ErrNotFound, store.ErrCorrupt and
+// store.ErrUnavailable, and never confuses one for another.
+func Errors(t *testing.T, f Faults) {
+	t.Run("NotFound", func(t *testing.T) {
+		_, err := f.Store.Get(t.Context(), missingSHA)
+		if !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Get: expected ErrNotFound, got %v", err)
+		}
+		if errors.Is(err, store.ErrUnavailable) || errors.Is(err, store.ErrCorrupt) {
+			t.Errorf("Get: missing object reported as a failure: %v", err)
+		}
+		exists, err := f.Store.Exists(t.Context(), missingSHA)
+		if err != nil || exists {
+			t.Errorf("Exists: got (%v, %v), want (false, nil)", exists, err)
+		}
+	})
+
+	t.Run("Corrupt", func(t *testing.T) {
+		sha, err := f.Store.Put(t.Context(), &object.Object{Type: object.TypeBlob, Data: []byte("hello\n")})
+		if err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		f.Corrupt(sha)
+
+		_, err = f.Store.Get(t.Context(), sha)
+		if !errors.Is(err, store.ErrCorrupt) {
+			t.Errorf("Get: expected ErrCorrupt, got %v", err)
+		}
+		if errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Get: corrupt object reported as missing: %v", err)
+		}
+	})
+
+	t.Run("Unavailable", func(t *testing.T) {
+		_, err := f.Unavailable.Put(t.Context(), &object.Object{Type: object.TypeBlob, Data: []byte("hello\n")})
+		if !errors.Is(err, store.ErrUnavailable) {
+			t.Errorf("Put: expected ErrUnavailable, got %v", err)
+		}
+		_, err = f.Unavailable.Get(t.Context(), missingSHA)
+		if !errors.Is(err, store.ErrUnavailable) {
+			t.Errorf("Get: expected ErrUnavailable, got %v", err)
+		}
+		if errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Get: outage reported as a missing object: %v", err)
+		}
+		if _, err := f.Unavailable.Exists(t.Context(), missingSHA); !errors.Is(err, store.ErrUnavailable) {
+			t.Errorf("Exists: expected ErrUnavailable, got %v", err)
+		}
+	})
+}
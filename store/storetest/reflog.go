@@ -0,0 +1,121 @@
+package storetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"git.wyat.me/git-storage/store"
+)
+
+// LoggedRefStore is a backend that holds both refs and their reflogs.
+type LoggedRefStore interface {
+	store.RefStore
+	store.ReflogStore
+}
+
+// Reflog runs the reflog conformance suite. newStore must return an empty
+// store for each call.
+func Reflog(t *testing.T, newStore func(t *testing.T) LoggedRefStore) {
+	t.Run("RecordsUpdates", func(t *testing.T) {
+		s := newStore(t)
+		rs := store.NewLoggingRefStore(s, s, "alice", "push")
+
+		mustUpdate(t, rs, "refs/heads/main", "", shaA)
+		mustUpdate(t, rs, "refs/heads/main", shaA, shaB)
+		if err := rs.DeleteRef(t.Context(), "refs/heads/main", ""); err != nil {
+			t.Fatalf("DeleteRef failed: %v", err)
+		}
+
+		entries, err := s.Reflog(t.Context(), "refs/heads/main")
+		if err != nil {
+			t.Fatalf("Reflog failed: %v", err)
+		}
+		want := []store.ReflogEntry{
+			{OldSHA: shaB, NewSHA: ""},
+			{OldSHA: shaA, NewSHA: shaB},
+			{OldSHA: "", NewSHA: shaA},
+		}
+		if len(entries) != len(want) {
+			t.Fatalf("got %d entries, want %d: %+v", len(entries), len(want), entries)
+		}
+		for i, e := range entries {
+			if e.OldSHA != want[i].OldSHA || e.NewSHA != want[i].NewSHA {
+				t.Errorf("entry %d: got %s -> %s, want %s -> %s", i, e.OldSHA, e.NewSHA, want[i].OldSHA, want[i].NewSHA)
+			}
+			if e.Committer != "alice" || e.Message != "push" || e.Time.IsZero() {
+				t.Errorf("entry %d: got %+v, want committer, message and time", i, e)
+			}
+		}
+	})
+
+	t.Run("SkipsRejectedUpdates", func(t *testing.T) {
+		s := newStore(t)
+		rs := store.NewLoggingRefStore(s, s, "alice", "push")
+		mustUpdate(t, rs, "refs/heads/main", "", shaA)
+
+		if err := rs.UpdateRef(t.Context(), "refs/heads/main", shaB, shaA); err == nil {
+			t.Fatal("expected stale update to fail")
+		}
+		err := rs.UpdateRefs(t.Context(), []store.RefUpdate{
+			{Name: "refs/heads/dev", NewSHA: shaA},
+			{Name: "refs/heads/main", OldSHA: shaB, NewSHA: shaA},
+		})
+		if err == nil {
+			t.Fatal("expected stale transaction to fail")
+		}
+
+		for name, want := range map[string]int{"refs/heads/main": 1, "refs/heads/dev": 0} {
+			entries, err := s.Reflog(t.Context(), name)
+			if err != nil {
+				t.Fatalf("Reflog failed: %v", err)
+			}
+			if len(entries) != want {
+				t.Errorf("%s has %d entries, want %d", name, len(entries), want)
+			}
+		}
+	})
+
+	t.Run("AppendFailureKeepsUpdate", func(t *testing.T) {
+		s := newStore(t)
+		rs := store.NewLoggingRefStore(s, failingReflog{s}, "alice", "push")
+		var failed []string
+		rs.LogFailed = func(name string, err error) { failed = append(failed, name) }
+
+		// the ref moves even though its entry cannot be written, and the
+		// caller is told the update succeeded
+		mustUpdate(t, rs, "refs/heads/main", "", shaA)
+		ref, err := s.GetRef(t.Context(), "refs/heads/main")
+		if err != nil || ref.SHA != shaA {
+			t.Fatalf("GetRef = %+v, %v, want %s", ref, err, shaA)
+		}
+		if len(failed) != 1 || failed[0] != "refs/heads/main" {
+			t.Errorf("LogFailed called for %v, want refs/heads/main", failed)
+		}
+	})
+
+	t.Run("SeparatesNestedNames", func(t *testing.T) {
+		s := newStore(t)
+		for _, name := range []string{"refs/heads/a", "refs/heads/a/b", "refs/heads/ab"} {
+			if err := s.AppendReflog(t.Context(), name, store.ReflogEntry{NewSHA: shaA, Message: name}); err != nil {
+				t.Fatalf("AppendReflog failed: %v", err)
+			}
+		}
+		entries, err := s.Reflog(t.Context(), "refs/heads/a")
+		if err != nil {
+			t.Fatalf("Reflog failed: %v", err)
+		}
+		if len(entries) != 1 || entries[0].Message != "refs/heads/a" {
+			t.Errorf("got %+v, want only the entry for refs/heads/a", entries)
+		}
+	})
+}
+
+// failingReflog is a ReflogStore whose appends always fail.
+type failingReflog struct {
+	store.ReflogStore
+}
+
+func (failingReflog) AppendReflog(ctx context.Context, name string, entry store.ReflogEntry) error {
+	return errors.New("reflog unavailable")
+}
@@ -0,0 +1,209 @@
+// Package storetest holds conformance tests shared by every store backend.
+package storetest
+
+import (
+	"errors"
+	"testing"
+
+	"git.wyat.me/git-storage/store"
+)
+
+const (
+	shaA = "ce013625030ba8dba906f756967f9e9ca394464a"
+	shaB = "94954abda49de8615a048f8d2e64b5de848e27a1"
+	// missingSHA is never stored by any test.
+	missingSHA = "1111111111111111111111111111111111111111"
+)
+
+// RefStore runs the ref store conformance suite. newStore must return an
+// empty store for each call.
+func RefStore(t *testing.T, newStore func(t *testing.T) store.RefStore) {
+	t.Run("CreateAndGet", func(t *testing.T) {
+		rs := newStore(t)
+
+		if _, err := rs.GetRef(t.Context(), "refs/heads/main"); !errors.Is(err, store.ErrRefNotFound) {
+			t.Fatalf("expected ErrRefNotFound before create, got %v", err)
+		}
+		if err := rs.UpdateRef(t.Context(), "refs/heads/main", "", shaA); err != nil {
+			t.Fatalf("create failed: %v", err)
+		}
+		ref, err := rs.GetRef(t.Context(), "refs/heads/main")
+		if err != nil {
+			t.Fatalf("GetRef failed: %v", err)
+		}
+		if ref.SHA != shaA || ref.Target != "" {
+			t.Errorf("got %+v, want SHA %s", ref, shaA)
+		}
+		if err := rs.UpdateRef(t.Context(), "refs/heads/main", "", shaB); !errors.Is(err, store.ErrRefConflict) {
+			t.Errorf("expected ErrRefConflict creating an existing ref, got %v", err)
+		}
+	})
+
+	t.Run("CompareAndSwap", func(t *testing.T) {
+		rs := newStore(t)
+		mustUpdate(t, rs, "refs/heads/main", "", shaA)
+
+		if err := rs.UpdateRef(t.Context(), "refs/heads/main", shaB, shaA); !errors.Is(err, store.ErrRefConflict) {
+			t.Errorf("expected ErrRefConflict for stale old value, got %v", err)
+		}
+		if err := rs.UpdateRef(t.Context(), "refs/heads/missing", shaA, shaB); !errors.Is(err, store.ErrRefConflict) {
+			t.Errorf("expected ErrRefConflict updating a missing ref, got %v", err)
+		}
+		mustUpdate(t, rs, "refs/heads/main", shaA, shaB)
+
+		ref, err := rs.GetRef(t.Context(), "refs/heads/main")
+		if err != nil {
+			t.Fatalf("GetRef failed: %v", err)
+		}
+		if ref.SHA != shaB {
+			t.Errorf("got %s after update, want %s", ref.SHA, shaB)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		rs := newStore(t)
+		mustUpdate(t, rs, "refs/heads/main", "", shaA)
+
+		if err := rs.DeleteRef(t.Context(), "refs/heads/main", shaB); !errors.Is(err, store.ErrRefConflict) {
+			t.Errorf("expected ErrRefConflict deleting with stale value, got %v", err)
+		}
+		if err := rs.DeleteRef(t.Context(), "refs/heads/main", shaA); err != nil {
+			t.Fatalf("DeleteRef failed: %v", err)
+		}
+		if _, err := rs.GetRef(t.Context(), "refs/heads/main"); !errors.Is(err, store.ErrRefNotFound) {
+			t.Errorf("expected ErrRefNotFound after delete, got %v", err)
+		}
+		refs, err := rs.ListRefs(t.Context(), "refs/")
+		if err != nil {
+			t.Fatalf("ListRefs failed: %v", err)
+		}
+		if len(refs) != 0 {
+			t.Errorf("deleted ref still listed: %+v", refs)
+		}
+
+		// a deleted ref can be created again
+		mustUpdate(t, rs, "refs/heads/main", "", shaB)
+	})
+
+	t.Run("ListByPrefix", func(t *testing.T) {
+		rs := newStore(t)
+		mustUpdate(t, rs, "refs/heads/main", "", shaA)
+		mustUpdate(t, rs, "refs/heads/dev", "", shaB)
+		mustUpdate(t, rs, "refs/tags/v1", "", shaA)
+		if err := rs.SetSymbolicRef(t.Context(), "HEAD", "refs/heads/main"); err != nil {
+			t.Fatalf("SetSymbolicRef failed: %v", err)
+		}
+
+		refs, err := rs.ListRefs(t.Context(), "refs/heads/")
+		if err != nil {
+			t.Fatalf("ListRefs failed: %v", err)
+		}
+		if len(refs) != 2 || refs[0].Name != "refs/heads/dev" || refs[1].Name != "refs/heads/main" {
+			t.Errorf("got %+v, want dev and main in name order", refs)
+		}
+
+		all, err := rs.ListRefs(t.Context(), "")
+		if err != nil {
+			t.Fatalf("ListRefs failed: %v", err)
+		}
+		if len(all) != 4 {
+			t.Errorf("got %d refs with empty prefix, want 4", len(all))
+		}
+	})
+
+	t.Run("SymbolicRef", func(t *testing.T) {
+		rs := newStore(t)
+		if err := rs.SetSymbolicRef(t.Context(), "HEAD", "refs/heads/main"); err != nil {
+			t.Fatalf("SetSymbolicRef failed: %v", err)
+		}
+
+		head, err := rs.GetRef(t.Context(), "HEAD")
+		if err != nil {
+			t.Fatalf("GetRef failed: %v", err)
+		}
+		if head.Target != "refs/heads/main" || head.SHA != "" {
+			t.Errorf("got %+v, want symbolic ref to main", head)
+		}
+		if _, err := store.ResolveRef(t.Context(), rs, "HEAD"); !errors.Is(err, store.ErrRefNotFound) {
+			t.Errorf("expected ErrRefNotFound resolving unborn HEAD, got %v", err)
+		}
+
+		mustUpdate(t, rs, "refs/heads/main", "", shaA)
+		resolved, err := store.ResolveRef(t.Context(), rs, "HEAD")
+		if err != nil {
+			t.Fatalf("ResolveRef failed: %v", err)
+		}
+		if resolved.SHA != shaA || resolved.Target != "refs/heads/main" {
+			t.Errorf("got %+v, want HEAD resolved to %s", resolved, shaA)
+		}
+	})
+
+	t.Run("Transaction", func(t *testing.T) {
+		rs := newStore(t)
+		mustUpdate(t, rs, "refs/heads/main", "", shaA)
+		mustUpdate(t, rs, "refs/heads/old", "", shaA)
+
+		err := rs.UpdateRefs(t.Context(), []store.RefUpdate{
+			{Name: "refs/heads/main", OldSHA: shaA, NewSHA: shaB},
+			{Name: "refs/heads/new", NewSHA: shaA},
+			{Name: "refs/heads/old", OldSHA: shaA},
+		})
+		if err != nil {
+			t.Fatalf("UpdateRefs failed: %v", err)
+		}
+
+		refs, err := rs.ListRefs(t.Context(), "refs/")
+		if err != nil {
+			t.Fatalf("ListRefs failed: %v", err)
+		}
+		if len(refs) != 2 || refs[0].Name != "refs/heads/main" || refs[0].SHA != shaB ||
+			refs[1].Name != "refs/heads/new" || refs[1].SHA != shaA {
+			t.Errorf("got %+v, want main at %s and new at %s", refs, shaB, shaA)
+		}
+	})
+
+	t.Run("TransactionRejected", func(t *testing.T) {
+		rs := newStore(t)
+		mustUpdate(t, rs, "refs/heads/main", "", shaA)
+		mustUpdate(t, rs, "refs/heads/dev", "", shaA)
+
+		err := rs.UpdateRefs(t.Context(), []store.RefUpdate{
+			{Name: "refs/heads/new", NewSHA: shaB},
+			{Name: "refs/heads/main", OldSHA: shaB, NewSHA: shaA},
+			{Name: "refs/heads/dev", NewSHA: shaB},
+		})
+		var txErr *store.TransactionError
+		if !errors.As(err, &txErr) {
+			t.Fatalf("expected *TransactionError, got %v", err)
+		}
+		if !errors.Is(err, store.ErrRefConflict) {
+			t.Errorf("expected error to wrap ErrRefConflict, got %v", err)
+		}
+		if len(txErr.Rejected) != 2 || txErr.Rejected[0].Name != "refs/heads/main" || txErr.Rejected[1].Name != "refs/heads/dev" {
+			t.Errorf("got rejections %+v, want main and dev", txErr.Rejected)
+		}
+
+		if _, err := rs.GetRef(t.Context(), "refs/heads/new"); !errors.Is(err, store.ErrRefNotFound) {
+			t.Errorf("new was created by a rejected transaction: %v", err)
+		}
+
+		err = rs.UpdateRefs(t.Context(), []store.RefUpdate{
+			{Name: "refs/heads/main", OldSHA: shaA, NewSHA: shaB},
+			{Name: "refs/heads/main", OldSHA: shaB, NewSHA: shaA},
+		})
+		if !errors.Is(err, store.ErrDuplicateRef) {
+			t.Errorf("expected ErrDuplicateRef, got %v", err)
+		}
+		ref, err := rs.GetRef(t.Context(), "refs/heads/main")
+		if err != nil || ref.SHA != shaA {
+			t.Errorf("main is %+v (%v) after rejected transactions, want %s", ref, err, shaA)
+		}
+	})
+}
+
+func mustUpdate(t *testing.T, rs store.RefStore, name, oldSHA, newSHA string) {
+	t.Helper()
+	if err := rs.UpdateRef(t.Context(), name, oldSHA, newSHA); err != nil {
+		t.Fatalf("UpdateRef %s failed: %v", name, err)
+	}
+}
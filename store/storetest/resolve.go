@@ -0,0 +1,88 @@
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+// Resolve runs the store.Resolve conformance suite against s. s may hold
+// objects from other tests.
+func Resolve(t *testing.T, s store.MutableStore) {
+	// find two blobs whose SHAs share their first store.MinAbbrev digits;
+	// by the birthday bound a few hundred tries are enough.
+	seen := map[string]*object.Object{}
+	var a, b *object.Object
+	for i := 0; a == nil; i++ {
+		obj := &object.Object{Type: object.TypeBlob, Data: fmt.Appendf(nil, "resolve %d\n", i)}
+		short := object.Hash(obj)[:store.MinAbbrev]
+		if prev, ok := seen[short]; ok {
+			a, b = prev, obj
+		}
+		seen[short] = obj
+	}
+	shaA, err := s.Put(t.Context(), a)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	shaB, err := s.Put(t.Context(), b)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	t.Run("Unique", func(t *testing.T) {
+		for _, prefix := range []string{shaA[:12], strings.ToUpper(shaA[:12]), shaA} {
+			got, err := store.Resolve(t.Context(), s, prefix)
+			if err != nil {
+				t.Fatalf("Resolve %s failed: %v", prefix, err)
+			}
+			if got != shaA {
+				t.Errorf("Resolve %s: got %s, want %s", prefix, got, shaA)
+			}
+		}
+	})
+
+	t.Run("Ambiguous", func(t *testing.T) {
+		_, err := store.Resolve(t.Context(), s, shaA[:store.MinAbbrev])
+		if !errors.Is(err, store.ErrAmbiguous) {
+			t.Fatalf("expected ErrAmbiguous, got %v", err)
+		}
+		var amb *store.AmbiguousError
+		if !errors.As(err, &amb) {
+			t.Fatalf("expected *AmbiguousError, got %T", err)
+		}
+		found := 0
+		for _, sha := range amb.Candidates {
+			if sha == shaA || sha == shaB {
+				found++
+			}
+		}
+		if found != 2 {
+			t.Errorf("candidates %v do not include %s and %s", amb.Candidates, shaA, shaB)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		// the same SHA with its last digit changed
+		last := "0"
+		if shaA[len(shaA)-1] == '0' {
+			last = "1"
+		}
+		_, err := store.Resolve(t.Context(), s, shaA[:len(shaA)-1]+last)
+		if !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, prefix := range []string{"", shaA[:store.MinAbbrev-1], "xyz123", "ab%d"} {
+			if _, err := store.Resolve(t.Context(), s, prefix); !errors.Is(err, store.ErrInvalidAbbrev) {
+				t.Errorf("Resolve %q: expected ErrInvalidAbbrev, got %v", prefix, err)
+			}
+		}
+	})
+}
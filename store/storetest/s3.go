@@ -0,0 +1,499 @@
+package storetest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// S3 is an in-process stand-in for the parts of the S3 API that the minio
+// and s3pack stores use, so that their tests run without a MinIO server.
+// It keeps everything in memory and does not check signatures.
+type S3 struct {
+	srv *httptest.Server
+
+	mu      sync.Mutex
+	buckets map[string]map[string]*s3Object
+	uploads map[string]*s3Upload
+	nextID  int
+	fail    func(r *http.Request) bool
+}
+
+type s3Object struct {
+	data        []byte
+	etag        string
+	modified    time.Time
+	contentType string
+	meta        http.Header
+}
+
+type s3Upload struct {
+	bucket, key string
+	contentType string
+	meta        http.Header
+	parts       map[int]*s3Object
+}
+
+var sharedS3 = sync.OnceValue(func() *S3 { return newS3() })
+
+// S3Endpoint returns the host:port of the S3 server for tests to use: the
+// MinIO server named by MINIO_ENDPOINT if there is one, and otherwise a
+// stand-in shared by the whole test binary. Either takes the credentials
+// minioadmin, minioadmin.
+func S3Endpoint() string {
+	if endpoint := os.Getenv("MINIO_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	return sharedS3().Endpoint()
+}
+
+// NewS3 starts a stand-in of a test's own, which is stopped when the test
+// ends, for tests that make requests fail with FailWhen.
+func NewS3(t *testing.T) *S3 {
+	s := newS3()
+	t.Cleanup(s.srv.Close)
+	return s
+}
+
+func newS3() *S3 {
+	s := &S3{buckets: make(map[string]map[string]*s3Object), uploads: make(map[string]*s3Upload)}
+	s.srv = httptest.NewServer(s)
+	return s
+}
+
+// Endpoint returns the stand-in's host:port.
+func (s *S3) Endpoint() string {
+	return s.srv.Listener.Addr().String()
+}
+
+// FailWhen makes every request that fail returns true for fail with
+// AccessDenied, which clients do not retry. A nil fail stops the failures.
+func (s *S3) FailWhen(fail func(r *http.Request) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = fail
+}
+
+func (s *S3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// bodies are read before the lock is taken, so that a slow client
+	// holds up no one else
+	body, err := readS3Body(r)
+	if err != nil {
+		s3Error(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail != nil && s.fail(r) {
+		s3Error(w, r, http.StatusForbidden, "AccessDenied", "failure injected by the test")
+		return
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if key == "" {
+		s.serveBucket(w, r, bucket, body)
+		return
+	}
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		s3Error(w, r, http.StatusNotFound, "NoSuchBucket", "bucket "+bucket+" does not exist")
+		return
+	}
+	s.serveObject(w, r, objects, bucket, key, body)
+}
+
+// readS3Body reads a request body, decoding the aws-chunked encoding
+// clients use to sign a payload as they stream it over plain HTTP.
+func readS3Body(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+	var body bytes.Buffer
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		hexSize, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(hexSize, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad chunk size %q", line)
+		}
+		if size == 0 {
+			// trailers, if any, are not checked
+			io.Copy(io.Discard, br)
+			return body.Bytes(), nil
+		}
+		if _, err := io.CopyN(&body, br, size); err != nil {
+			return nil, err
+		}
+		if _, err := br.Discard(2); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s *S3) serveBucket(w http.ResponseWriter, r *http.Request, bucket string, body []byte) {
+	q := r.URL.Query()
+	if r.Method == http.MethodPut {
+		if _, ok := s.buckets[bucket]; !ok {
+			s.buckets[bucket] = make(map[string]*s3Object)
+		}
+		return
+	}
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		s3Error(w, r, http.StatusNotFound, "NoSuchBucket", "bucket "+bucket+" does not exist")
+		return
+	}
+	switch {
+	case r.Method == http.MethodHead:
+	case r.Method == http.MethodGet && q.Has("location"):
+		writeXML(w, http.StatusOK, struct {
+			XMLName xml.Name `xml:"LocationConstraint"`
+		}{})
+	case r.Method == http.MethodGet:
+		writeXML(w, http.StatusOK, listObjects(bucket, objects, q.Get("prefix"), q.Get("delimiter"), q.Get("start-after")))
+	case r.Method == http.MethodPost && q.Has("delete"):
+		var req struct {
+			Objects []struct{ Key string } `xml:"Object"`
+		}
+		if err := xml.Unmarshal(body, &req); err != nil {
+			s3Error(w, r, http.StatusBadRequest, "MalformedXML", err.Error())
+			return
+		}
+		type deleted struct{ Key string }
+		var res struct {
+			XMLName xml.Name  `xml:"DeleteResult"`
+			Deleted []deleted `xml:"Deleted"`
+		}
+		for _, o := range req.Objects {
+			delete(objects, o.Key)
+			res.Deleted = append(res.Deleted, deleted{o.Key})
+		}
+		writeXML(w, http.StatusOK, res)
+	default:
+		s3Error(w, r, http.StatusNotImplemented, "NotImplemented", r.Method+" "+r.URL.String())
+	}
+}
+
+type s3Content struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type s3ListResult struct {
+	XMLName        xml.Name `xml:"ListBucketResult"`
+	Name           string
+	Prefix         string
+	Delimiter      string
+	KeyCount       int
+	MaxKeys        int
+	IsTruncated    bool
+	Contents       []s3Content
+	CommonPrefixes []struct{ Prefix string }
+}
+
+// listObjects answers a ListObjectsV2 request in a single page.
+func listObjects(bucket string, objects map[string]*s3Object, prefix, delimiter, startAfter string) s3ListResult {
+	res := s3ListResult{Name: bucket, Prefix: prefix, Delimiter: delimiter, MaxKeys: 1000}
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		rest, ok := strings.CutPrefix(key, prefix)
+		if !ok || key <= startAfter {
+			continue
+		}
+		if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+			p := prefix + rest[:i+len(delimiter)]
+			if !seen[p] {
+				seen[p] = true
+				res.CommonPrefixes = append(res.CommonPrefixes, struct{ Prefix string }{p})
+			}
+			continue
+		}
+		o := objects[key]
+		res.Contents = append(res.Contents, s3Content{
+			Key:          key,
+			LastModified: o.modified.Format("2006-01-02T15:04:05.000Z"),
+			ETag:         `"` + o.etag + `"`,
+			Size:         int64(len(o.data)),
+			StorageClass: "STANDARD",
+		})
+	}
+	res.KeyCount = len(res.Contents) + len(res.CommonPrefixes)
+	return res
+}
+
+func (s *S3) serveObject(w http.ResponseWriter, r *http.Request, objects map[string]*s3Object, bucket, key string, body []byte) {
+	q := r.URL.Query()
+	switch {
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		s.nextID++
+		id := strconv.Itoa(s.nextID)
+		s.uploads[id] = &s3Upload{bucket: bucket, key: key, contentType: r.Header.Get("Content-Type"), meta: userMeta(r.Header), parts: make(map[int]*s3Object)}
+		writeXML(w, http.StatusOK, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadID string `xml:"UploadId"`
+		}{Bucket: bucket, Key: key, UploadID: id})
+
+	case q.Has("uploadId"):
+		s.serveUpload(w, r, objects, bucket, key, body)
+
+	case r.Method == http.MethodPut:
+		if r.Header.Get("X-Amz-Copy-Source") != "" {
+			src, ok := s.copySource(w, r)
+			if !ok {
+				return
+			}
+			o := &s3Object{data: src.data, contentType: src.contentType, meta: src.meta}
+			if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+				o.contentType, o.meta = r.Header.Get("Content-Type"), userMeta(r.Header)
+			}
+			o = putObject(objects, key, o)
+			writeXML(w, http.StatusOK, struct {
+				XMLName      xml.Name `xml:"CopyObjectResult"`
+				ETag         string
+				LastModified string
+			}{ETag: `"` + o.etag + `"`, LastModified: o.modified.Format("2006-01-02T15:04:05.000Z")})
+			return
+		}
+		if !preconditions(objects[key], r.Header) {
+			s3Error(w, r, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the preconditions you specified did not hold")
+			return
+		}
+		o := putObject(objects, key, &s3Object{data: body, contentType: r.Header.Get("Content-Type"), meta: userMeta(r.Header)})
+		w.Header().Set("ETag", `"`+o.etag+`"`)
+
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		o, ok := objects[key]
+		if !ok {
+			s3Error(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+			return
+		}
+		data, status := o.data, http.StatusOK
+		if rng := r.Header.Get("Range"); rng != "" {
+			start, end, ok := parseRange(rng, int64(len(o.data)))
+			if !ok {
+				s3Error(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable")
+				return
+			}
+			data, status = o.data[start:end+1], http.StatusPartialContent
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(o.data)))
+		}
+		for k, v := range o.meta {
+			w.Header()[k] = v
+		}
+		w.Header().Set("ETag", `"`+o.etag+`"`)
+		w.Header().Set("Last-Modified", o.modified.Format(http.TimeFormat))
+		w.Header().Set("Content-Type", o.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+
+	case r.Method == http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		s3Error(w, r, http.StatusNotImplemented, "NotImplemented", r.Method+" "+r.URL.String())
+	}
+}
+
+// serveUpload handles the requests of a multipart upload after the first.
+func (s *S3) serveUpload(w http.ResponseWriter, r *http.Request, objects map[string]*s3Object, bucket, key string, body []byte) {
+	q := r.URL.Query()
+	id := q.Get("uploadId")
+	u, ok := s.uploads[id]
+	if !ok || u.bucket != bucket || u.key != key {
+		s3Error(w, r, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		n, err := strconv.Atoi(q.Get("partNumber"))
+		if err != nil {
+			s3Error(w, r, http.StatusBadRequest, "InvalidArgument", "bad part number")
+			return
+		}
+		data := body
+		if r.Header.Get("X-Amz-Copy-Source") != "" {
+			src, ok := s.copySource(w, r)
+			if !ok {
+				return
+			}
+			data = src.data
+			if rng := r.Header.Get("X-Amz-Copy-Source-Range"); rng != "" {
+				start, end, ok := parseRange(rng, int64(len(data)))
+				if !ok {
+					s3Error(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable")
+					return
+				}
+				data = data[start : end+1]
+			}
+		}
+		part := &s3Object{data: data, etag: etag(data), modified: time.Now()}
+		u.parts[n] = part
+		if r.Header.Get("X-Amz-Copy-Source") != "" {
+			writeXML(w, http.StatusOK, struct {
+				XMLName      xml.Name `xml:"CopyPartResult"`
+				ETag         string
+				LastModified string
+			}{ETag: `"` + part.etag + `"`, LastModified: part.modified.Format("2006-01-02T15:04:05.000Z")})
+			return
+		}
+		w.Header().Set("ETag", `"`+part.etag+`"`)
+
+	case http.MethodPost:
+		var req struct {
+			Parts []struct{ PartNumber int } `xml:"Part"`
+		}
+		if err := xml.Unmarshal(body, &req); err != nil {
+			s3Error(w, r, http.StatusBadRequest, "MalformedXML", err.Error())
+			return
+		}
+		var data []byte
+		for _, p := range req.Parts {
+			part, ok := u.parts[p.PartNumber]
+			if !ok {
+				s3Error(w, r, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d was not uploaded", p.PartNumber))
+				return
+			}
+			data = append(data, part.data...)
+		}
+		delete(s.uploads, id)
+		o := putObject(objects, key, &s3Object{data: data, contentType: u.contentType, meta: u.meta})
+		writeXML(w, http.StatusOK, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string
+			Key     string
+			ETag    string
+		}{Bucket: bucket, Key: key, ETag: `"` + o.etag + `"`})
+
+	case http.MethodDelete:
+		delete(s.uploads, id)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		s3Error(w, r, http.StatusNotImplemented, "NotImplemented", r.Method+" "+r.URL.String())
+	}
+}
+
+// copySource returns the object named by a copy request's
+// X-Amz-Copy-Source header, writing the error if there is none.
+func (s *S3) copySource(w http.ResponseWriter, r *http.Request) (*s3Object, bool) {
+	name, err := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+	if err == nil {
+		name, _, _ = strings.Cut(name, "?")
+		bucket, key, _ := strings.Cut(name, "/")
+		if o, ok := s.buckets[bucket][key]; ok {
+			return o, true
+		}
+	}
+	s3Error(w, r, http.StatusNotFound, "NoSuchKey", "The specified copy source does not exist.")
+	return nil, false
+}
+
+// putObject puts o under key, filling in its ETag and time.
+func putObject(objects map[string]*s3Object, key string, o *s3Object) *s3Object {
+	o.etag = etag(o.data)
+	o.modified = time.Now()
+	objects[key] = o
+	return o
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// preconditions checks a PUT's If-Match and If-None-Match headers against
+// the object it would replace, which is nil if there is none.
+func preconditions(o *s3Object, h http.Header) bool {
+	if match := h.Get("If-Match"); match != "" {
+		return o != nil && strings.Trim(match, `"`) == o.etag
+	}
+	if none := h.Get("If-None-Match"); none != "" {
+		return o == nil || (none != "*" && strings.Trim(none, `"`) != o.etag)
+	}
+	return true
+}
+
+func userMeta(h http.Header) http.Header {
+	meta := make(http.Header)
+	for k, v := range h {
+		if strings.HasPrefix(k, "X-Amz-Meta-") {
+			meta[k] = v
+		}
+	}
+	return meta
+}
+
+// parseRange parses "bytes=start-end" for an object of size bytes,
+// clamping end to the last byte.
+func parseRange(rng string, size int64) (int64, int64, bool) {
+	spec, ok := strings.CutPrefix(rng, "bytes=")
+	if !ok {
+		return 0, 0, false
+	}
+	first, last, _ := strings.Cut(spec, "-")
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	return start, end, true
+}
+
+func writeXML(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+// s3Error writes an S3 error response. Answers to HEAD requests have no
+// body, and clients tell their errors apart by status alone.
+func s3Error(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	writeXML(w, status, struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{Code: code, Message: message})
+}
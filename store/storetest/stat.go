@@ -0,0 +1,73 @@
+package storetest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+// Stat runs the StatStore conformance suite against s. Objects are also
+// written through PutMany and PutStream when s supports them, since each
+// write path must record the type and size.
+func Stat(t *testing.T, s store.StatStore) {
+	type want struct {
+		typ  object.ObjectType
+		size int64
+	}
+	cases := map[string]want{}
+
+	tree := &object.Object{Type: object.TypeTree, Data: []byte("100644 stat\x00aaaaaaaaaaaaaaaaaaaa")}
+	sha, err := s.Put(t.Context(), tree)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	cases[sha] = want{object.TypeTree, int64(len(tree.Data))}
+
+	if bs, ok := s.(store.BatchStore); ok {
+		objs := []*object.Object{
+			{Type: object.TypeBlob, Data: []byte("stat batch\n")},
+			{Type: object.TypeBlob, Data: nil},
+		}
+		shas, err := bs.PutMany(t.Context(), objs)
+		if err != nil {
+			t.Fatalf("PutMany failed: %v", err)
+		}
+		for i, sha := range shas {
+			cases[sha] = want{objs[i].Type, int64(len(objs[i].Data))}
+		}
+	}
+	if ss, ok := s.(store.StreamStore); ok {
+		// one object stored whole and one large enough to be chunked
+		for _, size := range []int{100, 20 << 20} {
+			data := make([]byte, size)
+			rand.Read(data)
+			sha, err := ss.PutStream(t.Context(), object.TypeBlob, int64(size), bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("PutStream failed: %v", err)
+			}
+			cases[sha] = want{object.TypeBlob, int64(size)}
+		}
+	}
+
+	t.Run("Stat", func(t *testing.T) {
+		for sha, w := range cases {
+			typ, size, err := s.Stat(t.Context(), sha)
+			if err != nil {
+				t.Fatalf("Stat %s failed: %v", sha, err)
+			}
+			if typ != w.typ || size != w.size {
+				t.Errorf("Stat %s: got (%s, %d), want (%s, %d)", sha, typ, size, w.typ, w.size)
+			}
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		if _, _, err := s.Stat(t.Context(), "0000000000000000000000000000000000000000"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+}
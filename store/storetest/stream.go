@@ -0,0 +1,82 @@
+package storetest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+// Stream runs the StreamStore conformance suite against s.
+func Stream(t *testing.T, s store.StreamStore) {
+	// large is incompressible and bigger than any backend's chunk or part
+	// size, so it is written in pieces everywhere.
+	large := make([]byte, 20<<20)
+	rand.Read(large)
+
+	for name, data := range map[string][]byte{
+		"Small": []byte("streamed\n"),
+		"Large": large,
+	} {
+		t.Run(name, func(t *testing.T) {
+			want := object.Hash(&object.Object{Type: object.TypeBlob, Data: data})
+
+			sha, err := s.PutStream(t.Context(), object.TypeBlob, int64(len(data)), bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("PutStream failed: %v", err)
+			}
+			if sha != want {
+				t.Errorf("SHA mismatch: got %s, want %s", sha, want)
+			}
+			// storing the same object again is not an error.
+			if _, err := s.PutStream(t.Context(), object.TypeBlob, int64(len(data)), bytes.NewReader(data)); err != nil {
+				t.Errorf("second PutStream failed: %v", err)
+			}
+
+			r, err := s.GetStream(t.Context(), sha)
+			if err != nil {
+				t.Fatalf("GetStream failed: %v", err)
+			}
+			defer r.Close()
+			if r.Type != object.TypeBlob || r.Size != int64(len(data)) {
+				t.Errorf("header: got %s %d, want blob %d", r.Type, r.Size, len(data))
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("read stream failed: %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Error("streamed body mismatch")
+			}
+
+			obj, err := s.Get(t.Context(), sha)
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			if !bytes.Equal(obj.Data, data) {
+				t.Error("Get body mismatch")
+			}
+		})
+	}
+
+	t.Run("WrongSize", func(t *testing.T) {
+		data := []byte("declared longer than it is\n")
+		sha := object.Hash(&object.Object{Type: object.TypeBlob, Data: data})
+		if _, err := s.PutStream(t.Context(), object.TypeBlob, int64(len(data))+1, bytes.NewReader(data)); err == nil {
+			t.Error("expected an error for a body shorter than its size")
+		}
+		if exists, err := s.Exists(t.Context(), sha); err != nil || exists {
+			t.Errorf("Exists after failed PutStream: got (%v, %v), want (false, nil)", exists, err)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		if _, err := s.GetStream(t.Context(), missingSHA); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+}
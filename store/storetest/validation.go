@@ -0,0 +1,64 @@
+package storetest
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"git.wyat.me/git-storage/object"
+	"git.wyat.me/git-storage/store"
+)
+
+// Validation checks that s, created with store.WithValidation, refuses
+// malformed objects on every write path and stores nothing for them.
+func Validation(t *testing.T, s store.ObjectStore) {
+	// a tree entry that would check out over .git
+	bad := &object.Object{Type: object.TypeTree, Data: []byte("40000 .git\x00" + strings.Repeat("\x01", 20))}
+	good := &object.Object{Type: object.TypeBlob, Data: []byte("validation\n")}
+
+	assertRejected := func(t *testing.T, err error, shas ...string) {
+		t.Helper()
+		if !errors.Is(err, object.ErrInvalid) {
+			t.Fatalf("expected object.ErrInvalid, got %v", err)
+		}
+		for _, sha := range shas {
+			if exists, err := s.Exists(t.Context(), sha); err != nil || exists {
+				t.Errorf("Exists %s after rejected write: got (%v, %v), want (false, nil)", sha, exists, err)
+			}
+		}
+	}
+
+	t.Run("Put", func(t *testing.T) {
+		_, err := s.Put(t.Context(), bad)
+		assertRejected(t, err, object.Hash(bad))
+
+		if _, err := s.Put(t.Context(), good); err != nil {
+			t.Errorf("Put of a valid object failed: %v", err)
+		}
+	})
+
+	t.Run("PutMany", func(t *testing.T) {
+		bs, ok := s.(store.BatchStore)
+		if !ok {
+			t.Skip("not a BatchStore")
+		}
+		// the valid object is refused along with the rest of the batch
+		other := &object.Object{Type: object.TypeBlob, Data: []byte("validation batch\n")}
+		_, err := bs.PutMany(t.Context(), []*object.Object{other, bad})
+		assertRejected(t, err, object.Hash(other), object.Hash(bad))
+	})
+
+	t.Run("PutStream", func(t *testing.T) {
+		ss, ok := s.(store.StreamStore)
+		if !ok {
+			t.Skip("not a StreamStore")
+		}
+		_, err := ss.PutStream(t.Context(), bad.Type, int64(len(bad.Data)), bytes.NewReader(bad.Data))
+		assertRejected(t, err, object.Hash(bad))
+
+		if _, err := ss.PutStream(t.Context(), good.Type, int64(len(good.Data)), bytes.NewReader(good.Data)); err != nil {
+			t.Errorf("PutStream of a valid object failed: %v", err)
+		}
+	})
+}
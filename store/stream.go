@@ -0,0 +1,63 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"git.wyat.me/git-storage/object"
+)
+
+// StreamStore is implemented by object stores that can write and read an
+// object body without holding it, or its compressed form, in memory.
+// Callers go through PutStream and GetStream, which fall back to Put and
+// Get for stores that cannot stream.
+type StreamStore interface {
+	ObjectStore
+	// PutStream stores an object of type typ whose size-byte body is read
+	// from r, and returns its SHA.
+	PutStream(ctx context.Context, typ object.ObjectType, size int64, r io.Reader) (string, error)
+	// GetStream opens sha for reading. The caller must close the reader.
+	GetStream(ctx context.Context, sha string) (*object.Reader, error)
+}
+
+// PutStream stores the object read from r in s, streaming it if s is a
+// StreamStore.
+func PutStream(ctx context.Context, s ObjectStore, typ object.ObjectType, size int64, r io.Reader) (string, error) {
+	if ss, ok := s.(StreamStore); ok {
+		return ss.PutStream(ctx, typ, size, r)
+	}
+	return PutWhole(ctx, s, typ, size, r)
+}
+
+// PutWhole reads the object from r into memory and stores it with Put.
+func PutWhole(ctx context.Context, s ObjectStore, typ object.ObjectType, size int64, r io.Reader) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, size+1))
+	if err != nil {
+		return "", fmt.Errorf("read object body: %w", err)
+	}
+	if int64(len(data)) != size {
+		return "", fmt.Errorf("object body is %d bytes, declared %d", len(data), size)
+	}
+	return s.Put(ctx, &object.Object{Type: typ, Data: data})
+}
+
+// GetStream opens sha in s, streaming it if s is a StreamStore.
+func GetStream(ctx context.Context, s ObjectStore, sha string) (*object.Reader, error) {
+	if ss, ok := s.(StreamStore); ok {
+		return ss.GetStream(ctx, sha)
+	}
+	return GetWhole(ctx, s, sha)
+}
+
+// GetWhole reads sha into memory with Get and returns a reader over its
+// body.
+func GetWhole(ctx context.Context, s ObjectStore, sha string) (*object.Reader, error) {
+	obj, err := s.Get(ctx, sha)
+	if err != nil {
+		return nil, err
+	}
+	body := io.NopCloser(bytes.NewReader(obj.Data))
+	return object.NewBodyReader(obj.Type, int64(len(obj.Data)), body), nil
+}